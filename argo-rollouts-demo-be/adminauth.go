@@ -0,0 +1,74 @@
+package main
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Endpoints that change what every viewer of the demo sees are guarded by
+// ADMIN_TOKEN, sent as "Authorization: Bearer <token>" or X-Admin-Token. Without
+// ADMIN_TOKEN they stay open, as they were before, so local runs need no
+// setup. It has its own header rather than X-API-Key, which identifies the
+// caller for quotas and ends up in Redis key names.
+const adminTokenHeader = "X-Admin-Token"
+
+var authFailuresTotal = promauto.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "auth_failures_total",
+		Help: "Requests to admin endpoints refused, by endpoint and reason (missing or invalid)",
+	},
+	[]string{"endpoint", "reason"},
+)
+
+// adminCredential returns the token a request presents, if any.
+func adminCredential(header http.Header) string {
+	if auth := header.Get("Authorization"); auth != "" {
+		if scheme, token, ok := strings.Cut(auth, " "); ok && strings.EqualFold(scheme, "Bearer") {
+			return strings.TrimSpace(token)
+		}
+		return ""
+	}
+	return header.Get(adminTokenHeader)
+}
+
+// checkAdminToken returns 0 when the request may go ahead, or the status
+// to refuse it with: 401 when no token was sent, 403 when it is wrong.
+func checkAdminToken(endpoint string, header http.Header) int {
+	token := adminToken.Value()
+	if token == "" {
+		return 0
+	}
+	provided := adminCredential(header)
+	if provided == "" {
+		authFailuresTotal.WithLabelValues(endpoint, "missing").Inc()
+		return http.StatusUnauthorized
+	}
+	if subtle.ConstantTimeCompare([]byte(provided), []byte(token)) != 1 {
+		authFailuresTotal.WithLabelValues(endpoint, "invalid").Inc()
+		return http.StatusForbidden
+	}
+	return 0
+}
+
+// requireAdminToken is route middleware for the admin endpoints.
+func requireAdminToken(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		switch code := checkAdminToken(c.Path(), c.Request().Header); code {
+		case 0:
+			return next(c)
+		case http.StatusUnauthorized:
+			c.Response().Header().Set(echo.HeaderWWWAuthenticate, `Bearer realm="admin"`)
+			httpRequestsTotal.WithLabelValues(c.Path(), fmt.Sprintf("%d", code)).Inc()
+			return c.JSON(code, map[string]string{"error": "Missing admin token"})
+		default:
+			httpRequestsTotal.WithLabelValues(c.Path(), fmt.Sprintf("%d", code)).Inc()
+			return c.JSON(code, map[string]string{"error": "Invalid admin token"})
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Write routes that stay open with ADMIN_TOKEN set, and why.
+var openWriteRoutes = map[string]string{
+	"POST /api/check/batch":              "traffic, like GET /api/check",
+	"POST /api/sessions":                 "each viewer's own session",
+	"DELETE /api/sessions/:token":        "the session token is the credential",
+	"POST /api/annotations":              "notes on the timeline, not behavior",
+	"POST /api/alerts":                   "Alertmanager webhook",
+	"POST /api/rollout/abort":            "guarded by ROLLOUT_ABORT_TOKEN",
+	"POST /api/runs":                     "records a run",
+	"POST /api/runs/:id/stop":            "records a run",
+	"POST /api/runs/:id/events":          "records a run",
+	"POST /api/load/runs":                "load generator reports",
+	"POST /api/load/assert":              "read-only evaluation",
+	"POST /ofrep/v1/evaluate/flags":      "read-only flag evaluation",
+	"POST /ofrep/v1/evaluate/flags/:key": "read-only flag evaluation",
+}
+
+func useAdminToken(t *testing.T, token string) {
+	t.Helper()
+	adminToken.mu.Lock()
+	previous := adminToken.value
+	adminToken.value = token
+	adminToken.mu.Unlock()
+	t.Cleanup(func() {
+		adminToken.mu.Lock()
+		adminToken.value = previous
+		adminToken.mu.Unlock()
+	})
+}
+
+// Every route that changes state must refuse a request without the admin
+// token, unless it is listed in openWriteRoutes.
+func TestWriteRoutesRequireAdminToken(t *testing.T) {
+	useAdminToken(t, "s3cret")
+	previousStatuses := pluginStatuses
+	t.Cleanup(func() { pluginStatuses = previousStatuses })
+
+	e := echo.New()
+	registerRoutes(e)
+
+	guarded, registered := 0, map[string]bool{}
+	for _, route := range e.Routes() {
+		registered[route.Method+" "+route.Path] = true
+		switch route.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodConnect, http.MethodTrace:
+			continue
+		}
+		key := route.Method + " " + route.Path
+		if _, ok := openWriteRoutes[key]; ok {
+			continue
+		}
+		t.Run(key, func(t *testing.T) {
+			path := strings.NewReplacer(":id", "x", ":token", "x", ":key", "x", "*", "x").Replace(route.Path)
+			req := httptest.NewRequest(route.Method, path, strings.NewReader("{}"))
+			req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, req)
+			if rec.Code != http.StatusUnauthorized {
+				t.Fatalf("got %d without a token, want 401", rec.Code)
+			}
+		})
+		guarded++
+	}
+	if guarded == 0 {
+		t.Fatal("no guarded routes found")
+	}
+	for key := range openWriteRoutes {
+		if !registered[key] {
+			t.Errorf("openWriteRoutes lists %s, which is not registered", key)
+		}
+	}
+}
@@ -0,0 +1,321 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	activeAlertsKey     = "alerts:active"
+	resolvedAlertsKey   = "alerts:resolved"
+	alertEventsChannel  = "alerts:events"
+	maxResolvedAlerts   = 50
+	alertStatusFiring   = "firing"
+	alertStatusResolved = "resolved"
+)
+
+// Alert is a single alert as sent by Alertmanager's webhook receiver.
+type Alert struct {
+	Status       string            `json:"status"`
+	Labels       map[string]string `json:"labels"`
+	Annotations  map[string]string `json:"annotations"`
+	StartsAt     time.Time         `json:"startsAt"`
+	EndsAt       time.Time         `json:"endsAt"`
+	GeneratorURL string            `json:"generatorURL"`
+	Fingerprint  string            `json:"fingerprint"`
+	ReceivedAt   time.Time         `json:"receivedAt"`
+}
+
+// AlertmanagerWebhook is the Alertmanager webhook payload (version 4).
+type AlertmanagerWebhook struct {
+	Version           string            `json:"version"`
+	GroupKey          string            `json:"groupKey"`
+	Status            string            `json:"status"`
+	Receiver          string            `json:"receiver"`
+	GroupLabels       map[string]string `json:"groupLabels"`
+	CommonLabels      map[string]string `json:"commonLabels"`
+	CommonAnnotations map[string]string `json:"commonAnnotations"`
+	ExternalURL       string            `json:"externalURL"`
+	Alerts            []Alert           `json:"alerts"`
+}
+
+// AlertEvent is pushed to /api/alerts/events subscribers when an alert
+// starts firing or resolves.
+type AlertEvent struct {
+	Type  string `json:"type"` // "firing" or "resolved"
+	Alert Alert  `json:"alert"`
+}
+
+var (
+	alertsHub = newSSEHub()
+
+	// Used when Redis isn't available
+	localActiveAlerts   = map[string]Alert{}
+	localResolvedAlerts []Alert
+	localAlertsMu       sync.Mutex
+
+	alertsReceivedTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "alerts_received_total",
+			Help: "Alerts received from Alertmanager by status",
+		},
+		[]string{"status"},
+	)
+)
+
+// alertFingerprint falls back to a hash of the sorted labels for senders that
+// don't fill in the fingerprint.
+func alertFingerprint(alert Alert) string {
+	if alert.Fingerprint != "" {
+		return alert.Fingerprint
+	}
+	names := make([]string, 0, len(alert.Labels))
+	for name := range alert.Labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	h := fnv.New64a()
+	for _, name := range names {
+		fmt.Fprintf(h, "%s=%s\xff", name, alert.Labels[name])
+	}
+	return fmt.Sprintf("%016x", h.Sum64())
+}
+
+// storeAlert updates the active set and reports whether the alert changed
+// state, so repeated notifications for the same firing alert stay quiet.
+func storeAlert(alert Alert) (bool, error) {
+	if redisClient == nil {
+		localAlertsMu.Lock()
+		defer localAlertsMu.Unlock()
+
+		_, wasActive := localActiveAlerts[alert.Fingerprint]
+		if alert.Status == alertStatusFiring {
+			localActiveAlerts[alert.Fingerprint] = alert
+			return !wasActive, nil
+		}
+		delete(localActiveAlerts, alert.Fingerprint)
+		localResolvedAlerts = append([]Alert{alert}, localResolvedAlerts...)
+		if len(localResolvedAlerts) > maxResolvedAlerts {
+			localResolvedAlerts = localResolvedAlerts[:maxResolvedAlerts]
+		}
+		return wasActive, nil
+	}
+
+	encoded, err := json.Marshal(alert)
+	if err != nil {
+		return false, err
+	}
+	if alert.Status == alertStatusFiring {
+		added, err := redisClient.HSet(redisCtx, activeAlertsKey, alert.Fingerprint, encoded).Result()
+		return added > 0, err
+	}
+
+	pipe := redisClient.TxPipeline()
+	removed := pipe.HDel(redisCtx, activeAlertsKey, alert.Fingerprint)
+	pipe.LPush(redisCtx, resolvedAlertsKey, encoded)
+	pipe.LTrim(redisCtx, resolvedAlertsKey, 0, maxResolvedAlerts-1)
+	if _, err := pipe.Exec(redisCtx); err != nil {
+		return false, err
+	}
+	return removed.Val() > 0, nil
+}
+
+// loadAlerts returns the firing alerts, oldest first, and the most recently
+// resolved ones, newest first.
+func loadAlerts() ([]Alert, []Alert, error) {
+	var active, resolved []Alert
+
+	if redisClient == nil {
+		localAlertsMu.Lock()
+		for _, alert := range localActiveAlerts {
+			active = append(active, alert)
+		}
+		resolved = append(resolved, localResolvedAlerts...)
+		localAlertsMu.Unlock()
+	} else {
+		fields, err := redisClient.HGetAll(redisCtx, activeAlertsKey).Result()
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, encoded := range fields {
+			var alert Alert
+			if json.Unmarshal([]byte(encoded), &alert) == nil {
+				active = append(active, alert)
+			}
+		}
+		members, err := redisClient.LRange(redisCtx, resolvedAlertsKey, 0, maxResolvedAlerts-1).Result()
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, encoded := range members {
+			var alert Alert
+			if json.Unmarshal([]byte(encoded), &alert) == nil {
+				resolved = append(resolved, alert)
+			}
+		}
+	}
+
+	sort.Slice(active, func(i, j int) bool { return active[i].StartsAt.Before(active[j].StartsAt) })
+	if active == nil {
+		active = []Alert{}
+	}
+	if resolved == nil {
+		resolved = []Alert{}
+	}
+	return active, resolved, nil
+}
+
+// publishAlertEvent notifies SSE subscribers on every pod through the Redis
+// bus, or just this pod's subscribers without it.
+func publishAlertEvent(event AlertEvent) {
+	encoded, err := json.Marshal(event)
+	if err != nil {
+		return
+	}
+	if redisClient == nil || busSubsystem.start() != nil {
+		alertsHub.publish("alert", encoded)
+		return
+	}
+	if err := redisClient.Publish(redisCtx, alertEventsChannel, encoded).Err(); err != nil {
+		warnf("Failed to publish alert event: %v", err)
+	}
+}
+
+// initAlertEventBus subscribes to the alert events channel, so events
+// published by any pod reach this pod's SSE subscribers. Without Redis there
+// is nothing to subscribe to and events stay local.
+func initAlertEventBus() error {
+	if redisClient == nil {
+		return nil
+	}
+	pubsub := redisClient.Subscribe(redisCtx, alertEventsChannel)
+	ctx, cancel := context.WithTimeout(redisCtx, 5*time.Second)
+	defer cancel()
+	if _, err := pubsub.Receive(ctx); err != nil {
+		pubsub.Close()
+		return fmt.Errorf("subscribing to %s: %w", alertEventsChannel, err)
+	}
+	go runAlertEventRelay(pubsub, backgroundStop)
+	return nil
+}
+
+// runAlertEventRelay forwards alert events published by any pod to this
+// pod's SSE subscribers.
+func runAlertEventRelay(pubsub *redis.PubSub, stop <-chan struct{}) {
+	defer pubsub.Close()
+
+	messages := pubsub.Channel()
+	for {
+		select {
+		case <-stop:
+			return
+		case msg, ok := <-messages:
+			if !ok {
+				return
+			}
+			alertsHub.publish("alert", []byte(msg.Payload))
+		}
+	}
+}
+
+func alertAnnotation(event AlertEvent) Annotation {
+	name := event.Alert.Labels["alertname"]
+	if name == "" {
+		name = event.Alert.Fingerprint
+	}
+	message := name
+	if summary := event.Alert.Annotations["summary"]; summary != "" {
+		message = fmt.Sprintf("%s: %s", name, summary)
+	}
+	return Annotation{
+		Timestamp: event.Alert.ReceivedAt,
+		Type:      "alert-" + event.Type,
+		Message:   message,
+	}
+}
+
+// receiveAlertsHandler is an Alertmanager webhook receiver. Alerts that
+// start firing or resolve are announced to SSE subscribers and marked on the
+// metrics timeline.
+func receiveAlertsHandler(c echo.Context) error {
+	var payload AlertmanagerWebhook
+	if err := json.NewDecoder(c.Request().Body).Decode(&payload); err != nil {
+		httpRequestsTotal.WithLabelValues("/api/alerts", fmt.Sprintf("%d", http.StatusBadRequest)).Inc()
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid JSON"})
+	}
+
+	now := time.Now().UTC()
+	changed := 0
+	for _, alert := range payload.Alerts {
+		alert.Status = strings.ToLower(alert.Status)
+		if alert.Status != alertStatusFiring && alert.Status != alertStatusResolved {
+			alert.Status = strings.ToLower(payload.Status)
+		}
+		if alert.Status != alertStatusFiring && alert.Status != alertStatusResolved {
+			httpRequestsTotal.WithLabelValues("/api/alerts", fmt.Sprintf("%d", http.StatusBadRequest)).Inc()
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "Alert status must be firing or resolved"})
+		}
+		alert.Fingerprint = alertFingerprint(alert)
+		alert.ReceivedAt = now
+		alertsReceivedTotal.WithLabelValues(alert.Status).Inc()
+
+		transitioned, err := storeAlert(alert)
+		if err != nil {
+			warnf("Failed to store alert %s: %v", alert.Fingerprint, err)
+			httpRequestsTotal.WithLabelValues("/api/alerts", fmt.Sprintf("%d", http.StatusInternalServerError)).Inc()
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to store alerts"})
+		}
+		if !transitioned {
+			continue
+		}
+		changed++
+
+		event := AlertEvent{Type: alert.Status, Alert: alert}
+		publishAlertEvent(event)
+		if err := addAnnotation(alertAnnotation(event)); err != nil {
+			warnf("Failed to annotate alert %s: %v", alert.Fingerprint, err)
+		}
+	}
+
+	httpRequestsTotal.WithLabelValues("/api/alerts", fmt.Sprintf("%d", http.StatusOK)).Inc()
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"received": len(payload.Alerts),
+		"changed":  changed,
+	})
+}
+
+func listAlertsHandler(c echo.Context) error {
+	active, resolved, err := loadAlerts()
+	if err != nil {
+		httpRequestsTotal.WithLabelValues("/api/alerts", fmt.Sprintf("%d", http.StatusInternalServerError)).Inc()
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to load alerts"})
+	}
+
+	httpRequestsTotal.WithLabelValues("/api/alerts", fmt.Sprintf("%d", http.StatusOK)).Inc()
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"active":   active,
+		"resolved": resolved,
+	})
+}
+
+func alertEventsHandler(c echo.Context) error {
+	// Without the bus this pod's subscribers only see events received here
+	if err := busSubsystem.start(); err != nil {
+		debugf("alerts: serving local events only: %v", err)
+	}
+	httpRequestsTotal.WithLabelValues("/api/alerts/events", fmt.Sprintf("%d", http.StatusOK)).Inc()
+	return serveSSE(c, alertsHub)
+}
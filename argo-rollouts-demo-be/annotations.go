@@ -0,0 +1,135 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	annotationsKey       = "annotations"
+	maxLocalAnnotations  = 500
+	maxAnnotationMessage = 1024
+)
+
+// Annotation marks a rollout milestone on the metrics timeline, e.g. sent by
+// an Argo Rollouts notification webhook on promotion or abort.
+type Annotation struct {
+	Timestamp time.Time `json:"timestamp"`
+	Type      string    `json:"type"` // e.g. "promoted", "aborted", "note"
+	Message   string    `json:"message,omitempty"`
+}
+
+var (
+	// Served when Redis isn't available
+	localAnnotations   []Annotation
+	localAnnotationsMu sync.RWMutex
+)
+
+// addAnnotation stores an annotation next to the rollups in Redis, or locally
+// when Redis isn't available. Both keep only the rollup retention period.
+func addAnnotation(annotation Annotation) error {
+	cutoff := time.Now().UTC().Add(-rollupRetention)
+
+	if redisClient == nil {
+		localAnnotationsMu.Lock()
+		defer localAnnotationsMu.Unlock()
+		kept := localAnnotations[:0]
+		for _, existing := range localAnnotations {
+			if existing.Timestamp.After(cutoff) {
+				kept = append(kept, existing)
+			}
+		}
+		localAnnotations = append(kept, annotation)
+		if len(localAnnotations) > maxLocalAnnotations {
+			localAnnotations = localAnnotations[len(localAnnotations)-maxLocalAnnotations:]
+		}
+		return nil
+	}
+
+	encoded, err := json.Marshal(annotation)
+	if err != nil {
+		return err
+	}
+	pipe := redisClient.Pipeline()
+	pipe.ZAdd(redisCtx, annotationsKey, redis.Z{Score: float64(annotation.Timestamp.UnixMilli()), Member: encoded})
+	pipe.ZRemRangeByScore(redisCtx, annotationsKey, "-inf", strconv.FormatInt(cutoff.UnixMilli(), 10))
+	_, err = pipe.Exec(redisCtx)
+	return err
+}
+
+// loadAnnotations returns the annotations since the given time, oldest first.
+func loadAnnotations(since time.Time) ([]Annotation, error) {
+	annotations := []Annotation{}
+
+	if redisClient == nil {
+		localAnnotationsMu.RLock()
+		defer localAnnotationsMu.RUnlock()
+		for _, annotation := range localAnnotations {
+			if !annotation.Timestamp.Before(since) {
+				annotations = append(annotations, annotation)
+			}
+		}
+	} else {
+		members, err := redisClient.ZRangeByScore(redisCtx, annotationsKey, &redis.ZRangeBy{
+			Min: strconv.FormatInt(since.UnixMilli(), 10),
+			Max: "+inf",
+		}).Result()
+		if err != nil {
+			return nil, err
+		}
+		for _, member := range members {
+			var annotation Annotation
+			if err := json.Unmarshal([]byte(member), &annotation); err != nil {
+				continue
+			}
+			annotations = append(annotations, annotation)
+		}
+	}
+
+	sort.SliceStable(annotations, func(i, j int) bool { return annotations[i].Timestamp.Before(annotations[j].Timestamp) })
+	return annotations, nil
+}
+
+func addAnnotationHandler(c echo.Context) error {
+	var annotation Annotation
+	if err := json.NewDecoder(c.Request().Body).Decode(&annotation); err != nil {
+		httpRequestsTotal.WithLabelValues("/api/annotations", fmt.Sprintf("%d", http.StatusBadRequest)).Inc()
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid JSON"})
+	}
+
+	if annotation.Type == "" {
+		httpRequestsTotal.WithLabelValues("/api/annotations", fmt.Sprintf("%d", http.StatusBadRequest)).Inc()
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "type is required"})
+	}
+	if len(annotation.Message) > maxAnnotationMessage {
+		httpRequestsTotal.WithLabelValues("/api/annotations", fmt.Sprintf("%d", http.StatusBadRequest)).Inc()
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": fmt.Sprintf("message must be at most %d bytes", maxAnnotationMessage),
+		})
+	}
+	if annotation.Timestamp.IsZero() {
+		annotation.Timestamp = time.Now()
+	}
+	annotation.Timestamp = annotation.Timestamp.UTC()
+	if annotation.Timestamp.Before(time.Now().Add(-rollupRetention)) {
+		httpRequestsTotal.WithLabelValues("/api/annotations", fmt.Sprintf("%d", http.StatusBadRequest)).Inc()
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "timestamp is older than the timeseries retention"})
+	}
+
+	if err := addAnnotation(annotation); err != nil {
+		warnf("Failed to store annotation: %v", err)
+		httpRequestsTotal.WithLabelValues("/api/annotations", fmt.Sprintf("%d", http.StatusInternalServerError)).Inc()
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to store annotation"})
+	}
+
+	httpRequestsTotal.WithLabelValues("/api/annotations", fmt.Sprintf("%d", http.StatusCreated)).Inc()
+	return c.JSON(http.StatusCreated, annotation)
+}
@@ -3,20 +3,25 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"flag"
 	"fmt"
-	"log"
+	"log/slog"
 	"math"
-	"math/rand"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
-	"sync"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
 	"sync/atomic"
 	"syscall"
 	"time"
 
+	"argo-rollouts-demo-be/internal/weighted"
+
 	"github.com/labstack/echo/v4"
-	"github.com/labstack/echo/v4/middleware"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
 	io_prometheus_client "github.com/prometheus/client_model/go"
@@ -24,58 +29,276 @@ import (
 )
 
 type ErrorRate struct {
-	Value float64 `json:"value"` // Expecting the key "value"
+	Value       float64               `json:"value"`            // Percentage, expecting the key "value"
+	Probability float64               `json:"probability"`      // Value as a 0-1 probability, ignored on input
+	Routes      map[string]RouteFault `json:"routes,omitempty"` // Replaces the route faults when set
+}
+
+type CheckResult struct {
+	Status          int       `json:"status"`
+	Version         string    `json:"version"`
+	Pod             string    `json:"pod"`
+	Timestamp       time.Time `json:"timestamp"`
+	LatencyInjected float64   `json:"latencyInjected"` // Milliseconds
+	ContentHash     string    `json:"contentHash"`     // Digest of every field except the timestamp and latency
 }
 
+// StatusCounts is the two-bucket view of /api/check outcomes that error
+// rates are computed from. Status500 counts every failed check whatever its
+// status code, so 503s and 429s from the status weights count too.
 type StatusCounts struct {
 	Status200 float64 `json:"200"`
 	Status500 float64 `json:"500"`
 }
 
+// statusCountsOf folds per-status-code counts into passed and failed checks.
+func statusCountsOf(counts map[string]float64) StatusCounts {
+	var folded StatusCounts
+	for status, n := range counts {
+		if status == "200" {
+			folded.Status200 += n
+		} else {
+			folded.Status500 += n
+		}
+	}
+	return folded
+}
+
 var (
-	errorRate   atomic.Uint64 // Store as uint64 bits of float64 for atomic operations
+	errorRate   atomic.Uint64 // Percentage as uint64 bits of float64, kept exactly as set so fractional rates read back unchanged
 	version     = getEnvOrDefault("VERSION", "1")
 	buildHash   = getEnvOrDefault("BUILD_HASH", "dev")
-	rng         = rand.New(rand.NewSource(time.Now().UnixNano()))
-	rngMu       sync.Mutex
-	redisClient *redis.Client
+	podName     = getEnvOrDefault("POD_NAME", hostname())
+	listenAddr  = ":8080" // Resolved from PORT and BIND_ADDR by parseServerFlags
+	redisAddr   = getEnvOrDefault("REDIS_ADDR", "localhost:6379")
+	redisClient redis.UniversalClient
 	redisCtx    = context.Background()
 
+	// Closed on shutdown to end all background workers, including those
+	// started lazily by subsystems
+	backgroundStop = make(chan struct{})
+
+	// Closed when the HTTP server starts shutting down, before it waits for
+	// in-flight requests, so SSE and WebSocket streams end instead of
+	// holding the shutdown open
+	streamsStop = make(chan struct{})
+
+	// Return a JSON body from /api/check by default instead of only ?verbose=1
+	checkVerbose = isTruthy(getEnvOrDefault("CHECK_VERBOSE", "false"))
+
 	// Prometheus metrics
 	httpRequestsTotal = promauto.NewCounterVec(
 		prometheus.CounterOpts{
-			Name: "http_requests_total",
-			Help: "Total number of HTTP requests by endpoint and status code",
+			Name:        "http_requests_total",
+			Help:        "Total number of HTTP requests by endpoint and status code",
+			ConstLabels: prometheus.Labels{"version": version},
 		},
 		[]string{"endpoint", "status_code"},
 	)
 )
 
 func checkHandler(c echo.Context) error {
-	currentErrorRate := getErrorRate()
-
-	// Determine if the response should be an error (500) based on errorRate
-	statusCode := http.StatusOK
-	rngMu.Lock()
-	if rng.Float64() < currentErrorRate {
-		statusCode = http.StatusInternalServerError
+	forced, err := parseForcedStatus(c)
+	if err != nil {
+		httpRequestsTotal.WithLabelValues("/api/check", fmt.Sprintf("%d", http.StatusBadRequest)).Inc()
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
 	}
-	rngMu.Unlock()
-
-	// Record the request in Prometheus metrics
-	httpRequestsTotal.WithLabelValues("/api/check", fmt.Sprintf("%d", statusCode)).Inc()
-
-	// Update Redis with the new count (non-blocking)
-	if redisClient != nil {
-		key := fmt.Sprintf("status_%d", statusCode)
-		go redisClient.Incr(redisCtx, key)
+	if forced != 0 {
+		c.SetRequest(c.Request().WithContext(withForcedStatus(c.Request().Context(), forced)))
 	}
+	delay, err := parseRequestDelay(c)
+	if err != nil {
+		httpRequestsTotal.WithLabelValues("/api/check", fmt.Sprintf("%d", http.StatusBadRequest)).Inc()
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+	if delay > 0 {
+		c.SetRequest(c.Request().WithContext(withRequestDelay(c.Request().Context(), delay)))
+	}
+	codePath := codePathFor(c)
+	latencyInjected, err := injectCheckLatency(c.Request().Context())
+	if err != nil {
+		return err
+	}
+	statusCode, currentErrorRate := simulateCheck(c.Request().Context(), codePath)
+	if err := recordCheckCounts(c.Request().Context(), map[int]int64{statusCode: 1}); err != nil {
+		httpRequestsTotal.WithLabelValues("/api/check", fmt.Sprintf("%d", http.StatusServiceUnavailable)).Inc()
+		return c.JSON(http.StatusServiceUnavailable, map[string]string{"error": "Counter store unavailable"})
+	}
+	countCheckOutcomes(c.Request().Context(), codePath, map[int]int64{statusCode: 1})
+	recordRollupEvent(rollupEvent{status: statusCode, n: 1, latency: requestDuration(c), timed: true})
+
+	debugContextf(c.Request().Context(), "check: code_path=%s error_rate=%.4f status=%d", codePath, currentErrorRate, statusCode)
 
 	// Set X-Version header
 	c.Response().Header().Set("X-Version", version)
+	c.Response().Header().Set("X-Code-Path", codePath)
+
+	if checkVerbose || isTruthy(c.QueryParam("verbose")) {
+		result := CheckResult{
+			Status:          statusCode,
+			Version:         version,
+			Pod:             podName,
+			LatencyInjected: latencyInjected,
+		}
+		result.ContentHash = checkContentHash(result)
+		result.Timestamp = time.Now().UTC()
+
+		// Only the successful representation can be revalidated, a failed
+		// check is always sent in full
+		etag := `W/"` + result.ContentHash + `"`
+		c.Response().Header().Set("ETag", etag)
+		if statusCode == http.StatusOK && etagMatches(c.Request(), etag) {
+			conditionalResponsesTotal.WithLabelValues("/api/check", "304").Inc()
+			return c.NoContent(http.StatusNotModified)
+		}
+		if statusCode == http.StatusOK {
+			conditionalResponsesTotal.WithLabelValues("/api/check", "200").Inc()
+		}
+		return c.JSON(statusCode, result)
+	}
 	return c.NoContent(statusCode)
 }
 
+// checkContentHash digests a verbose check result without its timestamp and
+// injected latency, so two responses hash alike exactly when they differ only
+// in when and how slowly they were served.
+func checkContentHash(result CheckResult) string {
+	result.Timestamp = time.Time{}
+	result.LatencyInjected = 0
+	result.ContentHash = ""
+	encoded, err := json.Marshal(result)
+	if err != nil {
+		return ""
+	}
+	return contentHash(encoded)
+}
+
+// checkOutcome is one possible result of a simulated check and the fault
+// rule responsible for it.
+type checkOutcome struct {
+	status      int
+	rule        string
+	probability float64
+}
+
+// simulateCheck rolls a single /api/check outcome for the code path. It is
+// stored by recordCheckCounts, so callers can batch the writes, and only
+// then counted in the local metrics by countCheckOutcomes, so a check
+// refused because the store is down isn't counted twice.
+func simulateCheck(ctx context.Context, codePath string) (int, float64) {
+	currentErrorRate, rule := weightGatedErrorRate(codePathErrorRate(codePath, sessionErrorRate(ctx, effectiveErrorRate())))
+	currentErrorRate, rule, armed := timeBombErrorRate(currentErrorRate, rule)
+	weights := currentStatusWeights()
+	if armed {
+		weights = nil
+	}
+	if chaosBypassed(ctx) {
+		// Signed smoke test requests see none of the injected failures
+		currentErrorRate, weights = 0, nil
+	}
+
+	outcome := checkOutcome{status: http.StatusOK}
+	weightsRate := 0.0
+	if status, ok := forcedStatus(ctx); ok {
+		// Asked for by the request itself, so the chaos bypass doesn't apply
+		outcome = forcedOutcome(status)
+		currentErrorRate, rule = 0, forcedStatusRule
+		if outcome.status != http.StatusOK {
+			currentErrorRate = 1
+		}
+	} else if burstActive() && !chaosBypassed(ctx) {
+		currentErrorRate, rule = 1, burstRule
+		outcome = checkOutcome{status: http.StatusInternalServerError, rule: burstRule, probability: 1}
+	} else if mode := currentErrorMode(); mode.Mode == errorModeEveryNth {
+		currentErrorRate, rule = 1/float64(mode.N), everyNthRule
+		if armed || chaosBypassed(ctx) {
+			currentErrorRate = 0
+		} else {
+			outcome = everyNthOutcome(mode.N)
+		}
+	} else {
+		// The error rate injects 500s and the status weights any other
+		// failure code; whatever probability is left over passes
+		choices := []weighted.Choice[checkOutcome]{
+			{Value: checkOutcome{status: http.StatusInternalServerError, rule: rule, probability: currentErrorRate}, Weight: currentErrorRate},
+		}
+		for _, choice := range statusWeightChoices(weights) {
+			choices = append(choices, choice)
+			weightsRate += choice.Weight
+		}
+		choices = append(choices, weighted.Choice[checkOutcome]{
+			Value:  checkOutcome{status: http.StatusOK},
+			Weight: max(1-currentErrorRate-weightsRate, 0),
+		})
+		if outcomes, err := weighted.New(choices); err == nil {
+			outcome = outcomes.Choose(randomFloat())
+		}
+	}
+	statusCode := outcome.status
+
+	ruleRate := currentErrorRate
+	if outcome.rule == statusWeightsRule {
+		ruleRate = weightsRate
+	}
+	switch {
+	case statusCode != http.StatusOK && outcome.rule != forcedStatusRule && !allowInjectedFailure(outcome.rule):
+		// Over the blast-radius cap, serve the check as if it had passed.
+		// A forced status was asked for, so it is neither capped nor counted
+		statusCode = http.StatusOK
+		recordFaultRule(outcome.rule, faultOutcomeCapped, ruleRate*100.0)
+	case statusCode != http.StatusOK:
+		recordFaultRule(outcome.rule, faultOutcomeApplied, ruleRate*100.0)
+		recordInjectedFailure(ctx, "/api/check", outcome.rule, statusCode,
+			fmt.Sprintf("code_path=%s probability=%.4f", codePath, outcome.probability))
+	default:
+		if currentErrorRate > 0 {
+			recordFaultRule(rule, faultOutcomeSampling, currentErrorRate*100.0)
+		}
+		if weightsRate > 0 {
+			recordFaultRule(statusWeightsRule, faultOutcomeSampling, weightsRate*100.0)
+		}
+	}
+
+	return statusCode, currentErrorRate
+}
+
+// countCheckOutcomes records stored check outcomes in the Prometheus metrics.
+func countCheckOutcomes(ctx context.Context, codePath string, counts map[int]int64) {
+	storedCheckCounts.add(counts)
+	session, inSession := sessionFromContext(ctx)
+	for statusCode, n := range counts {
+		status := fmt.Sprintf("%d", statusCode)
+		httpRequestsTotal.WithLabelValues("/api/check", status).Add(float64(n))
+		codePathRequestsTotal.WithLabelValues(codePath, status).Add(float64(n))
+		if inSession {
+			sessionRequestsTotal.WithLabelValues(session.Namespace, status).Add(float64(n))
+		}
+		if counterFile != nil {
+			counterFile.Add(statusCode, uint64(n))
+		}
+	}
+}
+
+// recordCheckCounts adds check outcomes to the counter store. With
+// the store failing open they are queued for the batch writer; failing
+// closed the write is synchronous and a failed write is returned so the
+// check can be refused.
+func recordCheckCounts(ctx context.Context, counts map[int]int64) error {
+	localSuccessWindow.add(time.Now(), counts)
+	if failPolicyFor(failFeatureStore).Mode == failClosed {
+		return dependencyFailure(failFeatureStore, writeCheckCounts(context.WithoutCancel(ctx), counts))
+	}
+	queueCheckCounts(counts)
+	return nil
+}
+
+func writeCheckCounts(ctx context.Context, counts map[int]int64) error {
+	if err := simulatedOutage(failFeatureStore); err != nil {
+		return err
+	}
+	return counterStore.Incr(ctx, counts)
+}
+
 func healthzHandler(c echo.Context) error {
 	statusCode := http.StatusOK
 	httpRequestsTotal.WithLabelValues("/api/healthz", fmt.Sprintf("%d", statusCode)).Inc()
@@ -83,103 +306,308 @@ func healthzHandler(c echo.Context) error {
 }
 
 func setErrorRate(c echo.Context) error {
-	var newRate ErrorRate
+	var newRate struct {
+		Value  *float64              `json:"value"`
+		Routes map[string]RouteFault `json:"routes"`
+	}
 	if err := json.NewDecoder(c.Request().Body).Decode(&newRate); err != nil {
 		httpRequestsTotal.WithLabelValues("/api/set-error-rate", fmt.Sprintf("%d", http.StatusBadRequest)).Inc()
 		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid JSON"})
 	}
+	// Only routes leaves the global rate alone, nothing at all clears it
+	if newRate.Value == nil && newRate.Routes == nil {
+		newRate.Value = new(float64)
+	}
 
-	if newRate.Value < 0 || newRate.Value > 100 {
+	if newRate.Value != nil {
+		if err := validateErrorRatePercent(*newRate.Value); err != nil {
+			httpRequestsTotal.WithLabelValues("/api/set-error-rate", fmt.Sprintf("%d", http.StatusBadRequest)).Inc()
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+		}
+	}
+	if err := validateRouteFaults(newRate.Routes); err != nil {
 		httpRequestsTotal.WithLabelValues("/api/set-error-rate", fmt.Sprintf("%d", http.StatusBadRequest)).Inc()
-		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Error rate must be between 0 and 100"})
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
 	}
 
-	storeErrorRate(newRate.Value / 100.0)
+	if newRate.Routes != nil {
+		setRouteFaults(newRate.Routes)
+	}
+	replicated := false
+	if newRate.Value != nil {
+		replicated = setManualErrorRate(c.Request().Context(), *newRate.Value, "POST /api/set-error-rate")
+	}
 
+	// Echo what was stored, so the caller can see the rate wasn't rounded
 	httpRequestsTotal.WithLabelValues("/api/set-error-rate", fmt.Sprintf("%d", http.StatusOK)).Inc()
-	return c.JSON(http.StatusOK, map[string]string{"message": "Error rate updated"})
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"message":     "Error rate updated",
+		"value":       getErrorRatePercent(),
+		"probability": getErrorRate(),
+		"routes":      currentRouteFaults(),
+		"replicated":  replicated,
+	})
 }
 
 func getErrorRateHandler(c echo.Context) error {
-	currentRate := getErrorRate() * 100.0
-
 	httpRequestsTotal.WithLabelValues("/api/error-rate", fmt.Sprintf("%d", http.StatusOK)).Inc()
-	return c.JSON(http.StatusOK, ErrorRate{Value: currentRate})
+	return c.JSON(http.StatusOK, ErrorRate{
+		Value:       getErrorRatePercent(),
+		Probability: getErrorRate(),
+		Routes:      currentRouteFaults(),
+	})
 }
 
+// validateErrorRatePercent accepts any finite percentage from 0 to 100,
+// fractions included.
+func validateErrorRatePercent(percent float64) error {
+	if math.IsNaN(percent) || math.IsInf(percent, 0) {
+		return fmt.Errorf("error rate must be a finite number")
+	}
+	if percent < 0 || percent > 100 {
+		return fmt.Errorf("error rate must be between 0 and 100")
+	}
+	return nil
+}
+
+// getErrorRate returns the configured error probability (0-1).
 func getErrorRate() float64 {
+	return getErrorRatePercent() / 100.0
+}
+
+func getErrorRatePercent() float64 {
 	bits := errorRate.Load()
 	return math.Float64frombits(bits)
 }
 
-func storeErrorRate(rate float64) {
-	bits := math.Float64bits(rate)
+func storeErrorRatePercent(percent float64) {
+	bits := math.Float64bits(percent)
 	errorRate.Store(bits)
 }
 
 func resetMetricsHandler(c echo.Context) error {
-	// Reset Redis counters
-	if redisClient != nil {
-		if err := redisClient.Del(redisCtx, "status_200", "status_500").Err(); err != nil {
-			log.Printf("Warning: Failed to reset Redis counters: %v", err)
-		}
+	if err := counterStore.Reset(redisCtx); err != nil {
+		warnf("Failed to reset %s counters: %v", counterStore.Name(), err)
 	}
 
 	// Reset Prometheus metrics
 	httpRequestsTotal.Reset()
+	storedCheckCounts.reset()
+	if counterFile != nil {
+		counterFile.Reset()
+	}
 
 	httpRequestsTotal.WithLabelValues("/api/reset-metrics", fmt.Sprintf("%d", http.StatusOK)).Inc()
 	return c.JSON(http.StatusOK, map[string]string{"message": "Metrics reset successfully"})
 }
 
 func metricsHandler(c echo.Context) error {
-	var count200, count500 float64
-
-	// Get counts from Redis if available
-	if redisClient != nil {
-		count200, _ = redisClient.Get(redisCtx, "status_200").Float64()
-		count500, _ = redisClient.Get(redisCtx, "status_500").Float64()
-	}
-
-	// If Redis is empty or unavailable, fallback to Prometheus metrics
-	if count200 == 0 && count500 == 0 {
-		metricChan := make(chan prometheus.Metric, 100)
-		httpRequestsTotal.Collect(metricChan)
-		close(metricChan)
-		for metric := range metricChan {
-			m := &io_prometheus_client.Metric{}
-			if err := metric.Write(m); err != nil {
-				continue
-			}
-			if m.Label == nil {
-				continue
-			}
-			var endpoint, statusCode string
-			for _, label := range m.Label {
-				if label.GetName() == "endpoint" {
-					endpoint = label.GetValue()
-				} else if label.GetName() == "status_code" {
-					statusCode = label.GetValue()
-				}
-			}
-			if endpoint == "" || statusCode == "" {
-				continue
+	if v := c.QueryParam("version"); v != "" {
+		return versionMetricsHandler(c, v)
+	}
+
+	counts, source, stale, err := metricsStatusCounts(c.Request().Context())
+	if err != nil {
+		return c.JSON(http.StatusServiceUnavailable, map[string]string{"error": "Counter store unavailable"})
+	}
+	if stale {
+		c.Response().Header().Set("X-Stale", "true")
+	}
+
+	// The counts stay top-level for the analysis templates' $.200 and $.500
+	body := map[string]interface{}{"source": source}
+	for status, n := range withBaseStatuses(counts) {
+		body[status] = n
+	}
+	if usesRedisCounters() && simulatedOutage(failFeatureStore) == nil {
+		ctx, cancel := context.WithTimeout(c.Request().Context(), metricsReadTimeout)
+		consistency, err := checkCounterConsistency(ctx)
+		cancel()
+		if err != nil {
+			debugf("metrics: consistency check failed: %v", err)
+		} else {
+			body["consistency"] = consistency
+		}
+	}
+	return conditionalJSON(c, "/api/metrics", body, body)
+}
+
+// withBaseStatuses copies counts, adding 200 and 500 when missing: analysis
+// templates query $.200 and $.500 and fail on absent fields.
+func withBaseStatuses(counts map[string]float64) map[string]float64 {
+	body := map[string]float64{"200": 0, "500": 0}
+	for status, n := range counts {
+		body[status] = n
+	}
+	return body
+}
+
+// getStatusCounts returns the /api/check totals by status code, preferring
+// the counter store and falling back to the local Prometheus metrics.
+func getStatusCounts() map[string]float64 {
+	counts, _ := storeStatusCounts(redisCtx)
+
+	// If the store is empty or unavailable, fallback to Prometheus metrics
+	if len(counts) == 0 {
+		counts = localStatusCounts()
+	}
+
+	return counts
+}
+
+// versionMetricsHandler serves /api/metrics?version=, the counts of a single
+// version. It always reads through, so there is no stale snapshot to fall
+// back on.
+func versionMetricsHandler(c echo.Context, v string) error {
+	ctx, cancel := context.WithTimeout(c.Request().Context(), metricsReadTimeout)
+	byVersion, err := counterStore.GetCounts(ctx)
+	cancel()
+	if err != nil {
+		return c.JSON(http.StatusServiceUnavailable, map[string]string{"error": "Counter store unavailable"})
+	}
+	counts := byVersion[v]
+	if len(byVersion) == 0 && v == version {
+		counts = localStatusCounts()
+	}
+
+	body := withBaseStatuses(counts)
+	return conditionalJSON(c, "/api/metrics", body, body)
+}
+
+// statusKey is the shared /api/check counter for one status code and
+// version, e.g. status_200:v2, so canary and stable counts stay apart.
+func statusKey(statusCode, v string) string {
+	return fmt.Sprintf("status_%s:v%s", statusCode, strings.TrimPrefix(v, "v"))
+}
+
+const (
+	// statusVersionsKey is the set of versions that have written counters.
+	statusVersionsKey = "status_versions"
+	// statusCodesKey is the set of status codes that have been counted.
+	statusCodesKey = "status_codes"
+)
+
+// readStatusCodes lists the counted status codes. 200 and 500 are always
+// included, since counters written before the set existed aren't in it.
+func readStatusCodes(ctx context.Context, client redis.UniversalClient) ([]string, error) {
+	codes, err := client.SMembers(ctx, statusCodesKey).Result()
+	if err != nil {
+		return nil, err
+	}
+	for _, base := range []string{"200", "500"} {
+		if !slices.Contains(codes, base) {
+			codes = append(codes, base)
+		}
+	}
+	sort.Strings(codes)
+	return codes, nil
+}
+
+// readRedisVersionCounts reads the shared counters by version and status
+// code. Missing keys are left out, any other failure is returned.
+func readRedisVersionCounts(ctx context.Context, client redis.UniversalClient) (map[string]map[string]float64, error) {
+	versions, err := client.SMembers(ctx, statusVersionsKey).Result()
+	if err != nil {
+		return nil, err
+	}
+	codes, err := readStatusCodes(ctx, client)
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[string]map[string]float64, len(versions))
+	if len(versions) == 0 {
+		return byVersion, nil
+	}
+	keys := make([]string, 0, len(codes)*len(versions))
+	for _, v := range versions {
+		for _, code := range codes {
+			keys = append(keys, statusKey(code, v))
+		}
+	}
+	values, err := getKeys(ctx, client, keys)
+	if err != nil {
+		return nil, err
+	}
+	for i, v := range versions {
+		counts := map[string]float64{}
+		for j, code := range codes {
+			if value := values[i*len(codes)+j]; value != nil {
+				counts[code] = redisFloat(value)
 			}
-			// Only count /api/check endpoint
-			if endpoint == "/api/check" {
-				if statusCode == "200" {
-					count200 = m.GetCounter().GetValue()
-				} else if statusCode == "500" {
-					count500 = m.GetCounter().GetValue()
-				}
+		}
+		byVersion[v] = counts
+	}
+	return byVersion, nil
+}
+
+// redisFloat converts an MGET value, treating missing keys as zero.
+func redisFloat(value interface{}) float64 {
+	s, ok := value.(string)
+	if !ok {
+		return 0
+	}
+	f, _ := strconv.ParseFloat(s, 64)
+	return f
+}
+
+// deleteRedisStatusCounts removes the counters of every version and status
+// code, in both stores while migrating.
+func deleteRedisStatusCounts() error {
+	versions, err := redisClient.SMembers(redisCtx, statusVersionsKey).Result()
+	if err != nil {
+		return err
+	}
+	codes, err := readStatusCodes(redisCtx, redisClient)
+	if err != nil {
+		return err
+	}
+	keys := []string{statusVersionsKey, statusCodesKey}
+	for _, v := range versions {
+		for _, code := range codes {
+			keys = append(keys, statusKey(code, v))
+		}
+	}
+	for _, key := range keys {
+		mirrorCounterSet(key, 0)
+	}
+	return deleteKeys(redisCtx, redisClient, keys)
+}
+
+// localStatusCounts reads this pod's /api/check totals by status code from
+// Prometheus.
+func localStatusCounts() map[string]float64 {
+	counts := map[string]float64{}
+
+	metricChan := make(chan prometheus.Metric, 100)
+	httpRequestsTotal.Collect(metricChan)
+	close(metricChan)
+	for metric := range metricChan {
+		m := &io_prometheus_client.Metric{}
+		if err := metric.Write(m); err != nil {
+			continue
+		}
+		if m.Label == nil {
+			continue
+		}
+		var endpoint, statusCode string
+		for _, label := range m.Label {
+			if label.GetName() == "endpoint" {
+				endpoint = label.GetValue()
+			} else if label.GetName() == "status_code" {
+				statusCode = label.GetValue()
 			}
 		}
+		if endpoint == "" || statusCode == "" {
+			continue
+		}
+		// Only count /api/check endpoint
+		if endpoint == "/api/check" {
+			counts[statusCode] = m.GetCounter().GetValue()
+		}
 	}
 
-	return c.JSON(http.StatusOK, map[string]float64{
-		"200": count200,
-		"500": count500,
-	})
+	return counts
 }
 
 func getEnvOrDefault(key, defaultValue string) string {
@@ -189,71 +617,403 @@ func getEnvOrDefault(key, defaultValue string) string {
 	return defaultValue
 }
 
-func main() {
-	log.Printf("Starting server - Version: %s, Build Hash: %s", version, buildHash)
-
-	// Initialize Redis client
-	redisClient = redis.NewClient(&redis.Options{
-		Addr:         getEnvOrDefault("REDIS_ADDR", "localhost:6379"),
-		Password:     "",
-		DB:           0,
-		DialTimeout:  5 * time.Second,
-		ReadTimeout:  3 * time.Second,
-		WriteTimeout: 3 * time.Second,
-	})
+func getEnvFloatOrDefault(key string, defaultValue float64) float64 {
+	if value, exists := os.LookupEnv(key); exists {
+		if parsed, err := strconv.ParseFloat(value, 64); err == nil {
+			return parsed
+		}
+	}
+	return defaultValue
+}
 
-	// Test Redis connection
-	_, err := redisClient.Ping(redisCtx).Result()
+// splitList parses a comma-separated value, dropping empty entries.
+func splitList(value string) []string {
+	items := []string{}
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+func hostname() string {
+	if name, err := os.Hostname(); err == nil {
+		return name
+	}
+	return "unknown"
+}
+
+// parseServerFlags resolves the listen address from -bind and -port,
+// defaulting to BIND_ADDR and PORT, then the config file, so two versions
+// can run side by side locally. An empty bind address listens on every
+// interface.
+func parseServerFlags(args []string) (string, error) {
+	cfg := currentConfig()
+	defaultPort := "8080"
+	if cfg.Port != 0 {
+		defaultPort = strconv.Itoa(cfg.Port)
+	}
+	fs := flag.NewFlagSet("server", flag.ExitOnError)
+	bind := fs.String("bind", getEnvOrDefault("BIND_ADDR", cfg.BindAddr), "address to listen on, empty for all interfaces")
+	port := fs.String("port", getEnvOrDefault("PORT", defaultPort), "port to listen on")
+	fs.Parse(args)
+
+	if n, err := strconv.Atoi(*port); err != nil || n < 0 || n > 65535 {
+		return "", fmt.Errorf("invalid port %q", *port)
+	}
+	return net.JoinHostPort(*bind, *port), nil
+}
+
+// localURL addresses this server on the loopback interface, or on the bind
+// address when it only listens on one.
+func localURL(path string) string {
+	host, port, err := net.SplitHostPort(listenAddr)
 	if err != nil {
-		log.Printf("Warning: Could not connect to Redis: %v", err)
-		log.Println("Falling back to local metrics only")
-		redisClient = nil
+		port = "8080"
 	}
+	if ip := net.ParseIP(host); ip == nil || ip.IsUnspecified() {
+		host = "127.0.0.1"
+	}
+	return fmt.Sprintf("http://%s%s", net.JoinHostPort(host, port), path)
+}
 
-	e := echo.New()
-	e.HideBanner = true
-	e.Use(middleware.Logger())
-	e.Use(middleware.Recover())
-
-	// Enable CORS
-	e.Use(middleware.CORSWithConfig(middleware.CORSConfig{
-		AllowOrigins:     []string{"*"},
-		AllowMethods:     []string{http.MethodGet, http.MethodPost, http.MethodPut, http.MethodDelete},
-		AllowHeaders:     []string{"*"},
-		ExposeHeaders:    []string{"X-Version", "Authorization", "Content-Length"},
-		AllowCredentials: true,
-	}))
-
-	// Register routes
+func isTruthy(value string) bool {
+	switch value {
+	case "1", "true", "yes", "on":
+		return true
+	}
+	return false
+}
+
+// registerRoutes mounts the API on e. Routes that change how the demo
+// behaves for everyone are behind requireAdminToken.
+func registerRoutes(e *echo.Echo) {
 	e.GET("/api/metrics", metricsHandler)
+	e.GET("/api/metrics/cluster", clusterMetricsHandler)
+	e.GET("/api/instances", instancesHandler)
 	e.GET("/api/healthz", healthzHandler)
+	e.GET("/api/readyz", readyzHandler)
+	e.GET("/api/analysis/success-rate", successRateHandler)
+	e.GET("/api/status", statusHandler)
+	e.GET("/api/info", infoHandler)
+	e.GET("/api/topology", topologyHandler)
+	e.POST("/api/sessions", createSessionHandler)
+	e.GET("/api/sessions/presets", listSessionPresetsHandler)
+	e.GET("/api/sessions/:token", getSessionHandler)
+	e.DELETE("/api/sessions/:token", deleteSessionHandler)
+	e.GET("/api/timeseries", timeseriesHandler)
+	e.GET("/api/timeseries/heatmap", heatmapHandler)
+	e.POST("/api/annotations", addAnnotationHandler)
+	e.GET("/api/alerts", listAlertsHandler)
+	e.POST("/api/alerts", receiveAlertsHandler)
+	e.GET("/api/alerts/events", alertEventsHandler)
+	e.GET("/metrics", prometheusHandler)
 	e.GET("/api/check", checkHandler)
+	e.POST("/api/check/batch", checkBatchHandler)
+	e.GET("/api/check/all", checkAllHandler)
 	e.GET("/api/error-rate", getErrorRateHandler)
-	e.POST("/api/set-error-rate", setErrorRate)
-	e.POST("/api/reset-metrics", resetMetricsHandler)
+	e.GET("/api/error-rate/jitter", getErrorRateJitterHandler)
+	e.POST("/api/error-rate/jitter", setErrorRateJitterHandler, requireAdminToken)
+	e.GET("/api/error-mode", getErrorModeHandler)
+	e.POST("/api/set-error-mode", setErrorModeHandler, requireAdminToken)
+	e.GET("/api/error-rate/schedule", getErrorRateScheduleHandler)
+	e.POST("/api/error-rate/schedule", setErrorRateScheduleHandler, requireAdminToken)
+	e.DELETE("/api/error-rate/schedule", deleteErrorRateScheduleHandler, requireAdminToken)
+	e.POST("/api/set-error-rate", setErrorRate, requireAdminToken)
+	e.GET("/api/latency", getLatencyHandler)
+	e.POST("/api/set-latency", setLatencyHandler, requireAdminToken)
+	e.GET("/api/status-weights", getStatusWeightsHandler)
+	e.POST("/api/set-status-weights", setStatusWeightsHandler, requireAdminToken)
+	e.POST("/api/reset-metrics", resetMetricsHandler, requireAdminToken)
+	e.GET("/api/cert", getCertHandler)
+	e.POST("/api/cert", setCertHandler, requireAdminToken)
+	e.GET("/api/quota", getQuotaHandler)
+	e.GET("/api/rate-limit", getRateLimitHandler)
+	e.POST("/api/rate-limit", setRateLimitHandler, requireAdminToken)
+	e.GET("/api/state/export", exportStateHandler)
+	e.POST("/api/state/import", importStateHandler, requireAdminToken)
+	e.GET("/api/middleware", getMiddlewareHandler)
+	e.GET("/api/config", getConfigHandler)
+	e.POST("/api/admin/reload", reloadConfigHandler, requireAdminToken)
+	e.GET("/api/migration", getMigrationHandler)
+	e.POST("/api/rollout/abort", abortRolloutHandler)
+	e.GET("/api/democonfig", getDemoConfigHandler)
+	e.GET("/api/feature-canary", getFeatureCanaryHandler)
+	e.POST("/api/feature-canary", setFeatureCanaryHandler, requireAdminToken)
+	e.GET("/api/runs", listRunsHandler)
+	e.POST("/api/runs", startRunHandler)
+	e.GET("/api/runs/:id", getRunHandler)
+	e.GET("/api/runs/:id/export", exportRunHandler)
+	e.POST("/api/runs/:id/stop", stopRunHandler)
+	e.POST("/api/runs/:id/events", addRunEventHandler)
+	e.GET("/api/payload", payloadHandler)
+	e.GET("/api/payload/config", getPayloadConfigHandler)
+	e.POST("/api/payload/config", setPayloadConfigHandler, requireAdminToken)
+	e.GET("/api/banner", bannerHandler)
+	e.GET("/api/content", contentHandler)
+	e.GET("/api/weight-failure", getWeightFailureHandler)
+	e.GET("/api/time-bomb", getTimeBombHandler)
+	e.POST("/api/time-bomb", setTimeBombHandler, requireAdminToken)
+	e.GET("/api/blast-radius", getBlastRadiusHandler)
+	e.POST("/api/blast-radius", setBlastRadiusHandler, requireAdminToken)
+	e.GET("/api/fail-policies", getFailPoliciesHandler)
+	e.POST("/api/fail-policies", setFailPoliciesHandler, requireAdminToken)
+	e.POST("/api/weight-failure", setWeightFailureHandler, requireAdminToken)
+	e.POST("/ofrep/v1/evaluate/flags", ofrepEvaluateFlagsHandler)
+	e.POST("/ofrep/v1/evaluate/flags/:key", ofrepEvaluateFlagHandler)
+	e.GET("/api/scenarios", listScenarioRunsHandler)
+	e.GET("/api/scenarios/:id", getScenarioRunHandler)
+	e.POST("/api/scenarios/run", runScenarioHandler, requireAdminToken)
+	e.GET("/api/plugins", listPluginsHandler)
+	e.GET("/api/compat", compatHandler)
+	e.POST("/api/compat", setCompatHandler, requireAdminToken)
+	e.POST("/api/load/runs", reportLoadRunHandler)
+	e.GET("/api/load/runs/latest", latestLoadRunHandler)
+	e.POST("/api/load/assert", assertLoadHandler)
+	e.POST("/api/loadgen/start", startLoadGenHandler, requireAdminToken)
+	e.POST("/api/loadgen/stop", stopLoadGenHandler, requireAdminToken)
+	e.GET("/api/loadgen/status", loadGenStatusHandler)
+
+	// Optional routes compiled in with build tags
+	mountRoutePlugins(e)
+	mountPprof(e)
+}
 
-	// Graceful shutdown
-	go func() {
-		if err := e.Start(":8080"); err != nil && err != http.ErrServerClosed {
-			log.Fatalf("Server failed to start: %v", err)
-		}
-	}()
+func main() {
+	// Alternative run modes share the binary and image with the API server
+	mode := getEnvOrDefault("MODE", "server")
+	args := os.Args[1:]
+	if len(args) > 0 && (args[0] == "loadgen" || args[0] == "scenario" || args[0] == "healthcheck") {
+		mode, args = args[0], args[1:]
+	}
+	switch mode {
+	case "healthcheck":
+		runHealthcheckMode(args)
+		return
+	case "loadgen":
+		runLoadgenMode(args)
+		return
+	case "scenario":
+		runScenarioMode(args)
+		return
+	}
+
+	// Load optional config file
+	cfg, err := loadConfig(configFile)
+	if err != nil {
+		fatalf("Failed to load config: %v", err)
+	}
+	appConfig, startupConfig = cfg, cfg
+	applyConfig(Config{}, cfg, true)
+
+	addr, err := parseServerFlags(args)
+	if err != nil {
+		fatalf("Invalid listen address: %v", err)
+	}
+	listenAddr = addr
+	if err := payloadConfig.validate(); err != nil {
+		fatalf("Invalid payload configuration: %v", err)
+	}
+	if err := validateStartupSettings(); err != nil {
+		fatalf("Invalid configuration: %v", err)
+	}
+
+	infof("Starting server - Version: %s, Build Hash: %s, Flavor: %s, Listen: %s", version, buildHash, buildFlavor, listenAddr)
+
+	e := echo.New()
+	e.HideBanner = true
+	e.HidePort = true
+	// net/http's own errors (TLS handshakes, bad requests) at warn
+	e.StdLogger = slog.NewLogLogger(logger().Handler(), slog.LevelWarn)
+	e.Server.ConnState = trackConnState
+	applyServerLimits(e.Server)
+	if h2cEnabled {
+		enableH2C(e.Server)
+	}
+	if err := configureTLSServer(e); err != nil {
+		fatalf("Invalid TLS configuration: %v", err)
+	}
+	e.Pre(requestIDMiddleware, inFlightMiddleware, chaosBypassMiddleware, injectionMiddleware, requestDurationMiddleware, serverTimingMiddleware, bodyLimitMiddleware)
+
+	// Build the middleware pipeline in the configured order
+	activeMiddlewareOrder = middlewareOrder()
+	pipeline, err := buildMiddlewarePipeline(activeMiddlewareOrder)
+	if err != nil {
+		fatalf("Invalid middleware configuration: %v", err)
+	}
+	e.Use(pipeline...)
+	infof("Middleware pipeline: %s", strings.Join(activeMiddlewareOrder, " -> "))
+
+	registerRoutes(e)
+
+	handleRuntimeSignals()
+	components := serverComponents(e)
+	if err := components.Start(context.Background()); err != nil {
+		fatalf("Server failed to start: %v", err)
+	}
+	markStarted()
 
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, os.Interrupt, syscall.SIGTERM)
-	<-quit
+	sig := <-quit
 
-	log.Println("Shutting down server...")
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	infof("Shutting down server...")
+	drain(e, sig)
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
 	defer cancel()
 
-	if err := e.Shutdown(ctx); err != nil {
-		log.Fatalf("Server forced to shutdown: %v", err)
+	if err := components.Stop(ctx); err != nil {
+		warnf("Shutdown was not clean: %v", err)
+	}
+	if n := inFlightRequests.Load(); n > 0 {
+		warnf("Exiting with %d requests still in flight", n)
+	}
+
+	infof("Server exited")
+}
+
+// serverComponents lists what runs alongside the HTTP server and what each
+// part needs running first. Everything the handlers use starts before the
+// server, and the server stops before any of it; what drives traffic at the
+// server itself, scenarios and the scrape check, stops before the server.
+func serverComponents(e *echo.Echo) *supervisor {
+	redisAvailable := func() bool { return redisClient != nil }
+
+	errorRateSync := newWorker(runErrorRateSync).when(redisAvailable)
+	latencySync := newWorker(runLatencySync).when(redisAvailable)
+
+	components := &supervisor{}
+	components.Add("redis", lifecycleFuncs{start: connectRedis, stop: closeRedis})
+	components.Add("storage-migration", lifecycleFuncs{
+		start: func(context.Context) error {
+			// Double-write to a second store while migrating storage backends
+			if err := startStorageMigration(); err != nil {
+				warnf("Could not start storage migration: %v", err)
+			}
+			return nil
+		},
+		stop: func(context.Context) error {
+			if migrationClient != nil {
+				return migrationClient.Close()
+			}
+			return nil
+		},
+	}, "redis")
+	components.Add("counter-file", lifecycleFuncs{start: openCounterFile, stop: closeCounterFile}, "redis")
+	// Register this pod in the shared instance registry
+	components.Add("instance-registry", newWorker(runInstanceHeartbeat).when(redisAvailable), "redis")
+	// Pick up the error rate the other pods are using and follow changes
+	components.Add("error-rate-sync", lifecycleFuncs{
+		start: func(ctx context.Context) error {
+			if redisClient != nil {
+				if err := loadSharedErrorRate(ctx); err != nil {
+					warnf("Could not load shared error rate: %v", err)
+				}
+			}
+			return errorRateSync.Start(ctx)
+		},
+		stop: errorRateSync.Stop,
+	}, "redis")
+	// Likewise for the injected latency
+	components.Add("latency-sync", lifecycleFuncs{
+		start: func(ctx context.Context) error {
+			if redisClient != nil {
+				if err := loadSharedLatency(ctx); err != nil {
+					warnf("Could not load shared latency: %v", err)
+				}
+			}
+			return latencySync.Start(ctx)
+		},
+		stop: latencySync.Stop,
+	}, "redis")
+	components.Add("error-rate-jitter", newWorker(runErrorRateJitter))
+	components.Add("error-rate-schedule", newWorker(runErrorRateSchedule), "error-rate-sync")
+	components.Add("secret-reload", newWorker(runSecretReload))
+	components.Add("rollup", newWorker(runRollupWorker), "redis")
+	components.Add("session-cleanup", newWorker(runSessionCleanup), "redis")
+	components.Add("quota-sweep", newWorker(runLocalQuotaSweep))
+	components.Add("demo-config-watch", newWorker(runDemoConfigWatcher).when(func() bool { return demoConfigWatch }), "error-rate-sync")
+	components.Add("migration-compare", newWorker(runStorageMigrationCompare).when(func() bool { return migrationClient != nil }), "storage-migration")
+	components.Add("redis-reconnect", newWorker(runRedisReconnect).when(redisAvailable), "redis")
+	components.Add("counter-store", lifecycleFuncs{start: openCounterStore}, "redis")
+	components.Add("counter-batcher", newWorker(runCounterBatcher), "counter-store", "storage-migration")
+	components.Add("counter-consistency", newWorker(runCounterConsistencyCheck).when(usesRedisCounters), "counter-store")
+	components.Add("subsystems", lifecycleFuncs{stop: stopSubsystems}, "redis")
+	components.Add("http", httpServer{e: e, addr: listenAddr},
+		"counter-batcher", "storage-migration", "counter-file", "instance-registry", "error-rate-sync", "latency-sync", "error-rate-jitter",
+		"error-rate-schedule", "secret-reload", "rollup", "session-cleanup", "quota-sweep", "demo-config-watch", "counter-consistency",
+		"redis-reconnect", "subsystems")
+	components.Add("scrape-check", newWorker(runScrapeSelfCheck), "http")
+	components.Add("scenarios", lifecycleFuncs{stop: stopScenarios}, "http")
+	components.Add("cpu-burn", lifecycleFuncs{stop: shutdownCPUBurn}, "http")
+	components.Add("loadgen-api", lifecycleFuncs{stop: shutdownLoadGen}, "http")
+	if pprofEnabled && pprofAddr != "" {
+		components.Add("pprof", newPprofServer())
 	}
+	addComponentPlugins(components)
+	return components
+}
 
-	if redisClient != nil {
-		redisClient.Close()
+// connectRedis sets up the shared counter store. When Redis can't be
+// reached the circuit breaker starts out open and the server keeps going on
+// local metrics until it reconnects. An empty REDIS_ADDR runs without Redis.
+func connectRedis(ctx context.Context) error {
+	if redisAddr == "" {
+		infof("REDIS_ADDR is empty, using local metrics only")
+		return nil
 	}
+	client, err := newRedisClient()
+	if err != nil {
+		return err
+	}
+	redisClient = client
 
-	log.Println("Server exited")
+	if tracingSubsystem.enabled {
+		redisClient.AddHook(redisTraceHook{})
+	}
+	redisClient.AddHook(redisMetricsHook{store: "primary"})
+	redisClient.AddHook(redisRequestIDHook{})
+	redisClient.AddHook(redisBreakerHook{})
+
+	// Test Redis connection
+	if _, err := redisClient.Ping(ctx).Result(); err != nil {
+		warnf("Could not connect to Redis (%s mode), using local metrics until it is back: %v", redisMode, err)
+		breaker.trip(err)
+	} else {
+		redisUp.Set(1)
+	}
+	return nil
+}
+
+func closeRedis(context.Context) error {
+	if redisClient == nil {
+		return nil
+	}
+	return redisClient.Close()
+}
+
+// openCounterFile opens the memory-mapped counter file for restart-surviving
+// counts, when MMAP_COUNTERS_PATH is set.
+func openCounterFile(context.Context) error {
+	path := getEnvOrDefault("MMAP_COUNTERS_PATH", "")
+	if path == "" {
+		return nil
+	}
+	var err error
+	counterFile, err = openMmapCounters(path)
+	if err != nil {
+		warnf("Could not open counter file %s: %v", path, err)
+		counterFile = nil
+		return nil
+	}
+	restoreFromCounterFile()
+	return nil
+}
+
+func closeCounterFile(context.Context) error {
+	if counterFile == nil {
+		return nil
+	}
+	return counterFile.Close()
 }
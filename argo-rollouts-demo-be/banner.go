@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"hash/fnv"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Banner is display-ready information about the serving version so the
+// frontend doesn't need its own version-to-color mapping.
+type Banner struct {
+	Version string   `json:"version"`
+	Color   string   `json:"color"`
+	Role    string   `json:"role"`             // canary, stable or unknown
+	Weight  *float64 `json:"weight,omitempty"` // Canary traffic weight in percent, when known
+	Pod     string   `json:"pod"`
+}
+
+// Colors are picked to stay distinguishable next to each other on a chart.
+var bannerPalette = []string{"#2563eb", "#16a34a", "#dc2626", "#d97706", "#7c3aed", "#0891b2", "#db2777", "#65a30d"}
+
+// versionColor returns BANNER_COLOR when set, otherwise a stable palette
+// color derived from the version so every pod of a version agrees.
+func versionColor(v string) string {
+	if color := getEnvOrDefault("BANNER_COLOR", ""); color != "" {
+		return color
+	}
+	h := fnv.New32a()
+	h.Write([]byte(v))
+	return bannerPalette[h.Sum32()%uint32(len(bannerPalette))]
+}
+
+// rolloutRole reads the pod's role in the rollout, typically injected from
+// a pod label or the Rollout's canary/stable metadata.
+func rolloutRole() string {
+	switch role := getEnvOrDefault("ROLLOUT_ROLE", ""); role {
+	case "canary", "stable":
+		return role
+	}
+	return "unknown"
+}
+
+// Pod annotations projected by the downward API. Unlike env vars the file is
+// refreshed by the kubelet, so a weight annotated per step is picked up live.
+var (
+	podAnnotationsFile     = getEnvOrDefault("POD_ANNOTATIONS_FILE", "/etc/podinfo/annotations")
+	canaryWeightAnnotation = getEnvOrDefault("CANARY_WEIGHT_ANNOTATION", "rollouts.argoproj.io/canary-weight")
+)
+
+// podAnnotation looks up a single annotation in the downward API file, where
+// each line has the form key="escaped value".
+func podAnnotation(key string) (string, bool) {
+	f, err := os.Open(podAnnotationsFile)
+	if err != nil {
+		return "", false
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		name, quoted, ok := strings.Cut(scanner.Text(), "=")
+		if !ok || name != key {
+			continue
+		}
+		value, err := strconv.Unquote(quoted)
+		if err != nil {
+			return "", false
+		}
+		return value, true
+	}
+	return "", false
+}
+
+// canaryWeight returns the canary traffic weight in percent when known, from
+// the pod annotation first and the CANARY_WEIGHT env var second.
+func canaryWeight() (float64, bool) {
+	value, ok := podAnnotation(canaryWeightAnnotation)
+	if !ok {
+		value = getEnvOrDefault("CANARY_WEIGHT", "")
+	}
+	if value == "" {
+		return 0, false
+	}
+	weight, err := strconv.ParseFloat(value, 64)
+	if err != nil || weight < 0 || weight > 100 {
+		return 0, false
+	}
+	return weight, true
+}
+
+func bannerHandler(c echo.Context) error {
+	banner := Banner{
+		Version: version,
+		Color:   versionColor(version),
+		Role:    rolloutRole(),
+		Pod:     podName,
+	}
+	if weight, ok := canaryWeight(); ok {
+		banner.Weight = &weight
+	}
+
+	c.Response().Header().Set("X-Version", version)
+	httpRequestsTotal.WithLabelValues("/api/banner", fmt.Sprintf("%d", http.StatusOK)).Inc()
+	return c.JSON(http.StatusOK, banner)
+}
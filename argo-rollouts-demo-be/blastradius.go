@@ -0,0 +1,134 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// BlastRadius caps how many failures this pod injects per minute regardless
+// of the configured rates, so an accidental 100% error rate can't burn a
+// shared environment's whole error budget. Zero disables the cap.
+type BlastRadius struct {
+	MaxFailuresPerMinute int64 `json:"maxFailuresPerMinute"`
+}
+
+var (
+	blastRadius = BlastRadius{
+		MaxFailuresPerMinute: int64(getEnvFloatOrDefault("MAX_INJECTED_FAILURES_PER_MINUTE", 0)),
+	}
+	blastRadiusMu sync.Mutex
+
+	// Fixed one-minute window of injected failures
+	blastRadiusWindow   time.Time
+	blastRadiusInjected int64
+
+	injectedFailuresCappedTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "injected_failures_capped_total",
+			Help: "Failures that were due to be injected but suppressed by the blast-radius cap, by rule",
+		},
+		[]string{"rule"},
+	)
+)
+
+func init() {
+	promauto.NewGaugeFunc(
+		prometheus.GaugeOpts{
+			Name: "blast_radius_cap_engaged",
+			Help: "1 while the per-minute injected failure cap is exhausted, 0 otherwise",
+		},
+		func() float64 {
+			if blastRadiusEngaged() {
+				return 1
+			}
+			return 0
+		},
+	)
+}
+
+// rollBlastRadiusWindow starts a new window when the minute has changed. The
+// caller must hold blastRadiusMu.
+func rollBlastRadiusWindow(now time.Time) {
+	if window := now.UTC().Truncate(time.Minute); window.After(blastRadiusWindow) {
+		blastRadiusWindow = window
+		blastRadiusInjected = 0
+	}
+}
+
+// allowInjectedFailure reserves one failure in the current window. When the
+// cap is reached it returns false and the caller must serve the request
+// normally instead.
+func allowInjectedFailure(rule string) bool {
+	blastRadiusMu.Lock()
+	defer blastRadiusMu.Unlock()
+
+	rollBlastRadiusWindow(time.Now())
+	if blastRadius.MaxFailuresPerMinute > 0 && blastRadiusInjected >= blastRadius.MaxFailuresPerMinute {
+		injectedFailuresCappedTotal.WithLabelValues(rule).Inc()
+		return false
+	}
+	blastRadiusInjected++
+	return true
+}
+
+func blastRadiusEngaged() bool {
+	blastRadiusMu.Lock()
+	defer blastRadiusMu.Unlock()
+
+	rollBlastRadiusWindow(time.Now())
+	return blastRadius.MaxFailuresPerMinute > 0 && blastRadiusInjected >= blastRadius.MaxFailuresPerMinute
+}
+
+func getBlastRadiusHandler(c echo.Context) error {
+	blastRadiusMu.Lock()
+	rollBlastRadiusWindow(time.Now())
+	current := blastRadius
+	injected := blastRadiusInjected
+	window := blastRadiusWindow
+	blastRadiusMu.Unlock()
+
+	httpRequestsTotal.WithLabelValues("/api/blast-radius", fmt.Sprintf("%d", http.StatusOK)).Inc()
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"maxFailuresPerMinute": current.MaxFailuresPerMinute,
+		"injectedThisMinute":   injected,
+		"windowStart":          window,
+		"engaged":              current.MaxFailuresPerMinute > 0 && injected >= current.MaxFailuresPerMinute,
+	})
+}
+
+func (b BlastRadius) validate() error {
+	if b.MaxFailuresPerMinute < 0 {
+		return fmt.Errorf("maxFailuresPerMinute must not be negative")
+	}
+	return nil
+}
+
+func setBlastRadiusHandler(c echo.Context) error {
+	blastRadiusMu.Lock()
+	update := blastRadius
+	blastRadiusMu.Unlock()
+
+	if err := json.NewDecoder(c.Request().Body).Decode(&update); err != nil {
+		httpRequestsTotal.WithLabelValues("/api/blast-radius", fmt.Sprintf("%d", http.StatusBadRequest)).Inc()
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid JSON"})
+	}
+
+	if err := update.validate(); err != nil {
+		httpRequestsTotal.WithLabelValues("/api/blast-radius", fmt.Sprintf("%d", http.StatusBadRequest)).Inc()
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+
+	blastRadiusMu.Lock()
+	blastRadius = update
+	blastRadiusMu.Unlock()
+
+	httpRequestsTotal.WithLabelValues("/api/blast-radius", fmt.Sprintf("%d", http.StatusOK)).Inc()
+	return c.JSON(http.StatusOK, update)
+}
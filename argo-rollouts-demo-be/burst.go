@@ -0,0 +1,141 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const (
+	burstRule = "burst"
+
+	// Longest burst accepted, so a typo can't leave the pod failing
+	maxBurstDuration = time.Hour
+)
+
+// ErrorBurst fails every /api/check for DurationSeconds and then recovers
+// by itself, a short spike that shows analysis aborting a rollout. It
+// overrides the error rate, the error mode and the time bomb; the blast
+// radius cap and chaos bypass still apply.
+type ErrorBurst struct {
+	DurationSeconds float64   `json:"durationSeconds"`
+	StartedAt       time.Time `json:"startedAt,omitzero"` // Ignored on input
+}
+
+// ErrorBurstStatus is the burst with its remaining time.
+type ErrorBurstStatus struct {
+	ErrorBurst
+	Active           bool      `json:"active"`
+	EndsAt           time.Time `json:"endsAt,omitzero"`
+	SecondsRemaining float64   `json:"secondsRemaining"`
+}
+
+var (
+	errorBurst   ErrorBurst
+	errorBurstMu sync.RWMutex
+	// Logs the recovery, replaced when a new burst starts
+	errorBurstTimer *time.Timer
+)
+
+func init() {
+	promauto.NewGaugeFunc(
+		prometheus.GaugeOpts{
+			Name: "error_burst_seconds_remaining",
+			Help: "Seconds until the error burst ends, 0 when none is running",
+		},
+		func() float64 { return errorBurstStatus(time.Now()).SecondsRemaining },
+	)
+}
+
+func (b ErrorBurst) validate() error {
+	if math.IsNaN(b.DurationSeconds) || math.IsInf(b.DurationSeconds, 0) || b.DurationSeconds <= 0 {
+		return fmt.Errorf("durationSeconds must be a positive number")
+	}
+	if b.DurationSeconds > maxBurstDuration.Seconds() {
+		return fmt.Errorf("durationSeconds must be at most %g", maxBurstDuration.Seconds())
+	}
+	return nil
+}
+
+func errorBurstStatus(now time.Time) ErrorBurstStatus {
+	errorBurstMu.RLock()
+	current := errorBurst
+	errorBurstMu.RUnlock()
+
+	status := ErrorBurstStatus{ErrorBurst: current}
+	if current.StartedAt.IsZero() {
+		return status
+	}
+	status.EndsAt = current.StartedAt.Add(time.Duration(current.DurationSeconds * float64(time.Second)))
+	if remaining := status.EndsAt.Sub(now); remaining > 0 {
+		status.Active = true
+		status.SecondsRemaining = remaining.Seconds()
+	}
+	return status
+}
+
+// burstActive reports whether checks are being failed by a burst.
+func burstActive() bool {
+	return errorBurstStatus(time.Now()).Active
+}
+
+// setErrorBurst starts a burst, or ends the running one with a zero burst.
+func setErrorBurst(burst ErrorBurst) {
+	errorBurstMu.Lock()
+	defer errorBurstMu.Unlock()
+	errorBurst = burst
+	if errorBurstTimer != nil {
+		errorBurstTimer.Stop()
+		errorBurstTimer = nil
+	}
+	if burst.StartedAt.IsZero() {
+		return
+	}
+	errorBurstTimer = time.AfterFunc(time.Duration(burst.DurationSeconds*float64(time.Second)), func() {
+		infof("Error burst over after %gs, checks recover", burst.DurationSeconds)
+	})
+}
+
+func getErrorBurstHandler(c echo.Context) error {
+	httpRequestsTotal.WithLabelValues("/api/chaos/burst", fmt.Sprintf("%d", http.StatusOK)).Inc()
+	return c.JSON(http.StatusOK, errorBurstStatus(time.Now()))
+}
+
+// startErrorBurstHandler starts a burst, replacing one already running.
+func startErrorBurstHandler(c echo.Context) error {
+	var burst ErrorBurst
+	if err := json.NewDecoder(c.Request().Body).Decode(&burst); err != nil {
+		httpRequestsTotal.WithLabelValues("/api/chaos/burst", fmt.Sprintf("%d", http.StatusBadRequest)).Inc()
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid JSON"})
+	}
+	if err := burst.validate(); err != nil {
+		httpRequestsTotal.WithLabelValues("/api/chaos/burst", fmt.Sprintf("%d", http.StatusBadRequest)).Inc()
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+	now := time.Now().UTC()
+	burst.StartedAt = now
+	setErrorBurst(burst)
+	warnf("Error burst: failing every check for %gs", burst.DurationSeconds)
+
+	httpRequestsTotal.WithLabelValues("/api/chaos/burst", fmt.Sprintf("%d", http.StatusOK)).Inc()
+	return c.JSON(http.StatusOK, errorBurstStatus(now))
+}
+
+// stopErrorBurstHandler ends a burst early.
+func stopErrorBurstHandler(c echo.Context) error {
+	wasActive := burstActive()
+	setErrorBurst(ErrorBurst{})
+	if wasActive {
+		infof("Error burst stopped early, checks recover")
+	}
+
+	httpRequestsTotal.WithLabelValues("/api/chaos/burst", fmt.Sprintf("%d", http.StatusOK)).Inc()
+	return c.JSON(http.StatusOK, errorBurstStatus(time.Now()))
+}
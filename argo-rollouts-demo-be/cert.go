@@ -0,0 +1,141 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// SimulatedCert is a fake upstream TLS certificate. Nothing is actually
+// served with it; it only exists so the demo can show expiry alerts and
+// readiness gates without minting real certificates.
+type SimulatedCert struct {
+	Subject   string    `json:"subject"`
+	NotBefore time.Time `json:"notBefore"`
+	NotAfter  time.Time `json:"notAfter"`
+}
+
+type CertStatus struct {
+	SimulatedCert
+	ExpiresInSeconds     float64 `json:"expiresInSeconds"`
+	MinValiditySeconds   float64 `json:"minValiditySeconds"`
+	Expired              bool    `json:"expired"`
+	BelowMinimumValidity bool    `json:"belowMinimumValidity"`
+}
+
+type CertUpdate struct {
+	ExpiresInSeconds *float64 `json:"expiresInSeconds"`
+	Subject          string   `json:"subject"`
+}
+
+// Furthest a simulated certificate can expire from now, either way. Well
+// inside what a time.Duration can hold (about 292 years).
+const certMaxExpiresIn = 100 * 365 * 24 * time.Hour
+
+var (
+	cert               SimulatedCert
+	certMu             sync.RWMutex
+	certMinValiditySec = getEnvFloatOrDefault("CERT_MIN_VALIDITY_SECONDS", 0)
+)
+
+func init() {
+	now := time.Now()
+	validity := getEnvFloatOrDefault("CERT_VALIDITY_SECONDS", (90 * 24 * time.Hour).Seconds())
+	cert = SimulatedCert{
+		Subject:   getEnvOrDefault("CERT_SUBJECT", "CN=argo-rollouts-demo-be"),
+		NotBefore: now,
+		NotAfter:  now.Add(time.Duration(validity * float64(time.Second))),
+	}
+
+	promauto.NewGaugeFunc(
+		prometheus.GaugeOpts{
+			Name: "cert_expiry_seconds",
+			Help: "Seconds until the simulated upstream certificate expires (negative once expired)",
+		},
+		func() float64 {
+			return certRemaining().Seconds()
+		},
+	)
+}
+
+func certRemaining() time.Duration {
+	certMu.RLock()
+	defer certMu.RUnlock()
+	return time.Until(cert.NotAfter)
+}
+
+func currentCertStatus() CertStatus {
+	certMu.RLock()
+	current := cert
+	certMu.RUnlock()
+
+	remaining := time.Until(current.NotAfter).Seconds()
+	return CertStatus{
+		SimulatedCert:        current,
+		ExpiresInSeconds:     remaining,
+		MinValiditySeconds:   certMinValiditySec,
+		Expired:              remaining <= 0,
+		BelowMinimumValidity: remaining < certMinValiditySec,
+	}
+}
+
+// certReady reports whether the simulated certificate is valid for at least
+// CERT_MIN_VALIDITY_SECONDS, returning a short reason when it is not.
+func certReady() (bool, string) {
+	status := currentCertStatus()
+	if status.Expired {
+		return false, "certificate expired"
+	}
+	if status.BelowMinimumValidity {
+		return false, fmt.Sprintf("certificate expires in %.0fs (minimum %.0fs)", status.ExpiresInSeconds, status.MinValiditySeconds)
+	}
+	return true, "ok"
+}
+
+func (u CertUpdate) validate() error {
+	if u.ExpiresInSeconds == nil {
+		return errors.New("expiresInSeconds is required")
+	}
+	if seconds := *u.ExpiresInSeconds; math.IsNaN(seconds) || math.Abs(seconds) > certMaxExpiresIn.Seconds() {
+		return fmt.Errorf("expiresInSeconds must be between -%.0f and %.0f", certMaxExpiresIn.Seconds(), certMaxExpiresIn.Seconds())
+	}
+	return nil
+}
+
+func getCertHandler(c echo.Context) error {
+	httpRequestsTotal.WithLabelValues("/api/cert", fmt.Sprintf("%d", http.StatusOK)).Inc()
+	return c.JSON(http.StatusOK, currentCertStatus())
+}
+
+func setCertHandler(c echo.Context) error {
+	var update CertUpdate
+	if err := json.NewDecoder(c.Request().Body).Decode(&update); err != nil {
+		httpRequestsTotal.WithLabelValues("/api/cert", fmt.Sprintf("%d", http.StatusBadRequest)).Inc()
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid JSON"})
+	}
+
+	if err := update.validate(); err != nil {
+		httpRequestsTotal.WithLabelValues("/api/cert", fmt.Sprintf("%d", http.StatusBadRequest)).Inc()
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+
+	now := time.Now()
+	certMu.Lock()
+	cert.NotBefore = now
+	cert.NotAfter = now.Add(time.Duration(*update.ExpiresInSeconds * float64(time.Second)))
+	if update.Subject != "" {
+		cert.Subject = update.Subject
+	}
+	certMu.Unlock()
+
+	httpRequestsTotal.WithLabelValues("/api/cert", fmt.Sprintf("%d", http.StatusOK)).Inc()
+	return c.JSON(http.StatusOK, currentCertStatus())
+}
@@ -0,0 +1,20 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+// Values past what a time.Duration holds would wrap the expiry around.
+func TestCertUpdateBoundsExpiresIn(t *testing.T) {
+	for _, seconds := range []float64{math.NaN(), math.Inf(1), 1e12, -1e12} {
+		if err := (CertUpdate{ExpiresInSeconds: &seconds}).validate(); err == nil {
+			t.Errorf("expiresInSeconds %g: want an error", seconds)
+		}
+	}
+	for _, seconds := range []float64{-3600, 0, 90 * 24 * 3600} {
+		if err := (CertUpdate{ExpiresInSeconds: &seconds}).validate(); err != nil {
+			t.Errorf("expiresInSeconds %g: %v", seconds, err)
+		}
+	}
+}
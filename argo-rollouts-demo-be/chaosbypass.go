@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// A request carrying a valid bypass token, in the X-Chaos-Bypass header or
+// the chaos_bypass query parameter, gets no injected failures or latency,
+// so pre-promotion smoke tests see the canary's real behavior. The token is
+// "<expiry>.<signature>": the expiry in Unix seconds and the hex
+// HMAC-SHA256 of "chaos-bypass:<expiry>" keyed with ADMIN_TOKEN, e.g.
+//
+//	exp=$(( $(date +%s) + 300 ))
+//	sig=$(printf 'chaos-bypass:%s' "$exp" | openssl dgst -sha256 -hmac "$ADMIN_TOKEN" -r | cut -d' ' -f1)
+//	curl -H "X-Chaos-Bypass: $exp.$sig" .../api/check
+const (
+	chaosBypassHeader = "X-Chaos-Bypass"
+	chaosBypassParam  = "chaos_bypass"
+)
+
+var (
+	// Tokens expiring further out than this are refused, so a leaked one
+	// can't switch chaos off for long
+	chaosBypassMaxTTL = time.Duration(getEnvFloatOrDefault("CHAOS_BYPASS_MAX_TTL_SECONDS", 900) * float64(time.Second))
+
+	errChaosBypassDisabled  = errors.New("chaos bypass needs ADMIN_TOKEN to be set")
+	errChaosBypassMalformed = errors.New("malformed chaos bypass token")
+	errChaosBypassExpired   = errors.New("chaos bypass token expired")
+	errChaosBypassTooLong   = errors.New("chaos bypass token expires too far in the future")
+	errChaosBypassSignature = errors.New("invalid chaos bypass signature")
+
+	chaosBypassRequestsTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "chaos_bypass_requests_total",
+			Help: "Requests carrying a chaos bypass token, by result (applied or rejected)",
+		},
+		[]string{"result"},
+	)
+)
+
+type chaosBypassKey struct{}
+
+// chaosBypassed reports whether injected chaos is off for the request.
+func chaosBypassed(ctx context.Context) bool {
+	bypassed, _ := ctx.Value(chaosBypassKey{}).(bool)
+	return bypassed
+}
+
+func chaosBypassSignature(key string, expiry int64) string {
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write([]byte("chaos-bypass:" + strconv.FormatInt(expiry, 10)))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// verifyChaosBypass checks a token against the current ADMIN_TOKEN.
+func verifyChaosBypass(token string, now time.Time) error {
+	key := adminToken.Value()
+	if key == "" {
+		return errChaosBypassDisabled
+	}
+	expiryText, signature, ok := strings.Cut(token, ".")
+	if !ok {
+		return errChaosBypassMalformed
+	}
+	expiry, err := strconv.ParseInt(expiryText, 10, 64)
+	if err != nil {
+		return errChaosBypassMalformed
+	}
+	// The signature is checked first so unsigned tokens learn nothing
+	if !hmac.Equal([]byte(signature), []byte(chaosBypassSignature(key, expiry))) {
+		return errChaosBypassSignature
+	}
+	expiresAt := time.Unix(expiry, 0)
+	if !now.Before(expiresAt) {
+		return errChaosBypassExpired
+	}
+	if expiresAt.Sub(now) > chaosBypassMaxTTL {
+		return errChaosBypassTooLong
+	}
+	return nil
+}
+
+// withChaosBypass verifies the request's bypass token, if it has one, and
+// returns the context to use for it.
+func withChaosBypass(ctx context.Context, token string) (context.Context, error) {
+	if token == "" {
+		return ctx, nil
+	}
+	if err := verifyChaosBypass(token, time.Now()); err != nil {
+		chaosBypassRequestsTotal.WithLabelValues("rejected").Inc()
+		return ctx, err
+	}
+	chaosBypassRequestsTotal.WithLabelValues("applied").Inc()
+	return context.WithValue(ctx, chaosBypassKey{}, true), nil
+}
+
+// chaosBypassMiddleware runs in e.Pre, ahead of everything that injects
+// chaos. A bad token is refused rather than ignored, so a smoke test can't
+// pass by accident against a canary that is still failing on purpose.
+func chaosBypassMiddleware(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		req := c.Request()
+		token := req.Header.Get(chaosBypassHeader)
+		if token == "" {
+			token = req.URL.Query().Get(chaosBypassParam)
+		}
+		ctx, err := withChaosBypass(req.Context(), token)
+		if err != nil {
+			debugContextf(req.Context(), "chaos bypass: refused %s %s: %v", req.Method, req.URL.Path, err)
+			return c.JSON(http.StatusForbidden, map[string]string{"error": err.Error()})
+		}
+		if ctx != req.Context() {
+			c.SetRequest(req.WithContext(ctx))
+			c.Response().Header().Set(chaosBypassHeader, "applied")
+		}
+		return next(c)
+	}
+}
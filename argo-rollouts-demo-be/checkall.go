@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// PeerCheck is one pod's answer to a fanned-out /api/check.
+type PeerCheck struct {
+	Pod       string  `json:"pod"`
+	Version   string  `json:"version"` // As registered, the response's own when it answered
+	Addr      string  `json:"addr,omitempty"`
+	Ready     bool    `json:"ready"` // From the registry heartbeat
+	Status    int     `json:"status,omitempty"`
+	CodePath  string  `json:"codePath,omitempty"`
+	LatencyMs float64 `json:"latencyMs"`
+	Error     string  `json:"error,omitempty"` // The pod could not be reached
+}
+
+// CheckAllReport is the /api/check/all response.
+type CheckAllReport struct {
+	Results   []PeerCheck              `json:"results"`
+	ByVersion map[string]ClusterCounts `json:"byVersion"` // Unreachable pods count as failed
+	Source    string                   `json:"source"`    // "redis" or "local"
+}
+
+var (
+	checkAllTimeout = time.Duration(getEnvFloatOrDefault("CHECK_ALL_TIMEOUT_SECONDS", 3) * float64(time.Second))
+
+	// Failures are what the fan-out shows, so they must not be retried away
+	peerCheckClient = newOutboundClient(outboundClientConfig{Name: "peer-check", NoRetry: true})
+)
+
+// checkPeer sends one /api/check to a pod, passing on the caller's client
+// ID and chaos bypass token so every pod answers as it would the caller.
+func checkPeer(ctx context.Context, instance Instance, header http.Header) PeerCheck {
+	result := PeerCheck{Pod: instance.Pod, Version: instance.Version, Addr: instance.Addr, Ready: instance.Ready}
+
+	url := "http://" + instance.Addr + "/api/check?verbose=true"
+	if instance.Pod == podName {
+		url = localURL("/api/check?verbose=true")
+	} else if instance.Addr == "" {
+		result.Error = "no address registered, set POD_IP or INSTANCE_ADDR"
+		return result
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	for _, name := range []string{"X-Client-ID", chaosBypassHeader} {
+		if value := header.Get(name); value != "" {
+			req.Header.Set(name, value)
+		}
+	}
+
+	start := time.Now()
+	resp, err := peerCheckClient.Do(req)
+	result.LatencyMs = float64(time.Since(start).Microseconds()) / 1000.0
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	defer resp.Body.Close()
+
+	result.Status = resp.StatusCode
+	result.CodePath = resp.Header.Get("X-Code-Path")
+	if responder := resp.Header.Get("X-Version"); responder != "" {
+		result.Version = responder
+	}
+	var body CheckResult
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 64*1024)).Decode(&body); err == nil && body.Pod != "" && body.Pod != instance.Pod {
+		// Behind a shared address another pod may have answered
+		result.Error = fmt.Sprintf("answered by pod %s", body.Pod)
+	}
+	return result
+}
+
+// checkAllHandler fans /api/check out to every registered pod at once, so
+// the UI can show both versions are live and how each pod fails. Pods are
+// reached directly at their registered address, bypassing the Service and
+// the rollout's traffic split.
+func checkAllHandler(c echo.Context) error {
+	instances := []Instance{localInstance()}
+	source := "local"
+	if redisClient != nil {
+		registered, err := listInstances()
+		if err != nil {
+			httpRequestsTotal.WithLabelValues("/api/check/all", fmt.Sprintf("%d", http.StatusServiceUnavailable)).Inc()
+			return c.JSON(http.StatusServiceUnavailable, map[string]string{"error": err.Error()})
+		}
+		instances, source = registered, "redis"
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request().Context(), checkAllTimeout)
+	defer cancel()
+
+	results := make([]PeerCheck, len(instances))
+	var wg sync.WaitGroup
+	for i, instance := range instances {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i] = checkPeer(ctx, instance, c.Request().Header)
+		}()
+	}
+	wg.Wait()
+	sort.Slice(results, func(i, j int) bool { return results[i].Pod < results[j].Pod })
+
+	byVersion := map[string][2]float64{}
+	for _, result := range results {
+		v := byVersion[result.Version]
+		if result.Error == "" && result.Status == http.StatusOK {
+			v[0]++
+		} else {
+			v[1]++
+		}
+		byVersion[result.Version] = v
+	}
+	report := CheckAllReport{Results: results, ByVersion: map[string]ClusterCounts{}, Source: source}
+	for v, counts := range byVersion {
+		report.ByVersion[v] = newClusterCounts(counts[0], counts[1])
+	}
+
+	httpRequestsTotal.WithLabelValues("/api/check/all", fmt.Sprintf("%d", http.StatusOK)).Inc()
+	return c.JSON(http.StatusOK, report)
+}
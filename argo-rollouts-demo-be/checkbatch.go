@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	defaultCheckBatchSize = 100
+	maxCheckBatchSize     = 10000
+)
+
+// CheckBatchResult summarizes N simulated checks performed in one request.
+type CheckBatchResult struct {
+	N                   int              `json:"n"`
+	Counts              map[string]int64 `json:"counts"`
+	ObservedErrorRate   float64          `json:"observedErrorRate"`   // Percentage of non-200 outcomes, as in /api/metrics
+	ConfiguredErrorRate float64          `json:"configuredErrorRate"` // Percentage for the code path
+	CodePath            string           `json:"codePath"`
+	Version             string           `json:"version"`
+	Pod                 string           `json:"pod"`
+}
+
+// checkBatchHandler performs n simulated checks in one HTTP request, so
+// environments with limited client-side RPS can still build up enough
+// samples for the analysis to be significant. Each check is counted exactly
+// like a request to /api/check.
+func checkBatchHandler(c echo.Context) error {
+	n := defaultCheckBatchSize
+	if value := c.QueryParam("n"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 1 || parsed > maxCheckBatchSize {
+			httpRequestsTotal.WithLabelValues("/api/check/batch", fmt.Sprintf("%d", http.StatusBadRequest)).Inc()
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"error": fmt.Sprintf("n must be between 1 and %d", maxCheckBatchSize),
+			})
+		}
+		n = parsed
+	}
+
+	codePath := codePathFor(c)
+	ctx := c.Request().Context()
+	counts := map[int]int64{}
+	var configured float64
+	for i := 0; i < n; i++ {
+		var statusCode int
+		statusCode, configured = simulateCheck(ctx, codePath)
+		counts[statusCode]++
+	}
+	if err := recordCheckCounts(ctx, counts); err != nil {
+		httpRequestsTotal.WithLabelValues("/api/check/batch", fmt.Sprintf("%d", http.StatusServiceUnavailable)).Inc()
+		return c.JSON(http.StatusServiceUnavailable, map[string]string{"error": "Counter store unavailable"})
+	}
+	countCheckOutcomes(ctx, codePath, counts)
+	for statusCode, count := range counts {
+		recordRollupEvent(rollupEvent{status: statusCode, n: count})
+	}
+
+	result := CheckBatchResult{
+		N:                   n,
+		Counts:              map[string]int64{},
+		ObservedErrorRate:   float64(int64(n)-counts[http.StatusOK]) / float64(n) * 100.0,
+		ConfiguredErrorRate: configured * 100.0,
+		CodePath:            codePath,
+		Version:             version,
+		Pod:                 podName,
+	}
+	for statusCode, count := range counts {
+		result.Counts[strconv.Itoa(statusCode)] = count
+	}
+
+	debugContextf(ctx, "check batch: code_path=%s n=%d counts=%v", codePath, n, counts)
+
+	c.Response().Header().Set("X-Version", version)
+	c.Response().Header().Set("X-Code-Path", codePath)
+	httpRequestsTotal.WithLabelValues("/api/check/batch", fmt.Sprintf("%d", http.StatusOK)).Inc()
+	return c.JSON(http.StatusOK, result)
+}
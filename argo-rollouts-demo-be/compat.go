@@ -0,0 +1,207 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/labstack/echo/v4"
+)
+
+// FrontendCompat is the range of frontend versions this backend serves.
+// Either bound may be empty for no limit. Raising the minimum past the
+// deployed frontend is how a demo shows a contract-skew failure on purpose.
+type FrontendCompat struct {
+	MinFrontendVersion string `json:"minFrontendVersion"`
+	MaxFrontendVersion string `json:"maxFrontendVersion"`
+}
+
+// CompatReport is the /api/compat response.
+type CompatReport struct {
+	BackendVersion string `json:"backendVersion"`
+	Flavor         string `json:"flavor"`
+	FrontendCompat
+	Capabilities map[string]bool `json:"capabilities"`
+
+	// Only when the caller sent its version
+	FrontendVersion string `json:"frontendVersion,omitempty"`
+	Compatible      *bool  `json:"compatible,omitempty"`
+	Reason          string `json:"reason,omitempty"`
+}
+
+// compatCapabilities are the optional features a frontend may look for,
+// each detected by the route it needs, so builds that leave a feature out
+// report it missing.
+var compatCapabilities = map[string]string{
+	"flags":         "POST /ofrep/v1/evaluate/flags",
+	"sse":           "GET /api/metrics/stream",
+	"websocket":     "GET /ws",
+	"heatmap":       "GET /api/timeseries/heatmap",
+	"timeseries":    "GET /api/timeseries",
+	"sessions":      "POST /api/sessions",
+	"successRate":   "GET /api/analysis/success-rate",
+	"statusWeights": "GET /api/status-weights",
+	"chaos":         "GET /api/faults",
+	"checkAll":      "GET /api/check/all",
+	"info":          "GET /api/info",
+	"errorMode":     "POST /api/set-error-mode",
+	"burst":         "POST /api/chaos/burst",
+	"cpuBurn":       "POST /api/chaos/cpu",
+	"crash":         "POST /api/chaos/crash",
+	"loadgen":       "POST /api/loadgen/start",
+}
+
+var (
+	frontendCompat = FrontendCompat{
+		MinFrontendVersion: getEnvOrDefault("FRONTEND_MIN_VERSION", "1.0.0"),
+		MaxFrontendVersion: getEnvOrDefault("FRONTEND_MAX_VERSION", ""),
+	}
+	frontendCompatMu sync.RWMutex
+)
+
+func init() {
+	if err := frontendCompat.validate(); err != nil {
+		warnf("Invalid FRONTEND_MIN_VERSION/FRONTEND_MAX_VERSION, accepting any frontend: %v", err)
+		frontendCompat = FrontendCompat{}
+	}
+}
+
+// parseSemver reads "1.2.3", with an optional "v" prefix and missing parts
+// taken as 0. Pre-release and build suffixes are ignored.
+func parseSemver(value string) ([3]int, error) {
+	var parts [3]int
+	trimmed := strings.TrimPrefix(strings.TrimSpace(value), "v")
+	if i := strings.IndexAny(trimmed, "-+"); i >= 0 {
+		trimmed = trimmed[:i]
+	}
+	fields := strings.Split(trimmed, ".")
+	if trimmed == "" || len(fields) > 3 {
+		return parts, fmt.Errorf("%q is not a version like 1.2.3", value)
+	}
+	for i, field := range fields {
+		n, err := strconv.Atoi(field)
+		if err != nil || n < 0 {
+			return parts, fmt.Errorf("%q is not a version like 1.2.3", value)
+		}
+		parts[i] = n
+	}
+	return parts, nil
+}
+
+func compareSemver(a, b [3]int) int {
+	for i := range a {
+		if a[i] != b[i] {
+			if a[i] < b[i] {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
+
+func (f FrontendCompat) validate() error {
+	var bounds [][3]int
+	for _, value := range []string{f.MinFrontendVersion, f.MaxFrontendVersion} {
+		if value == "" {
+			continue
+		}
+		parsed, err := parseSemver(value)
+		if err != nil {
+			return err
+		}
+		bounds = append(bounds, parsed)
+	}
+	if len(bounds) == 2 && compareSemver(bounds[0], bounds[1]) > 0 {
+		return fmt.Errorf("minFrontendVersion must not be above maxFrontendVersion")
+	}
+	return nil
+}
+
+// check reports whether a frontend version is in range, and why not.
+func (f FrontendCompat) check(frontend [3]int) (bool, string) {
+	if f.MinFrontendVersion != "" {
+		if lowest, err := parseSemver(f.MinFrontendVersion); err == nil && compareSemver(frontend, lowest) < 0 {
+			return false, fmt.Sprintf("frontend is older than the minimum %s", f.MinFrontendVersion)
+		}
+	}
+	if f.MaxFrontendVersion != "" {
+		if highest, err := parseSemver(f.MaxFrontendVersion); err == nil && compareSemver(frontend, highest) > 0 {
+			return false, fmt.Sprintf("frontend is newer than the maximum %s", f.MaxFrontendVersion)
+		}
+	}
+	return true, ""
+}
+
+func currentCapabilities(e *echo.Echo) map[string]bool {
+	mounted := map[string]bool{}
+	for _, route := range e.Routes() {
+		mounted[route.Method+" "+route.Path] = true
+	}
+	capabilities := make(map[string]bool, len(compatCapabilities))
+	for name, route := range compatCapabilities {
+		capabilities[name] = mounted[route]
+	}
+	return capabilities
+}
+
+// compatHandler serves GET /api/compat. A frontend passes its version as
+// ?frontend= or X-Frontend-Version and gets "compatible" back; without
+// one only the supported range and capabilities are reported.
+func compatHandler(c echo.Context) error {
+	frontendCompatMu.RLock()
+	current := frontendCompat
+	frontendCompatMu.RUnlock()
+
+	report := CompatReport{
+		BackendVersion: version,
+		Flavor:         buildFlavor,
+		FrontendCompat: current,
+		Capabilities:   currentCapabilities(c.Echo()),
+	}
+
+	frontend := c.QueryParam("frontend")
+	if frontend == "" {
+		frontend = c.Request().Header.Get("X-Frontend-Version")
+	}
+	if frontend != "" {
+		parsed, err := parseSemver(frontend)
+		if err != nil {
+			httpRequestsTotal.WithLabelValues("/api/compat", fmt.Sprintf("%d", http.StatusBadRequest)).Inc()
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+		}
+		compatible, reason := current.check(parsed)
+		report.FrontendVersion = frontend
+		report.Compatible = &compatible
+		report.Reason = reason
+	}
+
+	httpRequestsTotal.WithLabelValues("/api/compat", fmt.Sprintf("%d", http.StatusOK)).Inc()
+	return c.JSON(http.StatusOK, report)
+}
+
+// setCompatHandler changes the supported frontend range at runtime.
+func setCompatHandler(c echo.Context) error {
+	frontendCompatMu.RLock()
+	update := frontendCompat
+	frontendCompatMu.RUnlock()
+
+	if err := json.NewDecoder(c.Request().Body).Decode(&update); err != nil {
+		httpRequestsTotal.WithLabelValues("/api/compat", fmt.Sprintf("%d", http.StatusBadRequest)).Inc()
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid JSON"})
+	}
+	if err := update.validate(); err != nil {
+		httpRequestsTotal.WithLabelValues("/api/compat", fmt.Sprintf("%d", http.StatusBadRequest)).Inc()
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+
+	frontendCompatMu.Lock()
+	frontendCompat = update
+	frontendCompatMu.Unlock()
+
+	httpRequestsTotal.WithLabelValues("/api/compat", fmt.Sprintf("%d", http.StatusOK)).Inc()
+	return c.JSON(http.StatusOK, update)
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+type representationVersion struct {
+	etag     string
+	modified time.Time
+}
+
+var (
+	// Last representation served per endpoint, so Last-Modified only moves
+	// when the content actually changes
+	representations   = map[string]representationVersion{}
+	representationsMu sync.Mutex
+
+	conditionalResponsesTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "conditional_responses_total",
+			Help: "Responses from endpoints supporting conditional requests, by endpoint and status code (200 or 304)",
+		},
+		[]string{"endpoint", "status_code"},
+	)
+)
+
+// trackRepresentation returns the ETag and Last-Modified time for the
+// content identified by fingerprint.
+func trackRepresentation(endpoint string, fingerprint []byte) (string, time.Time) {
+	etag := `W/"` + contentHash(fingerprint) + `"`
+
+	representationsMu.Lock()
+	defer representationsMu.Unlock()
+
+	current, ok := representations[endpoint]
+	if !ok || current.etag != etag {
+		current = representationVersion{etag: etag, modified: time.Now().UTC().Truncate(time.Second)}
+		representations[endpoint] = current
+	}
+	return current.etag, current.modified
+}
+
+// contentHash is a short deterministic digest of the content, used for
+// ETags and exposed to clients comparing responses.
+func contentHash(content []byte) string {
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:8])
+}
+
+// etagMatches reports whether If-None-Match lists the ETag, using the weak
+// comparison RFC 9110 prescribes for it.
+func etagMatches(r *http.Request, etag string) bool {
+	for _, candidate := range strings.Split(r.Header.Get("If-None-Match"), ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
+
+// notModified evaluates If-None-Match, falling back to If-Modified-Since
+// only when no entity tag was sent, as RFC 9110 requires.
+func notModified(r *http.Request, etag string, modified time.Time) bool {
+	if r.Header.Get("If-None-Match") != "" {
+		return etagMatches(r, etag)
+	}
+	if since := r.Header.Get("If-Modified-Since"); since != "" {
+		if t, err := http.ParseTime(since); err == nil {
+			return !modified.After(t)
+		}
+	}
+	return false
+}
+
+// conditionalJSON writes body as JSON with validators, or a bare 304 when the
+// client's copy is current. fingerprint is what identifies the content; it
+// can leave out fields that change on every call, like uptime.
+func conditionalJSON(c echo.Context, endpoint string, body, fingerprint interface{}) error {
+	encoded, err := json.Marshal(fingerprint)
+	if err != nil {
+		return err
+	}
+	etag, modified := trackRepresentation(endpoint, encoded)
+
+	header := c.Response().Header()
+	header.Set("ETag", etag)
+	header.Set("Last-Modified", modified.Format(http.TimeFormat))
+	header.Set("Cache-Control", "no-cache")
+
+	if notModified(c.Request(), etag, modified) {
+		conditionalResponsesTotal.WithLabelValues(endpoint, "304").Inc()
+		return c.NoContent(http.StatusNotModified)
+	}
+	conditionalResponsesTotal.WithLabelValues(endpoint, "200").Inc()
+	return c.JSON(http.StatusOK, body)
+}
@@ -0,0 +1,268 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"reflect"
+	"slices"
+	"strconv"
+	"sync"
+
+	"github.com/labstack/echo/v4"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.yaml.in/yaml/v2"
+)
+
+// Config holds the optional file-based configuration loaded from CONFIG_FILE.
+// Environment variables always take precedence over values in the file.
+// The file is read again on SIGHUP or POST /api/admin/reload; the error
+// rate, latency, CORS origins and faults then change in place, while the
+// listen address, Redis address and middleware need a restart.
+//
+//	port: 8081
+//	redis:
+//	  addr: redis:6379
+//	errorRate: 5
+//	latency: {minMs: 50, maxMs: 200}
+//	corsOrigins: [https://demo.example.com]
+type Config struct {
+	Port        int           `yaml:"port" json:"port,omitempty"`         // PORT
+	BindAddr    string        `yaml:"bindAddr" json:"bindAddr,omitempty"` // BIND_ADDR
+	Redis       RedisConfig   `yaml:"redis" json:"redis"`
+	ErrorRate   *float64      `yaml:"errorRate" json:"errorRate,omitempty"` // Percentage, ERROR_RATE
+	Latency     *CheckLatency `yaml:"latency" json:"latency,omitempty"`     // CHECK_LATENCY_MIN_MS/MAX_MS
+	CORSOrigins []string      `yaml:"corsOrigins" json:"corsOrigins,omitempty"`
+	Middleware  []string      `yaml:"middleware" json:"middleware"`
+	Faults      *FaultConfig  `yaml:"faults" json:"faults,omitempty"`
+}
+
+type RedisConfig struct {
+	Addr       string `yaml:"addr" json:"addr,omitempty"`             // REDIS_ADDR
+	Mode       string `yaml:"mode" json:"mode,omitempty"`             // REDIS_MODE
+	MasterName string `yaml:"masterName" json:"masterName,omitempty"` // REDIS_MASTER_NAME
+}
+
+var (
+	configFile = getEnvOrDefault("CONFIG_FILE", "")
+	appConfig  Config
+	configMu   sync.RWMutex
+
+	// What the process started with, for settings only a restart applies
+	startupConfig Config
+
+	configReloadsTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "config_reloads_total",
+			Help: "Config file reloads by result",
+		},
+		[]string{"result"},
+	)
+)
+
+func loadConfig(path string) (Config, error) {
+	var cfg Config
+	if path == "" {
+		return cfg, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return cfg, fmt.Errorf("reading config file: %w", err)
+	}
+	if err := yaml.UnmarshalStrict(data, &cfg); err != nil {
+		return cfg, fmt.Errorf("parsing config file: %w", err)
+	}
+	if err := cfg.validate(); err != nil {
+		return cfg, fmt.Errorf("config file: %w", err)
+	}
+
+	infof("Loaded config from %s", path)
+	return cfg, nil
+}
+
+func (cfg Config) validate() error {
+	if cfg.Port < 0 || cfg.Port > 65535 {
+		return fmt.Errorf("port must be between 0 and 65535")
+	}
+	if cfg.ErrorRate != nil {
+		if err := validateErrorRatePercent(*cfg.ErrorRate); err != nil {
+			return fmt.Errorf("errorRate: %w", err)
+		}
+	}
+	if cfg.Latency != nil {
+		if err := cfg.Latency.validate(); err != nil {
+			return fmt.Errorf("latency: %w", err)
+		}
+	}
+	if cfg.Faults != nil {
+		if err := cfg.Faults.validate(); err != nil {
+			return fmt.Errorf("faults: %w", err)
+		}
+	}
+	return nil
+}
+
+// Runtime settings read from the environment into the package defaults
+var numericEnvSettings = []string{
+	"FAULT_LATENCY_MS", "FAULT_ERROR_RATE",
+	"ERROR_RATE_JITTER", "ERROR_RATE_JITTER_STEP",
+	"MAX_INJECTED_FAILURES_PER_MINUTE",
+	"WEIGHT_FAILURE_THRESHOLD", "WEIGHT_FAILURE_ERROR_RATE",
+	"CHECK_LATENCY_MIN_MS", "CHECK_LATENCY_MAX_MS",
+}
+
+// validateStartupSettings checks the runtime settings as the environment
+// and config file left them, so a bad value fails the start instead of
+// being served until somebody sets it through the API. An unparsable
+// number would otherwise silently become the default.
+func validateStartupSettings() error {
+	for _, name := range numericEnvSettings {
+		if value := getEnvOrDefault(name, ""); value != "" {
+			if _, err := strconv.ParseFloat(value, 64); err != nil {
+				return fmt.Errorf("%s: %q is not a number", name, value)
+			}
+		}
+	}
+	settings := []struct {
+		name     string
+		validate func() error
+	}{
+		{"faults", faultConfig.validate},
+		{"error rate jitter", errorRateJitter.validate},
+		{"blast radius", blastRadius.validate},
+		{"weight failure", weightFailure.validate},
+		{"check latency", checkLatency.validate},
+	}
+	for _, setting := range settings {
+		if err := setting.validate(); err != nil {
+			return fmt.Errorf("%s: %w", setting.name, err)
+		}
+	}
+	return nil
+}
+
+func currentConfig() Config {
+	configMu.RLock()
+	defer configMu.RUnlock()
+	return appConfig
+}
+
+// envSet reports whether any of the env vars overriding a file value is set.
+func envSet(names ...string) bool {
+	for _, name := range names {
+		if getEnvOrDefault(name, "") != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// applyConfig puts the file's runtime settings into effect. At startup
+// every value is applied; on reload only the ones that changed, so a
+// reload doesn't undo what was set through the API since.
+func applyConfig(prev, next Config, startup bool) {
+	if startup && !envSet("REDIS_ADDR") && next.Redis.Addr != "" {
+		redisAddr = next.Redis.Addr
+	}
+	if startup && !envSet("REDIS_MODE") && next.Redis.Mode != "" {
+		redisMode = next.Redis.Mode
+	}
+	if startup && !envSet("REDIS_MASTER_NAME") && next.Redis.MasterName != "" {
+		redisMasterName = next.Redis.MasterName
+	}
+
+	if startup || !reflect.DeepEqual(prev.Faults, next.Faults) {
+		applyFaultConfigFile(next.Faults)
+	}
+
+	if startup && envSet("ERROR_RATE") {
+		percent := getEnvFloatOrDefault("ERROR_RATE", 0)
+		if err := validateErrorRatePercent(percent); err != nil {
+			warnf("Ignoring ERROR_RATE: %v", err)
+		} else {
+			storeErrorRatePercent(percent)
+		}
+	} else if next.ErrorRate != nil && (startup || prev.ErrorRate == nil || *prev.ErrorRate != *next.ErrorRate) {
+		if startup {
+			// Only a default: the rate shared through Redis wins once synced
+			storeErrorRatePercent(*next.ErrorRate)
+		} else {
+			setManualErrorRate(redisCtx, *next.ErrorRate, "a config reload")
+		}
+	}
+
+	if !envSet("CHECK_LATENCY_MIN_MS", "CHECK_LATENCY_MAX_MS") && next.Latency != nil &&
+		(startup || prev.Latency == nil || *prev.Latency != *next.Latency) {
+		if startup {
+			// Only a default, like the error rate
+			storeCheckLatency(*next.Latency)
+		} else {
+			setCheckLatency(redisCtx, *next.Latency)
+		}
+	}
+
+	if !envSet("CORS_ORIGINS") && (startup || !slices.Equal(prev.CORSOrigins, next.CORSOrigins)) {
+		setCORSOrigins(next.CORSOrigins)
+	}
+}
+
+// restartRequired lists the settings changed since startup that a reload
+// can't apply.
+func restartRequired(prev, next Config) []string {
+	changed := []string{}
+	if prev.Port != next.Port || prev.BindAddr != next.BindAddr {
+		changed = append(changed, "port")
+	}
+	if prev.Redis != next.Redis {
+		changed = append(changed, "redis")
+	}
+	if !slices.Equal(prev.Middleware, next.Middleware) {
+		changed = append(changed, "middleware")
+	}
+	return changed
+}
+
+// reloadConfig reads the config file again and applies what changed.
+func reloadConfig() (Config, []string, error) {
+	if configFile == "" {
+		configReloadsTotal.WithLabelValues("error").Inc()
+		return Config{}, nil, errors.New("no config file, set CONFIG_FILE")
+	}
+	next, err := loadConfig(configFile)
+	if err != nil {
+		configReloadsTotal.WithLabelValues("error").Inc()
+		warnf("Failed to reload config, keeping the current one: %v", err)
+		return Config{}, nil, err
+	}
+
+	configMu.Lock()
+	prev := appConfig
+	appConfig = next
+	configMu.Unlock()
+
+	applyConfig(prev, next, false)
+	pending := restartRequired(startupConfig, next)
+	if len(pending) > 0 {
+		warnf("Config changes to %v take effect after a restart", pending)
+	}
+	configReloadsTotal.WithLabelValues("ok").Inc()
+	return next, pending, nil
+}
+
+func reloadConfigHandler(c echo.Context) error {
+	cfg, pending, err := reloadConfig()
+	if err != nil {
+		httpRequestsTotal.WithLabelValues("/api/admin/reload", fmt.Sprintf("%d", http.StatusBadRequest)).Inc()
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+
+	httpRequestsTotal.WithLabelValues("/api/admin/reload", fmt.Sprintf("%d", http.StatusOK)).Inc()
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"configFile":      configFile,
+		"config":          cfg,
+		"restartRequired": pending,
+	})
+}
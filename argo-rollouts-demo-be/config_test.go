@@ -0,0 +1,28 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestValidateStartupSettings(t *testing.T) {
+	if err := validateStartupSettings(); err != nil {
+		t.Fatalf("defaults: %v", err)
+	}
+
+	t.Run("unparsable env", func(t *testing.T) {
+		t.Setenv("FAULT_ERROR_RATE", "10%")
+		if err := validateStartupSettings(); err == nil {
+			t.Fatal("want an error for FAULT_ERROR_RATE=10%")
+		}
+	})
+
+	t.Run("out of range", func(t *testing.T) {
+		previous := errorRateJitter
+		t.Cleanup(func() { errorRateJitter = previous })
+		errorRateJitter.Amplitude = math.NaN()
+		if err := validateStartupSettings(); err == nil {
+			t.Fatal("want an error for a NaN jitter amplitude")
+		}
+	})
+}
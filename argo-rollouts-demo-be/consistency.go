@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Where /api/metrics took its counts from.
+const (
+	countSourceRedis      = "redis"      // Shared across pods
+	countSourcePrometheus = "prometheus" // This pod only
+)
+
+// CounterConsistency compares the two places this pod counts stored /api/check
+// outcomes: its hash in Redis and in process. Both are always written, so
+// they only drift apart when Redis writes fail or lag, or when one side is
+// reset without the other.
+type CounterConsistency struct {
+	Redis      map[string]float64 `json:"redis"`      // This pod's counts in Redis
+	Prometheus map[string]float64 `json:"prometheus"` // The same outcomes counted in process
+	Delta      map[string]float64 `json:"delta"`      // Redis minus in process, per status code
+	Consistent bool               `json:"consistent"`
+}
+
+var (
+	counterConsistencyInterval = time.Duration(getEnvFloatOrDefault("COUNTER_CONSISTENCY_INTERVAL_SECONDS", 15) * float64(time.Second))
+
+	// Redis writes are asynchronous under the fail-open policy, so a small
+	// delta right after a burst is expected. Alert on a sustained one:
+	//
+	//	counter_sources_consistent == 0  for: 2m
+	counterSourceDelta = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "counter_source_delta",
+			Help: "This pod's /api/check count in Redis minus its in-process count, by status code",
+		},
+		[]string{"status_code"},
+	)
+	counterSourcesConsistent = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "counter_sources_consistent",
+			Help: "1 when this pod's Redis and in-process /api/check counts agree at the last check, 0 otherwise",
+		},
+	)
+)
+
+func init() {
+	counterSourcesConsistent.Set(1)
+}
+
+// storedCheckCounts are the outcomes this pod has counted after storing
+// them, the ones its Redis hash receives. The /api/check series of
+// http_requests_total can't stand in for them: those also count refused
+// checks (400, 429, 503), global fault responses and restored totals.
+var storedCheckCounts = &checkCounts{counts: map[string]float64{}}
+
+type checkCounts struct {
+	mu     sync.Mutex
+	counts map[string]float64
+}
+
+func (c *checkCounts) add(counts map[int]int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for statusCode, n := range counts {
+		c.counts[strconv.Itoa(statusCode)] += float64(n)
+	}
+}
+
+func (c *checkCounts) snapshot() map[string]float64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	snapshot := make(map[string]float64, len(c.counts))
+	for status, n := range c.counts {
+		snapshot[status] = n
+	}
+	return snapshot
+}
+
+func (c *checkCounts) reset() {
+	c.mu.Lock()
+	c.counts = map[string]float64{}
+	c.mu.Unlock()
+}
+
+// checkCounterConsistency reads this pod's Redis counts, compares them with
+// storedCheckCounts and updates the divergence metrics.
+func checkCounterConsistency(ctx context.Context) (CounterConsistency, error) {
+	fields, err := redisClient.HGetAll(ctx, podCountsKey(podName)).Result()
+	if err != nil {
+		return CounterConsistency{}, err
+	}
+
+	result := CounterConsistency{
+		Redis:      make(map[string]float64, len(fields)),
+		Prometheus: storedCheckCounts.snapshot(),
+		Delta:      map[string]float64{},
+		Consistent: true,
+	}
+	for status, value := range fields {
+		result.Redis[status], _ = strconv.ParseFloat(value, 64)
+	}
+
+	codes := make([]string, 0, len(result.Redis)+len(result.Prometheus))
+	for status := range result.Redis {
+		codes = append(codes, status)
+	}
+	for status := range result.Prometheus {
+		if _, ok := result.Redis[status]; !ok {
+			codes = append(codes, status)
+		}
+	}
+	sort.Strings(codes)
+
+	// Reset first so codes that disappeared with a metrics reset don't keep
+	// reporting their last delta
+	counterSourceDelta.Reset()
+	for _, status := range codes {
+		delta := result.Redis[status] - result.Prometheus[status]
+		result.Delta[status] = delta
+		counterSourceDelta.WithLabelValues(status).Set(delta)
+		if delta != 0 {
+			result.Consistent = false
+		}
+	}
+	if result.Consistent {
+		counterSourcesConsistent.Set(1)
+	} else {
+		counterSourcesConsistent.Set(0)
+	}
+	return result, nil
+}
+
+// runCounterConsistencyCheck keeps the divergence metrics current when
+// nobody is reading /api/metrics.
+func runCounterConsistencyCheck(stop <-chan struct{}) {
+	interval := counterConsistencyInterval
+	if interval <= 0 {
+		interval = 15 * time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			ctx, cancel := context.WithTimeout(redisCtx, metricsReadTimeout)
+			if _, err := checkCounterConsistency(ctx); err != nil {
+				debugf("consistency: check failed: %v", err)
+			}
+			cancel()
+		}
+	}
+}
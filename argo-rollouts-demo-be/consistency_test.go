@@ -0,0 +1,32 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+)
+
+// Refused checks are in http_requests_total but never reach the pod's hash,
+// so they must not count as drift.
+func TestCounterConsistencyIgnoresRefusedChecks(t *testing.T) {
+	useCounterStore(t, newFakeRedis(t, false), redisCounterStore{})
+	storedCheckCounts.reset()
+	t.Cleanup(storedCheckCounts.reset)
+	ctx := context.Background()
+
+	counts := map[int]int64{http.StatusOK: 4, http.StatusInternalServerError: 1}
+	if err := writeCheckCounts(ctx, counts); err != nil {
+		t.Fatal(err)
+	}
+	countCheckOutcomes(ctx, "stable", counts)
+	httpRequestsTotal.WithLabelValues("/api/check", "429").Inc()
+	httpRequestsTotal.WithLabelValues("/api/check", "400").Inc()
+
+	result, err := checkCounterConsistency(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Consistent {
+		t.Fatalf("got delta %v, want none", result.Delta)
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Response contract revisions served by /api/content. Each one changes the
+// body the way real services drift between releases:
+//
+//	v1: baseline
+//	v2: adds "tags" (additive, backwards compatible)
+//	v3: renames "message" to "body" (breaking)
+const (
+	contentSchemaV1 = "v1"
+	contentSchemaV2 = "v2"
+	contentSchemaV3 = "v3"
+)
+
+// Localized fixture messages, so diffs can also be shown per language.
+var contentMessages = map[string]string{
+	"en": "Hello from the Argo Rollouts demo",
+	"es": "Hola desde la demo de Argo Rollouts",
+	"de": "Hallo aus der Argo Rollouts Demo",
+	"fr": "Bonjour depuis la démo Argo Rollouts",
+	"he": "שלום מהדמו של Argo Rollouts",
+}
+
+// contentSchema picks the contract revision: CONTENT_SCHEMA when set,
+// otherwise derived from the numeric VERSION (1, 2, 3 and up).
+func contentSchema() string {
+	switch schema := getEnvOrDefault("CONTENT_SCHEMA", ""); schema {
+	case contentSchemaV1, contentSchemaV2, contentSchemaV3:
+		return schema
+	}
+
+	n, err := strconv.Atoi(strings.TrimPrefix(version, "v"))
+	switch {
+	case err != nil || n <= 1:
+		return contentSchemaV1
+	case n == 2:
+		return contentSchemaV2
+	default:
+		return contentSchemaV3
+	}
+}
+
+// contentLanguage negotiates a fixture language from ?lang or
+// Accept-Language, ignoring quality values, and defaults to English.
+func contentLanguage(c echo.Context) string {
+	candidates := []string{c.QueryParam("lang")}
+	for _, part := range strings.Split(c.Request().Header.Get("Accept-Language"), ",") {
+		tag, _, _ := strings.Cut(strings.TrimSpace(part), ";")
+		candidates = append(candidates, tag)
+	}
+	for _, candidate := range candidates {
+		lang, _, _ := strings.Cut(strings.ToLower(candidate), "-")
+		if _, ok := contentMessages[lang]; ok {
+			return lang
+		}
+	}
+	return "en"
+}
+
+// contentBody is deterministic for a given schema and language, so mirrored
+// requests to stable and canary only differ where the contract changed.
+func contentBody(schema, lang string) map[string]interface{} {
+	body := map[string]interface{}{
+		"id":       "greeting",
+		"language": lang,
+	}
+
+	message := contentMessages[lang]
+	switch schema {
+	case contentSchemaV1:
+		body["message"] = message
+	case contentSchemaV2:
+		body["message"] = message
+		body["tags"] = []string{"demo", "argo-rollouts"}
+	case contentSchemaV3:
+		body["body"] = message
+		body["tags"] = []string{"demo", "argo-rollouts"}
+	}
+	return body
+}
+
+func contentHandler(c echo.Context) error {
+	schema := contentSchema()
+	lang := contentLanguage(c)
+
+	c.Response().Header().Set("X-Version", version)
+	c.Response().Header().Set("X-Content-Schema", schema)
+	c.Response().Header().Set("Content-Language", lang)
+	c.Response().Header().Add("Vary", "Accept-Language")
+
+	httpRequestsTotal.WithLabelValues("/api/content", fmt.Sprintf("%d", http.StatusOK)).Inc()
+	return c.JSON(http.StatusOK, contentBody(schema, lang))
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// With the store failing open, check outcomes are not written to Redis by
+// the request. They are queued for a single worker that sums them and
+// writes each batch with one pipeline, every COUNTER_FLUSH_INTERVAL_MS or
+// once COUNTER_FLUSH_MAX_EVENTS have queued up, whichever comes first.
+//
+// While Redis is unavailable the sums are kept and written once it is back,
+// so /api/metrics keeps the canary's failures from a Redis blip. They are
+// summed by status code, so memory doesn't grow with the outage; the
+// success-rate window counts them at the second they are written.
+var (
+	counterFlushInterval  = time.Duration(getEnvFloatOrDefault("COUNTER_FLUSH_INTERVAL_MS", 100) * float64(time.Millisecond))
+	counterFlushMaxEvents = int(getEnvFloatOrDefault("COUNTER_FLUSH_MAX_EVENTS", 500))
+
+	// A negative COUNTER_QUEUE_SIZE is taken as 0: every request writes
+	// its own counts
+	counterWrites = make(chan map[int]int64, max(int(getEnvFloatOrDefault("COUNTER_QUEUE_SIZE", 10000)), 0))
+	unflushed     = &unflushedCounts{counts: map[int]int64{}}
+
+	counterFlushesTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "counter_flushes_total",
+			Help: "Batched counter writes to Redis by result",
+		},
+		[]string{"result"},
+	)
+	counterFlushEvents = promauto.NewHistogram(
+		prometheus.HistogramOpts{
+			Name:    "counter_flush_events",
+			Help:    "Queued counter updates written per batch",
+			Buckets: prometheus.ExponentialBuckets(1, 4, 7),
+		},
+	)
+	counterQueueFullTotal = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: "counter_queue_full_total",
+			Help: "Counter updates written by the request itself because the queue was full",
+		},
+	)
+	counterReplayedTotal = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: "counter_replayed_total",
+			Help: "Counter updates held back while Redis was unavailable and written after it recovered",
+		},
+	)
+)
+
+func init() {
+	promauto.NewGaugeFunc(
+		prometheus.GaugeOpts{
+			Name: "counter_queue_length",
+			Help: "Counter updates waiting to be written to Redis",
+		},
+		func() float64 { return float64(len(counterWrites)) },
+	)
+	promauto.NewGaugeFunc(
+		prometheus.GaugeOpts{
+			Name: "counter_held_events",
+			Help: "Counter updates held back because Redis is unavailable",
+		},
+		func() float64 { return float64(unflushed.held()) },
+	)
+}
+
+// unflushedCounts sums the updates not yet written. events counts the
+// updates summed, held those of them a write has already failed on.
+type unflushedCounts struct {
+	mu     sync.Mutex
+	counts map[int]int64
+	events int
+	failed int
+}
+
+func (u *unflushedCounts) add(counts map[int]int64, events, failed int) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	for statusCode, n := range counts {
+		u.counts[statusCode] += n
+	}
+	u.events += events
+	u.failed += failed
+}
+
+// take empties the sums for writing.
+func (u *unflushedCounts) take() (counts map[int]int64, events, failed int) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	counts, events, failed = u.counts, u.events, u.failed
+	u.counts, u.events, u.failed = map[int]int64{}, 0, 0
+	return counts, events, failed
+}
+
+func (u *unflushedCounts) held() int {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return u.failed
+}
+
+// storeUnavailable reports whether a failed write is worth retrying once
+// Redis is back, rather than failing the same way again.
+func storeUnavailable(err error) bool {
+	return errors.Is(err, errSimulatedOutage) || errors.Is(err, errRedisCircuitOpen) || connectionError(err)
+}
+
+// queueCheckCounts hands counts to the batch writer. When the writer can't
+// keep up the caller writes them itself, so a backlog slows requests down
+// instead of losing counts.
+func queueCheckCounts(counts map[int]int64) {
+	select {
+	case counterWrites <- counts:
+	default:
+		counterQueueFullTotal.Inc()
+		err := writeCheckCounts(redisCtx, counts)
+		if storeUnavailable(err) {
+			unflushed.add(counts, 1, 1)
+		}
+		dependencyFailure(failFeatureStore, err)
+	}
+}
+
+// flushCheckCounts writes everything summed so far, putting it back when
+// Redis is unavailable.
+func flushCheckCounts() {
+	// Nothing to try while the breaker is open, the reconnect loop closes
+	// it once Redis answers. The other stores don't go through Redis.
+	if usesRedisCounters() && breaker.state() != nil {
+		return
+	}
+	counts, events, failed := unflushed.take()
+	if events == 0 {
+		return
+	}
+
+	err := writeCheckCounts(redisCtx, counts)
+	switch {
+	case err == nil:
+		counterFlushesTotal.WithLabelValues("ok").Inc()
+		if failed > 0 {
+			counterReplayedTotal.Add(float64(failed))
+			infof("Wrote %d check outcomes held back while Redis was unavailable", failed)
+		}
+	case storeUnavailable(err):
+		counterFlushesTotal.WithLabelValues("held").Inc()
+		unflushed.add(counts, events, events)
+	default:
+		counterFlushesTotal.WithLabelValues("error").Inc()
+	}
+	dependencyFailure(failFeatureStore, err)
+	counterFlushEvents.Observe(float64(events))
+}
+
+// runCounterBatcher writes the queued counts until stop is closed, then
+// writes whatever is still queued so shutdown loses nothing Redis can take.
+func runCounterBatcher(stop <-chan struct{}) {
+	interval := counterFlushInterval
+	if interval <= 0 {
+		interval = 100 * time.Millisecond
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	// Updates since the last flush, for flushing early on a burst
+	queued := 0
+	add := func(counts map[int]int64) {
+		unflushed.add(counts, 1, 0)
+		queued++
+	}
+
+	for {
+		select {
+		case <-stop:
+			for {
+				select {
+				case counts := <-counterWrites:
+					add(counts)
+				default:
+					flushCheckCounts()
+					if _, events, _ := unflushed.take(); events > 0 {
+						warnf("Dropping %d check outcomes, Redis is unavailable", events)
+					}
+					return
+				}
+			}
+		case counts := <-counterWrites:
+			add(counts)
+			if queued >= counterFlushMaxEvents {
+				flushCheckCounts()
+				queued = 0
+			}
+		case <-ticker.C:
+			flushCheckCounts()
+			queued = 0
+		}
+	}
+}
@@ -0,0 +1,25 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+)
+
+// The memory store doesn't depend on Redis, so an open breaker must not
+// hold its counts back.
+func TestFlushToMemoryStoreWithBreakerOpen(t *testing.T) {
+	store := newMemoryCounterStore()
+	useCounterStore(t, nil, store)
+	breaker.trip(errors.New("test outage"))
+	t.Cleanup(breaker.reset)
+
+	unflushed.add(map[int]int64{http.StatusOK: 2}, 1, 0)
+	flushCheckCounts()
+
+	byVersion, _ := store.GetCounts(context.Background())
+	if got := byVersion[version]["200"]; got != 2 {
+		t.Fatalf("memory store has %v 200s, want 2", got)
+	}
+}
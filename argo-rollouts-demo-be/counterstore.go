@@ -0,0 +1,206 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// CounterStore keeps the /api/check totals that /api/metrics and the
+// analysis templates read. COUNTER_STORE picks the implementation:
+//
+//	redis   shared by every pod (the default); without a Redis connection
+//	        at startup the noop store is used instead
+//	memory  this pod only, kept by version like the shared counters
+//	noop    nothing is stored, reads fall back to this pod's Prometheus
+//	        counters
+type CounterStore interface {
+	// Name is reported as the source of the counts
+	Name() string
+	// Incr adds this pod's check outcomes by status code
+	Incr(ctx context.Context, counts map[int]int64) error
+	// GetCounts returns the totals by version, then status code
+	GetCounts(ctx context.Context) (map[string]map[string]float64, error)
+	// Reset clears every total
+	Reset(ctx context.Context) error
+	// Restore replaces every total with counts, all attributed to this
+	// pod's version
+	Restore(ctx context.Context, counts map[int]int64) error
+}
+
+const (
+	counterStoreRedis  = "redis"
+	counterStoreMemory = "memory"
+	counterStoreNoop   = "noop"
+)
+
+var (
+	counterStoreKind              = getEnvOrDefault("COUNTER_STORE", counterStoreRedis)
+	counterStore     CounterStore = noopCounterStore{}
+)
+
+// openCounterStore selects the store once Redis has been connected to.
+func openCounterStore(context.Context) error {
+	switch counterStoreKind {
+	case counterStoreRedis:
+		if redisClient != nil {
+			counterStore = redisCounterStore{}
+		}
+	case counterStoreMemory:
+		counterStore = newMemoryCounterStore()
+	case counterStoreNoop:
+	default:
+		return fmt.Errorf("COUNTER_STORE must be %s, %s or %s, not %q",
+			counterStoreRedis, counterStoreMemory, counterStoreNoop, counterStoreKind)
+	}
+	infof("Counting checks in the %s store", counterStore.Name())
+	return nil
+}
+
+// storeStatusCounts reads the store's totals by status code, summed over
+// all versions.
+func storeStatusCounts(ctx context.Context) (map[string]float64, error) {
+	byVersion, err := counterStore.GetCounts(ctx)
+	if err != nil {
+		return nil, err
+	}
+	totals := map[string]float64{}
+	for _, counts := range byVersion {
+		for status, n := range counts {
+			totals[status] += n
+		}
+	}
+	return totals, nil
+}
+
+// usesRedisCounters reports whether this pod's counts also go to its hash in
+// Redis, the one the consistency check compares against.
+func usesRedisCounters() bool {
+	_, ok := counterStore.(redisCounterStore)
+	return ok
+}
+
+// redisCounterStore keeps the shared counters, plus this pod's hash and the
+// success-rate window, in Redis. Reads follow MIGRATION_READ_FROM.
+type redisCounterStore struct{}
+
+func (redisCounterStore) Name() string { return countSourceRedis }
+
+func (redisCounterStore) Incr(ctx context.Context, counts map[int]int64) error {
+	pipe := redisClient.Pipeline()
+	for statusCode, n := range counts {
+		pipe.IncrBy(ctx, statusKey(fmt.Sprintf("%d", statusCode), version), n)
+		pipe.HIncrBy(ctx, podCountsKey(podName), fmt.Sprintf("%d", statusCode), n)
+		pipe.SAdd(ctx, statusCodesKey, fmt.Sprintf("%d", statusCode))
+	}
+	pipe.SAdd(ctx, statusVersionsKey, version)
+	addSuccessWindow(ctx, pipe, time.Now(), counts)
+	_, err := pipe.Exec(ctx)
+	mirrorCheckCounts(ctx, counts)
+	return err
+}
+
+func (redisCounterStore) GetCounts(ctx context.Context) (map[string]map[string]float64, error) {
+	return readRedisVersionCounts(ctx, counterReadClient())
+}
+
+func (redisCounterStore) Reset(context.Context) error {
+	var errs []error
+	if err := deleteRedisStatusCounts(); err != nil {
+		errs = append(errs, fmt.Errorf("status counters: %w", err))
+	}
+	if err := resetPodCounts(); err != nil {
+		errs = append(errs, fmt.Errorf("per-pod counters: %w", err))
+	}
+	return errors.Join(errs...)
+}
+
+// Restore sets the shared counters and this pod's hash, but leaves the
+// success-rate window alone: the totals are from another time.
+func (s redisCounterStore) Restore(ctx context.Context, counts map[int]int64) error {
+	if err := s.Reset(ctx); err != nil {
+		return err
+	}
+	pipe := redisClient.Pipeline()
+	pipe.SAdd(ctx, statusVersionsKey, version)
+	mirrorSetAdd(statusVersionsKey, version)
+	for statusCode, n := range counts {
+		status := fmt.Sprintf("%d", statusCode)
+		pipe.Set(ctx, statusKey(status, version), n, 0)
+		pipe.HSet(ctx, podCountsKey(podName), status, n)
+		pipe.SAdd(ctx, statusCodesKey, status)
+		mirrorCounterSet(statusKey(status, version), n)
+		mirrorSetAdd(statusCodesKey, status)
+	}
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// memoryCounterStore counts in process, for running without shared
+// storage while keeping counts by version.
+type memoryCounterStore struct {
+	mu     sync.Mutex
+	counts map[string]map[string]float64
+}
+
+func newMemoryCounterStore() *memoryCounterStore {
+	return &memoryCounterStore{counts: map[string]map[string]float64{}}
+}
+
+func (*memoryCounterStore) Name() string { return counterStoreMemory }
+
+func (s *memoryCounterStore) Incr(_ context.Context, counts map[int]int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	byStatus := s.counts[version]
+	if byStatus == nil {
+		byStatus = map[string]float64{}
+		s.counts[version] = byStatus
+	}
+	for statusCode, n := range counts {
+		byStatus[fmt.Sprintf("%d", statusCode)] += float64(n)
+	}
+	return nil
+}
+
+func (s *memoryCounterStore) GetCounts(context.Context) (map[string]map[string]float64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	byVersion := make(map[string]map[string]float64, len(s.counts))
+	for v, counts := range s.counts {
+		copied := make(map[string]float64, len(counts))
+		for status, n := range counts {
+			copied[status] = n
+		}
+		byVersion[v] = copied
+	}
+	return byVersion, nil
+}
+
+func (s *memoryCounterStore) Reset(context.Context) error {
+	s.mu.Lock()
+	s.counts = map[string]map[string]float64{}
+	s.mu.Unlock()
+	return nil
+}
+
+func (s *memoryCounterStore) Restore(ctx context.Context, counts map[int]int64) error {
+	s.Reset(ctx)
+	return s.Incr(ctx, counts)
+}
+
+type noopCounterStore struct{}
+
+func (noopCounterStore) Name() string { return counterStoreNoop }
+
+func (noopCounterStore) Incr(context.Context, map[int]int64) error { return nil }
+
+func (noopCounterStore) GetCounts(context.Context) (map[string]map[string]float64, error) {
+	return map[string]map[string]float64{}, nil
+}
+
+func (noopCounterStore) Reset(context.Context) error { return nil }
+
+func (noopCounterStore) Restore(context.Context, map[int]int64) error { return nil }
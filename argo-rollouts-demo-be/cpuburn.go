@@ -0,0 +1,204 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const (
+	// Each worker spins for its share of a period and sleeps the rest, so
+	// utilization averages out over anything the kubelet samples
+	cpuBurnPeriod = 100 * time.Millisecond
+
+	maxCPUBurnDuration = time.Hour
+)
+
+// Most workers accepted, enough to saturate the node's cores a few times
+// over without letting a typo start a million goroutines.
+var maxCPUBurnWorkers = 4 * runtime.NumCPU()
+
+// CPUBurn keeps Workers goroutines busy at Utilization percent of a core
+// each for DurationSeconds, to push the HPA into scaling during a rollout
+// or fail a CPU-based analysis:
+//
+//	{"workers": 2, "utilization": 80, "durationSeconds": 120}
+//
+// The burn is bounded by the container's CPU limit like any other load.
+type CPUBurn struct {
+	Workers         int       `json:"workers"`
+	Utilization     float64   `json:"utilization"` // Percentage of a core per worker
+	DurationSeconds float64   `json:"durationSeconds"`
+	StartedAt       time.Time `json:"startedAt,omitzero"` // Ignored on input
+}
+
+// CPUBurnStatus is the burn with its remaining time.
+type CPUBurnStatus struct {
+	CPUBurn
+	Active           bool      `json:"active"`
+	ActiveWorkers    int64     `json:"activeWorkers"`
+	EndsAt           time.Time `json:"endsAt,omitzero"`
+	SecondsRemaining float64   `json:"secondsRemaining"`
+}
+
+var (
+	cpuBurn   CPUBurn
+	cpuBurnMu sync.Mutex
+	// Closed to stop the running burn's workers
+	cpuBurnStop   chan struct{}
+	cpuBurnWG     sync.WaitGroup
+	cpuBurnActive atomic.Int64
+)
+
+func init() {
+	promauto.NewGaugeFunc(
+		prometheus.GaugeOpts{
+			Name: "cpu_burn_workers_active",
+			Help: "Goroutines currently burning CPU for the CPU burn chaos",
+		},
+		func() float64 { return float64(cpuBurnActive.Load()) },
+	)
+}
+
+func (b CPUBurn) validate() error {
+	if b.Workers < 1 || b.Workers > maxCPUBurnWorkers {
+		return fmt.Errorf("workers must be between 1 and %d", maxCPUBurnWorkers)
+	}
+	if math.IsNaN(b.Utilization) || b.Utilization <= 0 || b.Utilization > 100 {
+		return fmt.Errorf("utilization must be above 0 and at most 100")
+	}
+	if math.IsNaN(b.DurationSeconds) || math.IsInf(b.DurationSeconds, 0) || b.DurationSeconds <= 0 {
+		return fmt.Errorf("durationSeconds must be a positive number")
+	}
+	if b.DurationSeconds > maxCPUBurnDuration.Seconds() {
+		return fmt.Errorf("durationSeconds must be at most %g", maxCPUBurnDuration.Seconds())
+	}
+	return nil
+}
+
+func cpuBurnStatus(now time.Time) CPUBurnStatus {
+	cpuBurnMu.Lock()
+	current := cpuBurn
+	cpuBurnMu.Unlock()
+
+	status := CPUBurnStatus{CPUBurn: current, ActiveWorkers: cpuBurnActive.Load()}
+	if current.StartedAt.IsZero() {
+		return status
+	}
+	status.EndsAt = current.StartedAt.Add(time.Duration(current.DurationSeconds * float64(time.Second)))
+	if remaining := status.EndsAt.Sub(now); remaining > 0 && status.ActiveWorkers > 0 {
+		status.Active = true
+		status.SecondsRemaining = remaining.Seconds()
+	}
+	return status
+}
+
+// burnCPU spins for the busy share of each period until the deadline or
+// until stop is closed.
+func burnCPU(stop <-chan struct{}, deadline time.Time, utilization float64) {
+	busy := time.Duration(float64(cpuBurnPeriod) * utilization / 100)
+	for time.Now().Before(deadline) {
+		for start := time.Now(); time.Since(start) < busy; {
+		}
+		select {
+		case <-stop:
+			return
+		case <-time.After(cpuBurnPeriod - busy):
+		}
+	}
+}
+
+// stopCPUBurnLocked stops the running workers and waits for them. The caller
+// holds cpuBurnMu.
+func stopCPUBurnLocked() {
+	if cpuBurnStop == nil {
+		return
+	}
+	close(cpuBurnStop)
+	cpuBurnStop = nil
+	cpuBurnWG.Wait()
+}
+
+// startCPUBurn replaces the running burn with burn.
+func startCPUBurn(burn CPUBurn) {
+	cpuBurnMu.Lock()
+	defer cpuBurnMu.Unlock()
+	stopCPUBurnLocked()
+
+	cpuBurn = burn
+	stop := make(chan struct{})
+	cpuBurnStop = stop
+	deadline := burn.StartedAt.Add(time.Duration(burn.DurationSeconds * float64(time.Second)))
+	cpuBurnActive.Add(int64(burn.Workers))
+	cpuBurnWG.Add(burn.Workers)
+	for range burn.Workers {
+		go func() {
+			defer cpuBurnWG.Done()
+			burnCPU(stop, deadline, burn.Utilization)
+			if cpuBurnActive.Add(-1) == 0 && !time.Now().Before(deadline) {
+				infof("CPU burn over after %gs", burn.DurationSeconds)
+			}
+		}()
+	}
+}
+
+// stopCPUBurn ends the burn early and reports whether one was running.
+func stopCPUBurn() bool {
+	cpuBurnMu.Lock()
+	defer cpuBurnMu.Unlock()
+	wasActive := cpuBurnActive.Load() > 0
+	stopCPUBurnLocked()
+	cpuBurn = CPUBurn{}
+	return wasActive
+}
+
+// shutdownCPUBurn stops the workers so they don't slow down draining.
+func shutdownCPUBurn(context.Context) error {
+	stopCPUBurn()
+	return nil
+}
+
+func getCPUBurnHandler(c echo.Context) error {
+	httpRequestsTotal.WithLabelValues("/api/chaos/cpu", fmt.Sprintf("%d", http.StatusOK)).Inc()
+	return c.JSON(http.StatusOK, cpuBurnStatus(time.Now()))
+}
+
+// startCPUBurnHandler starts a burn, replacing one already running.
+func startCPUBurnHandler(c echo.Context) error {
+	var burn CPUBurn
+	if err := json.NewDecoder(c.Request().Body).Decode(&burn); err != nil {
+		httpRequestsTotal.WithLabelValues("/api/chaos/cpu", fmt.Sprintf("%d", http.StatusBadRequest)).Inc()
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid JSON"})
+	}
+	if err := burn.validate(); err != nil {
+		httpRequestsTotal.WithLabelValues("/api/chaos/cpu", fmt.Sprintf("%d", http.StatusBadRequest)).Inc()
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+	now := time.Now().UTC()
+	burn.StartedAt = now
+	startCPUBurn(burn)
+	warnf("CPU burn: %d workers at %g%% for %gs", burn.Workers, burn.Utilization, burn.DurationSeconds)
+
+	httpRequestsTotal.WithLabelValues("/api/chaos/cpu", fmt.Sprintf("%d", http.StatusOK)).Inc()
+	return c.JSON(http.StatusOK, cpuBurnStatus(now))
+}
+
+// stopCPUBurnHandler ends a burn early.
+func stopCPUBurnHandler(c echo.Context) error {
+	if stopCPUBurn() {
+		infof("CPU burn stopped early")
+	}
+
+	httpRequestsTotal.WithLabelValues("/api/chaos/cpu", fmt.Sprintf("%d", http.StatusOK)).Inc()
+	return c.JSON(http.StatusOK, cpuBurnStatus(time.Now()))
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	// Longest crash delay accepted
+	maxCrashDelay = time.Hour
+
+	// Time for the response to reach the client before an immediate crash
+	crashResponseGrace = 100 * time.Millisecond
+)
+
+// Crash takes the process down after DelaySeconds, to show restarts,
+// liveness probes and a rollout aborting on CrashLoopBackOff. It exits with
+// ExitCode, 1 when omitted, or panics when Panic is set, which Go reports
+// with exit code 2 and a stack trace in the container log:
+//
+//	{"delaySeconds": 5, "exitCode": 137}
+type Crash struct {
+	DelaySeconds float64   `json:"delaySeconds"`
+	ExitCode     int       `json:"exitCode,omitempty"`
+	Panic        bool      `json:"panic,omitempty"`
+	CrashAt      time.Time `json:"crashAt,omitzero"` // Ignored on input
+}
+
+var (
+	pendingCrash   Crash
+	pendingCrashMu sync.Mutex
+	crashTimer     *time.Timer
+)
+
+func (c Crash) validate() error {
+	if math.IsNaN(c.DelaySeconds) || math.IsInf(c.DelaySeconds, 0) || c.DelaySeconds < 0 {
+		return fmt.Errorf("delaySeconds must be a finite non-negative number")
+	}
+	if c.DelaySeconds > maxCrashDelay.Seconds() {
+		return fmt.Errorf("delaySeconds must be at most %g", maxCrashDelay.Seconds())
+	}
+	if c.ExitCode < 0 || c.ExitCode > 255 {
+		return fmt.Errorf("exitCode must be between 1 and 255")
+	}
+	if c.Panic && c.ExitCode != 0 {
+		return errors.New("set either panic or exitCode, not both")
+	}
+	return nil
+}
+
+// crashNow ends the process the way crash asks for. The panic runs on a
+// timer goroutine, out of reach of the recover middleware.
+func crashNow(crash Crash) {
+	if crash.Panic {
+		errorf("Crashing on request: panic")
+		panic("chaos crash requested through /api/chaos/crash")
+	}
+	errorf("Crashing on request: exit code %d", crash.ExitCode)
+	os.Exit(crash.ExitCode)
+}
+
+// scheduleCrash replaces any pending crash.
+func scheduleCrash(crash Crash) {
+	pendingCrashMu.Lock()
+	defer pendingCrashMu.Unlock()
+	if crashTimer != nil {
+		crashTimer.Stop()
+	}
+	pendingCrash = crash
+	crashTimer = time.AfterFunc(time.Until(crash.CrashAt), func() { crashNow(crash) })
+}
+
+// cancelCrash stops a pending crash and reports whether there was one.
+func cancelCrash() bool {
+	pendingCrashMu.Lock()
+	defer pendingCrashMu.Unlock()
+	if crashTimer == nil || !crashTimer.Stop() {
+		return false
+	}
+	crashTimer = nil
+	pendingCrash = Crash{}
+	return true
+}
+
+func getCrashHandler(c echo.Context) error {
+	pendingCrashMu.Lock()
+	crash := pendingCrash
+	pendingCrashMu.Unlock()
+
+	if crash.CrashAt.IsZero() {
+		httpRequestsTotal.WithLabelValues("/api/chaos/crash", fmt.Sprintf("%d", http.StatusNotFound)).Inc()
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "No crash pending"})
+	}
+	httpRequestsTotal.WithLabelValues("/api/chaos/crash", fmt.Sprintf("%d", http.StatusOK)).Inc()
+	return c.JSON(http.StatusOK, crash)
+}
+
+// crashHandler schedules the crash and answers before it happens.
+func crashHandler(c echo.Context) error {
+	var crash Crash
+	// An empty body crashes right away with exit code 1
+	if err := json.NewDecoder(c.Request().Body).Decode(&crash); err != nil && !errors.Is(err, io.EOF) {
+		httpRequestsTotal.WithLabelValues("/api/chaos/crash", fmt.Sprintf("%d", http.StatusBadRequest)).Inc()
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid JSON"})
+	}
+	if err := crash.validate(); err != nil {
+		httpRequestsTotal.WithLabelValues("/api/chaos/crash", fmt.Sprintf("%d", http.StatusBadRequest)).Inc()
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+	if !crash.Panic && crash.ExitCode == 0 {
+		crash.ExitCode = 1
+	}
+	delay := max(time.Duration(crash.DelaySeconds*float64(time.Second)), crashResponseGrace)
+	crash.CrashAt = time.Now().UTC().Add(delay)
+	scheduleCrash(crash)
+	warnf("Crash requested, exiting at %s", crash.CrashAt.Format(time.RFC3339))
+
+	httpRequestsTotal.WithLabelValues("/api/chaos/crash", fmt.Sprintf("%d", http.StatusAccepted)).Inc()
+	return c.JSON(http.StatusAccepted, crash)
+}
+
+// cancelCrashHandler calls off a delayed crash that hasn't happened yet.
+func cancelCrashHandler(c echo.Context) error {
+	if !cancelCrash() {
+		httpRequestsTotal.WithLabelValues("/api/chaos/crash", fmt.Sprintf("%d", http.StatusNotFound)).Inc()
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "No crash pending"})
+	}
+	infof("Pending crash cancelled")
+
+	httpRequestsTotal.WithLabelValues("/api/chaos/crash", fmt.Sprintf("%d", http.StatusOK)).Inc()
+	return c.JSON(http.StatusOK, map[string]string{"message": "Crash cancelled"})
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"runtime"
+	"runtime/pprof"
+)
+
+// dumpState writes goroutine stacks, the effective runtime configuration and
+// current counters to the log, for inspecting a pod without HTTP access.
+func dumpState() {
+	var stacks bytes.Buffer
+	if err := pprof.Lookup("goroutine").WriteTo(&stacks, 1); err != nil {
+		warnf("Failed to collect goroutine stacks: %v", err)
+	}
+
+	faultConfigMu.RLock()
+	faults := faultConfig
+	faultConfigMu.RUnlock()
+
+	featureCanaryMu.RLock()
+	canary := featureCanary
+	featureCanaryMu.RUnlock()
+
+	state := map[string]interface{}{
+		"version":       version,
+		"buildHash":     buildHash,
+		"pod":           podName,
+		"goroutines":    runtime.NumGoroutine(),
+		"redis":         redisClient != nil,
+		"debugLogging":  debugEnabled(),
+		"errorRate":     getErrorRatePercent(),
+		"faults":        faults,
+		"featureCanary": canary,
+		"middleware":    activeMiddlewareOrder,
+		"quotaWindows":  quotaWindows,
+		"certificate":   currentCertStatus(),
+		"counters":      getStatusCounts(),
+	}
+
+	encoded, err := json.Marshal(state)
+	if err != nil {
+		warnf("Failed to encode state dump: %v", err)
+	}
+	infof("State dump: %s", encoded)
+	infof("Goroutine dump:\n%s", stacks.String())
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+const democonfigAPIPath = "/apis/rollouts-demo.io/v1alpha1"
+
+// DemoConfigSpec is the desired chaos settings in a DemoConfig custom
+// resource (see crd/democonfig.yaml). Unset fields leave the pod's current
+// value alone.
+type DemoConfigSpec struct {
+	ErrorRate *float64 `json:"errorRate,omitempty"` // Percentage, same as /api/set-error-rate
+	LatencyMs *float64 `json:"latencyMs,omitempty"` // Global fault middleware latency
+}
+
+type demoConfigObject struct {
+	Metadata struct {
+		Name            string `json:"name"`
+		ResourceVersion string `json:"resourceVersion"`
+		Generation      int64  `json:"generation"`
+	} `json:"metadata"`
+	Spec DemoConfigSpec `json:"spec"`
+}
+
+type demoConfigEvent struct {
+	Type   string          `json:"type"`
+	Object json.RawMessage `json:"object"`
+}
+
+var (
+	// Watching is opt-in so the demo still works without the CRD installed
+	demoConfigWatch = isTruthy(getEnvOrDefault("DEMO_CONFIG_WATCH", "false"))
+	demoConfigName  = getEnvOrDefault("DEMO_CONFIG_NAME", "argo-rollouts-demo")
+
+	demoConfigMu         sync.Mutex
+	demoConfigApplied    *DemoConfigSpec
+	demoConfigGeneration int64
+	demoConfigAppliedAt  time.Time
+	demoConfigLastError  string
+)
+
+// applyDemoConfig pushes the resource's spec into the runtime settings.
+func applyDemoConfig(obj demoConfigObject) error {
+	spec := obj.Spec
+	if spec.ErrorRate != nil {
+		if err := validateErrorRatePercent(*spec.ErrorRate); err != nil {
+			return fmt.Errorf("spec.errorRate: %w", err)
+		}
+	}
+	if spec.LatencyMs != nil && *spec.LatencyMs < 0 {
+		return fmt.Errorf("spec.latencyMs must not be negative")
+	}
+
+	if spec.ErrorRate != nil {
+		setManualErrorRate(redisCtx, *spec.ErrorRate, "the DemoConfig")
+	}
+	if spec.LatencyMs != nil {
+		faultConfigMu.Lock()
+		faultConfig.LatencyMs = *spec.LatencyMs
+		faultConfigMu.Unlock()
+	}
+
+	demoConfigMu.Lock()
+	demoConfigApplied = &spec
+	demoConfigGeneration = obj.Metadata.Generation
+	demoConfigAppliedAt = time.Now().UTC()
+	demoConfigLastError = ""
+	demoConfigMu.Unlock()
+
+	infof("Applied DemoConfig %s generation %d", obj.Metadata.Name, obj.Metadata.Generation)
+	return nil
+}
+
+func setDemoConfigError(err error) {
+	demoConfigMu.Lock()
+	demoConfigLastError = err.Error()
+	demoConfigMu.Unlock()
+}
+
+// syncDemoConfig gets the resource, applies it and then follows changes
+// until the watch ends. It returns the error that ended the watch.
+func syncDemoConfig(ctx context.Context, kube *kubeClient) error {
+	base := fmt.Sprintf("%s/namespaces/%s/democonfigs", democonfigAPIPath, kube.namespace)
+
+	var obj demoConfigObject
+	if err := kube.getJSON(ctx, base+"/"+demoConfigName, &obj); err != nil {
+		return err
+	}
+	if err := applyDemoConfig(obj); err != nil {
+		setDemoConfigError(err)
+		warnf("Ignoring invalid DemoConfig %s: %v", demoConfigName, err)
+	}
+
+	query := url.Values{
+		"watch":           {"true"},
+		"fieldSelector":   {"metadata.name=" + demoConfigName},
+		"resourceVersion": {obj.Metadata.ResourceVersion},
+	}
+	resp, err := kube.do(ctx, http.MethodGet, base+"?"+query.Encode(), "", nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return fmt.Errorf("kubernetes API returned %d: %s", resp.StatusCode, strings.TrimSpace(string(message)))
+	}
+
+	decoder := json.NewDecoder(resp.Body)
+	for {
+		var event demoConfigEvent
+		if err := decoder.Decode(&event); err != nil {
+			return err
+		}
+		switch event.Type {
+		case "ADDED", "MODIFIED":
+			var changed demoConfigObject
+			if err := json.Unmarshal(event.Object, &changed); err != nil {
+				return err
+			}
+			if err := applyDemoConfig(changed); err != nil {
+				setDemoConfigError(err)
+				warnf("Ignoring invalid DemoConfig %s: %v", demoConfigName, err)
+			}
+		case "DELETED":
+			// Keep the last applied settings, like a removed env var would
+			infof("DemoConfig %s deleted, keeping current settings", demoConfigName)
+		case "ERROR":
+			// Usually 410 Gone for an expired resourceVersion; start over
+			return fmt.Errorf("watch error: %s", string(event.Object))
+		}
+	}
+}
+
+// runDemoConfigWatcher keeps the DemoConfig watch alive until stop is
+// closed, relisting after errors and watch timeouts.
+func runDemoConfigWatcher(stop <-chan struct{}) {
+	kube, err := newInClusterKubeClient()
+	if err != nil {
+		warnf("DemoConfig watch disabled: %v", err)
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		<-stop
+		cancel()
+	}()
+
+	infof("Watching DemoConfig %s in namespace %s", demoConfigName, kube.namespace)
+	backoff := time.Second
+	for {
+		started := time.Now()
+		err := syncDemoConfig(ctx, kube)
+		if ctx.Err() != nil {
+			return
+		}
+		setDemoConfigError(err)
+		debugf("democonfig: watch ended: %v", err)
+
+		// A watch that ran for a while ended normally, retry right away
+		if time.Since(started) > time.Minute {
+			backoff = time.Second
+			continue
+		}
+		warnf("DemoConfig watch failed, retrying in %s: %v", backoff, err)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, time.Minute)
+	}
+}
+
+func getDemoConfigHandler(c echo.Context) error {
+	demoConfigMu.Lock()
+	response := map[string]interface{}{
+		"enabled": demoConfigWatch,
+		"name":    demoConfigName,
+	}
+	if demoConfigApplied != nil {
+		response["spec"] = demoConfigApplied
+		response["generation"] = demoConfigGeneration
+		response["appliedAt"] = demoConfigAppliedAt
+	}
+	if demoConfigLastError != "" {
+		response["lastError"] = demoConfigLastError
+	}
+	demoConfigMu.Unlock()
+
+	httpRequestsTotal.WithLabelValues("/api/democonfig", fmt.Sprintf("%d", http.StatusOK)).Inc()
+	return c.JSON(http.StatusOK, response)
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/smtp"
+	"strings"
+	"time"
+)
+
+var (
+	digestSMTPAddr     = getEnvOrDefault("RUN_DIGEST_SMTP_ADDR", "")
+	digestSMTPFrom     = getEnvOrDefault("RUN_DIGEST_SMTP_FROM", "argo-rollouts-demo@localhost")
+	digestSMTPTo       = getEnvOrDefault("RUN_DIGEST_SMTP_TO", "")
+	digestSMTPUsername = getEnvOrDefault("RUN_DIGEST_SMTP_USERNAME", "")
+
+	digestHTTPClient = newOutboundClient(outboundClientConfig{
+		Name:               "digest-webhook",
+		Timeout:            10 * time.Second,
+		RetryNonIdempotent: true,
+	})
+)
+
+// sendRunDigest delivers the run summary to every configured target. It is
+// a no-op when neither a webhook nor an SMTP server is configured.
+func sendRunDigest(ctx context.Context, summary RunSummary) error {
+	var errs []error
+
+	if digestWebhookURL.Value() != "" {
+		if err := sendDigestWebhook(ctx, summary); err != nil {
+			errs = append(errs, fmt.Errorf("webhook: %w", err))
+		}
+	}
+
+	if digestSMTPAddr != "" && digestSMTPTo != "" {
+		if err := sendDigestEmail(summary); err != nil {
+			errs = append(errs, fmt.Errorf("smtp: %w", err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func sendDigestWebhook(ctx context.Context, summary RunSummary) error {
+	body, err := json.Marshal(summary)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, digestWebhookURL.Value(), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := digestHTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+func sendDigestEmail(summary RunSummary) error {
+	recipients := strings.Split(digestSMTPTo, ",")
+	for i := range recipients {
+		recipients[i] = strings.TrimSpace(recipients[i])
+	}
+
+	var auth smtp.Auth
+	if digestSMTPUsername != "" {
+		host, _, err := net.SplitHostPort(digestSMTPAddr)
+		if err != nil {
+			return err
+		}
+		auth = smtp.PlainAuth("", digestSMTPUsername, digestSMTPPassword.Value(), host)
+	}
+
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", digestSMTPFrom)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(recipients, ", "))
+	fmt.Fprintf(&msg, "Subject: Demo run recap: %s\r\n", digestTitle(summary))
+	fmt.Fprintf(&msg, "Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	msg.WriteString(formatDigestText(summary))
+
+	return smtp.SendMail(digestSMTPAddr, auth, digestSMTPFrom, recipients, msg.Bytes())
+}
+
+func digestTitle(summary RunSummary) string {
+	if summary.Name != "" {
+		return summary.Name
+	}
+	return summary.RunID
+}
+
+func formatDigestText(summary RunSummary) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Run:        %s (%s)\n", digestTitle(summary), summary.RunID)
+	fmt.Fprintf(&b, "Started:    %s\n", summary.StartedAt.Format(time.RFC3339))
+	fmt.Fprintf(&b, "Ended:      %s\n", summary.EndedAt.Format(time.RFC3339))
+	fmt.Fprintf(&b, "Duration:   %s\n", time.Duration(summary.DurationSeconds*float64(time.Second)).Round(time.Second))
+	fmt.Fprintf(&b, "Requests:   %.0f (200: %.0f, 500: %.0f)\n", summary.TotalRequests, summary.Requests200, summary.Requests500)
+	fmt.Fprintf(&b, "Error rate: %.2f%%\n", summary.ErrorRate)
+	fmt.Fprintf(&b, "Rollbacks:  %d\n", summary.RollbacksObserved)
+	fmt.Fprintf(&b, "Reported by version %s on pod %s\n", summary.Version, summary.Pod)
+
+	if len(summary.ErrorRateOverTime) > 0 {
+		b.WriteString("\nError rate over time (configured / observed):\n")
+		for _, sample := range summary.ErrorRateOverTime {
+			fmt.Fprintf(&b, "  %s  %6.2f%% / %6.2f%%  (%.0f requests)\n",
+				sample.Timestamp.Format("15:04:05"), sample.ConfiguredRate, sample.ObservedRate, sample.IntervalRequests)
+		}
+	}
+
+	if len(summary.Events) > 0 {
+		b.WriteString("\nEvents:\n")
+		for _, event := range summary.Events {
+			fmt.Fprintf(&b, "  %s  %s  %s\n", event.Timestamp.Format("15:04:05"), event.Type, event.Message)
+		}
+	}
+
+	return b.String()
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Error modes.
+const (
+	errorModeRandom   = "random"    // Each check fails with the error rate's probability
+	errorModeEveryNth = "every-nth" // Exactly every Nth check fails
+
+	everyNthRule = "every-nth"
+)
+
+// ErrorMode picks how /api/check decides to fail. Random sampling makes a
+// small live demo noisy; every-nth fails exactly one check in N, so the
+// analysis sees the same success rate on every run. N counts this pod's
+// checks, and the error rate, status weights, sessions and code paths
+// don't apply while it is on.
+type ErrorMode struct {
+	Mode string `json:"mode"`
+	N    int    `json:"n,omitempty"` // every-nth only
+}
+
+var (
+	errorMode = ErrorMode{
+		Mode: getEnvOrDefault("ERROR_MODE", errorModeRandom),
+		N:    int(getEnvFloatOrDefault("ERROR_EVERY_N", 10)),
+	}
+	errorModeMu sync.RWMutex
+
+	// Checks counted towards the next every-nth failure
+	everyNthCount atomic.Uint64
+)
+
+func init() {
+	if err := errorMode.validate(); err != nil {
+		warnf("Invalid ERROR_MODE/ERROR_EVERY_N, using random: %v", err)
+		errorMode = ErrorMode{Mode: errorModeRandom}
+	}
+	if errorMode.Mode == errorModeRandom {
+		errorMode.N = 0
+	}
+}
+
+func (m ErrorMode) validate() error {
+	switch m.Mode {
+	case errorModeRandom:
+	case errorModeEveryNth:
+		if m.N < 1 {
+			return fmt.Errorf("n must be at least 1")
+		}
+	default:
+		return fmt.Errorf("mode must be %s or %s", errorModeRandom, errorModeEveryNth)
+	}
+	return nil
+}
+
+func currentErrorMode() ErrorMode {
+	errorModeMu.RLock()
+	defer errorModeMu.RUnlock()
+	return errorMode
+}
+
+// applyErrorMode switches to a valid mode and starts the count over.
+func applyErrorMode(update ErrorMode) ErrorMode {
+	if update.Mode == errorModeRandom {
+		update.N = 0
+	}
+	errorModeMu.Lock()
+	errorMode = update
+	everyNthCount.Store(0)
+	errorModeMu.Unlock()
+	return update
+}
+
+// everyNthOutcome counts a check and fails it when it is the Nth.
+func everyNthOutcome(n int) checkOutcome {
+	if everyNthCount.Add(1)%uint64(n) == 0 {
+		return checkOutcome{status: http.StatusInternalServerError, rule: everyNthRule, probability: 1 / float64(n)}
+	}
+	return checkOutcome{status: http.StatusOK}
+}
+
+func getErrorModeHandler(c echo.Context) error {
+	httpRequestsTotal.WithLabelValues("/api/error-mode", fmt.Sprintf("%d", http.StatusOK)).Inc()
+	return c.JSON(http.StatusOK, currentErrorMode())
+}
+
+// setErrorModeHandler switches the mode. The count starts over, so the Nth
+// check after the change is the first to fail.
+func setErrorModeHandler(c echo.Context) error {
+	var update ErrorMode
+	if err := json.NewDecoder(c.Request().Body).Decode(&update); err != nil {
+		httpRequestsTotal.WithLabelValues("/api/set-error-mode", fmt.Sprintf("%d", http.StatusBadRequest)).Inc()
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid JSON"})
+	}
+	if err := update.validate(); err != nil {
+		httpRequestsTotal.WithLabelValues("/api/set-error-mode", fmt.Sprintf("%d", http.StatusBadRequest)).Inc()
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+	update = applyErrorMode(update)
+
+	httpRequestsTotal.WithLabelValues("/api/set-error-mode", fmt.Sprintf("%d", http.StatusOK)).Inc()
+	return c.JSON(http.StatusOK, update)
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Schedule states reported by GET /api/error-rate/schedule.
+const (
+	scheduleStatePending = "pending" // Waiting for StartAt
+	scheduleStateRamping = "ramping"
+	scheduleStateDone    = "done" // To applied, the rate is left alone
+)
+
+// ErrorRateSchedule ramps the error rate linearly from From to To over
+// DurationSeconds, starting at StartAt, so a presenter can program "the
+// canary starts failing 2 minutes in" and keep talking:
+//
+//	{"to": 50, "durationSeconds": 30, "delaySeconds": 120}
+//
+// The rate is set the same way as POST /api/set-error-rate, so every pod
+// follows the pod running the schedule. Once done it sets To one last time
+// and leaves the rate alone. Setting the rate any other way, on any pod,
+// cancels it.
+type ErrorRateSchedule struct {
+	From            *float64  `json:"from"`                   // Percentage, the current rate when omitted
+	To              float64   `json:"to"`                     // Percentage
+	DurationSeconds float64   `json:"durationSeconds"`        // 0 steps straight to To
+	StartAt         time.Time `json:"startAt"`                // Wall-clock start, now when omitted
+	DelaySeconds    float64   `json:"delaySeconds,omitempty"` // Start this long from now instead of at StartAt
+
+	finished bool // To has been applied
+}
+
+// ErrorRateScheduleStatus is the schedule with its progress.
+type ErrorRateScheduleStatus struct {
+	ErrorRateSchedule
+	State    string    `json:"state"`
+	EndsAt   time.Time `json:"endsAt"`
+	Value    float64   `json:"value"`    // Scheduled percentage right now
+	Progress float64   `json:"progress"` // 0-1
+}
+
+var (
+	errorRateSchedule   *ErrorRateSchedule
+	errorRateScheduleMu sync.Mutex
+)
+
+func (s ErrorRateSchedule) validate() error {
+	if s.From != nil {
+		if err := validateErrorRatePercent(*s.From); err != nil {
+			return fmt.Errorf("from: %w", err)
+		}
+	}
+	if err := validateErrorRatePercent(s.To); err != nil {
+		return fmt.Errorf("to: %w", err)
+	}
+	for name, seconds := range map[string]float64{"durationSeconds": s.DurationSeconds, "delaySeconds": s.DelaySeconds} {
+		if math.IsNaN(seconds) || math.IsInf(seconds, 0) || seconds < 0 {
+			return fmt.Errorf("%s must be a finite non-negative number", name)
+		}
+	}
+	if s.DelaySeconds > 0 && !s.StartAt.IsZero() {
+		return errors.New("set either startAt or delaySeconds, not both")
+	}
+	return nil
+}
+
+func (s ErrorRateSchedule) endsAt() time.Time {
+	return s.StartAt.Add(time.Duration(s.DurationSeconds * float64(time.Second)))
+}
+
+// status works out where the ramp is at now. From is always set on a
+// stored schedule.
+func (s ErrorRateSchedule) status(now time.Time) ErrorRateScheduleStatus {
+	status := ErrorRateScheduleStatus{ErrorRateSchedule: s, EndsAt: s.endsAt()}
+	switch {
+	case now.Before(s.StartAt):
+		status.State, status.Value = scheduleStatePending, *s.From
+	case !now.Before(status.EndsAt):
+		status.State, status.Value, status.Progress = scheduleStateDone, s.To, 1
+	default:
+		status.State = scheduleStateRamping
+		status.Progress = now.Sub(s.StartAt).Seconds() / s.DurationSeconds
+		status.Value = *s.From + (s.To-*s.From)*status.Progress
+	}
+	return status
+}
+
+// cancelErrorRateSchedule drops the schedule and reports whether there was
+// one.
+func cancelErrorRateSchedule() bool {
+	errorRateScheduleMu.Lock()
+	defer errorRateScheduleMu.Unlock()
+	cancelled := errorRateSchedule != nil
+	errorRateSchedule = nil
+	return cancelled
+}
+
+// overrideErrorRateSchedule cancels the schedule because the rate was set
+// some other way.
+func overrideErrorRateSchedule(source string) {
+	if cancelErrorRateSchedule() {
+		infof("Error rate schedule cancelled by %s", source)
+	}
+}
+
+// setManualErrorRate sets the error rate on behalf of anything but the
+// schedule, cancelling the schedule first so its next step can't undo it.
+func setManualErrorRate(ctx context.Context, percent float64, source string) bool {
+	overrideErrorRateSchedule(source)
+	return setErrorRatePercent(ctx, percent)
+}
+
+// stepErrorRateSchedule applies the scheduled rate once the ramp has
+// started, and To once more when it is done. A finished schedule stays
+// visible until replaced or cancelled, but no longer sets the rate. The
+// lock is held while setting it, so a manual change, which cancels the
+// schedule first, can't land between reading a step and applying it and be
+// overwritten by the stale step.
+func stepErrorRateSchedule(now time.Time) {
+	errorRateScheduleMu.Lock()
+	defer errorRateScheduleMu.Unlock()
+	if errorRateSchedule == nil || errorRateSchedule.finished {
+		return
+	}
+	status := errorRateSchedule.status(now)
+	if status.State == scheduleStateDone {
+		errorRateSchedule.finished = true
+		infof("Error rate schedule finished at %g%%", status.Value)
+	}
+	if status.State == scheduleStatePending || status.Value == getErrorRatePercent() {
+		return
+	}
+	setErrorRatePercent(redisCtx, status.Value)
+}
+
+func runErrorRateSchedule(stop <-chan struct{}) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case now := <-ticker.C:
+			stepErrorRateSchedule(now)
+		}
+	}
+}
+
+// startErrorRateSchedule fills in From and StartAt where omitted, replaces
+// the schedule and applies a ramp that has already started without waiting
+// for the tick. The schedule must be valid.
+func startErrorRateSchedule(schedule ErrorRateSchedule, now time.Time) ErrorRateSchedule {
+	if schedule.From == nil {
+		from := getErrorRatePercent()
+		schedule.From = &from
+	}
+	if schedule.StartAt.IsZero() {
+		schedule.StartAt = now.Add(time.Duration(schedule.DelaySeconds * float64(time.Second)))
+	}
+	schedule.DelaySeconds = 0
+	schedule.finished = false
+
+	errorRateScheduleMu.Lock()
+	errorRateSchedule = &schedule
+	errorRateScheduleMu.Unlock()
+	infof("Error rate schedule: %g%% to %g%% over %gs from %s",
+		*schedule.From, schedule.To, schedule.DurationSeconds, schedule.StartAt.Format(time.RFC3339))
+
+	stepErrorRateSchedule(now)
+	return schedule
+}
+
+// currentErrorRateSchedule returns a copy of the schedule, nil without one.
+func currentErrorRateSchedule() *ErrorRateSchedule {
+	errorRateScheduleMu.Lock()
+	defer errorRateScheduleMu.Unlock()
+	if errorRateSchedule == nil {
+		return nil
+	}
+	// A copy, as the ticker marks it finished
+	snapshot := *errorRateSchedule
+	return &snapshot
+}
+
+func getErrorRateScheduleHandler(c echo.Context) error {
+	current := currentErrorRateSchedule()
+	if current == nil {
+		httpRequestsTotal.WithLabelValues("/api/error-rate/schedule", fmt.Sprintf("%d", http.StatusNotFound)).Inc()
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "No error rate schedule"})
+	}
+	httpRequestsTotal.WithLabelValues("/api/error-rate/schedule", fmt.Sprintf("%d", http.StatusOK)).Inc()
+	return c.JSON(http.StatusOK, current.status(time.Now()))
+}
+
+// setErrorRateScheduleHandler replaces any running schedule.
+func setErrorRateScheduleHandler(c echo.Context) error {
+	var schedule ErrorRateSchedule
+	if err := json.NewDecoder(c.Request().Body).Decode(&schedule); err != nil {
+		httpRequestsTotal.WithLabelValues("/api/error-rate/schedule", fmt.Sprintf("%d", http.StatusBadRequest)).Inc()
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid JSON"})
+	}
+	if err := schedule.validate(); err != nil {
+		httpRequestsTotal.WithLabelValues("/api/error-rate/schedule", fmt.Sprintf("%d", http.StatusBadRequest)).Inc()
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+
+	now := time.Now().UTC()
+	schedule = startErrorRateSchedule(schedule, now)
+
+	httpRequestsTotal.WithLabelValues("/api/error-rate/schedule", fmt.Sprintf("%d", http.StatusOK)).Inc()
+	return c.JSON(http.StatusOK, schedule.status(now))
+}
+
+func deleteErrorRateScheduleHandler(c echo.Context) error {
+	if !cancelErrorRateSchedule() {
+		httpRequestsTotal.WithLabelValues("/api/error-rate/schedule", fmt.Sprintf("%d", http.StatusNotFound)).Inc()
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "No error rate schedule"})
+	}
+	infof("Error rate schedule cancelled at %g%%", getErrorRatePercent())
+
+	httpRequestsTotal.WithLabelValues("/api/error-rate/schedule", fmt.Sprintf("%d", http.StatusOK)).Inc()
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"message": "Error rate schedule cancelled",
+		"value":   getErrorRatePercent(),
+	})
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	errorRateKey     = "config:error_rate" // Percentage, as set
+	errorRateChannel = "config:error_rate:updates"
+)
+
+// errorRateUpdate is published whenever a pod changes the error rate.
+type errorRateUpdate struct {
+	Value float64 `json:"value"` // Percentage
+	Pod   string  `json:"pod"`
+}
+
+var (
+	// Pub/sub delivers updates immediately; the periodic reload catches
+	// any published while this pod was disconnected
+	errorRateResyncInterval = time.Duration(getEnvFloatOrDefault("ERROR_RATE_RESYNC_SECONDS", 30) * float64(time.Second))
+
+	errorRateSyncTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "error_rate_sync_total",
+			Help: "Error rate replication events by kind (published, received, reloaded) and result",
+		},
+		[]string{"kind", "result"},
+	)
+)
+
+// setErrorRatePercent applies a new error rate on this pod and shares it with
+// every other pod through Redis. It reports whether the rate was replicated;
+// without Redis it only applies here.
+func setErrorRatePercent(ctx context.Context, percent float64) bool {
+	storeErrorRatePercent(percent)
+	if redisClient == nil {
+		return false
+	}
+
+	encoded, err := json.Marshal(errorRateUpdate{Value: percent, Pod: podName})
+	if err != nil {
+		return false
+	}
+	pipe := redisClient.TxPipeline()
+	pipe.Set(ctx, errorRateKey, strconv.FormatFloat(percent, 'g', -1, 64), 0)
+	pipe.Publish(ctx, errorRateChannel, encoded)
+	if _, err := pipe.Exec(ctx); err != nil {
+		errorRateSyncTotal.WithLabelValues("published", "error").Inc()
+		warnf("Failed to replicate error rate: %v", err)
+		return false
+	}
+	errorRateSyncTotal.WithLabelValues("published", "ok").Inc()
+	return true
+}
+
+// loadSharedErrorRate applies the error rate stored in Redis, if any.
+func loadSharedErrorRate(ctx context.Context) error {
+	value, err := redisClient.Get(ctx, errorRateKey).Result()
+	if err == redis.Nil {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	percent, err := strconv.ParseFloat(value, 64)
+	if err == nil {
+		err = validateErrorRatePercent(percent)
+	}
+	if err != nil {
+		return err
+	}
+	if percent != getErrorRatePercent() {
+		debugf("error rate: reloaded %g%% from Redis", percent)
+	}
+	storeErrorRatePercent(percent)
+	return nil
+}
+
+// runErrorRateSync keeps this pod's error rate in step with the shared one.
+func runErrorRateSync(stop <-chan struct{}) {
+	pubsub := redisClient.Subscribe(redisCtx, errorRateChannel)
+	defer pubsub.Close()
+
+	var resync <-chan time.Time
+	if errorRateResyncInterval > 0 {
+		ticker := time.NewTicker(errorRateResyncInterval)
+		defer ticker.Stop()
+		resync = ticker.C
+	}
+
+	messages := pubsub.Channel()
+	for {
+		select {
+		case <-stop:
+			return
+		case msg, ok := <-messages:
+			if !ok {
+				return
+			}
+			var update errorRateUpdate
+			if err := json.Unmarshal([]byte(msg.Payload), &update); err != nil || validateErrorRatePercent(update.Value) != nil {
+				errorRateSyncTotal.WithLabelValues("received", "invalid").Inc()
+				continue
+			}
+			errorRateSyncTotal.WithLabelValues("received", "ok").Inc()
+			if update.Pod != podName {
+				overrideErrorRateSchedule("an error rate change on pod " + update.Pod)
+				storeErrorRatePercent(update.Value)
+				debugf("error rate: %g%% from pod %s", update.Value, update.Pod)
+			}
+		case <-resync:
+			if err := loadSharedErrorRate(redisCtx); err != nil {
+				errorRateSyncTotal.WithLabelValues("reloaded", "error").Inc()
+				warnf("Failed to reload shared error rate: %v", err)
+				continue
+			}
+			errorRateSyncTotal.WithLabelValues("reloaded", "ok").Inc()
+		}
+	}
+}
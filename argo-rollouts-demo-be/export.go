@@ -0,0 +1,184 @@
+package main
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"net/http"
+	"sort"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/labstack/echo/v4"
+)
+
+// Items written between flushes, so clients see progress on long exports
+const exportFlushEvery = 256
+
+// jsonStream writes a JSON document piece by piece, so exports of runs with
+// hours of per-second samples never sit fully encoded in memory. It is
+// compressed with zstd or gzip, whichever the client weighs highest, zstd on
+// a tie.
+// The first write error sticks and later writes are skipped.
+type jsonStream struct {
+	c       echo.Context
+	w       io.Writer
+	enc     streamEncoder // nil when sent uncompressed
+	pending int
+	err     error
+}
+
+// streamEncoder is a compressing writer, *gzip.Writer or *zstd.Encoder.
+type streamEncoder interface {
+	io.Writer
+	Flush() error
+	Close() error
+}
+
+// newJSONStream starts a chunked 200 response, compressed when the client
+// accepts it.
+func newJSONStream(c echo.Context) *jsonStream {
+	s := &jsonStream{c: c, w: c.Response()}
+
+	header := c.Response().Header()
+	header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	header.Add(echo.HeaderVary, echo.HeaderAcceptEncoding)
+	switch preferredEncoding(c, "zstd", "gzip") {
+	case "zstd":
+		// One goroutine, as a stream is flushed often and small
+		if enc, err := zstd.NewWriter(c.Response(), zstd.WithEncoderConcurrency(1)); err == nil {
+			header.Set(echo.HeaderContentEncoding, "zstd")
+			s.enc = enc
+		}
+	case "gzip":
+		header.Set(echo.HeaderContentEncoding, "gzip")
+		s.enc = gzip.NewWriter(c.Response())
+	}
+	if s.enc != nil {
+		s.w = s.enc
+	}
+	c.Response().WriteHeader(http.StatusOK)
+	return s
+}
+
+func (s *jsonStream) raw(text string) {
+	if s.err == nil {
+		_, s.err = io.WriteString(s.w, text)
+	}
+}
+
+func (s *jsonStream) value(v interface{}) {
+	if s.err != nil {
+		return
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		s.err = err
+		return
+	}
+	_, s.err = s.w.Write(data)
+}
+
+// objectWithArray writes header as a JSON object with the field key replaced
+// by an array of n items. Callers clear that field in header first so it
+// isn't encoded twice. writeItem writes the i-th item to the stream,
+// which lets arrays nest without encoding a parent in one piece.
+func (s *jsonStream) objectWithArray(header interface{}, key string, n int, writeItem func(i int)) {
+	if s.err != nil {
+		return
+	}
+	data, err := json.Marshal(header)
+	if err != nil {
+		s.err = err
+		return
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		s.err = err
+		return
+	}
+	delete(fields, key)
+	names := make([]string, 0, len(fields))
+	for name := range fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	s.raw("{")
+	for _, name := range names {
+		s.value(name)
+		s.raw(":")
+		s.value(fields[name])
+		s.raw(",")
+	}
+	s.value(key)
+	s.raw(":[")
+	for i := 0; i < n && s.err == nil; i++ {
+		if i > 0 {
+			s.raw(",")
+		}
+		writeItem(i)
+	}
+	s.raw("]}")
+}
+
+// tick flushes every exportFlushEvery items.
+func (s *jsonStream) tick() {
+	s.pending++
+	if s.pending < exportFlushEvery {
+		return
+	}
+	s.pending = 0
+	s.flush()
+}
+
+func (s *jsonStream) flush() {
+	if s.err != nil {
+		return
+	}
+	if s.enc != nil {
+		s.err = s.enc.Flush()
+	}
+	s.c.Response().Flush()
+}
+
+// Close finishes the compressed stream and returns the first error.
+func (s *jsonStream) Close() error {
+	if s.enc != nil {
+		if err := s.enc.Close(); s.err == nil {
+			s.err = err
+		}
+	}
+	return s.err
+}
+
+// streamRun writes a run with its samples streamed. Samples are append-only,
+// so the slice captured under the lock stays valid after releasing it.
+func streamRun(s *jsonStream, run Run) {
+	samples := run.Samples
+	run.Samples = nil
+	s.objectWithArray(run, "samples", len(samples), func(i int) {
+		s.value(samples[i])
+		s.tick()
+	})
+}
+
+// streamStateArchive writes the archive in the same shape as StateArchive,
+// streaming runs and their samples.
+func streamStateArchive(s *jsonStream, archive StateArchive) {
+	archivedRuns := archive.Runs
+	archive.Runs = nil
+	s.objectWithArray(archive, "runs", len(archivedRuns), func(i int) {
+		streamRun(s, archivedRuns[i])
+		s.flush()
+	})
+}
+
+// streamRunSummary writes a run report, generating the error rate series
+// from the run's samples as it goes rather than copying them into the
+// summary first.
+func streamRunSummary(s *jsonStream, summary RunSummary, samples []RunSample) {
+	s.objectWithArray(summary, "errorRateOverTime", len(samples), func(i int) {
+		s.value(samples[i])
+		s.tick()
+	})
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/labstack/echo/v4"
+)
+
+func TestPreferredEncoding(t *testing.T) {
+	for _, tc := range []struct {
+		accept string
+		want   string
+	}{
+		{"", ""},
+		{"gzip", "gzip"},
+		{"gzip, zstd", "zstd"},
+		{"zstd;q=0.5, gzip", "gzip"},
+		{"zstd;q=0.0, gzip;q=0.1", "gzip"},
+		{"zstd; q=0, gzip; q=0", ""},
+		{"*", "zstd"},
+		{"*;q=0.2, gzip;q=0.5", "gzip"},
+		{"ZSTD", "zstd"},
+		{"zstd;q=bad", ""},
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set(echo.HeaderAcceptEncoding, tc.accept)
+		c := echo.New().NewContext(req, httptest.NewRecorder())
+		if got := preferredEncoding(c, "zstd", "gzip"); got != tc.want {
+			t.Errorf("Accept-Encoding %q: got %q, want %q", tc.accept, got, tc.want)
+		}
+	}
+}
+
+func TestStateExportDecodesWithZstd(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/api/state/export", nil)
+	req.Header.Set(echo.HeaderAcceptEncoding, "zstd")
+	rec := httptest.NewRecorder()
+	if err := exportStateHandler(echo.New().NewContext(req, rec)); err != nil {
+		t.Fatal(err)
+	}
+	if got := rec.Header().Get(echo.HeaderContentEncoding); got != "zstd" {
+		t.Fatalf("Content-Encoding %q, want zstd", got)
+	}
+
+	dec, err := zstd.NewReader(rec.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dec.Close()
+	var archive StateArchive
+	if err := json.NewDecoder(dec).Decode(&archive); err != nil {
+		t.Fatalf("decoding the export: %v", err)
+	}
+	if archive.FormatVersion != stateFormatVersion || archive.Source.Pod != podName {
+		t.Fatalf("got %+v", archive)
+	}
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/labstack/echo/v4"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Dependency-backed features with a configurable failure policy.
+const (
+	failFeatureStore = "store" // Redis counters behind /api/check and /api/metrics
+	failFeatureAuth  = "auth"  // Session lookups
+	failFeatureFlags = "flags" // Flag engine behind OFREP
+)
+
+const (
+	failOpen   = "open"   // Degrade: fall back and keep serving
+	failClosed = "closed" // Refuse: reject the request instead of guessing
+)
+
+// FailPolicy decides what a feature does when its dependency fails.
+// SimulateOutage makes the dependency fail on purpose, so the trade-off can
+// be shown without breaking anything for real; the flag engine is
+// in-process, so it can only fail this way.
+type FailPolicy struct {
+	Mode           string `json:"mode"`
+	SimulateOutage bool   `json:"simulateOutage"`
+}
+
+var (
+	// Counters and flags degrade gracefully, but a session that can't be
+	// verified is refused rather than silently widened to the shared settings
+	defaultFailPolicies = map[string]string{
+		failFeatureStore: failOpen,
+		failFeatureAuth:  failClosed,
+		failFeatureFlags: failOpen,
+	}
+
+	failPolicies   = loadFailPolicies()
+	failPoliciesMu sync.RWMutex
+
+	errSimulatedOutage = errors.New("simulated dependency outage")
+
+	dependencyFailuresTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "dependency_failures_total",
+			Help: "Dependency failures by feature and the policy applied (open: served a fallback, closed: rejected)",
+		},
+		[]string{"feature", "policy"},
+	)
+)
+
+// loadFailPolicies reads FAIL_POLICY_<FEATURE> for every feature.
+func loadFailPolicies() map[string]FailPolicy {
+	policies := make(map[string]FailPolicy, len(defaultFailPolicies))
+	for feature, mode := range defaultFailPolicies {
+		key := "FAIL_POLICY_" + strings.ToUpper(feature)
+		if value := getEnvOrDefault(key, mode); validFailPolicyMode(value) {
+			mode = value
+		} else {
+			warnf("Invalid %s %q, using %q", key, value, mode)
+		}
+		policies[feature] = FailPolicy{Mode: mode}
+	}
+	return policies
+}
+
+func failPolicyFor(feature string) FailPolicy {
+	failPoliciesMu.RLock()
+	defer failPoliciesMu.RUnlock()
+	return failPolicies[feature]
+}
+
+// simulatedOutage returns errSimulatedOutage while the feature's outage
+// simulation is switched on.
+func simulatedOutage(feature string) error {
+	if failPolicyFor(feature).SimulateOutage {
+		return errSimulatedOutage
+	}
+	return nil
+}
+
+// dependencyFailure applies the feature's policy to a dependency error. It
+// returns nil when the caller should fall back and carry on, and the error
+// when it should reject the request. A nil err is passed through untouched.
+func dependencyFailure(feature string, err error) error {
+	if err == nil {
+		return nil
+	}
+	policy := failPolicyFor(feature)
+	dependencyFailuresTotal.WithLabelValues(feature, policy.Mode).Inc()
+	debugf("fail policy: %s dependency failed (fail-%s): %v", feature, policy.Mode, err)
+
+	if policy.Mode == failClosed {
+		return err
+	}
+	return nil
+}
+
+func validFailPolicyMode(mode string) bool {
+	return mode == failOpen || mode == failClosed
+}
+
+func getFailPoliciesHandler(c echo.Context) error {
+	failPoliciesMu.RLock()
+	current := make(map[string]FailPolicy, len(failPolicies))
+	for feature, policy := range failPolicies {
+		current[feature] = policy
+	}
+	failPoliciesMu.RUnlock()
+
+	httpRequestsTotal.WithLabelValues("/api/fail-policies", fmt.Sprintf("%d", http.StatusOK)).Inc()
+	return c.JSON(http.StatusOK, current)
+}
+
+// setFailPoliciesHandler updates the features present in the body and leaves
+// the others as they are.
+func setFailPoliciesHandler(c echo.Context) error {
+	failPoliciesMu.RLock()
+	update := make(map[string]FailPolicy, len(failPolicies))
+	for feature, policy := range failPolicies {
+		update[feature] = policy
+	}
+	failPoliciesMu.RUnlock()
+
+	var changes map[string]json.RawMessage
+	if err := json.NewDecoder(c.Request().Body).Decode(&changes); err != nil {
+		httpRequestsTotal.WithLabelValues("/api/fail-policies", fmt.Sprintf("%d", http.StatusBadRequest)).Inc()
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid JSON"})
+	}
+
+	features := make([]string, 0, len(changes))
+	for feature := range changes {
+		features = append(features, feature)
+	}
+	sort.Strings(features)
+
+	for _, feature := range features {
+		policy, ok := update[feature]
+		if !ok {
+			httpRequestsTotal.WithLabelValues("/api/fail-policies", fmt.Sprintf("%d", http.StatusBadRequest)).Inc()
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("Unknown feature %q", feature)})
+		}
+		if err := json.Unmarshal(changes[feature], &policy); err != nil || !validFailPolicyMode(policy.Mode) {
+			httpRequestsTotal.WithLabelValues("/api/fail-policies", fmt.Sprintf("%d", http.StatusBadRequest)).Inc()
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"error": fmt.Sprintf("Policy for %s must have mode \"open\" or \"closed\"", feature),
+			})
+		}
+		update[feature] = policy
+	}
+
+	failPoliciesMu.Lock()
+	failPolicies = update
+	failPoliciesMu.Unlock()
+
+	httpRequestsTotal.WithLabelValues("/api/fail-policies", fmt.Sprintf("%d", http.StatusOK)).Inc()
+	return c.JSON(http.StatusOK, update)
+}
@@ -0,0 +1,339 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// fakeRedis is a minimal RESP2 server with the commands the counters use,
+// so tests can run against "Redis" without one. In cluster mode it refuses
+// multi-key commands spanning slots with CROSSSLOT, as a real cluster node
+// does.
+type fakeRedis struct {
+	addr    string
+	cluster bool
+
+	mu      sync.Mutex
+	strings map[string]string
+	sets    map[string]map[string]bool
+	hashes  map[string]map[string]string
+}
+
+func newFakeRedis(t *testing.T, cluster bool) *fakeRedis {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := &fakeRedis{
+		addr:    listener.Addr().String(),
+		cluster: cluster,
+		strings: map[string]string{},
+		sets:    map[string]map[string]bool{},
+		hashes:  map[string]map[string]string{},
+	}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go f.serve(conn)
+		}
+	}()
+	return f
+}
+
+// client connects to the fake, through a cluster client in cluster mode.
+func (f *fakeRedis) client(t *testing.T) redis.UniversalClient {
+	t.Helper()
+	var client redis.UniversalClient
+	if f.cluster {
+		// A single node serving every slot
+		client = redis.NewClusterClient(&redis.ClusterOptions{
+			ClusterSlots: func(context.Context) ([]redis.ClusterSlot, error) {
+				return []redis.ClusterSlot{{Start: 0, End: 16383, Nodes: []redis.ClusterNode{{Addr: f.addr}}}}, nil
+			},
+		})
+	} else {
+		client = redis.NewClient(&redis.Options{Addr: f.addr})
+	}
+	t.Cleanup(func() { client.Close() })
+	return client
+}
+
+func (f *fakeRedis) serve(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	w := bufio.NewWriter(conn)
+	var queued [][]string
+	inMulti := false
+	for {
+		args, err := readRESP(r)
+		if err != nil {
+			return
+		}
+		name := strings.ToUpper(args[0])
+		switch {
+		case name == "MULTI":
+			inMulti, queued = true, nil
+			w.WriteString("+OK\r\n")
+		case name == "EXEC":
+			inMulti = false
+			fmt.Fprintf(w, "*%d\r\n", len(queued))
+			for _, queuedArgs := range queued {
+				writeRESP(w, f.exec(queuedArgs))
+			}
+		case inMulti:
+			queued = append(queued, args)
+			w.WriteString("+QUEUED\r\n")
+		default:
+			writeRESP(w, f.exec(args))
+		}
+		if r.Buffered() == 0 {
+			if err := w.Flush(); err != nil {
+				return
+			}
+		}
+	}
+}
+
+type respError string
+type respStatus string
+
+func (f *fakeRedis) exec(args []string) interface{} {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	name, keys := strings.ToUpper(args[0]), args[1:]
+	switch name {
+	case "MGET", "DEL":
+		if f.cluster && !sameSlot(keys) {
+			return respError("CROSSSLOT Keys in request don't hash to the same slot")
+		}
+	}
+
+	switch name {
+	case "PING":
+		return respStatus("PONG")
+	case "HELLO":
+		return respError("ERR unknown command 'HELLO'")
+	case "CLIENT", "SELECT", "READONLY":
+		return respStatus("OK")
+	case "GET":
+		if value, ok := f.strings[keys[0]]; ok {
+			return value
+		}
+		return nil
+	case "SET":
+		f.strings[keys[0]] = keys[1]
+		return respStatus("OK")
+	case "MGET":
+		values := make([]interface{}, len(keys))
+		for i, key := range keys {
+			if value, ok := f.strings[key]; ok {
+				values[i] = value
+			}
+		}
+		return values
+	case "DEL":
+		n := int64(0)
+		for _, key := range keys {
+			_, isString := f.strings[key]
+			_, isSet := f.sets[key]
+			_, isHash := f.hashes[key]
+			if isString || isSet || isHash {
+				n++
+			}
+			delete(f.strings, key)
+			delete(f.sets, key)
+			delete(f.hashes, key)
+		}
+		return n
+	case "INCR", "INCRBY":
+		by := int64(1)
+		if name == "INCRBY" {
+			by, _ = strconv.ParseInt(keys[1], 10, 64)
+		}
+		n, _ := strconv.ParseInt(f.strings[keys[0]], 10, 64)
+		n += by
+		f.strings[keys[0]] = strconv.FormatInt(n, 10)
+		return n
+	case "EXPIRE":
+		return int64(1)
+	case "SADD":
+		set := f.sets[keys[0]]
+		if set == nil {
+			set = map[string]bool{}
+			f.sets[keys[0]] = set
+		}
+		added := int64(0)
+		for _, member := range keys[1:] {
+			if !set[member] {
+				set[member] = true
+				added++
+			}
+		}
+		return added
+	case "SMEMBERS":
+		members := make([]interface{}, 0, len(f.sets[keys[0]]))
+		for member := range f.sets[keys[0]] {
+			members = append(members, member)
+		}
+		return members
+	case "HINCRBY":
+		hash := f.hashes[keys[0]]
+		if hash == nil {
+			hash = map[string]string{}
+			f.hashes[keys[0]] = hash
+		}
+		by, _ := strconv.ParseInt(keys[2], 10, 64)
+		n, _ := strconv.ParseInt(hash[keys[1]], 10, 64)
+		n += by
+		hash[keys[1]] = strconv.FormatInt(n, 10)
+		return n
+	case "HSET":
+		hash := f.hashes[keys[0]]
+		if hash == nil {
+			hash = map[string]string{}
+			f.hashes[keys[0]] = hash
+		}
+		for i := 1; i+1 < len(keys); i += 2 {
+			hash[keys[i]] = keys[i+1]
+		}
+		return int64(len(keys) / 2)
+	case "HGETALL":
+		fields := []interface{}{}
+		for field, value := range f.hashes[keys[0]] {
+			fields = append(fields, field, value)
+		}
+		return fields
+	case "PUBLISH":
+		return int64(0)
+	case "SCAN":
+		pattern := "*"
+		for i := 1; i+1 < len(keys); i++ {
+			if strings.EqualFold(keys[i], "MATCH") {
+				pattern = keys[i+1]
+			}
+		}
+		matched := []interface{}{}
+		for _, key := range f.keys() {
+			if ok, _ := path.Match(pattern, key); ok {
+				matched = append(matched, key)
+			}
+		}
+		return []interface{}{"0", matched}
+	}
+	return respError(fmt.Sprintf("ERR unknown command '%s'", args[0]))
+}
+
+func (f *fakeRedis) keys() []string {
+	var keys []string
+	for key := range f.strings {
+		keys = append(keys, key)
+	}
+	for key := range f.sets {
+		keys = append(keys, key)
+	}
+	for key := range f.hashes {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func readRESP(r *bufio.Reader) ([]string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	line = strings.TrimRight(line, "\r\n")
+	if !strings.HasPrefix(line, "*") {
+		return strings.Fields(line), nil
+	}
+	n, err := strconv.Atoi(line[1:])
+	if err != nil {
+		return nil, err
+	}
+	args := make([]string, n)
+	for i := range args {
+		header, err := r.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		size, err := strconv.Atoi(strings.TrimRight(header, "\r\n")[1:])
+		if err != nil {
+			return nil, err
+		}
+		buf := make([]byte, size+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		args[i] = string(buf[:size])
+	}
+	return args, nil
+}
+
+func writeRESP(w *bufio.Writer, value interface{}) {
+	switch v := value.(type) {
+	case nil:
+		w.WriteString("$-1\r\n")
+	case respError:
+		fmt.Fprintf(w, "-%s\r\n", v)
+	case respStatus:
+		fmt.Fprintf(w, "+%s\r\n", v)
+	case int64:
+		fmt.Fprintf(w, ":%d\r\n", v)
+	case string:
+		fmt.Fprintf(w, "$%d\r\n%s\r\n", len(v), v)
+	case []interface{}:
+		fmt.Fprintf(w, "*%d\r\n", len(v))
+		for _, item := range v {
+			writeRESP(w, item)
+		}
+	}
+}
+
+// sameSlot reports whether every key hashes to one cluster slot.
+func sameSlot(keys []string) bool {
+	for _, key := range keys[1:] {
+		if clusterSlot(key) != clusterSlot(keys[0]) {
+			return false
+		}
+	}
+	return true
+}
+
+// clusterSlot is the Redis Cluster hash slot of a key, honouring {hash tags}.
+func clusterSlot(key string) int {
+	if start := strings.IndexByte(key, '{'); start >= 0 {
+		if end := strings.IndexByte(key[start+1:], '}'); end > 0 {
+			key = key[start+1 : start+1+end]
+		}
+	}
+	crc := uint16(0)
+	for i := 0; i < len(key); i++ {
+		crc ^= uint16(key[i]) << 8
+		for bit := 0; bit < 8; bit++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return int(crc % 16384)
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+const faultLogCapacity = 500
+
+// InjectedFailure records one intentionally failed request, so presenters
+// can answer "why did that request fail?" without digging through logs.
+type InjectedFailure struct {
+	Timestamp time.Time `json:"timestamp"`
+	Endpoint  string    `json:"endpoint"`
+	Rule      string    `json:"rule"`
+	Status    int       `json:"status"`
+	TraceID   string    `json:"traceId,omitempty"`
+	Detail    string    `json:"detail,omitempty"`
+}
+
+// faultLog is a fixed-size ring buffer; once full, the oldest entry is
+// overwritten so recording never allocates or blocks for long.
+type faultLog struct {
+	mu      sync.Mutex
+	entries [faultLogCapacity]InjectedFailure
+	next    int
+	count   int
+	total   int64
+}
+
+var injectedFailures = &faultLog{}
+
+func (l *faultLog) Record(entry InjectedFailure) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.entries[l.next] = entry
+	l.next = (l.next + 1) % faultLogCapacity
+	if l.count < faultLogCapacity {
+		l.count++
+	}
+	l.total++
+}
+
+// Recent returns up to limit entries, newest first.
+func (l *faultLog) Recent(limit int) ([]InjectedFailure, int64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if limit <= 0 || limit > l.count {
+		limit = l.count
+	}
+	entries := make([]InjectedFailure, 0, limit)
+	for i := 0; i < limit; i++ {
+		idx := (l.next - 1 - i + faultLogCapacity) % faultLogCapacity
+		entries = append(entries, l.entries[idx])
+	}
+	return entries, l.total
+}
+
+func (l *faultLog) Clear() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.next, l.count = 0, 0
+}
+
+func recordInjectedFailure(ctx context.Context, endpoint, rule string, status int, detail string) {
+	entry := InjectedFailure{
+		Timestamp: time.Now().UTC(),
+		Endpoint:  endpoint,
+		Rule:      rule,
+		Status:    status,
+		Detail:    detail,
+	}
+	if trace, ok := traceFromContext(ctx); ok {
+		entry.TraceID = trace.TraceID
+	}
+	injectedFailures.Record(entry)
+	markInjected(ctx, status)
+}
+
+func faultLogHandler(c echo.Context) error {
+	limit := faultLogCapacity
+	if value := c.QueryParam("limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 1 {
+			httpRequestsTotal.WithLabelValues("/api/faults/log", fmt.Sprintf("%d", http.StatusBadRequest)).Inc()
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "limit must be a positive integer"})
+		}
+		limit = parsed
+	}
+
+	entries, total := injectedFailures.Recent(limit)
+	httpRequestsTotal.WithLabelValues("/api/faults/log", fmt.Sprintf("%d", http.StatusOK)).Inc()
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"capacity": faultLogCapacity,
+		"total":    total,
+		"entries":  entries,
+	})
+}
+
+func clearFaultLogHandler(c echo.Context) error {
+	injectedFailures.Clear()
+	httpRequestsTotal.WithLabelValues("/api/faults/log", fmt.Sprintf("%d", http.StatusOK)).Inc()
+	return c.JSON(http.StatusOK, map[string]string{"message": "Fault log cleared"})
+}
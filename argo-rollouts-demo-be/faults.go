@@ -0,0 +1,196 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"argo-rollouts-demo-be/internal/weighted"
+
+	"github.com/labstack/echo/v4"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// FaultConfig is the global chaos applied by the "faults" middleware to every
+// route except the exempt ones. Unlike the /api/check error rate it affects
+// all endpoints, which is why probes are exempt by default.
+type FaultConfig struct {
+	LatencyMs   float64  `json:"latencyMs" yaml:"latencyMs"`
+	ErrorRate   float64  `json:"errorRate" yaml:"errorRate"` // Percentage
+	ExemptPaths []string `json:"exemptPaths" yaml:"exemptPaths"`
+}
+
+var (
+	// Endpoints that kubelet probes, scrapers and profiling depend on.
+	// Entries ending in "*" match by prefix.
+	defaultFaultExemptPaths = []string{"/api/healthz", "/api/readyz", "/api/metrics", "/metrics", "/debug/pprof/*"}
+
+	faultConfig = FaultConfig{
+		LatencyMs:   getEnvFloatOrDefault("FAULT_LATENCY_MS", 0),
+		ErrorRate:   getEnvFloatOrDefault("FAULT_ERROR_RATE", 0),
+		ExemptPaths: splitList(getEnvOrDefault("FAULT_EXEMPT_PATHS", strings.Join(defaultFaultExemptPaths, ","))),
+	}
+	faultConfigMu sync.RWMutex
+
+	faultsInjectedTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "faults_injected_total",
+			Help: "Total number of faults injected by the global fault middleware by endpoint and type",
+		},
+		[]string{"endpoint", "type"},
+	)
+	faultsExemptedTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "faults_exempted_total",
+			Help: "Total number of requests skipped by the global fault middleware because the endpoint is exempt",
+		},
+		[]string{"endpoint"},
+	)
+)
+
+func (f FaultConfig) validate() error {
+	if math.IsNaN(f.LatencyMs) || math.IsInf(f.LatencyMs, 0) || f.LatencyMs < 0 {
+		return fmt.Errorf("latency must be a finite non-negative number")
+	}
+	return validateErrorRatePercent(f.ErrorRate)
+}
+
+// applyFaultConfigFile overlays the config file settings. Environment
+// variables win, so a field is only taken from the file when unset in env.
+func applyFaultConfigFile(cfg *FaultConfig) {
+	if cfg == nil {
+		return
+	}
+
+	faultConfigMu.Lock()
+	defer faultConfigMu.Unlock()
+	if getEnvOrDefault("FAULT_LATENCY_MS", "") == "" {
+		faultConfig.LatencyMs = cfg.LatencyMs
+	}
+	if getEnvOrDefault("FAULT_ERROR_RATE", "") == "" {
+		faultConfig.ErrorRate = cfg.ErrorRate
+	}
+	if getEnvOrDefault("FAULT_EXEMPT_PATHS", "") == "" && cfg.ExemptPaths != nil {
+		faultConfig.ExemptPaths = cfg.ExemptPaths
+	}
+}
+
+func isFaultExempt(path string, exempt []string) bool {
+	for _, pattern := range exempt {
+		if prefix, ok := strings.CutSuffix(pattern, "*"); ok {
+			if strings.HasPrefix(path, prefix) {
+				return true
+			}
+		} else if path == pattern {
+			return true
+		}
+	}
+	return false
+}
+
+// faultsMiddleware applies the chaos header, then the global faults, then
+// the route faults.
+func faultsMiddleware(next echo.HandlerFunc) echo.HandlerFunc {
+	return headerFaultsMiddleware(globalFaultsMiddleware(routeFaultsMiddleware(next)))
+}
+
+func globalFaultsMiddleware(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		faultConfigMu.RLock()
+		cfg := faultConfig
+		faultConfigMu.RUnlock()
+
+		if (cfg.LatencyMs <= 0 && cfg.ErrorRate <= 0) || chaosBypassed(c.Request().Context()) {
+			return next(c)
+		}
+
+		// Never fault the fault controls themselves, or chaos can't be turned off
+		endpoint := c.Path()
+		if strings.HasPrefix(endpoint, "/api/faults") {
+			return next(c)
+		}
+		if isFaultExempt(endpoint, cfg.ExemptPaths) {
+			faultsExemptedTotal.WithLabelValues(endpoint).Inc()
+			if cfg.LatencyMs > 0 {
+				recordFaultRule("global-latency", faultOutcomeExempt, 100)
+			}
+			if cfg.ErrorRate > 0 {
+				recordFaultRule("global-fault", faultOutcomeExempt, cfg.ErrorRate)
+			}
+			return next(c)
+		}
+
+		if cfg.LatencyMs > 0 {
+			faultsInjectedTotal.WithLabelValues(endpoint, "latency").Inc()
+			recordFaultRule("global-latency", faultOutcomeApplied, 100)
+			start := time.Now()
+			select {
+			case <-time.After(time.Duration(cfg.LatencyMs * float64(time.Millisecond))):
+			case <-c.Request().Context().Done():
+				return c.Request().Context().Err()
+			}
+			addServerTiming(c.Request().Context(), timingDelay, time.Since(start))
+		}
+
+		if cfg.ErrorRate > 0 {
+			failed := weighted.Chance(cfg.ErrorRate/100.0, randomFloat())
+			if failed && !allowInjectedFailure("global-fault") {
+				recordFaultRule("global-fault", faultOutcomeCapped, cfg.ErrorRate)
+				return next(c)
+			}
+			if failed {
+				recordFaultRule("global-fault", faultOutcomeApplied, cfg.ErrorRate)
+				debugContextf(c.Request().Context(), "faults: injected error on %s", endpoint)
+				faultsInjectedTotal.WithLabelValues(endpoint, "error").Inc()
+				recordInjectedFailure(c.Request().Context(), endpoint, "global-fault", http.StatusInternalServerError,
+					fmt.Sprintf("probability=%.4f", cfg.ErrorRate/100.0))
+				httpRequestsTotal.WithLabelValues(endpoint, fmt.Sprintf("%d", http.StatusInternalServerError)).Inc()
+				return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Injected fault"})
+			}
+			recordFaultRule("global-fault", faultOutcomeSampling, cfg.ErrorRate)
+		}
+
+		return next(c)
+	}
+}
+
+func getFaultsHandler(c echo.Context) error {
+	faultConfigMu.RLock()
+	current := faultConfig
+	faultConfigMu.RUnlock()
+
+	httpRequestsTotal.WithLabelValues("/api/faults", fmt.Sprintf("%d", http.StatusOK)).Inc()
+	return c.JSON(http.StatusOK, current)
+}
+
+func setFaultsHandler(c echo.Context) error {
+	faultConfigMu.RLock()
+	update := faultConfig
+	faultConfigMu.RUnlock()
+
+	// Omitted fields keep their current value
+	if err := json.NewDecoder(c.Request().Body).Decode(&update); err != nil {
+		httpRequestsTotal.WithLabelValues("/api/faults", fmt.Sprintf("%d", http.StatusBadRequest)).Inc()
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid JSON"})
+	}
+
+	if err := update.validate(); err != nil {
+		httpRequestsTotal.WithLabelValues("/api/faults", fmt.Sprintf("%d", http.StatusBadRequest)).Inc()
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+	if update.ExemptPaths == nil {
+		update.ExemptPaths = []string{}
+	}
+
+	faultConfigMu.Lock()
+	faultConfig = update
+	faultConfigMu.Unlock()
+
+	httpRequestsTotal.WithLabelValues("/api/faults", fmt.Sprintf("%d", http.StatusOK)).Inc()
+	return c.JSON(http.StatusOK, update)
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Outcomes of evaluating a fault rule against a request.
+const (
+	faultOutcomeApplied  = "applied"
+	faultOutcomeSampling = "skipped_sampling" // Rule matched but the dice said no
+	faultOutcomeExempt   = "skipped_exempt"   // Endpoint is exempt from the rule
+	faultOutcomeCapped   = "skipped_cap"      // Suppressed by the blast-radius cap
+)
+
+// FaultRuleStats reports how a rule is actually firing, to compare against
+// the rate it was configured with.
+type FaultRuleStats struct {
+	Rule            string    `json:"rule"`
+	Matches         int64     `json:"matches"`
+	Applied         int64     `json:"applied"`
+	SkippedSampling int64     `json:"skippedSampling"`
+	SkippedExempt   int64     `json:"skippedExempt"`
+	SkippedCap      int64     `json:"skippedCap"`
+	ConfiguredRate  float64   `json:"configuredRate"` // Percentage at the last evaluation
+	ObservedRate    float64   `json:"observedRate"`   // Applied share of sampled requests, percentage
+	LastAppliedAt   time.Time `json:"lastAppliedAt,omitempty"`
+}
+
+var (
+	faultRuleStats   = map[string]*FaultRuleStats{}
+	faultRuleStatsMu sync.Mutex
+	faultStatsSince  = time.Now().UTC()
+
+	faultRuleEvaluationsTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "fault_rule_evaluations_total",
+			Help: "Fault rule evaluations by rule and outcome (applied, skipped_sampling, skipped_exempt, skipped_cap)",
+		},
+		[]string{"rule", "outcome"},
+	)
+)
+
+// recordFaultRule counts one evaluation of a rule. configuredRate is the
+// rule's probability in percent (100 for rules that always apply).
+func recordFaultRule(rule, outcome string, configuredRate float64) {
+	faultRuleEvaluationsTotal.WithLabelValues(rule, outcome).Inc()
+
+	faultRuleStatsMu.Lock()
+	defer faultRuleStatsMu.Unlock()
+
+	stats, ok := faultRuleStats[rule]
+	if !ok {
+		stats = &FaultRuleStats{Rule: rule}
+		faultRuleStats[rule] = stats
+	}
+	stats.Matches++
+	stats.ConfiguredRate = configuredRate
+	switch outcome {
+	case faultOutcomeApplied:
+		stats.Applied++
+		stats.LastAppliedAt = time.Now().UTC()
+	case faultOutcomeSampling:
+		stats.SkippedSampling++
+	case faultOutcomeExempt:
+		stats.SkippedExempt++
+	case faultOutcomeCapped:
+		stats.SkippedCap++
+	}
+}
+
+func faultStatsHandler(c echo.Context) error {
+	faultRuleStatsMu.Lock()
+	rules := make([]FaultRuleStats, 0, len(faultRuleStats))
+	for _, stats := range faultRuleStats {
+		snapshot := *stats
+		if sampled := snapshot.Applied + snapshot.SkippedSampling + snapshot.SkippedCap; sampled > 0 {
+			snapshot.ObservedRate = float64(snapshot.Applied) / float64(sampled) * 100.0
+		}
+		rules = append(rules, snapshot)
+	}
+	since := faultStatsSince
+	faultRuleStatsMu.Unlock()
+
+	sort.Slice(rules, func(i, j int) bool { return rules[i].Rule < rules[j].Rule })
+
+	httpRequestsTotal.WithLabelValues("/api/faults/stats", fmt.Sprintf("%d", http.StatusOK)).Inc()
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"since": since,
+		"rules": rules,
+	})
+}
+
+func resetFaultStatsHandler(c echo.Context) error {
+	faultRuleStatsMu.Lock()
+	faultRuleStats = map[string]*FaultRuleStats{}
+	faultStatsSince = time.Now().UTC()
+	faultRuleStatsMu.Unlock()
+
+	httpRequestsTotal.WithLabelValues("/api/faults/stats", fmt.Sprintf("%d", http.StatusOK)).Inc()
+	return c.JSON(http.StatusOK, map[string]string{"message": "Fault stats reset"})
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"net/http"
+	"sync"
+
+	"github.com/labstack/echo/v4"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const (
+	codePathLegacy = "legacy"
+	codePathNew    = "new"
+)
+
+// FeatureCanary configures the in-app "new code path" cohort. Unlike the
+// deployment canary, every pod runs both paths and the client ID decides
+// which one executes, so the same client always lands on the same path.
+type FeatureCanary struct {
+	Percent   float64  `json:"percent"`             // Share of clients on the new path (0-100)
+	ErrorRate *float64 `json:"errorRate,omitempty"` // Error rate for the new path in percent, global rate when unset
+}
+
+var (
+	featureCanary = FeatureCanary{
+		Percent: getEnvFloatOrDefault("FEATURE_CANARY_PERCENT", 0),
+	}
+	featureCanaryMu sync.RWMutex
+
+	codePathRequestsTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "code_path_requests_total",
+			Help: "Total number of /api/check requests by in-app code path and status code",
+		},
+		[]string{"code_path", "status_code"},
+	)
+)
+
+// clientBucket maps a client ID onto a stable bucket in [0, 100) using the
+// trailing two decimal digits of its FNV-1a hash.
+func clientBucket(clientID string) uint32 {
+	h := fnv.New32a()
+	h.Write([]byte(clientID))
+	return h.Sum32() % 100
+}
+
+// codePathFor decides which code path serves the request. Requests without a
+// client ID always take the legacy path so anonymous traffic is unaffected.
+func codePathFor(c echo.Context) string {
+	clientID := c.Request().Header.Get("X-Client-ID")
+	if clientID == "" {
+		clientID = c.QueryParam("clientId")
+	}
+	return codePathForClient(clientID)
+}
+
+// codePathForClient is the header-independent part of codePathFor, shared
+// with the flag engine.
+func codePathForClient(clientID string) string {
+	if clientID == "" {
+		return codePathLegacy
+	}
+
+	featureCanaryMu.RLock()
+	percent := featureCanary.Percent
+	featureCanaryMu.RUnlock()
+
+	if float64(clientBucket(clientID)) < percent {
+		return codePathNew
+	}
+	return codePathLegacy
+}
+
+// codePathErrorRate returns the error probability (0-1) for the code path
+// and the name of the rule it came from.
+func codePathErrorRate(codePath string, globalRate float64) (float64, string) {
+	if codePath != codePathNew {
+		return globalRate, "error-rate"
+	}
+
+	featureCanaryMu.RLock()
+	defer featureCanaryMu.RUnlock()
+	if featureCanary.ErrorRate != nil {
+		return *featureCanary.ErrorRate / 100.0, "feature-canary"
+	}
+	return globalRate, "error-rate"
+}
+
+func getFeatureCanaryHandler(c echo.Context) error {
+	featureCanaryMu.RLock()
+	current := featureCanary
+	featureCanaryMu.RUnlock()
+
+	httpRequestsTotal.WithLabelValues("/api/feature-canary", fmt.Sprintf("%d", http.StatusOK)).Inc()
+	return c.JSON(http.StatusOK, current)
+}
+
+func (f FeatureCanary) validate() error {
+	if math.IsNaN(f.Percent) || f.Percent < 0 || f.Percent > 100 {
+		return fmt.Errorf("percent must be between 0 and 100")
+	}
+	if f.ErrorRate != nil {
+		return validateErrorRatePercent(*f.ErrorRate)
+	}
+	return nil
+}
+
+func setFeatureCanaryHandler(c echo.Context) error {
+	var update FeatureCanary
+	if err := json.NewDecoder(c.Request().Body).Decode(&update); err != nil {
+		httpRequestsTotal.WithLabelValues("/api/feature-canary", fmt.Sprintf("%d", http.StatusBadRequest)).Inc()
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid JSON"})
+	}
+
+	if err := update.validate(); err != nil {
+		httpRequestsTotal.WithLabelValues("/api/feature-canary", fmt.Sprintf("%d", http.StatusBadRequest)).Inc()
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+
+	featureCanaryMu.Lock()
+	featureCanary = update
+	featureCanaryMu.Unlock()
+
+	httpRequestsTotal.WithLabelValues("/api/feature-canary", fmt.Sprintf("%d", http.StatusOK)).Inc()
+	return c.JSON(http.StatusOK, update)
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Evaluation reasons, following the OpenFeature specification.
+const (
+	flagReasonStatic  = "STATIC"
+	flagReasonSplit   = "SPLIT"
+	flagReasonDefault = "DEFAULT"
+	flagReasonError   = "ERROR"
+)
+
+// FlagContext is the evaluation context sent by a client. TargetingKey
+// plays the role of the X-Client-ID header.
+type FlagContext struct {
+	TargetingKey string                 `json:"targetingKey,omitempty"`
+	Attributes   map[string]interface{} `json:"-"`
+}
+
+type FlagEvaluation struct {
+	Key      string                 `json:"key"`
+	Value    interface{}            `json:"value"`
+	Reason   string                 `json:"reason"`
+	Variant  string                 `json:"variant,omitempty"`
+	Metadata map[string]interface{} `json:"metadata,omitempty"`
+}
+
+// Flags served by the backend. They are read-only views of the runtime
+// configuration, so changing e.g. the feature canary through its API is
+// immediately visible to flag clients.
+var flagEngine = map[string]func(ctx FlagContext) FlagEvaluation{
+	"new-code-path": func(ctx FlagContext) FlagEvaluation {
+		if ctx.TargetingKey == "" {
+			return FlagEvaluation{Value: false, Reason: flagReasonDefault, Variant: codePathLegacy}
+		}
+		codePath := codePathForClient(ctx.TargetingKey)
+		return FlagEvaluation{
+			Value:    codePath == codePathNew,
+			Reason:   flagReasonSplit,
+			Variant:  codePath,
+			Metadata: map[string]interface{}{"bucket": clientBucket(ctx.TargetingKey)},
+		}
+	},
+	"error-rate": func(FlagContext) FlagEvaluation {
+		return FlagEvaluation{Value: effectiveErrorRate() * 100.0, Reason: flagReasonStatic}
+	},
+	"banner-color": func(FlagContext) FlagEvaluation {
+		return FlagEvaluation{Value: versionColor(version), Reason: flagReasonStatic, Variant: version}
+	},
+	"rollout-role": func(FlagContext) FlagEvaluation {
+		role := rolloutRole()
+		return FlagEvaluation{Value: role, Reason: flagReasonStatic, Variant: role}
+	},
+	"verbose-check": func(FlagContext) FlagEvaluation {
+		return FlagEvaluation{Value: checkVerbose, Reason: flagReasonStatic}
+	},
+}
+
+// initFlagEngine checks that every flag has a fail-open default, so a flag
+// added without one disables the engine instead of serving nil.
+func initFlagEngine() error {
+	for key := range flagEngine {
+		if _, ok := flagDefaults[key]; !ok {
+			return fmt.Errorf("flag %q has no default value", key)
+		}
+	}
+	return nil
+}
+
+// Values served when the flag engine fails open: the safe choice for each
+// flag, i.e. the legacy code path and no injected errors.
+var flagDefaults = map[string]interface{}{
+	"new-code-path": false,
+	"error-rate":    0.0,
+	"banner-color":  "",
+	"rollout-role":  "unknown",
+	"verbose-check": false,
+}
+
+// evaluateFlag returns false when the flag doesn't exist. When the engine is
+// unavailable it returns the flag's default with reason ERROR, or the error
+// if the engine fails closed or is disabled.
+func evaluateFlag(key string, ctx FlagContext) (FlagEvaluation, bool, error) {
+	if err := flagsSubsystem.start(); err != nil {
+		return FlagEvaluation{}, true, err
+	}
+	evaluate, ok := flagEngine[key]
+	if !ok {
+		return FlagEvaluation{}, false, nil
+	}
+	if err := simulatedOutage(failFeatureFlags); err != nil {
+		if err := dependencyFailure(failFeatureFlags, err); err != nil {
+			return FlagEvaluation{}, true, err
+		}
+		return FlagEvaluation{Key: key, Value: flagDefaults[key], Reason: flagReasonError}, true, nil
+	}
+	evaluation := evaluate(ctx)
+	evaluation.Key = key
+	return evaluation, true, nil
+}
+
+func evaluateAllFlags(ctx FlagContext) ([]FlagEvaluation, error) {
+	keys := make([]string, 0, len(flagEngine))
+	for key := range flagEngine {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	evaluations := make([]FlagEvaluation, 0, len(keys))
+	for _, key := range keys {
+		evaluation, _, err := evaluateFlag(key, ctx)
+		if err != nil {
+			return nil, err
+		}
+		evaluations = append(evaluations, evaluation)
+	}
+	return evaluations, nil
+}
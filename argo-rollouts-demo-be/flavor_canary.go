@@ -0,0 +1,16 @@
+//go:build !stable
+
+package main
+
+// The canary flavor, the default, adds the newer features on top of the
+// stable one, so rolling canary out over stable changes real code paths and
+// binary size rather than only the VERSION variable.
+const buildFlavor = "canary"
+
+func init() {
+	registerRoutePlugin("canary", func(r *pluginRoutes) {
+		r.GET("/api/metrics/stream", metricsStreamHandler)
+		r.GET("/ws", websocketHandler)
+	})
+	registerComponentPlugin("grpc", func() Lifecycle { return newGRPCServer(grpcAddr) }, "http")
+}
@@ -0,0 +1,7 @@
+//go:build stable
+
+package main
+
+// The stable flavor (-tags stable) is the minimal binary: it leaves out the
+// live dashboard streams and the gRPC server that the canary flavor adds.
+const buildFlavor = "stable"
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+)
+
+// A single /api/check can ask for its status with ?status=503 or an
+// X-Force-Status header, to poke the analysis during a presentation without
+// touching the error rate. The check is counted like any other, so the
+// forced failure shows up in /api/metrics and Prometheus, but it is outside
+// the blast radius cap: never capped, and not using up the budget.
+const (
+	forceStatusHeader = "X-Force-Status"
+	forceStatusParam  = "status"
+
+	forcedStatusRule = "forced-status"
+)
+
+type forcedStatusKey struct{}
+
+// parseForcedStatus reads the status a check asks for, the query parameter
+// winning over the header. It returns 0 when none was asked for.
+func parseForcedStatus(c echo.Context) (int, error) {
+	value := c.QueryParam(forceStatusParam)
+	if value == "" {
+		value = c.Request().Header.Get(forceStatusHeader)
+	}
+	if value == "" {
+		return 0, nil
+	}
+	status, err := strconv.Atoi(value)
+	if err != nil || (status != http.StatusOK && (status < 400 || status > 599)) {
+		return 0, fmt.Errorf("forced status must be 200 or a 4xx or 5xx code")
+	}
+	return status, nil
+}
+
+func withForcedStatus(ctx context.Context, status int) context.Context {
+	return context.WithValue(ctx, forcedStatusKey{}, status)
+}
+
+// forcedStatus returns the status the request forces, if any.
+func forcedStatus(ctx context.Context) (int, bool) {
+	status, ok := ctx.Value(forcedStatusKey{}).(int)
+	return status, ok && status != 0
+}
+
+// forcedOutcome is the check outcome for a forced status.
+func forcedOutcome(status int) checkOutcome {
+	if status == http.StatusOK {
+		return checkOutcome{status: http.StatusOK}
+	}
+	return checkOutcome{status: status, rule: forcedStatusRule, probability: 1}
+}
@@ -0,0 +1,395 @@
+//go:build !stable
+
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// DemoService (proto/demo/v1/demo.proto) is served with net/http's
+// cleartext HTTP/2 and hand-encoded messages rather than grpc-go: the four
+// methods take a few scalar fields each, and the gRPC wire protocol on top
+// of HTTP/2 is a length-prefixed body and a status trailer.
+const (
+	grpcServiceName     = "demo.v1.DemoService"
+	grpcMaxMessageBytes = 4 << 20 // grpc-go's default receive limit
+)
+
+// gRPC status codes, see google.golang.org/grpc/codes.
+const (
+	grpcOK                = 0
+	grpcUnknown           = 2
+	grpcInvalidArgument   = 3
+	grpcPermissionDenied  = 7
+	grpcResourceExhausted = 8
+	grpcUnimplemented     = 12
+	grpcInternal          = 13
+	grpcUnavailable       = 14
+	grpcUnauthenticated   = 16
+)
+
+var grpcCodeNames = map[int]string{
+	grpcOK:                "OK",
+	grpcUnknown:           "Unknown",
+	grpcInvalidArgument:   "InvalidArgument",
+	grpcPermissionDenied:  "PermissionDenied",
+	grpcResourceExhausted: "ResourceExhausted",
+	grpcUnimplemented:     "Unimplemented",
+	grpcInternal:          "Internal",
+	grpcUnavailable:       "Unavailable",
+	grpcUnauthenticated:   "Unauthenticated",
+}
+
+var (
+	// Empty disables the gRPC server
+	grpcAddr = getEnvOrDefault("GRPC_ADDR", ":9090")
+
+	// Named like go-grpc-prometheus' metric so existing dashboards and
+	// analysis queries work unchanged
+	grpcServerHandledTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name:        "grpc_server_handled_total",
+			Help:        "gRPC calls completed by the server, by method and status code",
+			ConstLabels: prometheus.Labels{"version": version},
+		},
+		[]string{"grpc_service", "grpc_method", "grpc_code"},
+	)
+)
+
+// grpcError is a failed call's status.
+type grpcError struct {
+	code    int
+	message string
+}
+
+func (e *grpcError) Error() string {
+	return fmt.Sprintf("%s: %s", grpcCodeNames[e.code], e.message)
+}
+
+func grpcErrorf(code int, format string, args ...interface{}) error {
+	return &grpcError{code: code, message: fmt.Sprintf(format, args...)}
+}
+
+// grpcCodeForHTTP maps an injected HTTP failure to the status a gRPC client
+// would see from a proxy, following gRPC's HTTP to gRPC status mapping.
+func grpcCodeForHTTP(statusCode int) int {
+	switch statusCode {
+	case http.StatusBadRequest:
+		return grpcInternal
+	case http.StatusUnauthorized:
+		return grpcUnauthenticated
+	case http.StatusForbidden:
+		return grpcPermissionDenied
+	case http.StatusNotFound:
+		return grpcUnimplemented
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return grpcUnavailable
+	}
+	return grpcUnknown
+}
+
+// grpcMethod handles one call: it gets the request message and the call's
+// metadata and returns the encoded response message.
+type grpcMethod func(ctx context.Context, metadata http.Header, request []byte) ([]byte, error)
+
+var grpcMethods = map[string]grpcMethod{
+	"Check":        grpcCheck,
+	"GetMetrics":   grpcGetMetrics,
+	"SetErrorRate": grpcSetErrorRate,
+	"Healthz":      grpcHealthz,
+}
+
+// serveGRPC serves one unary call.
+func serveGRPC(w http.ResponseWriter, r *http.Request) {
+	contentType := r.Header.Get("Content-Type")
+	if r.Method != http.MethodPost || (contentType != "application/grpc" && !strings.HasPrefix(contentType, "application/grpc+proto")) {
+		http.Error(w, "gRPC requests only", http.StatusUnsupportedMediaType)
+		return
+	}
+	w.Header().Set("Content-Type", "application/grpc")
+	w.Header().Set("X-Version", version)
+
+	name, ok := strings.CutPrefix(r.URL.Path, "/"+grpcServiceName+"/")
+	method, found := grpcMethods[name]
+	if !ok || !found {
+		writeGRPCResponse(w, nil, grpcErrorf(grpcUnimplemented, "unknown method %s", r.URL.Path))
+		return
+	}
+
+	ctx, requestID := withRequestID(r.Context(), r.Header.Get("X-Request-ID"))
+	w.Header().Set("X-Request-ID", requestID)
+	ctx, err := withChaosBypass(ctx, r.Header.Get(chaosBypassHeader))
+	if err != nil {
+		err = grpcErrorf(grpcPermissionDenied, "%v", err)
+	}
+	var request, response []byte
+	if err == nil {
+		request, err = readGRPCMessage(r.Body)
+	}
+	if err == nil {
+		response, err = method(ctx, r.Header, request)
+	}
+	code := writeGRPCResponse(w, response, err)
+	grpcServerHandledTotal.WithLabelValues(grpcServiceName, name, grpcCodeNames[code]).Inc()
+}
+
+// readGRPCMessage reads the single length-prefixed message of a unary call.
+func readGRPCMessage(body io.Reader) ([]byte, error) {
+	var prefix [5]byte
+	if _, err := io.ReadFull(body, prefix[:]); err != nil {
+		return nil, grpcErrorf(grpcInternal, "reading request: %v", err)
+	}
+	if prefix[0] != 0 {
+		// No grpc-accept-encoding is advertised, so clients shouldn't compress
+		return nil, grpcErrorf(grpcUnimplemented, "compressed messages are not supported")
+	}
+	length := binary.BigEndian.Uint32(prefix[1:])
+	if length > grpcMaxMessageBytes {
+		return nil, grpcErrorf(grpcResourceExhausted, "message of %d bytes is larger than %d", length, grpcMaxMessageBytes)
+	}
+	message := make([]byte, length)
+	if _, err := io.ReadFull(body, message); err != nil {
+		return nil, grpcErrorf(grpcInternal, "reading request: %v", err)
+	}
+	return message, nil
+}
+
+// writeGRPCResponse sends the response message with an OK status, or a
+// trailers-only response for an error. It returns the status code sent.
+func writeGRPCResponse(w http.ResponseWriter, response []byte, err error) int {
+	if err != nil {
+		var status *grpcError
+		if !errors.As(err, &status) {
+			status = &grpcError{code: grpcInternal, message: err.Error()}
+		}
+		w.Header().Set("Grpc-Status", strconv.Itoa(status.code))
+		w.Header().Set("Grpc-Message", grpcPercentEncode(status.message))
+		w.WriteHeader(http.StatusOK)
+		return status.code
+	}
+
+	w.WriteHeader(http.StatusOK)
+	frame := make([]byte, 5, 5+len(response))
+	binary.BigEndian.PutUint32(frame[1:], uint32(len(response)))
+	if _, err := w.Write(append(frame, response...)); err != nil {
+		debugf("grpc: writing response: %v", err)
+	}
+	w.Header().Set(http.TrailerPrefix+"Grpc-Status", strconv.Itoa(grpcOK))
+	return grpcOK
+}
+
+// grpcPercentEncode escapes a status message for the grpc-message header.
+func grpcPercentEncode(message string) string {
+	var b strings.Builder
+	for i := 0; i < len(message); i++ {
+		if c := message[i]; c >= 0x20 && c <= 0x7E && c != '%' {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// decodeProto calls field with every field of an encoded message. Fields
+// the caller doesn't know are meant to be ignored.
+func decodeProto(message []byte, field func(num protowire.Number, typ protowire.Type, value []byte)) error {
+	for len(message) > 0 {
+		num, typ, n := protowire.ConsumeTag(message)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		message = message[n:]
+		n = protowire.ConsumeFieldValue(num, typ, message)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		field(num, typ, message[:n])
+		message = message[n:]
+	}
+	return nil
+}
+
+func appendProtoString(b []byte, num protowire.Number, value string) []byte {
+	if value == "" {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendString(b, value)
+}
+
+func appendProtoDouble(b []byte, num protowire.Number, value float64) []byte {
+	if value == 0 {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.Fixed64Type)
+	return protowire.AppendFixed64(b, math.Float64bits(value))
+}
+
+func appendProtoBool(b []byte, num protowire.Number, value bool) []byte {
+	if !value {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.VarintType)
+	return protowire.AppendVarint(b, 1)
+}
+
+// grpcCheck is Check, counted like a request to /api/check.
+func grpcCheck(ctx context.Context, metadata http.Header, request []byte) ([]byte, error) {
+	var clientID string
+	err := decodeProto(request, func(num protowire.Number, typ protowire.Type, value []byte) {
+		if num == 1 && typ == protowire.BytesType {
+			clientID, _ = protowire.ConsumeString(value)
+		}
+	})
+	if err != nil {
+		return nil, grpcErrorf(grpcInvalidArgument, "invalid CheckRequest: %v", err)
+	}
+	if clientID == "" {
+		clientID = metadata.Get("X-Client-ID")
+	}
+
+	codePath := codePathForClient(clientID)
+	if _, err := injectCheckLatency(ctx); err != nil {
+		return nil, err
+	}
+	statusCode, currentErrorRate := simulateCheck(ctx, codePath)
+	if err := recordCheckCounts(ctx, map[int]int64{statusCode: 1}); err != nil {
+		return nil, grpcErrorf(grpcUnavailable, "counter store unavailable")
+	}
+	countCheckOutcomes(ctx, codePath, map[int]int64{statusCode: 1})
+	recordRollupEvent(rollupEvent{status: statusCode, n: 1})
+
+	debugContextf(ctx, "grpc check: code_path=%s error_rate=%.4f status=%d", codePath, currentErrorRate, statusCode)
+
+	if statusCode != http.StatusOK {
+		return nil, grpcErrorf(grpcCodeForHTTP(statusCode), "injected failure: HTTP %d", statusCode)
+	}
+	var response []byte
+	response = protowire.AppendTag(response, 1, protowire.VarintType)
+	response = protowire.AppendVarint(response, uint64(statusCode))
+	response = appendProtoString(response, 2, version)
+	response = appendProtoString(response, 3, podName)
+	response = appendProtoString(response, 4, codePath)
+	return response, nil
+}
+
+func grpcGetMetrics(ctx context.Context, metadata http.Header, request []byte) ([]byte, error) {
+	counts, source, stale, err := metricsStatusCounts(ctx)
+	if err != nil {
+		return nil, grpcErrorf(grpcUnavailable, "counter store unavailable")
+	}
+	counts = withBaseStatuses(counts)
+
+	statuses := make([]string, 0, len(counts))
+	for status := range counts {
+		statuses = append(statuses, status)
+	}
+	sort.Strings(statuses)
+
+	var response []byte
+	for _, status := range statuses {
+		// Map entries are messages with the key as field 1 and the value as
+		// field 2; a zero value is left out like any other proto3 scalar
+		var entry []byte
+		entry = appendProtoString(entry, 1, status)
+		entry = appendProtoDouble(entry, 2, counts[status])
+		response = protowire.AppendTag(response, 1, protowire.BytesType)
+		response = protowire.AppendBytes(response, entry)
+	}
+	response = appendProtoString(response, 2, source)
+	response = appendProtoBool(response, 3, stale)
+	return response, nil
+}
+
+func grpcSetErrorRate(ctx context.Context, metadata http.Header, request []byte) ([]byte, error) {
+	switch checkAdminToken("/demo.v1.DemoService/SetErrorRate", metadata) {
+	case http.StatusUnauthorized:
+		return nil, grpcErrorf(grpcUnauthenticated, "missing admin token")
+	case http.StatusForbidden:
+		return nil, grpcErrorf(grpcPermissionDenied, "invalid admin token")
+	}
+	var percent float64
+	err := decodeProto(request, func(num protowire.Number, typ protowire.Type, value []byte) {
+		if num == 1 && typ == protowire.Fixed64Type {
+			bits, _ := protowire.ConsumeFixed64(value)
+			percent = math.Float64frombits(bits)
+		}
+	})
+	if err != nil {
+		return nil, grpcErrorf(grpcInvalidArgument, "invalid SetErrorRateRequest: %v", err)
+	}
+	if err := validateErrorRatePercent(percent); err != nil {
+		return nil, grpcErrorf(grpcInvalidArgument, "%v", err)
+	}
+
+	replicated := setManualErrorRate(ctx, percent, "gRPC SetErrorRate")
+
+	var response []byte
+	response = appendProtoDouble(response, 1, getErrorRatePercent())
+	response = appendProtoDouble(response, 2, getErrorRate())
+	response = appendProtoBool(response, 3, replicated)
+	return response, nil
+}
+
+func grpcHealthz(ctx context.Context, metadata http.Header, request []byte) ([]byte, error) {
+	return appendProtoString(nil, 1, "SERVING"), nil
+}
+
+// grpcServer runs DemoService on its own port as a component. It only
+// speaks cleartext HTTP/2 ("h2c" with prior knowledge), which is what gRPC
+// clients use without TLS.
+type grpcServer struct {
+	server *http.Server
+}
+
+func newGRPCServer(addr string) *grpcServer {
+	protocols := new(http.Protocols)
+	protocols.SetUnencryptedHTTP2(true)
+	return &grpcServer{server: &http.Server{
+		Addr:              addr,
+		Handler:           http.HandlerFunc(serveGRPC),
+		Protocols:         protocols,
+		ReadHeaderTimeout: 10 * time.Second,
+	}}
+}
+
+func (s *grpcServer) Start(ctx context.Context) error {
+	if s.server.Addr == "" {
+		return nil
+	}
+	listener, err := net.Listen("tcp", s.server.Addr)
+	if err != nil {
+		return err
+	}
+	infof("gRPC server listening on %s", listener.Addr())
+	go func() {
+		if err := s.server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			warnf("gRPC server stopped: %v", err)
+		}
+	}()
+	return nil
+}
+
+// Stop waits for in-flight calls, like the HTTP server.
+func (s *grpcServer) Stop(ctx context.Context) error {
+	if s.server.Addr == "" {
+		return nil
+	}
+	return s.server.Shutdown(ctx)
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// A request carrying the chaos header (X-Chaos, or CHAOS_HEADER; empty
+// turns it off) gets the fault it asks for, whatever the error rates say.
+// Service mesh canary demos route only header-tagged traffic to the canary,
+// and this way the faults follow the same header:
+//
+//	X-Chaos: error               500
+//	X-Chaos: error=503           that status
+//	X-Chaos: delay=2s            delayed, then served normally
+//	X-Chaos: delay=500ms,error   both
+//
+// It applies to every route ahead of the global and route faults. The chaos
+// bypass and the blast radius cap still win.
+var chaosHeader = getEnvOrDefault("CHAOS_HEADER", "X-Chaos")
+
+// headerFault is a parsed chaos header.
+type headerFault struct {
+	status int // 0 to serve the request
+	delay  time.Duration
+}
+
+func parseHeaderFault(value string) (headerFault, error) {
+	var fault headerFault
+	for _, directive := range splitList(value) {
+		name, arg, hasArg := strings.Cut(directive, "=")
+		switch strings.ToLower(strings.TrimSpace(name)) {
+		case "error":
+			fault.status = http.StatusInternalServerError
+			if hasArg {
+				status, err := strconv.Atoi(strings.TrimSpace(arg))
+				if err != nil || status < 400 || status > 599 {
+					return fault, fmt.Errorf("error status must be between 400 and 599")
+				}
+				fault.status = status
+			}
+		case "delay":
+			delay, err := time.ParseDuration(strings.TrimSpace(arg))
+			if err != nil || delay < 0 {
+				return fault, fmt.Errorf("delay must be a duration such as 1500ms")
+			}
+			if delay > maxCheckLatencyMs*time.Millisecond {
+				return fault, fmt.Errorf("delay must be at most %dms", maxCheckLatencyMs)
+			}
+			fault.delay = delay
+		default:
+			return fault, fmt.Errorf("unknown directive %q, expected error or delay", name)
+		}
+	}
+	return fault, nil
+}
+
+func headerFaultsMiddleware(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		if chaosHeader == "" {
+			return next(c)
+		}
+		value := c.Request().Header.Get(chaosHeader)
+		if value == "" || chaosBypassed(c.Request().Context()) {
+			return next(c)
+		}
+
+		endpoint := routeLabel(c)
+		fault, err := parseHeaderFault(value)
+		if err != nil {
+			httpRequestsTotal.WithLabelValues(endpoint, fmt.Sprintf("%d", http.StatusBadRequest)).Inc()
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("Invalid %s header: %v", chaosHeader, err)})
+		}
+
+		if fault.delay > 0 {
+			faultsInjectedTotal.WithLabelValues(endpoint, "latency").Inc()
+			recordFaultRule("header-latency", faultOutcomeApplied, 100)
+			start := time.Now()
+			select {
+			case <-time.After(fault.delay):
+			case <-c.Request().Context().Done():
+				return c.Request().Context().Err()
+			}
+			addServerTiming(c.Request().Context(), timingDelay, time.Since(start))
+		}
+
+		if fault.status != 0 {
+			if !allowInjectedFailure("header-fault") {
+				recordFaultRule("header-fault", faultOutcomeCapped, 100)
+				return next(c)
+			}
+			recordFaultRule("header-fault", faultOutcomeApplied, 100)
+			debugContextf(c.Request().Context(), "faults: %s forced %d on %s", chaosHeader, fault.status, endpoint)
+			faultsInjectedTotal.WithLabelValues(endpoint, "error").Inc()
+			recordInjectedFailure(c.Request().Context(), endpoint, "header-fault", fault.status,
+				fmt.Sprintf("header=%q", value))
+			httpRequestsTotal.WithLabelValues(endpoint, fmt.Sprintf("%d", fault.status)).Inc()
+			return c.JSON(fault.status, map[string]string{"error": "Injected fault"})
+		}
+
+		return next(c)
+	}
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+)
+
+// runHealthcheckMode probes /api/healthz of a server running in the same
+// container, for the image's HEALTHCHECK. It reads the listen address the
+// way the server does, from -bind/-port, BIND_ADDR/PORT and the config
+// file, so the probe follows the server wherever it is moved. The plain
+// listener is there even with TLS enabled, so it is always the one probed.
+func runHealthcheckMode(args []string) {
+	cfg, err := loadConfig(configFile)
+	if err != nil {
+		fatalf("Failed to load config: %v", err)
+	}
+	appConfig = cfg
+	addr, err := parseServerFlags(args)
+	if err != nil {
+		fatalf("Invalid listen address: %v", err)
+	}
+	listenAddr = addr
+
+	client := &http.Client{Timeout: 3 * time.Second}
+	resp, err := client.Get(localURL("/api/healthz"))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "healthcheck: %v\n", err)
+		os.Exit(1)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		fmt.Fprintf(os.Stderr, "healthcheck: %s\n", resp.Status)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"runtime"
+	"runtime/debug"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Info is the /api/info response: which build, pod and node served the
+// request, for the frontend to show next to each response during a
+// canary. Namespace and node come from the downward API:
+//
+//	env:
+//	- name: POD_NAMESPACE
+//	  valueFrom: {fieldRef: {fieldPath: metadata.namespace}}
+//	- name: NODE_NAME
+//	  valueFrom: {fieldRef: {fieldPath: spec.nodeName}}
+type Info struct {
+	Version       string    `json:"version"`
+	BuildHash     string    `json:"buildHash"`
+	GitCommit     string    `json:"gitCommit,omitempty"`
+	GoVersion     string    `json:"goVersion"`
+	Flavor        string    `json:"flavor"`
+	StartedAt     time.Time `json:"startedAt"`
+	UptimeSeconds float64   `json:"uptimeSeconds"`
+	Pod           string    `json:"pod"`
+	Namespace     string    `json:"namespace,omitempty"`
+	Node          string    `json:"node,omitempty"`
+}
+
+// build_info follows the Prometheus convention of a constant 1 carrying the
+// build in its labels, so queries can join on the version actually running:
+//
+//	sum by (version, build_hash) (rate(http_requests_total[1m]) * on(version) group_left(build_hash) build_info)
+func init() {
+	promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "build_info",
+		Help: "Always 1, labeled with the running build's version, build hash and Go version",
+		ConstLabels: prometheus.Labels{
+			"version":    version,
+			"build_hash": buildHash,
+			"go_version": runtime.Version(),
+		},
+	}).Set(1)
+}
+
+var (
+	podNamespace = getEnvOrDefault("POD_NAMESPACE", "")
+	nodeName     = getEnvOrDefault("NODE_NAME", "")
+	gitCommit    = getEnvOrDefault("GIT_COMMIT", vcsRevision())
+)
+
+// vcsRevision is the commit Go stamped into the binary, when it was built
+// from a checkout. Docker builds without .git pass GIT_COMMIT instead.
+func vcsRevision() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return ""
+	}
+	var revision string
+	var modified bool
+	for _, setting := range info.Settings {
+		switch setting.Key {
+		case "vcs.revision":
+			revision = setting.Value
+		case "vcs.modified":
+			modified = setting.Value == "true"
+		}
+	}
+	if revision != "" && modified {
+		revision += "-dirty"
+	}
+	return revision
+}
+
+func currentInfo() Info {
+	return Info{
+		Version:       version,
+		BuildHash:     buildHash,
+		GitCommit:     gitCommit,
+		GoVersion:     runtime.Version(),
+		Flavor:        buildFlavor,
+		StartedAt:     startTime,
+		UptimeSeconds: time.Since(startTime).Seconds(),
+		Pod:           podName,
+		Namespace:     podNamespace,
+		Node:          nodeName,
+	}
+}
+
+func infoHandler(c echo.Context) error {
+	httpRequestsTotal.WithLabelValues("/api/info", fmt.Sprintf("%d", http.StatusOK)).Inc()
+	return c.JSON(http.StatusOK, currentInfo())
+}
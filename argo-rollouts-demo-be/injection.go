@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+
+	"github.com/labstack/echo/v4"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// httpResponsesTotal counts every response like http_requests_total, with an
+// "injected" label telling failures the chaos engine produced on purpose
+// from genuine ones (panics, Redis errors), so analysis can leave
+// intentional chaos out:
+//
+//	sum(rate(http_responses_total{status_code=~"5..",injected="false"}[1m]))
+var httpResponsesTotal = promauto.NewCounterVec(
+	prometheus.CounterOpts{
+		Name:        "http_responses_total",
+		Help:        "HTTP responses by route, status code and whether the chaos engine injected the status",
+		ConstLabels: prometheus.Labels{"version": version},
+	},
+	[]string{"endpoint", "status_code", "injected"},
+)
+
+// injectionMarker remembers the status of a failure injected into the
+// request, 0 when there was none.
+type injectionMarker struct {
+	status atomic.Int64
+}
+
+type injectionMarkerKey struct{}
+
+// markInjected records that the request's failure status was injected. It
+// is called by recordInjectedFailure for every injected failure.
+func markInjected(ctx context.Context, status int) {
+	if marker, ok := ctx.Value(injectionMarkerKey{}).(*injectionMarker); ok {
+		marker.status.Store(int64(status))
+	}
+}
+
+// injectionMiddleware counts responses in http_responses_total. It is
+// installed with e.Pre ahead of requestDurationMiddleware, which writes
+// error responses, so the final status is known here. A response counts as
+// injected only when it carries the injected status: a batch check that
+// injected failures into some of its items still answers 200 organically.
+func injectionMiddleware(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		marker := &injectionMarker{}
+		req := c.Request()
+		c.SetRequest(req.WithContext(context.WithValue(req.Context(), injectionMarkerKey{}, marker)))
+
+		err := next(c)
+
+		status := c.Response().Status
+		injected := marker.status.Load() != 0 && marker.status.Load() == int64(status)
+		httpResponsesTotal.WithLabelValues(routeLabel(c), fmt.Sprintf("%d", status), fmt.Sprintf("%t", injected)).Inc()
+		return err
+	}
+}
@@ -0,0 +1,74 @@
+// Package weighted picks values according to relative weights. Choosers take
+// the uniform random number as an argument instead of owning a source, so
+// callers keep control of seeding and locking and results are reproducible.
+package weighted
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+)
+
+// Choice is a value with a relative weight. Weights don't need to sum to
+// anything in particular; a weight of zero means the value is never chosen.
+type Choice[T any] struct {
+	Value  T
+	Weight float64
+}
+
+// Chooser picks among a fixed set of weighted choices.
+type Chooser[T any] struct {
+	values     []T
+	cumulative []float64 // Running weight totals, strictly increasing
+	total      float64
+}
+
+// New builds a Chooser. Weights must be finite and non-negative, and at
+// least one must be positive.
+func New[T any](choices []Choice[T]) (*Chooser[T], error) {
+	c := &Chooser[T]{}
+	for i, choice := range choices {
+		if math.IsNaN(choice.Weight) || math.IsInf(choice.Weight, 0) || choice.Weight < 0 {
+			return nil, fmt.Errorf("choice %d: weight must be a finite non-negative number, got %v", i, choice.Weight)
+		}
+		if choice.Weight == 0 {
+			continue
+		}
+		c.total += choice.Weight
+		c.values = append(c.values, choice.Value)
+		c.cumulative = append(c.cumulative, c.total)
+	}
+	if c.total == 0 {
+		return nil, errors.New("at least one choice must have a positive weight")
+	}
+	return c, nil
+}
+
+// Choose maps u, a uniform random number in [0, 1), onto a choice. Values
+// outside that range are clamped.
+func (c *Chooser[T]) Choose(u float64) T {
+	target := math.Min(math.Max(u, 0), 1) * c.total
+	i := sort.Search(len(c.cumulative), func(i int) bool { return c.cumulative[i] > target })
+	if i == len(c.cumulative) {
+		// u == 1 or rounding at the upper edge
+		i = len(c.cumulative) - 1
+	}
+	return c.values[i]
+}
+
+// Probability returns the share of draws that pick the i-th positive-weight
+// choice, in the order they were given to New.
+func (c *Chooser[T]) Probability(i int) float64 {
+	previous := 0.0
+	if i > 0 {
+		previous = c.cumulative[i-1]
+	}
+	return (c.cumulative[i] - previous) / c.total
+}
+
+// Chance reports whether an event with probability p happens for the
+// uniform random number u. p outside [0, 1] is clamped.
+func Chance(p, u float64) bool {
+	return u < math.Min(math.Max(p, 0), 1)
+}
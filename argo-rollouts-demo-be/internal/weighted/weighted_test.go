@@ -0,0 +1,141 @@
+package weighted
+
+import (
+	"math"
+	"testing"
+	"testing/quick"
+)
+
+// weights turns arbitrary quick-generated values into valid weights with at
+// least one positive entry.
+func weights(raw []uint8) []float64 {
+	w := make([]float64, 0, len(raw)+1)
+	for _, r := range raw {
+		// Keep plenty of zeros in the mix
+		if r%4 == 0 {
+			w = append(w, 0)
+		} else {
+			w = append(w, float64(r))
+		}
+	}
+	return append(w, 1)
+}
+
+func chooserFor(t *testing.T, w []float64) *Chooser[int] {
+	t.Helper()
+	choices := make([]Choice[int], len(w))
+	for i := range w {
+		choices[i] = Choice[int]{Value: i, Weight: w[i]}
+	}
+	c, err := New(choices)
+	if err != nil {
+		t.Fatalf("New(%v): %v", w, err)
+	}
+	return c
+}
+
+func TestChooseNeverPicksZeroWeight(t *testing.T) {
+	property := func(raw []uint8, u float64) bool {
+		w := weights(raw)
+		picked := chooserFor(t, w).Choose(math.Abs(math.Mod(u, 1)))
+		return w[picked] > 0
+	}
+	if err := quick.Check(property, nil); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestChooseIsMonotonicInU(t *testing.T) {
+	property := func(raw []uint8, a, b float64) bool {
+		c := chooserFor(t, weights(raw))
+		u1, u2 := math.Abs(math.Mod(a, 1)), math.Abs(math.Mod(b, 1))
+		if u1 > u2 {
+			u1, u2 = u2, u1
+		}
+		return c.Choose(u1) <= c.Choose(u2)
+	}
+	if err := quick.Check(property, nil); err != nil {
+		t.Error(err)
+	}
+}
+
+// With evenly spaced u values every choice must be picked in proportion to
+// its weight, within one step of the grid.
+func TestChooseMatchesWeights(t *testing.T) {
+	const n = 10000
+	property := func(raw []uint8) bool {
+		w := weights(raw)
+		c := chooserFor(t, w)
+
+		counts := make([]int, len(w))
+		for i := 0; i < n; i++ {
+			counts[c.Choose((float64(i)+0.5)/n)]++
+		}
+
+		total := 0.0
+		for _, weight := range w {
+			total += weight
+		}
+		for i, weight := range w {
+			if math.Abs(float64(counts[i])/n-weight/total) > 1.0/n+1e-9 {
+				return false
+			}
+		}
+		return true
+	}
+	if err := quick.Check(property, nil); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestProbabilitiesSumToOne(t *testing.T) {
+	property := func(raw []uint8) bool {
+		c := chooserFor(t, weights(raw))
+		sum := 0.0
+		for i := range c.values {
+			sum += c.Probability(i)
+		}
+		return math.Abs(sum-1) < 1e-9
+	}
+	if err := quick.Check(property, nil); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestChooseClampsOutOfRange(t *testing.T) {
+	c := chooserFor(t, []float64{1, 1})
+	if got := c.Choose(-0.5); got != 0 {
+		t.Errorf("Choose(-0.5) = %d, want 0", got)
+	}
+	if got := c.Choose(1); got != 1 {
+		t.Errorf("Choose(1) = %d, want 1", got)
+	}
+}
+
+func TestChance(t *testing.T) {
+	property := func(p, u float64) bool {
+		p, u = math.Abs(math.Mod(p, 1)), math.Abs(math.Mod(u, 1))
+		return Chance(p, u) == (u < p)
+	}
+	if err := quick.Check(property, nil); err != nil {
+		t.Error(err)
+	}
+	if Chance(0, 0) {
+		t.Error("Chance(0, 0) = true, want false")
+	}
+	if !Chance(1, 0.999999) {
+		t.Error("Chance(1, 0.999999) = false, want true")
+	}
+}
+
+func TestNewRejectsInvalidWeights(t *testing.T) {
+	for _, w := range [][]float64{{}, {0, 0}, {-1, 2}, {math.NaN()}, {math.Inf(1)}} {
+		choices := make([]Choice[int], len(w))
+		for i := range w {
+			choices[i] = Choice[int]{Value: i, Weight: w[i]}
+		}
+		if _, err := New(choices); err == nil {
+			t.Errorf("New(%v) succeeded, want error", w)
+		}
+	}
+}
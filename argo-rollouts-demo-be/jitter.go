@@ -0,0 +1,141 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// ErrorRateJitter adds a bounded random walk on top of the configured error
+// rate so graphs wander like production data instead of drawing flat lines.
+// Both values are in percentage points.
+type ErrorRateJitter struct {
+	Amplitude float64 `json:"amplitude"` // Maximum distance from the configured rate
+	Step      float64 `json:"step"`      // Maximum change per second
+}
+
+var (
+	errorRateJitter = ErrorRateJitter{
+		Amplitude: getEnvFloatOrDefault("ERROR_RATE_JITTER", 0),
+		Step:      getEnvFloatOrDefault("ERROR_RATE_JITTER_STEP", 0.5),
+	}
+	jitterOffset float64 // Current walk position, percentage points
+	jitterMu     sync.RWMutex
+)
+
+func init() {
+	promauto.NewGaugeFunc(
+		prometheus.GaugeOpts{
+			Name: "error_rate_effective",
+			Help: "Instantaneous /api/check error probability (0-1) including jitter",
+		},
+		effectiveErrorRate,
+	)
+	promauto.NewGaugeFunc(
+		prometheus.GaugeOpts{
+			Name: "error_rate_configured",
+			Help: "Configured /api/check error probability (0-1) before jitter, to overlay on the observed failure ratio",
+		},
+		getErrorRate,
+	)
+}
+
+// effectiveErrorRate returns the configured error probability (0-1) shifted
+// by the current jitter offset, clamped to a valid probability.
+func effectiveErrorRate() float64 {
+	jitterMu.RLock()
+	offset := jitterOffset
+	jitterMu.RUnlock()
+
+	rate := getErrorRate() + offset/100.0
+	return math.Min(math.Max(rate, 0), 1)
+}
+
+// stepErrorRateJitter advances the random walk by one step, reflecting it
+// back inside the amplitude bounds.
+func stepErrorRateJitter() {
+	jitterMu.Lock()
+	defer jitterMu.Unlock()
+
+	if errorRateJitter.Amplitude <= 0 {
+		jitterOffset = 0
+		return
+	}
+
+	delta := (randomFloat()*2 - 1) * errorRateJitter.Step
+
+	offset := jitterOffset + delta
+	if offset > errorRateJitter.Amplitude {
+		offset = 2*errorRateJitter.Amplitude - offset
+	} else if offset < -errorRateJitter.Amplitude {
+		offset = -2*errorRateJitter.Amplitude - offset
+	}
+	jitterOffset = math.Min(math.Max(offset, -errorRateJitter.Amplitude), errorRateJitter.Amplitude)
+}
+
+func runErrorRateJitter(stop <-chan struct{}) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			stepErrorRateJitter()
+		}
+	}
+}
+
+func getErrorRateJitterHandler(c echo.Context) error {
+	jitterMu.RLock()
+	current := errorRateJitter
+	offset := jitterOffset
+	jitterMu.RUnlock()
+
+	httpRequestsTotal.WithLabelValues("/api/error-rate/jitter", fmt.Sprintf("%d", http.StatusOK)).Inc()
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"amplitude": current.Amplitude,
+		"step":      current.Step,
+		"offset":    offset,
+		"effective": effectiveErrorRate() * 100.0,
+	})
+}
+
+func (j ErrorRateJitter) validate() error {
+	if math.IsNaN(j.Amplitude) || math.IsNaN(j.Step) || j.Amplitude < 0 || j.Amplitude > 100 || j.Step < 0 || j.Step > 100 {
+		return fmt.Errorf("amplitude and step must be between 0 and 100")
+	}
+	return nil
+}
+
+func setErrorRateJitterHandler(c echo.Context) error {
+	jitterMu.RLock()
+	update := errorRateJitter
+	jitterMu.RUnlock()
+
+	if err := json.NewDecoder(c.Request().Body).Decode(&update); err != nil {
+		httpRequestsTotal.WithLabelValues("/api/error-rate/jitter", fmt.Sprintf("%d", http.StatusBadRequest)).Inc()
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid JSON"})
+	}
+
+	if err := update.validate(); err != nil {
+		httpRequestsTotal.WithLabelValues("/api/error-rate/jitter", fmt.Sprintf("%d", http.StatusBadRequest)).Inc()
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+
+	jitterMu.Lock()
+	errorRateJitter = update
+	jitterOffset = math.Min(math.Max(jitterOffset, -update.Amplitude), update.Amplitude)
+	jitterMu.Unlock()
+
+	httpRequestsTotal.WithLabelValues("/api/error-rate/jitter", fmt.Sprintf("%d", http.StatusOK)).Inc()
+	return c.JSON(http.StatusOK, update)
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	serviceAccountDir  = "/var/run/secrets/kubernetes.io/serviceaccount"
+	kubeRequestTimeout = 10 * time.Second
+)
+
+// kubeClient is a minimal in-cluster Kubernetes API client authenticated with
+// the pod's service account. It only speaks raw REST, which is all the demo
+// needs, and keeps client-go out of the image.
+type kubeClient struct {
+	baseURL   string
+	namespace string
+	client    *http.Client
+}
+
+func newInClusterKubeClient() (*kubeClient, error) {
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" || port == "" {
+		return nil, fmt.Errorf("not running in a Kubernetes cluster")
+	}
+
+	caCert, err := os.ReadFile(serviceAccountDir + "/ca.crt")
+	if err != nil {
+		return nil, fmt.Errorf("reading service account CA: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(caCert) {
+		return nil, fmt.Errorf("no certificates in service account CA")
+	}
+
+	namespace := podNamespace
+	if namespace == "" {
+		data, err := os.ReadFile(serviceAccountDir + "/namespace")
+		if err != nil {
+			return nil, fmt.Errorf("reading service account namespace: %w", err)
+		}
+		namespace = strings.TrimSpace(string(data))
+	}
+
+	return &kubeClient{
+		baseURL:   "https://" + net.JoinHostPort(host, port),
+		namespace: namespace,
+		// No client timeout, watches stay open; requests set their own deadline
+		client: newOutboundClient(outboundClientConfig{
+			Name: "kubernetes",
+			TLS:  &tls.Config{RootCAs: pool},
+		}),
+	}, nil
+}
+
+// do sends a request to the API server. The token is read on every call
+// because projected service account tokens are rotated by the kubelet.
+func (k *kubeClient) do(ctx context.Context, method, path, contentType string, body io.Reader) (*http.Response, error) {
+	token, err := os.ReadFile(serviceAccountDir + "/token")
+	if err != nil {
+		return nil, fmt.Errorf("reading service account token: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, k.baseURL+path, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+	req.Header.Set("Accept", "application/json")
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	return k.client.Do(req)
+}
+
+// patch applies a patch and turns non-2xx responses into errors carrying the
+// API server's message.
+func (k *kubeClient) patch(ctx context.Context, path, patchType string, body []byte) error {
+	ctx, cancel := context.WithTimeout(ctx, kubeRequestTimeout)
+	defer cancel()
+
+	resp, err := k.do(ctx, http.MethodPatch, path, patchType, strings.NewReader(string(body)))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return fmt.Errorf("kubernetes API returned %d: %s", resp.StatusCode, strings.TrimSpace(string(message)))
+	}
+	return nil
+}
+
+// getJSON decodes a single object from the API server.
+func (k *kubeClient) getJSON(ctx context.Context, path string, out interface{}) error {
+	ctx, cancel := context.WithTimeout(ctx, kubeRequestTimeout)
+	defer cancel()
+
+	resp, err := k.do(ctx, http.MethodGet, path, "", nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return fmt.Errorf("kubernetes API returned %d: %s", resp.StatusCode, strings.TrimSpace(string(message)))
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
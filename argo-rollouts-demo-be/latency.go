@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// maxCheckLatencyMs bounds the injected delay so a typo can't park requests
+// for longer than any client would wait.
+const maxCheckLatencyMs = 60000
+
+// CheckLatency delays /api/check responses by a random duration between
+// MinMs and MaxMs, so latency-based analysis (p95/p99) has something to
+// catch. Equal bounds give a fixed delay.
+type CheckLatency struct {
+	MinMs float64 `yaml:"minMs" json:"minMs"`
+	MaxMs float64 `yaml:"maxMs" json:"maxMs"`
+}
+
+var (
+	// Longest delay a single check may ask for with ?delay
+	checkMaxRequestDelay = time.Duration(getEnvFloatOrDefault("CHECK_MAX_REQUEST_DELAY_MS", 10000) * float64(time.Millisecond))
+
+	checkLatency = CheckLatency{
+		MinMs: getEnvFloatOrDefault("CHECK_LATENCY_MIN_MS", 0),
+		MaxMs: getEnvFloatOrDefault("CHECK_LATENCY_MAX_MS", 0),
+	}
+	checkLatencyMu sync.RWMutex
+)
+
+func init() {
+	promauto.NewGaugeFunc(
+		prometheus.GaugeOpts{
+			Name: "latency_min_ms",
+			Help: "Configured minimum /api/check injected latency in milliseconds",
+		},
+		func() float64 { return currentCheckLatency().MinMs },
+	)
+	promauto.NewGaugeFunc(
+		prometheus.GaugeOpts{
+			Name: "latency_max_ms",
+			Help: "Configured maximum /api/check injected latency in milliseconds",
+		},
+		func() float64 { return currentCheckLatency().MaxMs },
+	)
+}
+
+func currentCheckLatency() CheckLatency {
+	checkLatencyMu.RLock()
+	defer checkLatencyMu.RUnlock()
+	return checkLatency
+}
+
+func (l CheckLatency) validate() error {
+	if math.IsNaN(l.MinMs) || math.IsNaN(l.MaxMs) || math.IsInf(l.MinMs, 0) || math.IsInf(l.MaxMs, 0) {
+		return fmt.Errorf("latency must be a finite number")
+	}
+	if l.MinMs < 0 || l.MaxMs > maxCheckLatencyMs {
+		return fmt.Errorf("latency must be between 0 and %d ms", maxCheckLatencyMs)
+	}
+	if l.MinMs > l.MaxMs {
+		return fmt.Errorf("minMs must not be greater than maxMs")
+	}
+	return nil
+}
+
+type requestDelayKey struct{}
+
+// parseRequestDelay reads the delay a single check asks for with
+// ?delay=1500ms, so load tests can mix in slow requests. A bare number is
+// milliseconds.
+func parseRequestDelay(c echo.Context) (time.Duration, error) {
+	value := c.QueryParam("delay")
+	if value == "" {
+		return 0, nil
+	}
+	delay, err := time.ParseDuration(value)
+	if err != nil {
+		ms, numErr := strconv.ParseFloat(value, 64)
+		if numErr != nil || math.IsNaN(ms) {
+			return 0, fmt.Errorf("delay must be a duration such as 1500ms")
+		}
+		delay = time.Duration(ms * float64(time.Millisecond))
+	}
+	if delay < 0 || delay > checkMaxRequestDelay {
+		return 0, fmt.Errorf("delay must be between 0 and %s", checkMaxRequestDelay)
+	}
+	return delay, nil
+}
+
+func withRequestDelay(ctx context.Context, delay time.Duration) context.Context {
+	return context.WithValue(ctx, requestDelayKey{}, delay)
+}
+
+// injectCheckLatency sleeps for the configured delay plus any the request
+// asked for, and returns it in milliseconds. It returns early with the
+// context's error when the client goes away.
+func injectCheckLatency(ctx context.Context) (float64, error) {
+	checkLatencyMu.RLock()
+	current := checkLatency
+	checkLatencyMu.RUnlock()
+
+	delayMs := 0.0
+	if current.MaxMs > 0 && !chaosBypassed(ctx) {
+		delayMs = current.MinMs + randomFloat()*(current.MaxMs-current.MinMs)
+	}
+	// Asked for by the request itself, so the chaos bypass doesn't apply
+	if requested, ok := ctx.Value(requestDelayKey{}).(time.Duration); ok {
+		delayMs += float64(requested) / float64(time.Millisecond)
+	}
+	if delayMs <= 0 {
+		return 0, nil
+	}
+
+	start := time.Now()
+	defer func() { addServerTiming(ctx, timingDelay, time.Since(start)) }()
+
+	timer := time.NewTimer(time.Duration(delayMs * float64(time.Millisecond)))
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return delayMs, nil
+	case <-ctx.Done():
+		return delayMs, ctx.Err()
+	}
+}
+
+func getLatencyHandler(c echo.Context) error {
+	checkLatencyMu.RLock()
+	current := checkLatency
+	checkLatencyMu.RUnlock()
+
+	httpRequestsTotal.WithLabelValues("/api/latency", fmt.Sprintf("%d", http.StatusOK)).Inc()
+	return c.JSON(http.StatusOK, current)
+}
+
+func setLatencyHandler(c echo.Context) error {
+	checkLatencyMu.RLock()
+	update := checkLatency
+	checkLatencyMu.RUnlock()
+
+	if err := json.NewDecoder(c.Request().Body).Decode(&update); err != nil {
+		httpRequestsTotal.WithLabelValues("/api/set-latency", fmt.Sprintf("%d", http.StatusBadRequest)).Inc()
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid JSON"})
+	}
+	if err := update.validate(); err != nil {
+		httpRequestsTotal.WithLabelValues("/api/set-latency", fmt.Sprintf("%d", http.StatusBadRequest)).Inc()
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+
+	setCheckLatency(c.Request().Context(), update)
+
+	httpRequestsTotal.WithLabelValues("/api/set-latency", fmt.Sprintf("%d", http.StatusOK)).Inc()
+	return c.JSON(http.StatusOK, update)
+}
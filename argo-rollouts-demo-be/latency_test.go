@@ -0,0 +1,35 @@
+package main
+
+import (
+	"context"
+	"math"
+	"testing"
+)
+
+func TestCheckLatencyRejectsNaN(t *testing.T) {
+	for _, latency := range []CheckLatency{{MinMs: math.NaN(), MaxMs: 10}, {MinMs: 0, MaxMs: math.NaN()}} {
+		if err := latency.validate(); err == nil {
+			t.Errorf("%+v: want an error", latency)
+		}
+	}
+}
+
+// A pod starting after the latency was set picks it up from Redis.
+func TestCheckLatencySharedThroughRedis(t *testing.T) {
+	useCounterStore(t, newFakeRedis(t, false), counterStore)
+	previous := currentCheckLatency()
+	t.Cleanup(func() { storeCheckLatency(previous) })
+	ctx := context.Background()
+
+	set := CheckLatency{MinMs: 20, MaxMs: 80}
+	if !setCheckLatency(ctx, set) {
+		t.Fatal("latency was not replicated")
+	}
+	storeCheckLatency(CheckLatency{})
+	if err := loadSharedLatency(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if got := currentCheckLatency(); got != set {
+		t.Fatalf("loaded %+v, want %+v", got, set)
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/redis/go-redis/v9"
+)
+
+// The injected /api/check latency is shared by every pod the same way the
+// error rate is: the last value set is kept in Redis for pods starting
+// later, and each change is published for the pods already running.
+const (
+	latencyKey     = "config:latency" // CheckLatency as JSON
+	latencyChannel = "config:latency:updates"
+)
+
+// latencyUpdate is published whenever a pod changes the latency.
+type latencyUpdate struct {
+	CheckLatency
+	Pod string `json:"pod"`
+}
+
+var latencySyncTotal = promauto.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "latency_sync_total",
+		Help: "Injected latency replication events by kind (published, received, reloaded) and result",
+	},
+	[]string{"kind", "result"},
+)
+
+func storeCheckLatency(latency CheckLatency) {
+	checkLatencyMu.Lock()
+	checkLatency = latency
+	checkLatencyMu.Unlock()
+}
+
+// setCheckLatency applies a new latency on this pod and shares it with
+// every other pod through Redis. It reports whether the latency was
+// replicated; without Redis it only applies here.
+func setCheckLatency(ctx context.Context, latency CheckLatency) bool {
+	storeCheckLatency(latency)
+	if redisClient == nil {
+		return false
+	}
+
+	stored, err := json.Marshal(latency)
+	if err != nil {
+		return false
+	}
+	encoded, err := json.Marshal(latencyUpdate{CheckLatency: latency, Pod: podName})
+	if err != nil {
+		return false
+	}
+	pipe := redisClient.TxPipeline()
+	pipe.Set(ctx, latencyKey, stored, 0)
+	pipe.Publish(ctx, latencyChannel, encoded)
+	if _, err := pipe.Exec(ctx); err != nil {
+		latencySyncTotal.WithLabelValues("published", "error").Inc()
+		warnf("Failed to replicate latency: %v", err)
+		return false
+	}
+	latencySyncTotal.WithLabelValues("published", "ok").Inc()
+	return true
+}
+
+// loadSharedLatency applies the latency stored in Redis, if any.
+func loadSharedLatency(ctx context.Context) error {
+	value, err := redisClient.Get(ctx, latencyKey).Result()
+	if err == redis.Nil {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var latency CheckLatency
+	if err := json.Unmarshal([]byte(value), &latency); err != nil {
+		return err
+	}
+	if err := latency.validate(); err != nil {
+		return err
+	}
+	if latency != currentCheckLatency() {
+		debugf("latency: reloaded %g-%gms from Redis", latency.MinMs, latency.MaxMs)
+	}
+	storeCheckLatency(latency)
+	return nil
+}
+
+// runLatencySync keeps this pod's latency in step with the shared one.
+func runLatencySync(stop <-chan struct{}) {
+	pubsub := redisClient.Subscribe(redisCtx, latencyChannel)
+	defer pubsub.Close()
+
+	var resync <-chan time.Time
+	if errorRateResyncInterval > 0 {
+		ticker := time.NewTicker(errorRateResyncInterval)
+		defer ticker.Stop()
+		resync = ticker.C
+	}
+
+	messages := pubsub.Channel()
+	for {
+		select {
+		case <-stop:
+			return
+		case msg, ok := <-messages:
+			if !ok {
+				return
+			}
+			var update latencyUpdate
+			if err := json.Unmarshal([]byte(msg.Payload), &update); err != nil || update.CheckLatency.validate() != nil {
+				latencySyncTotal.WithLabelValues("received", "invalid").Inc()
+				continue
+			}
+			latencySyncTotal.WithLabelValues("received", "ok").Inc()
+			if update.Pod != podName {
+				storeCheckLatency(update.CheckLatency)
+				debugf("latency: %g-%gms from pod %s", update.MinMs, update.MaxMs, update.Pod)
+			}
+		case <-resync:
+			if err := loadSharedLatency(redisCtx); err != nil {
+				latencySyncTotal.WithLabelValues("reloaded", "error").Inc()
+				warnf("Failed to reload shared latency: %v", err)
+				continue
+			}
+			latencySyncTotal.WithLabelValues("reloaded", "ok").Inc()
+		}
+	}
+}
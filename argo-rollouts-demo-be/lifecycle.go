@@ -0,0 +1,219 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Lifecycle is a long-running part of the process. Start returns once the
+// component is running; Stop returns once it has ended, or with ctx's error
+// when that takes too long.
+type Lifecycle interface {
+	Start(ctx context.Context) error
+	Stop(ctx context.Context) error
+}
+
+type supervisedComponent struct {
+	name      string
+	component Lifecycle
+	dependsOn []string
+}
+
+// supervisor starts components after the ones they depend on and stops them
+// in the reverse order, so nothing is stopped while something still uses it:
+// the HTTP server stops taking requests before the workers behind it end,
+// and Redis closes last.
+type supervisor struct {
+	components []supervisedComponent
+	started    []supervisedComponent
+}
+
+// Add registers a component. Dependencies must be added too, in any order.
+func (s *supervisor) Add(name string, component Lifecycle, dependsOn ...string) {
+	s.components = append(s.components, supervisedComponent{name: name, component: component, dependsOn: dependsOn})
+}
+
+// order sorts the components so each comes after its dependencies, keeping
+// the order they were added in otherwise.
+func (s *supervisor) order() ([]supervisedComponent, error) {
+	byName := make(map[string]supervisedComponent, len(s.components))
+	for _, c := range s.components {
+		if _, ok := byName[c.name]; ok {
+			return nil, fmt.Errorf("component %s added twice", c.name)
+		}
+		byName[c.name] = c
+	}
+
+	const (
+		visiting = 1
+		visited  = 2
+	)
+	marks := map[string]int{}
+	ordered := make([]supervisedComponent, 0, len(s.components))
+	var visit func(c supervisedComponent) error
+	visit = func(c supervisedComponent) error {
+		switch marks[c.name] {
+		case visiting:
+			return fmt.Errorf("dependency cycle through %s", c.name)
+		case visited:
+			return nil
+		}
+		marks[c.name] = visiting
+		for _, name := range c.dependsOn {
+			dep, ok := byName[name]
+			if !ok {
+				return fmt.Errorf("component %s depends on unknown component %s", c.name, name)
+			}
+			if err := visit(dep); err != nil {
+				return err
+			}
+		}
+		marks[c.name] = visited
+		ordered = append(ordered, c)
+		return nil
+	}
+	for _, c := range s.components {
+		if err := visit(c); err != nil {
+			return nil, err
+		}
+	}
+	return ordered, nil
+}
+
+// Start starts every component in dependency order. If one fails, the ones
+// already started are stopped again and the error is returned.
+func (s *supervisor) Start(ctx context.Context) error {
+	ordered, err := s.order()
+	if err != nil {
+		return err
+	}
+	for _, c := range ordered {
+		if err := c.component.Start(ctx); err != nil {
+			s.Stop(ctx)
+			return fmt.Errorf("starting %s: %w", c.name, err)
+		}
+		debugf("lifecycle: started %s", c.name)
+		s.started = append(s.started, c)
+	}
+	return nil
+}
+
+// Stop stops the started components in reverse order. A component failing
+// to stop doesn't keep the others running; all errors are returned.
+func (s *supervisor) Stop(ctx context.Context) error {
+	var errs []error
+	for i := len(s.started) - 1; i >= 0; i-- {
+		c := s.started[i]
+		if err := c.component.Stop(ctx); err != nil {
+			warnf("Failed to stop %s: %v", c.name, err)
+			errs = append(errs, fmt.Errorf("stopping %s: %w", c.name, err))
+			continue
+		}
+		debugf("lifecycle: stopped %s", c.name)
+	}
+	s.started = nil
+	return errors.Join(errs...)
+}
+
+// lifecycleFuncs adapts a pair of functions to Lifecycle. Either may be nil.
+type lifecycleFuncs struct {
+	start func(ctx context.Context) error
+	stop  func(ctx context.Context) error
+}
+
+func (l lifecycleFuncs) Start(ctx context.Context) error {
+	if l.start == nil {
+		return nil
+	}
+	return l.start(ctx)
+}
+
+func (l lifecycleFuncs) Stop(ctx context.Context) error {
+	if l.stop == nil {
+		return nil
+	}
+	return l.stop(ctx)
+}
+
+// worker runs one of the background loops taking a stop channel, like
+// runRollupWorker, as a component.
+type worker struct {
+	run     func(stop <-chan struct{})
+	enabled func() bool // Checked at start, nil for always
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+func newWorker(run func(stop <-chan struct{})) *worker {
+	return &worker{run: run}
+}
+
+// when makes the worker only run if enabled reports true at start, for
+// workers depending on optional features such as Redis.
+func (w *worker) when(enabled func() bool) *worker {
+	w.enabled = enabled
+	return w
+}
+
+func (w *worker) Start(ctx context.Context) error {
+	if w.enabled != nil && !w.enabled() {
+		return nil
+	}
+	w.stop = make(chan struct{})
+	w.done = make(chan struct{})
+	go func() {
+		defer close(w.done)
+		w.run(w.stop)
+	}()
+	return nil
+}
+
+func (w *worker) Stop(ctx context.Context) error {
+	if w.stop == nil {
+		return nil
+	}
+	close(w.stop)
+	select {
+	case <-w.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// httpServer runs the Echo server as a component, with its HTTPS listener
+// when one is configured.
+type httpServer struct {
+	e    *echo.Echo
+	addr string
+}
+
+func (s httpServer) Start(ctx context.Context) error {
+	infof("HTTP server listening on %s (h2c: %t)", s.addr, h2cEnabled)
+	go func() {
+		if err := s.e.Start(s.addr); err != nil && err != http.ErrServerClosed {
+			fatalf("Server failed to start: %v", err)
+		}
+	}()
+	if s.e.TLSServer.TLSConfig != nil {
+		infof("HTTPS server listening on %s", s.e.TLSServer.Addr)
+		go func() {
+			if err := s.e.StartServer(s.e.TLSServer); err != nil && err != http.ErrServerClosed {
+				fatalf("TLS server failed to start: %v", err)
+			}
+		}()
+	}
+	return nil
+}
+
+// Stop ends the open streams, stops accepting connections on both
+// listeners and waits for in-flight requests.
+func (s httpServer) Stop(ctx context.Context) error {
+	close(streamsStop)
+	return s.e.Shutdown(ctx)
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Server limits keeping malformed or deliberately slow clients from tying up
+// the demo backend. A slowloris client trickling headers is cut off by
+// ReadHeaderTimeout, a slow body by ReadTimeout.
+var (
+	maxBodyBytes      = int64(getEnvFloatOrDefault("MAX_BODY_BYTES", 1<<20))
+	maxHeaderBytes    = int(getEnvFloatOrDefault("MAX_HEADER_BYTES", 64<<10))
+	readHeaderTimeout = time.Duration(getEnvFloatOrDefault("READ_HEADER_TIMEOUT_SECONDS", 5) * float64(time.Second))
+	readTimeout       = time.Duration(getEnvFloatOrDefault("READ_TIMEOUT_SECONDS", 30) * float64(time.Second))
+	idleTimeout       = time.Duration(getEnvFloatOrDefault("IDLE_TIMEOUT_SECONDS", 120) * float64(time.Second))
+
+	// Connections that haven't had a request reach a handler yet
+	pendingConns sync.Map
+
+	requestsRejectedTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "http_requests_rejected_total",
+			Help: "Requests rejected by server limits by reason (body_too_large, slow_body, closed_before_request)",
+		},
+		[]string{"reason"},
+	)
+)
+
+// applyServerLimits sets the timeouts and header limit on the echo server.
+// WriteTimeout stays unset so injected latency and streams aren't cut off.
+func applyServerLimits(s *http.Server) {
+	s.ReadHeaderTimeout = readHeaderTimeout
+	s.ReadTimeout = readTimeout
+	s.IdleTimeout = idleTimeout
+	s.MaxHeaderBytes = maxHeaderBytes
+	s.ConnContext = func(ctx context.Context, conn net.Conn) context.Context {
+		return context.WithValue(ctx, connContextKey{}, conn)
+	}
+}
+
+type connContextKey struct{}
+
+// markConnServed clears the pending flag once a request from the connection
+// made it past header parsing.
+func markConnServed(ctx context.Context) {
+	if conn, ok := ctx.Value(connContextKey{}).(net.Conn); ok {
+		pendingConns.Delete(conn)
+	}
+}
+
+// trackPendingConn counts connections closed before sending a complete
+// request, which is where header timeouts (and port probes) end up.
+func trackPendingConn(conn net.Conn, state http.ConnState) {
+	switch state {
+	case http.StateNew:
+		pendingConns.Store(conn, struct{}{})
+	case http.StateClosed:
+		if _, pending := pendingConns.LoadAndDelete(conn); pending {
+			requestsRejectedTotal.WithLabelValues("closed_before_request").Inc()
+		}
+	case http.StateHijacked:
+		pendingConns.Delete(conn)
+	}
+}
+
+// limitedBody counts why a body read failed, once per request.
+type limitedBody struct {
+	io.ReadCloser
+	counted bool
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if err != nil && err != io.EOF && !b.counted {
+		var maxBytesErr *http.MaxBytesError
+		var netErr net.Error
+		switch {
+		case errors.As(err, &maxBytesErr):
+			b.counted = true
+			requestsRejectedTotal.WithLabelValues("body_too_large").Inc()
+		case errors.As(err, &netErr) && netErr.Timeout():
+			b.counted = true
+			requestsRejectedTotal.WithLabelValues("slow_body").Inc()
+		}
+	}
+	return n, err
+}
+
+// bodyLimitMiddleware rejects declared oversized bodies up front and caps
+// chunked ones while they are read. It also clears the connection's pending
+// flag. Like the in-flight counter it is installed with e.Pre, outside the
+// configurable pipeline.
+func bodyLimitMiddleware(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		req := c.Request()
+		markConnServed(req.Context())
+		if maxBodyBytes <= 0 {
+			return next(c)
+		}
+
+		if req.ContentLength > maxBodyBytes {
+			requestsRejectedTotal.WithLabelValues("body_too_large").Inc()
+			httpRequestsTotal.WithLabelValues(req.URL.Path, fmt.Sprintf("%d", http.StatusRequestEntityTooLarge)).Inc()
+			return c.JSON(http.StatusRequestEntityTooLarge, map[string]string{
+				"error": fmt.Sprintf("Request body exceeds %d bytes", maxBodyBytes),
+			})
+		}
+
+		req.Body = &limitedBody{ReadCloser: http.MaxBytesReader(c.Response(), req.Body, maxBodyBytes)}
+		return next(c)
+	}
+}
@@ -0,0 +1,671 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"sort"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const (
+	discoveryNone = "none" // Send everything to the target URL, the Service balances
+	discoveryDNS  = "dns"  // Resolve the target host's A records (headless Service)
+	discoverySRV  = "srv"  // Resolve SRV records for the target host
+)
+
+type LoadGenConfig struct {
+	Target          string        `json:"target"`
+	RPS             float64       `json:"rps"` // The peak when replaying a profile
+	Concurrency     int           `json:"concurrency"`
+	Duration        time.Duration `json:"duration"` // Zero runs until stopped
+	Discovery       string        `json:"discovery"`
+	SRVService      string        `json:"srvService"`
+	ResolveInterval time.Duration `json:"resolveInterval"`
+
+	// Traffic profile to replay instead of a constant rate, and how long
+	// one pass takes; zero uses the profile's recorded period
+	Profile       string        `json:"profile,omitempty"`
+	ProfilePeriod time.Duration `json:"profilePeriod,omitempty"`
+
+	// Pattern schedule to follow instead, RPS defaults to its peak
+	Schedule *TrafficSchedule `json:"schedule,omitempty"`
+}
+
+// LoadGenProfile is the rate the generator is currently aiming for.
+type LoadGenProfile struct {
+	Profile       string        `json:"profile"` // Empty for a constant rate
+	PeakRPS       float64       `json:"peakRps"`
+	ProfilePeriod time.Duration `json:"profilePeriod,omitempty"`
+	Elapsed       time.Duration `json:"elapsed"`
+	TargetRPS     float64       `json:"targetRps"`
+	Available     []string      `json:"available"`
+
+	// Following a pattern schedule: the pattern playing and its step
+	Pattern      string `json:"pattern,omitempty"`
+	ScheduleStep int    `json:"scheduleStep,omitempty"` // From 1
+}
+
+type LoadGenStats struct {
+	Sent        int64                       `json:"sent"`
+	Errors      int64                       `json:"errors"` // Transport errors, no HTTP status
+	StatusCodes map[string]int64            `json:"statusCodes"`
+	Versions    map[string]int64            `json:"versions"`
+	ByEndpoint  map[string]map[string]int64 `json:"byEndpoint"`
+
+	// Latency histogram of answered requests over rollupBucketsMs
+	LatencyBuckets []int64 `json:"latencyBuckets"`
+}
+
+type loadGenerator struct {
+	cfg    LoadGenConfig
+	client *http.Client
+	target *url.URL
+
+	mu        sync.Mutex
+	endpoints []string
+	next      int
+	stats     LoadGenStats
+
+	// Switchable while running, see SetProfile
+	peakRPS        float64
+	profile        *TrafficProfile
+	profilePeriod  time.Duration
+	schedule       *TrafficSchedule
+	profileStarted time.Time
+
+	// Set by Start
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+var (
+	// Registered by the loadgen subsystem, so servers that never generate
+	// load don't export empty series
+	loadgenRequestsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "loadgen_requests_total",
+			Help: "Total number of load generator requests by target endpoint, status code and responding version",
+		},
+		[]string{"endpoint", "status_code", "version"},
+	)
+	loadgenRequestDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "loadgen_request_duration_seconds",
+			Help:    "Load generator request latency by target endpoint",
+			Buckets: prometheus.DefBuckets,
+		},
+		[]string{"endpoint"},
+	)
+	loadgenTargetRPS = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "loadgen_target_rps",
+		Help: "Request rate the load generator is currently aiming for",
+	})
+)
+
+// loadgenPaceInterval is how often the generator releases the requests due,
+// short enough that a profile's changing rate looks smooth.
+const loadgenPaceInterval = 20 * time.Millisecond
+
+func initLoadgenMetrics() error {
+	if err := prometheus.Register(loadgenRequestsTotal); err != nil {
+		return err
+	}
+	if err := prometheus.Register(loadgenTargetRPS); err != nil {
+		return err
+	}
+	return prometheus.Register(loadgenRequestDuration)
+}
+
+func newLoadGenerator(cfg LoadGenConfig) (*loadGenerator, error) {
+	if err := loadgenSubsystem.start(); err != nil {
+		return nil, err
+	}
+	target, err := url.Parse(cfg.Target)
+	if err != nil || target.Host == "" {
+		return nil, fmt.Errorf("invalid target URL %q", cfg.Target)
+	}
+	if cfg.Schedule != nil {
+		if cfg.Profile != "" {
+			return nil, fmt.Errorf("set either a profile or a schedule, not both")
+		}
+		if err := cfg.Schedule.validate(); err != nil {
+			return nil, err
+		}
+		if cfg.RPS <= 0 {
+			cfg.RPS = cfg.Schedule.peakRPS()
+		}
+	}
+	if cfg.RPS <= 0 {
+		return nil, fmt.Errorf("rps must be positive")
+	}
+	if cfg.Concurrency <= 0 {
+		cfg.Concurrency = 1
+	}
+	if cfg.ResolveInterval <= 0 {
+		cfg.ResolveInterval = 10 * time.Second
+	}
+	switch cfg.Discovery {
+	case "":
+		cfg.Discovery = discoveryNone
+	case discoveryNone, discoveryDNS, discoverySRV:
+	default:
+		return nil, fmt.Errorf("unknown discovery mode %q", cfg.Discovery)
+	}
+
+	var profile *TrafficProfile
+	if cfg.Profile != "" {
+		if profile, err = lookupTrafficProfile(cfg.Profile); err != nil {
+			return nil, err
+		}
+	}
+	if cfg.ProfilePeriod < 0 {
+		return nil, fmt.Errorf("profile period must not be negative")
+	}
+
+	return &loadGenerator{
+		cfg:           cfg,
+		target:        target,
+		peakRPS:       cfg.RPS,
+		profile:       profile,
+		profilePeriod: cfg.ProfilePeriod,
+		schedule:      cfg.Schedule,
+		// Deliberately not the outbound client: retries would hide the very
+		// errors the generator is measuring
+		client: &http.Client{
+			Timeout: 10 * time.Second,
+			Transport: &http.Transport{
+				MaxIdleConnsPerHost: cfg.Concurrency,
+			},
+		},
+		stats: LoadGenStats{
+			StatusCodes:    map[string]int64{},
+			Versions:       map[string]int64{},
+			ByEndpoint:     map[string]map[string]int64{},
+			LatencyBuckets: make([]int64, len(rollupBucketsMs)+1),
+		},
+	}, nil
+}
+
+// resolveEndpoints returns the host:port pairs requests are spread across.
+// With discovery disabled it is just the target host, so the Service (and
+// any Rollout traffic split) decides which pod answers.
+func (g *loadGenerator) resolveEndpoints(ctx context.Context) ([]string, error) {
+	host := g.target.Hostname()
+	port := g.target.Port()
+	if port == "" {
+		port = "80"
+		if g.target.Scheme == "https" {
+			port = "443"
+		}
+	}
+
+	var resolver net.Resolver
+	switch g.cfg.Discovery {
+	case discoveryDNS:
+		addrs, err := resolver.LookupHost(ctx, host)
+		if err != nil {
+			return nil, err
+		}
+		endpoints := make([]string, 0, len(addrs))
+		for _, addr := range addrs {
+			endpoints = append(endpoints, net.JoinHostPort(addr, port))
+		}
+		sort.Strings(endpoints)
+		return endpoints, nil
+
+	case discoverySRV:
+		_, records, err := resolver.LookupSRV(ctx, g.cfg.SRVService, "tcp", host)
+		if err != nil {
+			return nil, err
+		}
+		endpoints := make([]string, 0, len(records))
+		for _, record := range records {
+			endpoints = append(endpoints, net.JoinHostPort(record.Target, strconv.Itoa(int(record.Port))))
+		}
+		sort.Strings(endpoints)
+		return endpoints, nil
+	}
+
+	return []string{g.target.Host}, nil
+}
+
+func (g *loadGenerator) refreshEndpoints(ctx context.Context) {
+	endpoints, err := g.resolveEndpoints(ctx)
+	if err != nil {
+		warnf("Failed to resolve load generator endpoints: %v", err)
+		return
+	}
+	if len(endpoints) == 0 {
+		warnf("Load generator discovery returned no endpoints, keeping previous set")
+		return
+	}
+
+	g.mu.Lock()
+	g.endpoints = endpoints
+	g.mu.Unlock()
+}
+
+// pickEndpoint round-robins across the discovered endpoints.
+func (g *loadGenerator) pickEndpoint() string {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if len(g.endpoints) == 0 {
+		return g.target.Host
+	}
+	endpoint := g.endpoints[g.next%len(g.endpoints)]
+	g.next++
+	return endpoint
+}
+
+func (g *loadGenerator) send(ctx context.Context, endpoint string) {
+	reqURL := *g.target
+	reqURL.Host = endpoint
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, reqURL.String(), nil)
+	if err != nil {
+		return
+	}
+	// Keep the original Host so virtual hosting still works when dialing pod IPs
+	req.Host = g.target.Host
+
+	start := time.Now()
+	resp, err := g.client.Do(req)
+	elapsed := time.Since(start)
+	loadgenRequestDuration.WithLabelValues(endpoint).Observe(elapsed.Seconds())
+
+	status := "error"
+	responder := ""
+	if err == nil {
+		status = strconv.Itoa(resp.StatusCode)
+		responder = resp.Header.Get("X-Version")
+		resp.Body.Close()
+	} else if ctx.Err() != nil {
+		return
+	}
+	loadgenRequestsTotal.WithLabelValues(endpoint, status, responder).Inc()
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.stats.Sent++
+	if err != nil {
+		g.stats.Errors++
+	} else {
+		g.stats.StatusCodes[status]++
+		g.stats.LatencyBuckets[sort.SearchFloat64s(rollupBucketsMs, float64(elapsed)/float64(time.Millisecond))]++
+		if responder != "" {
+			g.stats.Versions[responder]++
+		}
+	}
+	if g.stats.ByEndpoint[endpoint] == nil {
+		g.stats.ByEndpoint[endpoint] = map[string]int64{}
+	}
+	g.stats.ByEndpoint[endpoint][status]++
+}
+
+// SetProfile switches a running generator to replay a profile from its
+// start, or to a constant peakRPS when name is empty.
+func (g *loadGenerator) SetProfile(name string, peakRPS float64, period time.Duration) error {
+	if peakRPS <= 0 {
+		return fmt.Errorf("rps must be positive")
+	}
+	if period < 0 {
+		return fmt.Errorf("profile period must not be negative")
+	}
+	var profile *TrafficProfile
+	if name != "" {
+		var err error
+		if profile, err = lookupTrafficProfile(name); err != nil {
+			return err
+		}
+	}
+
+	g.mu.Lock()
+	g.peakRPS = peakRPS
+	g.profile = profile
+	g.profilePeriod = period
+	g.schedule = nil
+	g.profileStarted = time.Now()
+	g.mu.Unlock()
+	infof("Load generator switched to profile %q at %.1f peak rps", name, peakRPS)
+	return nil
+}
+
+// Profile reports the profile being replayed and the current target rate.
+func (g *loadGenerator) Profile() LoadGenProfile {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.profileLocked(time.Now())
+}
+
+func (g *loadGenerator) profileLocked(now time.Time) LoadGenProfile {
+	current := LoadGenProfile{
+		PeakRPS:   g.peakRPS,
+		TargetRPS: g.peakRPS,
+		Available: trafficProfileNames(),
+	}
+	if g.profile != nil {
+		current.Profile = g.profile.Name
+		current.ProfilePeriod = g.profilePeriod
+		if current.ProfilePeriod == 0 {
+			current.ProfilePeriod = g.profile.Period
+		}
+		if !g.profileStarted.IsZero() {
+			current.Elapsed = now.Sub(g.profileStarted)
+		}
+		current.TargetRPS = g.peakRPS * g.profile.fraction(current.Elapsed, current.ProfilePeriod)
+	}
+	if g.schedule != nil {
+		if !g.profileStarted.IsZero() {
+			current.Elapsed = now.Sub(g.profileStarted)
+		}
+		step, into := g.schedule.at(current.Elapsed)
+		current.Pattern = g.schedule.Steps[step].name()
+		current.ScheduleStep = step + 1
+		current.TargetRPS = g.schedule.Steps[step].rate(into)
+	}
+	return current
+}
+
+// targetRPS is the rate to send at right now.
+func (g *loadGenerator) targetRPS(now time.Time) float64 {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.profileStarted.IsZero() {
+		g.profileStarted = now
+	}
+	return g.profileLocked(now).TargetRPS
+}
+
+// Stats returns a copy of the counts observed so far.
+func (g *loadGenerator) Stats() LoadGenStats {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	stats := LoadGenStats{
+		Sent:           g.stats.Sent,
+		Errors:         g.stats.Errors,
+		StatusCodes:    map[string]int64{},
+		Versions:       map[string]int64{},
+		ByEndpoint:     map[string]map[string]int64{},
+		LatencyBuckets: append([]int64(nil), g.stats.LatencyBuckets...),
+	}
+	for k, v := range g.stats.StatusCodes {
+		stats.StatusCodes[k] = v
+	}
+	for k, v := range g.stats.Versions {
+		stats.Versions[k] = v
+	}
+	for endpoint, codes := range g.stats.ByEndpoint {
+		stats.ByEndpoint[endpoint] = map[string]int64{}
+		for k, v := range codes {
+			stats.ByEndpoint[endpoint][k] = v
+		}
+	}
+	return stats
+}
+
+// Run sends requests at the configured rate until ctx is done or the
+// configured duration elapses.
+func (g *loadGenerator) Run(ctx context.Context) {
+	if g.cfg.Duration > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, g.cfg.Duration)
+		defer cancel()
+	}
+
+	g.refreshEndpoints(ctx)
+
+	jobs := make(chan string, g.cfg.Concurrency)
+	var wg sync.WaitGroup
+	for i := 0; i < g.cfg.Concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for endpoint := range jobs {
+				g.send(ctx, endpoint)
+			}
+		}()
+	}
+
+	ticker := time.NewTicker(loadgenPaceInterval)
+	defer ticker.Stop()
+	last := time.Now()
+	due := 0.0
+	resolve := time.NewTicker(g.cfg.ResolveInterval)
+	defer resolve.Stop()
+
+loop:
+	for {
+		select {
+		case <-ctx.Done():
+			break loop
+		case <-resolve.C:
+			if g.cfg.Discovery != discoveryNone {
+				g.refreshEndpoints(ctx)
+			}
+		case now := <-ticker.C:
+			rate := g.targetRPS(now)
+			loadgenTargetRPS.Set(rate)
+			due += rate * now.Sub(last).Seconds()
+			last = now
+			for ; due >= 1; due-- {
+				// Drop the request rather than queue when all workers are busy
+				select {
+				case jobs <- g.pickEndpoint():
+				default:
+				}
+			}
+		}
+	}
+
+	close(jobs)
+	wg.Wait()
+}
+
+// Start runs the generator in the background until Stop is called or the
+// configured duration elapses. Cancelling ctx doesn't stop it.
+func (g *loadGenerator) Start(ctx context.Context) error {
+	ctx, g.cancel = context.WithCancel(context.WithoutCancel(ctx))
+	g.done = make(chan struct{})
+	go func() {
+		defer close(g.done)
+		g.Run(ctx)
+	}()
+	return nil
+}
+
+// Stop ends a generator started with Start and waits for in-flight requests.
+func (g *loadGenerator) Stop(ctx context.Context) error {
+	if g.cancel == nil {
+		return nil
+	}
+	g.cancel()
+	select {
+	case <-g.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Done is closed when a generator started with Start has finished.
+func (g *loadGenerator) Done() <-chan struct{} {
+	return g.done
+}
+
+// runLoadgenMode runs the binary as a standalone load generator instead of
+// the API server, e.g. as a Kubernetes Job or a sidecar next to the frontend.
+func runLoadgenMode(args []string) {
+	fs := flag.NewFlagSet("loadgen", flag.ExitOnError)
+	target := fs.String("target", getEnvOrDefault("LOADGEN_TARGET", "http://localhost:8080/api/check"), "URL to send requests to")
+	rps := fs.Float64("rps", getEnvFloatOrDefault("LOADGEN_RPS", 10), "requests per second, the peak when replaying a profile")
+	concurrency := fs.Int("concurrency", int(getEnvFloatOrDefault("LOADGEN_CONCURRENCY", 4)), "number of concurrent workers")
+	duration := fs.Duration("duration", time.Duration(getEnvFloatOrDefault("LOADGEN_DURATION_SECONDS", 0)*float64(time.Second)), "how long to run, 0 runs until interrupted")
+	discovery := fs.String("discovery", getEnvOrDefault("LOADGEN_DISCOVERY", discoveryNone), "endpoint discovery: none, dns or srv")
+	srvService := fs.String("srv-service", getEnvOrDefault("LOADGEN_SRV_SERVICE", "http"), "SRV service name (port name) for srv discovery")
+	metricsAddr := fs.String("metrics-addr", getEnvOrDefault("LOADGEN_METRICS_ADDR", ":9090"), "address to serve Prometheus metrics on, empty to disable")
+	profile := fs.String("profile", getEnvOrDefault("LOADGEN_PROFILE", ""), fmt.Sprintf("traffic profile to replay, one of %v, empty for a constant rate", trafficProfileNames()))
+	profilePeriod := fs.Duration("profile-period", time.Duration(getEnvFloatOrDefault("LOADGEN_PROFILE_PERIOD_SECONDS", 0)*float64(time.Second)), "how long one pass of the profile takes, 0 for its recorded length")
+	reportURL := fs.String("report-url", getEnvOrDefault("LOADGEN_REPORT_URL", ""), "server URL to report the finished run to, e.g. http://argo-rollouts-demo-be/api/load/runs")
+	fs.Parse(args)
+
+	gen, err := newLoadGenerator(LoadGenConfig{
+		Target:        *target,
+		RPS:           *rps,
+		Concurrency:   *concurrency,
+		Duration:      *duration,
+		Discovery:     *discovery,
+		SRVService:    *srvService,
+		Profile:       *profile,
+		ProfilePeriod: *profilePeriod,
+	})
+	if err != nil {
+		fatalf("Invalid load generator configuration: %v", err)
+	}
+
+	var components supervisor
+	if *metricsAddr != "" {
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", promhttp.Handler())
+		mux.Handle("/profile", loadgenProfileHandler(gen))
+		srv := &http.Server{Addr: *metricsAddr, Handler: mux}
+		components.Add("metrics-server", lifecycleFuncs{
+			start: func(context.Context) error {
+				go func() {
+					if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+						warnf("Load generator metrics server stopped: %v", err)
+					}
+				}()
+				return nil
+			},
+			stop: srv.Shutdown,
+		})
+	}
+	components.Add("generator", gen)
+	components.Add("stats-logger", newWorker(func(stop <-chan struct{}) {
+		ticker := time.NewTicker(10 * time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				logLoadgenStats(gen.Stats())
+			}
+		}
+	}), "generator")
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	startedAt := time.Now().UTC()
+	if err := components.Start(ctx); err != nil {
+		fatalf("Load generator failed to start: %v", err)
+	}
+	infof("Load generator started - target: %s, rps: %.1f, concurrency: %d, discovery: %s, profile: %q",
+		*target, *rps, *concurrency, gen.cfg.Discovery, *profile)
+
+	// Run until interrupted or the duration is over
+	select {
+	case <-ctx.Done():
+	case <-gen.Done():
+	}
+	stopCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	components.Stop(stopCtx)
+
+	stats := gen.Stats()
+	logLoadgenStats(stats)
+
+	if *reportURL != "" {
+		if err := reportLoadRun(*reportURL, summarizeLoadRun("loadgen", *target, startedAt, stats)); err != nil {
+			warnf("Failed to report load run: %v", err)
+		}
+	}
+	infof("Load generator exited")
+}
+
+// loadgenProfileHandler lets a standalone generator be switched between
+// profiles without a restart: GET shows the current one, POST takes
+// {"profile": "flash-sale", "peakRps": 50, "profilePeriod": "30m"}.
+func loadgenProfileHandler(gen *loadGenerator) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeJSON := func(code int, body interface{}) {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(code)
+			json.NewEncoder(w).Encode(body)
+		}
+
+		switch r.Method {
+		case http.MethodGet:
+		case http.MethodPost:
+			current := gen.Profile()
+			update := struct {
+				Profile       string  `json:"profile"`
+				PeakRPS       float64 `json:"peakRps"`
+				ProfilePeriod string  `json:"profilePeriod"`
+			}{PeakRPS: current.PeakRPS}
+			if err := json.NewDecoder(r.Body).Decode(&update); err != nil {
+				writeJSON(http.StatusBadRequest, map[string]string{"error": "Invalid JSON"})
+				return
+			}
+			var period time.Duration
+			if update.ProfilePeriod != "" {
+				var err error
+				if period, err = time.ParseDuration(update.ProfilePeriod); err != nil {
+					writeJSON(http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("invalid profilePeriod %q", update.ProfilePeriod)})
+					return
+				}
+			}
+			if err := gen.SetProfile(update.Profile, update.PeakRPS, period); err != nil {
+				writeJSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+				return
+			}
+		default:
+			w.Header().Set("Allow", "GET, POST")
+			writeJSON(http.StatusMethodNotAllowed, map[string]string{"error": "Method not allowed"})
+			return
+		}
+		writeJSON(http.StatusOK, gen.Profile())
+	})
+}
+
+// reportLoadRun posts the run summary to a server, so a Job can assert on it
+// with POST /api/load/assert afterwards.
+func reportLoadRun(reportURL string, run LoadRun) error {
+	encoded, err := json.Marshal(run)
+	if err != nil {
+		return err
+	}
+	client := newOutboundClient(outboundClientConfig{Name: "loadgen-report", Timeout: 10 * time.Second, RetryNonIdempotent: true})
+	resp, err := client.Post(reportURL, "application/json", bytes.NewReader(encoded))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+func logLoadgenStats(stats LoadGenStats) {
+	infof("Load generator: sent=%d errors=%d status=%v versions=%v", stats.Sent, stats.Errors, stats.StatusCodes, stats.Versions)
+	for endpoint, codes := range stats.ByEndpoint {
+		infof("  %s: %v", endpoint, codes)
+	}
+}
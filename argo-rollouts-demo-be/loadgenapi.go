@@ -0,0 +1,231 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// The load generator can also run inside the server, against its own
+// /api/check, so a demo doesn't need a k6 or hey pod:
+//
+//	curl -X POST .../api/loadgen/start -d '{"rps": 20, "concurrency": 4, "durationSeconds": 300}'
+//
+// Requests go to this pod only, not through the Service. Each finished run
+// is recorded like a scenario's, for POST /api/load/assert.
+var (
+	// Highest rate and concurrency accepted, so a typo can't flood the pod
+	loadgenAPIMaxRPS         = getEnvFloatOrDefault("LOADGEN_API_MAX_RPS", 1000)
+	loadgenAPIMaxConcurrency = int(getEnvFloatOrDefault("LOADGEN_API_MAX_CONCURRENCY", 100))
+)
+
+// LoadGenStart is the POST /api/loadgen/start payload. The rate follows
+// the pattern, a schedule of patterns or a recorded profile peaking at RPS,
+// so the graphs look like organic traffic during a long demo:
+//
+//	{"pattern": "sine", "rps": 40, "minRps": 10, "periodSeconds": 120}
+//	{"schedule": {"loop": true, "steps": [
+//	  {"pattern": "ramp", "rps": 30, "periodSeconds": 60, "durationSeconds": 60},
+//	  {"pattern": "spike", "rps": 80, "minRps": 30, "periodSeconds": 60, "spikeSeconds": 10, "durationSeconds": 300}]}}
+type LoadGenStart struct {
+	Path string `json:"path"` // On this server, /api/check when omitted
+	TrafficPattern
+	Schedule        *TrafficSchedule `json:"schedule,omitempty"`
+	Profile         string           `json:"profile,omitempty"`
+	Concurrency     int              `json:"concurrency"`     // 4 when omitted
+	DurationSeconds float64          `json:"durationSeconds"` // 0 runs until stopped
+}
+
+// LoadGenStatus is the generator's state and what it has seen so far.
+type LoadGenStatus struct {
+	Running     bool               `json:"running"`
+	Config      *LoadGenStart      `json:"config,omitempty"`
+	Rate        *LoadGenProfile    `json:"rate,omitempty"`
+	StartedAt   time.Time          `json:"startedAt,omitzero"`
+	FinishedAt  time.Time          `json:"finishedAt,omitzero"`
+	Stats       *LoadGenStats      `json:"stats,omitempty"`
+	StatusShare map[string]float64 `json:"statusShare,omitempty"` // Percentage of answered requests by status code
+}
+
+// apiLoadGen is the generator run through the API. It is kept after
+// finishing so its final stats stay visible until the next start.
+type apiLoadGen struct {
+	gen        *loadGenerator
+	config     LoadGenStart
+	startedAt  time.Time
+	finishedAt time.Time
+}
+
+var (
+	currentLoadGen   *apiLoadGen
+	currentLoadGenMu sync.Mutex
+	// Held while replacing the generator, so two starts can't both run
+	loadGenStartMu sync.Mutex
+)
+
+func (s LoadGenStart) validate() error {
+	if !strings.HasPrefix(s.Path, "/") {
+		return fmt.Errorf("path must start with /")
+	}
+	peak := s.RPS
+	if s.Schedule != nil {
+		if s.Pattern != "" || s.Profile != "" {
+			return fmt.Errorf("set one of pattern, schedule or profile")
+		}
+		if err := s.Schedule.validate(); err != nil {
+			return err
+		}
+		peak = s.Schedule.peakRPS()
+	} else {
+		if s.Pattern != "" && s.Profile != "" {
+			return fmt.Errorf("set one of pattern, schedule or profile")
+		}
+		if err := s.TrafficPattern.validate(); err != nil {
+			return err
+		}
+	}
+	if peak > loadgenAPIMaxRPS {
+		return fmt.Errorf("rps must be at most %g", loadgenAPIMaxRPS)
+	}
+	if s.Concurrency < 1 || s.Concurrency > loadgenAPIMaxConcurrency {
+		return fmt.Errorf("concurrency must be between 1 and %d", loadgenAPIMaxConcurrency)
+	}
+	if math.IsNaN(s.DurationSeconds) || math.IsInf(s.DurationSeconds, 0) || s.DurationSeconds < 0 {
+		return fmt.Errorf("durationSeconds must be a finite non-negative number")
+	}
+	return nil
+}
+
+// finish stops the run if it is still going and records it, once.
+func (l *apiLoadGen) finish() {
+	l.gen.Stop(context.Background())
+
+	currentLoadGenMu.Lock()
+	if !l.finishedAt.IsZero() {
+		currentLoadGenMu.Unlock()
+		return
+	}
+	l.finishedAt = time.Now().UTC()
+	currentLoadGenMu.Unlock()
+
+	stats := l.gen.Stats()
+	recordLoadRun(summarizeLoadRun("loadgen-api", l.gen.cfg.Target, l.startedAt, stats))
+	infof("Load generator finished: sent=%d errors=%d status=%v", stats.Sent, stats.Errors, stats.StatusCodes)
+}
+
+func (l *apiLoadGen) status() LoadGenStatus {
+	stats := l.gen.Stats()
+	rate := l.gen.Profile()
+	status := LoadGenStatus{
+		Running:     l.finishedAt.IsZero(),
+		Config:      &l.config,
+		Rate:        &rate,
+		StartedAt:   l.startedAt,
+		FinishedAt:  l.finishedAt,
+		Stats:       &stats,
+		StatusShare: map[string]float64{},
+	}
+	answered := stats.Sent - stats.Errors
+	for code, n := range stats.StatusCodes {
+		status.StatusShare[code] = float64(n) / float64(answered) * 100.0
+	}
+	return status
+}
+
+func loadGenStatus() LoadGenStatus {
+	currentLoadGenMu.Lock()
+	defer currentLoadGenMu.Unlock()
+	if currentLoadGen == nil {
+		return LoadGenStatus{}
+	}
+	return currentLoadGen.status()
+}
+
+// stopLoadGen stops the running generator and reports whether there was one.
+func stopLoadGen() bool {
+	currentLoadGenMu.Lock()
+	current := currentLoadGen
+	running := current != nil && current.finishedAt.IsZero()
+	currentLoadGenMu.Unlock()
+
+	if running {
+		current.finish()
+	}
+	return running
+}
+
+// shutdownLoadGen stops the generator before the server it sends to.
+func shutdownLoadGen(context.Context) error {
+	stopLoadGen()
+	return nil
+}
+
+// startLoadGenHandler starts the generator, replacing a running one.
+func startLoadGenHandler(c echo.Context) error {
+	start := LoadGenStart{Path: "/api/check", Concurrency: 4}
+	if err := json.NewDecoder(c.Request().Body).Decode(&start); err != nil {
+		httpRequestsTotal.WithLabelValues("/api/loadgen/start", fmt.Sprintf("%d", http.StatusBadRequest)).Inc()
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid JSON"})
+	}
+	if err := start.validate(); err != nil {
+		httpRequestsTotal.WithLabelValues("/api/loadgen/start", fmt.Sprintf("%d", http.StatusBadRequest)).Inc()
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+
+	schedule := start.Schedule
+	if schedule == nil && start.name() != trafficPatternConstant {
+		// The last step of a schedule plays on, so one step of any length
+		// keeps the pattern going for the whole run
+		schedule = &TrafficSchedule{Steps: []TrafficScheduleStep{{TrafficPattern: start.TrafficPattern, DurationSeconds: 1}}}
+	}
+	gen, err := newLoadGenerator(LoadGenConfig{
+		Target:      localURL(start.Path),
+		RPS:         start.RPS,
+		Concurrency: start.Concurrency,
+		Duration:    time.Duration(start.DurationSeconds * float64(time.Second)),
+		Profile:     start.Profile,
+		Schedule:    schedule,
+	})
+	if err != nil {
+		httpRequestsTotal.WithLabelValues("/api/loadgen/start", fmt.Sprintf("%d", http.StatusBadRequest)).Inc()
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+
+	loadGenStartMu.Lock()
+	defer loadGenStartMu.Unlock()
+	stopLoadGen()
+	run := &apiLoadGen{gen: gen, config: start, startedAt: time.Now().UTC()}
+	gen.Start(context.Background())
+	currentLoadGenMu.Lock()
+	currentLoadGen = run
+	currentLoadGenMu.Unlock()
+	go func() {
+		<-gen.Done()
+		run.finish()
+	}()
+	infof("Load generator started against %s at %g peak rps, concurrency %d", start.Path, gen.peakRPS, start.Concurrency)
+
+	httpRequestsTotal.WithLabelValues("/api/loadgen/start", fmt.Sprintf("%d", http.StatusOK)).Inc()
+	return c.JSON(http.StatusOK, loadGenStatus())
+}
+
+func stopLoadGenHandler(c echo.Context) error {
+	if !stopLoadGen() {
+		httpRequestsTotal.WithLabelValues("/api/loadgen/stop", fmt.Sprintf("%d", http.StatusNotFound)).Inc()
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Load generator not running"})
+	}
+	httpRequestsTotal.WithLabelValues("/api/loadgen/stop", fmt.Sprintf("%d", http.StatusOK)).Inc()
+	return c.JSON(http.StatusOK, loadGenStatus())
+}
+
+func loadGenStatusHandler(c echo.Context) error {
+	httpRequestsTotal.WithLabelValues("/api/loadgen/status", fmt.Sprintf("%d", http.StatusOK)).Inc()
+	return c.JSON(http.StatusOK, loadGenStatus())
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/redis/go-redis/v9"
+)
+
+const latestLoadRunKey = "load:latest"
+
+// LoadRun is the summary of a finished load generator run, whether started
+// by a scenario or /api/loadgen on this server or reported by a standalone
+// generator Job.
+type LoadRun struct {
+	Source      string           `json:"source"` // "scenario", "loadgen" or "loadgen-api"
+	Target      string           `json:"target"`
+	StartedAt   time.Time        `json:"startedAt"`
+	FinishedAt  time.Time        `json:"finishedAt"`
+	Sent        int64            `json:"sent"`
+	Failures    int64            `json:"failures"`  // Transport errors and 5xx responses
+	ErrorRate   float64          `json:"errorRate"` // Percentage of Sent
+	P50         float64          `json:"p50"`       // Milliseconds
+	P95         float64          `json:"p95"`
+	P99         float64          `json:"p99"`
+	StatusCodes map[string]int64 `json:"statusCodes"`
+	Versions    map[string]int64 `json:"versions"`
+}
+
+// LoadAssertion holds the thresholds for POST /api/load/assert. Unset
+// thresholds aren't checked.
+type LoadAssertion struct {
+	MaxErrorPercent *float64 `json:"maxErrorPercent"`
+	MaxP95Ms        *float64 `json:"maxP95Ms"`
+	MinRequests     int64    `json:"minRequests"`
+	MaxAgeSeconds   float64  `json:"maxAgeSeconds"` // Reject runs that finished longer ago, 0 for any
+}
+
+type LoadAssertionCheck struct {
+	Name      string  `json:"name"`
+	Threshold float64 `json:"threshold"`
+	Actual    float64 `json:"actual"`
+	Passed    bool    `json:"passed"`
+}
+
+type LoadAssertionResult struct {
+	Passed bool                 `json:"passed"`
+	Checks []LoadAssertionCheck `json:"checks"`
+	Run    LoadRun              `json:"run"`
+}
+
+var (
+	// Latest run when Redis isn't available
+	localLoadRun   *LoadRun
+	localLoadRunMu sync.Mutex
+)
+
+// summarizeLoadRun turns a generator's final stats into a LoadRun.
+func summarizeLoadRun(source, target string, startedAt time.Time, stats LoadGenStats) LoadRun {
+	run := LoadRun{
+		Source:      source,
+		Target:      target,
+		StartedAt:   startedAt,
+		FinishedAt:  time.Now().UTC(),
+		Sent:        stats.Sent,
+		Failures:    stats.Errors,
+		P50:         histogramQuantile(stats.LatencyBuckets, 0.50),
+		P95:         histogramQuantile(stats.LatencyBuckets, 0.95),
+		P99:         histogramQuantile(stats.LatencyBuckets, 0.99),
+		StatusCodes: stats.StatusCodes,
+		Versions:    stats.Versions,
+	}
+	for status, n := range stats.StatusCodes {
+		if code, err := strconv.Atoi(status); err == nil && code >= 500 {
+			run.Failures += n
+		}
+	}
+	if run.Sent > 0 {
+		run.ErrorRate = float64(run.Failures) / float64(run.Sent) * 100.0
+	}
+	return run
+}
+
+// recordLoadRun makes run the latest one, shared through Redis so any pod
+// can evaluate it.
+func recordLoadRun(run LoadRun) {
+	localLoadRunMu.Lock()
+	localLoadRun = &run
+	localLoadRunMu.Unlock()
+
+	if redisClient == nil {
+		return
+	}
+	encoded, err := json.Marshal(run)
+	if err != nil {
+		return
+	}
+	if err := redisClient.Set(redisCtx, latestLoadRunKey, encoded, 0).Err(); err != nil {
+		warnf("Failed to store load run: %v", err)
+	}
+}
+
+func latestLoadRun() (LoadRun, bool, error) {
+	if redisClient == nil {
+		localLoadRunMu.Lock()
+		defer localLoadRunMu.Unlock()
+		if localLoadRun == nil {
+			return LoadRun{}, false, nil
+		}
+		return *localLoadRun, true, nil
+	}
+
+	encoded, err := redisClient.Get(redisCtx, latestLoadRunKey).Bytes()
+	if err == redis.Nil {
+		return LoadRun{}, false, nil
+	}
+	if err != nil {
+		return LoadRun{}, false, err
+	}
+	var run LoadRun
+	if err := json.Unmarshal(encoded, &run); err != nil {
+		return LoadRun{}, false, err
+	}
+	return run, true, nil
+}
+
+func evaluateLoadAssertion(run LoadRun, assertion LoadAssertion) LoadAssertionResult {
+	result := LoadAssertionResult{Passed: true, Checks: []LoadAssertionCheck{}, Run: run}
+	check := func(name string, threshold, actual float64, passed bool) {
+		result.Checks = append(result.Checks, LoadAssertionCheck{Name: name, Threshold: threshold, Actual: actual, Passed: passed})
+		result.Passed = result.Passed && passed
+	}
+
+	if assertion.MaxAgeSeconds > 0 {
+		age := time.Since(run.FinishedAt).Seconds()
+		check("maxAgeSeconds", assertion.MaxAgeSeconds, age, age <= assertion.MaxAgeSeconds)
+	}
+	if assertion.MinRequests > 0 {
+		check("minRequests", float64(assertion.MinRequests), float64(run.Sent), run.Sent >= assertion.MinRequests)
+	}
+	if assertion.MaxErrorPercent != nil {
+		check("maxErrorPercent", *assertion.MaxErrorPercent, run.ErrorRate, run.ErrorRate <= *assertion.MaxErrorPercent)
+	}
+	if assertion.MaxP95Ms != nil {
+		check("maxP95Ms", *assertion.MaxP95Ms, run.P95, run.P95 <= *assertion.MaxP95Ms)
+	}
+	return result
+}
+
+// assertLoadHandler evaluates the latest load run against the thresholds.
+// A failed assertion answers 412 so that `curl --fail` as the last step of
+// a Job fails the Job, and with it the analysis.
+func assertLoadHandler(c echo.Context) error {
+	var assertion LoadAssertion
+	if err := json.NewDecoder(c.Request().Body).Decode(&assertion); err != nil {
+		httpRequestsTotal.WithLabelValues("/api/load/assert", fmt.Sprintf("%d", http.StatusBadRequest)).Inc()
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid JSON"})
+	}
+	if (assertion.MaxErrorPercent != nil && *assertion.MaxErrorPercent < 0) ||
+		(assertion.MaxP95Ms != nil && *assertion.MaxP95Ms < 0) ||
+		assertion.MinRequests < 0 || assertion.MaxAgeSeconds < 0 {
+		httpRequestsTotal.WithLabelValues("/api/load/assert", fmt.Sprintf("%d", http.StatusBadRequest)).Inc()
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Thresholds must not be negative"})
+	}
+
+	run, ok, err := latestLoadRun()
+	if err != nil {
+		warnf("Failed to load latest load run: %v", err)
+		httpRequestsTotal.WithLabelValues("/api/load/assert", fmt.Sprintf("%d", http.StatusInternalServerError)).Inc()
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to load the latest load run"})
+	}
+	if !ok {
+		httpRequestsTotal.WithLabelValues("/api/load/assert", fmt.Sprintf("%d", http.StatusNotFound)).Inc()
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "No load run recorded"})
+	}
+
+	result := evaluateLoadAssertion(run, assertion)
+	statusCode := http.StatusOK
+	if !result.Passed {
+		statusCode = http.StatusPreconditionFailed
+	}
+	httpRequestsTotal.WithLabelValues("/api/load/assert", fmt.Sprintf("%d", statusCode)).Inc()
+	return c.JSON(statusCode, result)
+}
+
+func latestLoadRunHandler(c echo.Context) error {
+	run, ok, err := latestLoadRun()
+	if err != nil {
+		httpRequestsTotal.WithLabelValues("/api/load/runs/latest", fmt.Sprintf("%d", http.StatusInternalServerError)).Inc()
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to load the latest load run"})
+	}
+	if !ok {
+		httpRequestsTotal.WithLabelValues("/api/load/runs/latest", fmt.Sprintf("%d", http.StatusNotFound)).Inc()
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "No load run recorded"})
+	}
+	httpRequestsTotal.WithLabelValues("/api/load/runs/latest", fmt.Sprintf("%d", http.StatusOK)).Inc()
+	return c.JSON(http.StatusOK, run)
+}
+
+// reportLoadRunHandler records a run reported by a standalone load
+// generator (LOADGEN_REPORT_URL).
+func reportLoadRunHandler(c echo.Context) error {
+	var run LoadRun
+	if err := json.NewDecoder(c.Request().Body).Decode(&run); err != nil {
+		httpRequestsTotal.WithLabelValues("/api/load/runs", fmt.Sprintf("%d", http.StatusBadRequest)).Inc()
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid JSON"})
+	}
+	if run.Sent < 0 || run.Failures < 0 || run.Failures > run.Sent || run.FinishedAt.IsZero() {
+		httpRequestsTotal.WithLabelValues("/api/load/runs", fmt.Sprintf("%d", http.StatusBadRequest)).Inc()
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Run must have finishedAt and 0 <= failures <= sent"})
+	}
+	if run.Source == "" {
+		run.Source = "loadgen"
+	}
+	if run.Sent > 0 {
+		run.ErrorRate = float64(run.Failures) / float64(run.Sent) * 100.0
+	}
+
+	recordLoadRun(run)
+	httpRequestsTotal.WithLabelValues("/api/load/runs", fmt.Sprintf("%d", http.StatusCreated)).Inc()
+	return c.JSON(http.StatusCreated, run)
+}
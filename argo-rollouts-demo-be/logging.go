@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
+	"github.com/redis/go-redis/v9"
+)
+
+// Logs are JSON lines on stderr, one object per entry with time, level,
+// msg, version and pod, so Loki or ELK can filter a rollout's logs by
+// version. LOG_FORMAT=text switches to logfmt for reading locally.
+// LOG_LEVEL (debug, info, warn, error) sets the threshold; DEBUG=true is
+// kept as a shorthand for debug, and SIGUSR2 toggles debug at runtime.
+var (
+	logLevel      = new(slog.LevelVar)
+	baseLogLevel  slog.Level // LOG_LEVEL, what toggling debug off returns to
+	configureOnce sync.Once
+	defaultLogger *slog.Logger
+)
+
+// logger returns the configured logger. It is set up on first use rather
+// than in init, since package variables log while being initialized.
+func logger() *slog.Logger {
+	configureOnce.Do(configureLogging)
+	return defaultLogger
+}
+
+func configureLogging() {
+	level, err := parseLogLevel(getEnvOrDefault("LOG_LEVEL", "info"))
+	if isTruthy(getEnvOrDefault("DEBUG", "false")) {
+		level = slog.LevelDebug
+	}
+	baseLogLevel = level
+	logLevel.Set(level)
+
+	out := &redactingWriter{out: os.Stderr}
+	opts := &slog.HandlerOptions{Level: logLevel}
+	var handler slog.Handler = slog.NewJSONHandler(out, opts)
+	if strings.EqualFold(getEnvOrDefault("LOG_FORMAT", "json"), "text") {
+		handler = slog.NewTextHandler(out, opts)
+	}
+	defaultLogger = slog.New(requestContextHandler{handler}).With("version", version, "pod", podName)
+	// The standard logger, used by net/http, and the Redis client's logger
+	// go through the same handler
+	slog.SetDefault(defaultLogger)
+	redis.SetLogger(redisLogger{})
+
+	if err != nil {
+		defaultLogger.Warn(err.Error())
+	}
+}
+
+// redisLogger takes the Redis client's own messages, e.g. failed
+// reconnects.
+type redisLogger struct{}
+
+func (redisLogger) Printf(ctx context.Context, format string, args ...interface{}) {
+	warnf(format, args...)
+}
+
+func parseLogLevel(value string) (slog.Level, error) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(value)); err != nil {
+		return slog.LevelInfo, fmt.Errorf("invalid LOG_LEVEL %q, using info", value)
+	}
+	return level, nil
+}
+
+func logf(ctx context.Context, level slog.Level, format string, args ...interface{}) {
+	l := logger()
+	if !l.Enabled(ctx, level) {
+		return
+	}
+	l.Log(ctx, level, fmt.Sprintf(format, args...))
+}
+
+// debugf writes the extra per-request lines that are only wanted while
+// investigating.
+func debugf(format string, args ...interface{}) {
+	logf(context.Background(), slog.LevelDebug, format, args...)
+}
+
+// debugContextf is debugf for a request's context, tagging the line with
+// the request ID.
+func debugContextf(ctx context.Context, format string, args ...interface{}) {
+	logf(ctx, slog.LevelDebug, format, args...)
+}
+
+func infof(format string, args ...interface{}) {
+	logf(context.Background(), slog.LevelInfo, format, args...)
+}
+
+func warnf(format string, args ...interface{}) {
+	logf(context.Background(), slog.LevelWarn, format, args...)
+}
+
+func errorf(format string, args ...interface{}) {
+	logf(context.Background(), slog.LevelError, format, args...)
+}
+
+// fatalf logs at error level and exits, like log.Fatalf.
+func fatalf(format string, args ...interface{}) {
+	errorf(format, args...)
+	os.Exit(1)
+}
+
+func debugEnabled() bool {
+	return logger().Enabled(context.Background(), slog.LevelDebug)
+}
+
+// toggleDebugLogging switches between debug and LOG_LEVEL.
+func toggleDebugLogging() {
+	enabled := !debugEnabled()
+	if enabled {
+		logLevel.Set(slog.LevelDebug)
+	} else {
+		logLevel.Set(max(baseLogLevel, slog.LevelInfo))
+	}
+	infof("Debug logging enabled: %t", enabled)
+}
+
+// requestLogMiddleware writes one access log entry per request. It takes
+// the place of Echo's logger middleware ("logger" in MIDDLEWARE_ORDER) so
+// access logs are structured like everything else. Server errors are
+// logged at warn.
+func requestLogMiddleware(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		start := time.Now()
+		err := next(c)
+		if err != nil {
+			// Write the error response now so its status is the one logged
+			c.Error(err)
+		}
+
+		req, res := c.Request(), c.Response()
+		level := slog.LevelInfo
+		if res.Status >= 500 {
+			level = slog.LevelWarn
+		}
+		attrs := []slog.Attr{
+			slog.String("method", req.Method),
+			slog.String("route", routeLabel(c)),
+			slog.String("uri", req.RequestURI),
+			slog.Int("status", res.Status),
+			slog.Float64("latency_ms", float64(time.Since(start).Microseconds())/1000.0),
+			slog.Int64("bytes_out", res.Size),
+			slog.String("remote_ip", c.RealIP()),
+		}
+		if err != nil {
+			attrs = append(attrs, slog.String("error", err.Error()))
+		}
+		logger().LogAttrs(req.Context(), level, "request", attrs...)
+		return err
+	}
+}
+
+// recoverMiddleware is Echo's recover middleware logging the panic through
+// slog instead of Echo's own logger.
+var recoverMiddleware = middleware.RecoverWithConfig(middleware.RecoverConfig{
+	LogErrorFunc: func(c echo.Context, err error, stack []byte) error {
+		logger().ErrorContext(c.Request().Context(), "Recovered from panic",
+			"route", routeLabel(c), "error", err.Error(), "stack", string(stack))
+		return err
+	},
+})
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+type metricsSnapshot struct {
+	counts    map[string]float64
+	fetchedAt time.Time
+}
+
+var (
+	// How long /api/metrics waits for Redis before serving the last snapshot
+	metricsReadTimeout = time.Duration(getEnvFloatOrDefault("METRICS_READ_TIMEOUT_MS", 250) * float64(time.Millisecond))
+
+	lastMetrics         metricsSnapshot
+	lastMetricsMu       sync.RWMutex
+	metricsRevalidating atomic.Bool
+
+	metricsStaleResponsesTotal = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: "metrics_stale_responses_total",
+			Help: "Number of /api/metrics responses served from the last snapshot because Redis was slow or unavailable",
+		},
+	)
+)
+
+func storeMetricsSnapshot(counts map[string]float64) {
+	lastMetricsMu.Lock()
+	lastMetrics = metricsSnapshot{counts: counts, fetchedAt: time.Now()}
+	lastMetricsMu.Unlock()
+}
+
+// metricsStatusCounts is getStatusCounts with stale-while-revalidate: when
+// the counter store read fails or takes longer than METRICS_READ_TIMEOUT_MS,
+// the last good snapshot is returned (stale=true) and refreshed in the
+// background, instead of zeros that make the graphs drop to the floor. With
+// the store failing closed the error is returned instead. The source is the
+// store's name or "prometheus", whichever the counts came from.
+func metricsStatusCounts(ctx context.Context) (map[string]float64, string, bool, error) {
+	err := simulatedOutage(failFeatureStore)
+	if err == nil {
+		var counts map[string]float64
+		readCtx, cancel := context.WithTimeout(ctx, metricsReadTimeout)
+		counts, err = storeStatusCounts(readCtx)
+		cancel()
+		if err == nil {
+			if len(counts) == 0 {
+				return localStatusCounts(), countSourcePrometheus, false, nil
+			}
+			storeMetricsSnapshot(counts)
+			return counts, counterStore.Name(), false, nil
+		}
+	}
+	if err := dependencyFailure(failFeatureStore, err); err != nil {
+		return nil, "", false, err
+	}
+
+	lastMetricsMu.RLock()
+	snapshot := lastMetrics
+	lastMetricsMu.RUnlock()
+	if snapshot.fetchedAt.IsZero() {
+		return localStatusCounts(), countSourcePrometheus, false, nil
+	}
+
+	metricsStaleResponsesTotal.Inc()
+	revalidateMetrics()
+	return snapshot.counts, counterStore.Name(), true, nil
+}
+
+// revalidateMetrics refreshes the snapshot with the client's full timeouts.
+// Only one refresh runs at a time.
+func revalidateMetrics() {
+	if !metricsRevalidating.CompareAndSwap(false, true) {
+		return
+	}
+	go func() {
+		defer metricsRevalidating.Store(false)
+		counts, err := storeStatusCounts(redisCtx)
+		if err != nil {
+			debugf("metrics: background refresh failed: %v", err)
+			return
+		}
+		storeMetricsSnapshot(counts)
+	}()
+}
@@ -0,0 +1,121 @@
+//go:build !stable
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// MetricsStreamEvent is pushed to /api/metrics/stream clients on every tick.
+type MetricsStreamEvent struct {
+	Timestamp         time.Time          `json:"timestamp"`
+	Counts            map[string]float64 `json:"counts"`            // Same as /api/metrics
+	ErrorRate         float64            `json:"errorRate"`         // Configured percentage
+	ObservedErrorRate float64            `json:"observedErrorRate"` // Percentage of checks that failed so far
+	Stale             bool               `json:"stale"`
+}
+
+var (
+	metricsStreamInterval = time.Duration(getEnvFloatOrDefault("METRICS_STREAM_INTERVAL_MS", 1000) * float64(time.Millisecond))
+
+	metricsStreamHub = newSSEHub()
+
+	// The publisher only runs while somebody is listening
+	metricsStreamClients int
+	metricsStreamStop    chan struct{}
+	metricsStreamMu      sync.Mutex
+)
+
+// joinMetricsStream registers a client, starting the publisher for the
+// first one. The returned function unregisters it and stops the publisher
+// after the last one leaves.
+func joinMetricsStream() func() {
+	metricsStreamMu.Lock()
+	defer metricsStreamMu.Unlock()
+
+	metricsStreamClients++
+	if metricsStreamClients == 1 {
+		metricsStreamStop = make(chan struct{})
+		go runMetricsStream(metricsStreamStop)
+	}
+	return func() {
+		metricsStreamMu.Lock()
+		defer metricsStreamMu.Unlock()
+		metricsStreamClients--
+		if metricsStreamClients == 0 {
+			close(metricsStreamStop)
+		}
+	}
+}
+
+// runMetricsStream reads the counts once per tick for all clients, so the
+// Redis load doesn't grow with the number of open dashboards.
+func runMetricsStream(stop <-chan struct{}) {
+	interval := metricsStreamInterval
+	if interval <= 0 {
+		interval = time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-backgroundStop:
+			return
+		case <-ticker.C:
+			publishMetricsEvent()
+		}
+	}
+}
+
+// currentMetricsEvent reads the counts the way /api/metrics does.
+func currentMetricsEvent() (MetricsStreamEvent, error) {
+	counts, _, stale, err := metricsStatusCounts(redisCtx)
+	if err != nil {
+		return MetricsStreamEvent{}, err
+	}
+
+	event := MetricsStreamEvent{
+		Timestamp: time.Now().UTC(),
+		Counts:    withBaseStatuses(counts),
+		ErrorRate: getErrorRatePercent(),
+		Stale:     stale,
+	}
+	if folded := statusCountsOf(counts); folded.Status200+folded.Status500 > 0 {
+		event.ObservedErrorRate = folded.Status500 / (folded.Status200 + folded.Status500) * 100.0
+	}
+	return event, nil
+}
+
+func publishMetricsEvent() {
+	event, err := currentMetricsEvent()
+	if err != nil {
+		debugf("metrics stream: skipping tick: %v", err)
+		return
+	}
+	encoded, err := json.Marshal(event)
+	if err != nil {
+		warnf("Failed to encode metrics event: %v", err)
+		return
+	}
+	metricsStreamHub.publish("metrics", encoded)
+}
+
+// metricsStreamHandler pushes the /api/check counts and error rate every
+// METRICS_STREAM_INTERVAL_MS as "metrics" events, so live charts don't need
+// to poll /api/metrics.
+func metricsStreamHandler(c echo.Context) error {
+	leave := joinMetricsStream()
+	defer leave()
+
+	httpRequestsTotal.WithLabelValues("/api/metrics/stream", fmt.Sprintf("%d", http.StatusOK)).Inc()
+	return serveSSE(c, metricsStreamHub)
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"sync/atomic"
+
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
+)
+
+var (
+	// Named middleware available to the pipeline. Order is decided by the
+	// config file or MIDDLEWARE_ORDER, not by this map.
+	middlewareRegistry = map[string]echo.MiddlewareFunc{
+		"tracing":   tracingMiddleware,
+		"logger":    requestLogMiddleware,
+		"recover":   recoverMiddleware,
+		"cors":      corsMiddleware,
+		"sessions":  sessionsMiddleware,
+		"faults":    faultsMiddleware,
+		"quota":     quotaMiddleware,
+		"ratelimit": rateLimitMiddleware,
+	}
+
+	defaultMiddlewareOrder = []string{"tracing", "logger", "recover", "cors", "ratelimit", "sessions", "faults", "quota"}
+
+	// Resolved pipeline, exposed via /api/middleware
+	activeMiddlewareOrder []string
+)
+
+// middlewareOrder resolves the pipeline order: MIDDLEWARE_ORDER env var,
+// then the config file, then the built-in default.
+func middlewareOrder() []string {
+	if value := getEnvOrDefault("MIDDLEWARE_ORDER", ""); value != "" {
+		return splitList(value)
+	}
+	if cfg := currentConfig(); len(cfg.Middleware) > 0 {
+		return cfg.Middleware
+	}
+	return defaultMiddlewareOrder
+}
+
+// corsHandler is the CORS middleware for the current allowed origins,
+// swapped by setCORSOrigins when the config file is reloaded.
+var corsHandler atomic.Pointer[echo.MiddlewareFunc]
+
+func init() {
+	setCORSOrigins(splitList(getEnvOrDefault("CORS_ORIGINS", "")))
+}
+
+// setCORSOrigins allows the given origins, or any origin when empty.
+func setCORSOrigins(origins []string) {
+	if len(origins) == 0 {
+		origins = []string{"*"}
+	}
+	handler := middleware.CORSWithConfig(middleware.CORSConfig{
+		AllowOrigins:     origins,
+		AllowMethods:     []string{http.MethodGet, http.MethodPost, http.MethodPut, http.MethodDelete},
+		AllowHeaders:     []string{"*"},
+		ExposeHeaders:    []string{"X-Version", "X-Code-Path", "X-Trace-Id", "X-Request-ID", "Authorization", "Content-Length", "ETag", "X-Stale", "X-Chaos-Bypass", "X-Session-Namespace", "Server-Timing"},
+		AllowCredentials: true,
+	})
+	corsHandler.Store(&handler)
+}
+
+func corsMiddleware(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		return (*corsHandler.Load())(next)(c)
+	}
+}
+
+func buildMiddlewarePipeline(order []string) ([]echo.MiddlewareFunc, error) {
+	seen := map[string]bool{}
+	pipeline := make([]echo.MiddlewareFunc, 0, len(order))
+
+	for _, name := range order {
+		mw, ok := middlewareRegistry[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown middleware %q", name)
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("middleware %q listed more than once", name)
+		}
+		seen[name] = true
+		pipeline = append(pipeline, mw)
+	}
+
+	return pipeline, nil
+}
+
+func getMiddlewareHandler(c echo.Context) error {
+	available := make([]string, 0, len(middlewareRegistry))
+	for name := range middlewareRegistry {
+		available = append(available, name)
+	}
+	sort.Strings(available)
+
+	httpRequestsTotal.WithLabelValues("/api/middleware", fmt.Sprintf("%d", http.StatusOK)).Inc()
+	return c.JSON(http.StatusOK, map[string][]string{
+		"active":    activeMiddlewareOrder,
+		"available": available,
+	})
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	migrationReadPrimary   = "primary"
+	migrationReadSecondary = "secondary"
+)
+
+// Storage migration double-writes the /api/check counters to a second Redis
+// and periodically compares both, so a storage move can be rolled out (and
+// watched) the same way as an application version. Reads stay on the
+// primary until MIGRATION_READ_FROM=secondary flips them over.
+var (
+	migrationAddr            = getEnvOrDefault("MIGRATION_REDIS_ADDR", "")
+	migrationReadFrom        = getEnvOrDefault("MIGRATION_READ_FROM", migrationReadPrimary)
+	migrationCompareInterval = time.Duration(getEnvFloatOrDefault("MIGRATION_COMPARE_SECONDS", 5) * float64(time.Second))
+	migrationClient          *redis.Client
+
+	migrationMu         sync.Mutex
+	migrationLastDiff   = map[string]float64{}
+	migrationComparedAt time.Time
+
+	migrationWriteErrorsTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "storage_migration_write_errors_total",
+			Help: "Failed counter writes during storage migration by backend",
+		},
+		[]string{"backend"},
+	)
+	migrationComparisonsTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "storage_migration_comparisons_total",
+			Help: "Read-compare results between the primary and secondary store",
+		},
+		[]string{"result"},
+	)
+	migrationDivergence = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "storage_migration_divergence",
+			Help: "Absolute difference between the primary and secondary store per counter at the last comparison",
+		},
+		[]string{"counter"},
+	)
+)
+
+// startStorageMigration connects the secondary store and backfills the
+// counters it doesn't have yet, so comparisons start from the same baseline.
+func startStorageMigration() error {
+	if migrationAddr == "" || redisClient == nil {
+		return nil
+	}
+	if migrationReadFrom != migrationReadPrimary && migrationReadFrom != migrationReadSecondary {
+		return fmt.Errorf("MIGRATION_READ_FROM must be %s or %s", migrationReadPrimary, migrationReadSecondary)
+	}
+
+	client := redis.NewClient(&redis.Options{
+		Addr:         migrationAddr,
+		DialTimeout:  5 * time.Second,
+		ReadTimeout:  3 * time.Second,
+		WriteTimeout: 3 * time.Second,
+	})
+	if tracingSubsystem.enabled {
+		client.AddHook(redisTraceHook{})
+	}
+	client.AddHook(redisMetricsHook{store: "secondary"})
+	client.AddHook(redisRequestIDHook{})
+	if err := client.Ping(redisCtx).Err(); err != nil {
+		client.Close()
+		return err
+	}
+
+	codes, err := readStatusCodes(redisCtx, redisClient)
+	if err != nil {
+		client.Close()
+		return fmt.Errorf("reading %s from primary: %w", statusCodesKey, err)
+	}
+	for _, code := range codes {
+		key := statusKey(code, version)
+		value, err := redisClient.Get(redisCtx, key).Int64()
+		if err == redis.Nil {
+			continue
+		} else if err != nil {
+			client.Close()
+			return fmt.Errorf("reading %s from primary: %w", key, err)
+		}
+		if err := client.SetNX(redisCtx, key, value, 0).Err(); err != nil {
+			client.Close()
+			return fmt.Errorf("backfilling %s: %w", key, err)
+		}
+	}
+	if err := client.SAdd(redisCtx, statusVersionsKey, version).Err(); err != nil {
+		client.Close()
+		return fmt.Errorf("backfilling %s: %w", statusVersionsKey, err)
+	}
+	if err := client.SAdd(redisCtx, statusCodesKey, codes).Err(); err != nil {
+		client.Close()
+		return fmt.Errorf("backfilling %s: %w", statusCodesKey, err)
+	}
+
+	migrationClient = client
+	infof("Storage migration enabled - double-writing to %s, reading from %s", migrationAddr, migrationReadFrom)
+	return nil
+}
+
+// counterReadClient is the store /api/metrics reads from.
+func counterReadClient() redis.UniversalClient {
+	if migrationClient != nil && migrationReadFrom == migrationReadSecondary {
+		return migrationClient
+	}
+	return redisClient
+}
+
+// mirrorCheckCounts applies the same increments to the secondary store.
+func mirrorCheckCounts(ctx context.Context, counts map[int]int64) {
+	if migrationClient == nil {
+		return
+	}
+	pipe := migrationClient.Pipeline()
+	for statusCode, n := range counts {
+		pipe.IncrBy(ctx, statusKey(fmt.Sprintf("%d", statusCode), version), n)
+		pipe.SAdd(ctx, statusCodesKey, fmt.Sprintf("%d", statusCode))
+	}
+	pipe.SAdd(ctx, statusVersionsKey, version)
+	if _, err := pipe.Exec(ctx); err != nil {
+		migrationWriteErrorsTotal.WithLabelValues("secondary").Inc()
+	}
+}
+
+// mirrorSetAdd adds member to one of the counter sets (versions, status
+// codes) in the secondary store.
+func mirrorSetAdd(key, member string) {
+	if migrationClient == nil {
+		return
+	}
+	if err := migrationClient.SAdd(redisCtx, key, member).Err(); err != nil {
+		migrationWriteErrorsTotal.WithLabelValues("secondary").Inc()
+		warnf("Failed to update %s in secondary store: %v", key, err)
+	}
+}
+
+// mirrorCounterSet overwrites a counter in the secondary store, used by
+// reset and state import so both stores stay comparable.
+func mirrorCounterSet(key string, value int64) {
+	if migrationClient == nil {
+		return
+	}
+	var err error
+	if value == 0 {
+		err = migrationClient.Del(redisCtx, key).Err()
+	} else {
+		err = migrationClient.Set(redisCtx, key, value, 0).Err()
+	}
+	if err != nil {
+		migrationWriteErrorsTotal.WithLabelValues("secondary").Inc()
+		warnf("Failed to update %s in secondary store: %v", key, err)
+	}
+}
+
+func compareStores() {
+	diffs := map[string]float64{}
+	matched := true
+	codes, err := readStatusCodes(redisCtx, redisClient)
+	if err != nil {
+		migrationComparisonsTotal.WithLabelValues("error").Inc()
+		return
+	}
+	for _, code := range codes {
+		key := statusKey(code, version)
+		primary, err := redisClient.Get(redisCtx, key).Float64()
+		if err != nil && err != redis.Nil {
+			migrationComparisonsTotal.WithLabelValues("error").Inc()
+			return
+		}
+		secondary, err := migrationClient.Get(redisCtx, key).Float64()
+		if err != nil && err != redis.Nil {
+			migrationComparisonsTotal.WithLabelValues("error").Inc()
+			return
+		}
+		diffs[key] = math.Abs(primary - secondary)
+		if diffs[key] != 0 {
+			matched = false
+		}
+	}
+
+	for key, diff := range diffs {
+		migrationDivergence.WithLabelValues(key).Set(diff)
+	}
+	if matched {
+		migrationComparisonsTotal.WithLabelValues("match").Inc()
+	} else {
+		migrationComparisonsTotal.WithLabelValues("mismatch").Inc()
+	}
+
+	migrationMu.Lock()
+	migrationLastDiff = diffs
+	migrationComparedAt = time.Now().UTC()
+	migrationMu.Unlock()
+}
+
+func runStorageMigrationCompare(stop <-chan struct{}) {
+	ticker := time.NewTicker(migrationCompareInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			compareStores()
+		}
+	}
+}
+
+func getMigrationHandler(c echo.Context) error {
+	response := map[string]interface{}{
+		"enabled": migrationClient != nil,
+	}
+	if migrationClient != nil {
+		migrationMu.Lock()
+		diffs := map[string]float64{}
+		for k, v := range migrationLastDiff {
+			diffs[k] = v
+		}
+		response["comparedAt"] = migrationComparedAt
+		migrationMu.Unlock()
+
+		response["secondary"] = migrationAddr
+		response["readFrom"] = migrationReadFrom
+		response["divergence"] = diffs
+	}
+
+	httpRequestsTotal.WithLabelValues("/api/migration", fmt.Sprintf("%d", http.StatusOK)).Inc()
+	return c.JSON(http.StatusOK, response)
+}
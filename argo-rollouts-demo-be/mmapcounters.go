@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"sync/atomic"
+	"unsafe"
+)
+
+// Layout of the shared counter segment, so a sidecar can read it without
+// talking to this process:
+//
+//	offset 0:  8-byte magic "ARDMCNT1"
+//	offset 8:  600 little-endian uint64 slots, one per HTTP status code
+//	           (slot N holds the /api/check count for status N)
+const (
+	mmapCountersMagic = "ARDMCNT1"
+	mmapCountersSlots = 600
+	mmapCountersSize  = 8 + mmapCountersSlots*8
+)
+
+type mmapCounters struct {
+	data  []byte
+	unmap func() error
+}
+
+var counterFile *mmapCounters
+
+func (m *mmapCounters) slot(status int) *uint64 {
+	if status < 0 || status >= mmapCountersSlots {
+		return nil
+	}
+	return (*uint64)(unsafe.Pointer(&m.data[8+status*8]))
+}
+
+func (m *mmapCounters) Add(status int, n uint64) {
+	if p := m.slot(status); p != nil {
+		atomic.AddUint64(p, n)
+	}
+}
+
+func (m *mmapCounters) Load(status int) uint64 {
+	if p := m.slot(status); p != nil {
+		return atomic.LoadUint64(p)
+	}
+	return 0
+}
+
+func (m *mmapCounters) Reset() {
+	for status := 0; status < mmapCountersSlots; status++ {
+		atomic.StoreUint64(m.slot(status), 0)
+	}
+}
+
+func (m *mmapCounters) Close() error {
+	return m.unmap()
+}
+
+// initCounterSegment validates or stamps the magic header of a freshly
+// mapped segment. Atomic access relies on the host being little-endian,
+// which holds for every platform we build images for.
+func initCounterSegment(data []byte) error {
+	if len(data) != mmapCountersSize {
+		return fmt.Errorf("unexpected segment size %d", len(data))
+	}
+	if string(data[:8]) == mmapCountersMagic {
+		return nil
+	}
+	for _, b := range data[:8] {
+		if b != 0 {
+			return fmt.Errorf("segment has unknown header %q", data[:8])
+		}
+	}
+	copy(data[:8], mmapCountersMagic)
+	return nil
+}
+
+// restoreFromCounterFile seeds the local Prometheus counters with the counts
+// that survived a container restart.
+func restoreFromCounterFile() {
+	for status := 0; status < mmapCountersSlots; status++ {
+		if count := counterFile.Load(status); count > 0 {
+			httpRequestsTotal.WithLabelValues("/api/check", fmt.Sprintf("%d", status)).Add(float64(count))
+			infof("Restored %d /api/check %d responses from counter file", count, status)
+		}
+	}
+}
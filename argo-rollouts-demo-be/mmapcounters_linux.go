@@ -0,0 +1,35 @@
+//go:build linux
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+func openMmapCounters(path string) (*mmapCounters, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	if err := file.Truncate(mmapCountersSize); err != nil {
+		return nil, fmt.Errorf("sizing counter file: %w", err)
+	}
+
+	data, err := syscall.Mmap(int(file.Fd()), 0, mmapCountersSize, syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_SHARED)
+	if err != nil {
+		return nil, fmt.Errorf("mapping counter file: %w", err)
+	}
+	if err := initCounterSegment(data); err != nil {
+		syscall.Munmap(data)
+		return nil, err
+	}
+
+	return &mmapCounters{
+		data:  data,
+		unmap: func() error { return syscall.Munmap(data) },
+	}, nil
+}
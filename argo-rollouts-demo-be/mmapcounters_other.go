@@ -0,0 +1,9 @@
+//go:build !linux
+
+package main
+
+import "errors"
+
+func openMmapCounters(path string) (*mmapCounters, error) {
+	return nil, errors.New("memory-mapped counters are only supported on linux")
+}
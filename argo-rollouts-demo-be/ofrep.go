@@ -0,0 +1,102 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+)
+
+// OpenFeature Remote Evaluation Protocol (OFREP) error codes.
+const (
+	ofrepParseError   = "PARSE_ERROR"
+	ofrepFlagNotFound = "FLAG_NOT_FOUND"
+	ofrepGeneral      = "GENERAL"
+)
+
+type ofrepRequest struct {
+	Context map[string]interface{} `json:"context"`
+}
+
+type ofrepError struct {
+	Key          string `json:"key,omitempty"`
+	ErrorCode    string `json:"errorCode"`
+	ErrorDetails string `json:"errorDetails,omitempty"`
+}
+
+// decodeOFREPContext reads the optional evaluation context from the body.
+func decodeOFREPContext(c echo.Context) (FlagContext, error) {
+	var req ofrepRequest
+	if c.Request().ContentLength != 0 {
+		if err := json.NewDecoder(c.Request().Body).Decode(&req); err != nil {
+			return FlagContext{}, err
+		}
+	}
+
+	ctx := FlagContext{Attributes: req.Context}
+	if key, ok := req.Context["targetingKey"].(string); ok {
+		ctx.TargetingKey = key
+	}
+	return ctx, nil
+}
+
+// ofrepEvaluateFlagHandler implements POST /ofrep/v1/evaluate/flags/{key},
+// so standard OpenFeature SDKs with an OFREP provider can point at this
+// backend.
+func ofrepEvaluateFlagHandler(c echo.Context) error {
+	key := c.Param("key")
+	ctx, err := decodeOFREPContext(c)
+	if err != nil {
+		httpRequestsTotal.WithLabelValues("/ofrep/v1/evaluate/flags/:key", fmt.Sprintf("%d", http.StatusBadRequest)).Inc()
+		return c.JSON(http.StatusBadRequest, ofrepError{Key: key, ErrorCode: ofrepParseError, ErrorDetails: "Invalid JSON"})
+	}
+
+	evaluation, ok, err := evaluateFlag(key, ctx)
+	if err != nil {
+		httpRequestsTotal.WithLabelValues("/ofrep/v1/evaluate/flags/:key", fmt.Sprintf("%d", http.StatusInternalServerError)).Inc()
+		return c.JSON(http.StatusInternalServerError, ofrepError{Key: key, ErrorCode: ofrepGeneral, ErrorDetails: err.Error()})
+	}
+	if !ok {
+		httpRequestsTotal.WithLabelValues("/ofrep/v1/evaluate/flags/:key", fmt.Sprintf("%d", http.StatusNotFound)).Inc()
+		return c.JSON(http.StatusNotFound, ofrepError{Key: key, ErrorCode: ofrepFlagNotFound, ErrorDetails: fmt.Sprintf("flag %q not found", key)})
+	}
+
+	httpRequestsTotal.WithLabelValues("/ofrep/v1/evaluate/flags/:key", fmt.Sprintf("%d", http.StatusOK)).Inc()
+	return c.JSON(http.StatusOK, evaluation)
+}
+
+// ofrepEvaluateFlagsHandler implements bulk evaluation. The ETag lets
+// client-side SDKs poll cheaply for changes.
+func ofrepEvaluateFlagsHandler(c echo.Context) error {
+	ctx, err := decodeOFREPContext(c)
+	if err != nil {
+		httpRequestsTotal.WithLabelValues("/ofrep/v1/evaluate/flags", fmt.Sprintf("%d", http.StatusBadRequest)).Inc()
+		return c.JSON(http.StatusBadRequest, ofrepError{ErrorCode: ofrepParseError, ErrorDetails: "Invalid JSON"})
+	}
+
+	evaluations, err := evaluateAllFlags(ctx)
+	if err != nil {
+		httpRequestsTotal.WithLabelValues("/ofrep/v1/evaluate/flags", fmt.Sprintf("%d", http.StatusInternalServerError)).Inc()
+		return c.JSON(http.StatusInternalServerError, ofrepError{ErrorCode: ofrepGeneral, ErrorDetails: err.Error()})
+	}
+
+	body := map[string]interface{}{"flags": evaluations}
+	encoded, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	sum := sha256.Sum256(encoded)
+	etag := `"` + hex.EncodeToString(sum[:8]) + `"`
+	c.Response().Header().Set("ETag", etag)
+
+	if c.Request().Header.Get("If-None-Match") == etag {
+		httpRequestsTotal.WithLabelValues("/ofrep/v1/evaluate/flags", fmt.Sprintf("%d", http.StatusNotModified)).Inc()
+		return c.NoContent(http.StatusNotModified)
+	}
+
+	httpRequestsTotal.WithLabelValues("/ofrep/v1/evaluate/flags", fmt.Sprintf("%d", http.StatusOK)).Inc()
+	return c.JSONBlob(http.StatusOK, encoded)
+}
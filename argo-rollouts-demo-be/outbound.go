@@ -0,0 +1,192 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Connection pool and retry settings shared by every outbound client.
+var (
+	outboundMaxIdleConns        = int(getEnvFloatOrDefault("OUTBOUND_MAX_IDLE_CONNS", 100))
+	outboundMaxIdleConnsPerHost = int(getEnvFloatOrDefault("OUTBOUND_MAX_IDLE_CONNS_PER_HOST", 10))
+	outboundMaxConnsPerHost     = int(getEnvFloatOrDefault("OUTBOUND_MAX_CONNS_PER_HOST", 50))
+	outboundMaxRetries          = int(getEnvFloatOrDefault("OUTBOUND_MAX_RETRIES", 2))
+	outboundRetryBackoff        = time.Duration(getEnvFloatOrDefault("OUTBOUND_RETRY_BACKOFF_MS", 100) * float64(time.Millisecond))
+
+	// Transport for clients without their own TLS settings, so they share
+	// one connection pool
+	sharedOutboundTransport = newOutboundTransport(nil)
+
+	outboundRequestDuration = promauto.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "outbound_request_duration_seconds",
+			Help:    "Outbound HTTP request latency per attempt by client, destination host, method and status code (\"error\" without a response)",
+			Buckets: []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10},
+		},
+		[]string{"client", "destination", "method", "status_code"},
+	)
+	outboundRequestErrorsTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "outbound_request_errors_total",
+			Help: "Outbound HTTP attempts that failed with a transport error or a 5xx status, by client and destination host",
+		},
+		[]string{"client", "destination"},
+	)
+	outboundRequestRetriesTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "outbound_request_retries_total",
+			Help: "Outbound HTTP requests retried after a failed attempt, by client and destination host",
+		},
+		[]string{"client", "destination"},
+	)
+)
+
+// outboundClientConfig describes one consumer of the outbound client.
+type outboundClientConfig struct {
+	Name    string        // Metric label, e.g. "kubernetes" or "digest-webhook"
+	Timeout time.Duration // Overall request timeout, zero for none (watches)
+	TLS     *tls.Config   // Custom TLS settings, nil to use the shared pool
+
+	// Retry POST and PATCH too. Only for receivers that tolerate duplicates,
+	// like webhooks; idempotent methods are always retried.
+	RetryNonIdempotent bool
+
+	// Never retry, for callers measuring the failures a retry would hide
+	NoRetry bool
+}
+
+func newOutboundTransport(tlsConfig *tls.Config) *http.Transport {
+	return &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: (&net.Dialer{
+			Timeout:   5 * time.Second,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		TLSClientConfig:       tlsConfig,
+		ForceAttemptHTTP2:     true,
+		MaxIdleConns:          outboundMaxIdleConns,
+		MaxIdleConnsPerHost:   outboundMaxIdleConnsPerHost,
+		MaxConnsPerHost:       outboundMaxConnsPerHost,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   5 * time.Second,
+		ExpectContinueTimeout: time.Second,
+	}
+}
+
+// newOutboundClient returns an http.Client that propagates the trace,
+// records per-destination metrics and retries failed attempts with jittered
+// exponential backoff. Every outbound feature should get its client here.
+func newOutboundClient(cfg outboundClientConfig) *http.Client {
+	base := sharedOutboundTransport
+	if cfg.TLS != nil {
+		base = newOutboundTransport(cfg.TLS)
+	}
+	return &http.Client{
+		Timeout:   cfg.Timeout,
+		Transport: &instrumentedTransport{cfg: cfg, base: base},
+	}
+}
+
+type instrumentedTransport struct {
+	cfg  outboundClientConfig
+	base http.RoundTripper
+}
+
+func (t *instrumentedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	// RoundTrippers must not modify the caller's request
+	req = req.Clone(req.Context())
+	injectTraceHeaders(req.Context(), req)
+	injectRequestID(req.Context(), req)
+
+	destination := req.URL.Host
+	retryable := t.retryable(req)
+
+	for attempt := 0; ; attempt++ {
+		start := time.Now()
+		resp, err := t.base.RoundTrip(req)
+
+		status := "error"
+		if err == nil {
+			status = fmt.Sprintf("%d", resp.StatusCode)
+		}
+		outboundRequestDuration.WithLabelValues(t.cfg.Name, destination, req.Method, status).Observe(time.Since(start).Seconds())
+
+		failed := err != nil || resp.StatusCode >= 500
+		if failed {
+			outboundRequestErrorsTotal.WithLabelValues(t.cfg.Name, destination).Inc()
+		}
+		if !failed || !retryable || attempt >= outboundMaxRetries || !retryableFailure(resp, err) {
+			return resp, err
+		}
+
+		if resp != nil {
+			io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+			resp.Body.Close()
+		}
+		if req.Body != nil && req.GetBody != nil {
+			body, bodyErr := req.GetBody()
+			if bodyErr != nil {
+				return nil, bodyErr
+			}
+			req.Body = body
+		}
+
+		outboundRequestRetriesTotal.WithLabelValues(t.cfg.Name, destination).Inc()
+		if err := sleepBackoff(req.Context(), attempt); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// retryable reports whether the request may be sent again: the method must
+// allow it and a body must be replayable.
+func (t *instrumentedTransport) retryable(req *http.Request) bool {
+	if t.cfg.NoRetry {
+		return false
+	}
+	if req.Body != nil && req.Body != http.NoBody && req.GetBody == nil {
+		return false
+	}
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return t.cfg.RetryNonIdempotent
+}
+
+// retryableFailure leaves out failures a retry can't fix: a canceled or
+// expired request, and 5xx statuses other than the transient ones.
+func retryableFailure(resp *http.Response, err error) bool {
+	if err != nil {
+		return !errors.Is(err, context.Canceled) && !errors.Is(err, context.DeadlineExceeded)
+	}
+	switch resp.StatusCode {
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// sleepBackoff waits a random duration up to base * 2^attempt ("full
+// jitter"), so clients retrying together don't hit the target in lockstep.
+func sleepBackoff(ctx context.Context, attempt int) error {
+	delay := time.Duration(randomFloat() * float64(outboundRetryBackoff<<attempt))
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
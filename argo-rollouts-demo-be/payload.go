@@ -0,0 +1,197 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/labstack/echo/v4"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const maxPayloadSize = 10 << 20
+
+// PayloadConfig controls the synthetic response served by /api/payload.
+// Compressibility is the share of the body made of a repeating pattern; the
+// rest is random bytes, so 0 is incompressible and 100 compresses to almost
+// nothing. Compression toggles gzip for clients that accept it.
+type PayloadConfig struct {
+	SizeBytes       int     `json:"sizeBytes"`
+	Compressibility float64 `json:"compressibility"` // Percentage
+	Compression     bool    `json:"compression"`
+}
+
+var (
+	payloadConfig = PayloadConfig{
+		SizeBytes:       int(getEnvFloatOrDefault("PAYLOAD_SIZE_BYTES", 16*1024)),
+		Compressibility: getEnvFloatOrDefault("PAYLOAD_COMPRESSIBILITY", 50),
+		Compression:     isTruthy(getEnvOrDefault("PAYLOAD_COMPRESSION", "true")),
+	}
+	payloadConfigMu sync.RWMutex
+
+	payloadBytesTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "payload_bytes_total",
+			Help: "Total bytes generated by /api/payload before and after content encoding",
+		},
+		[]string{"stage", "encoding"},
+	)
+)
+
+func (p PayloadConfig) validate() error {
+	if p.SizeBytes < 0 || p.SizeBytes > maxPayloadSize {
+		return fmt.Errorf("sizeBytes must be between 0 and %d", maxPayloadSize)
+	}
+	if math.IsNaN(p.Compressibility) || p.Compressibility < 0 || p.Compressibility > 100 {
+		return fmt.Errorf("compressibility must be between 0 and 100")
+	}
+	return nil
+}
+
+// generatePayload builds a body of the given size where roughly
+// compressibility percent of the bytes are a repeating pattern.
+func generatePayload(size int, compressibility float64) []byte {
+	body := make([]byte, size)
+	repeated := int(float64(size) * compressibility / 100.0)
+
+	pattern := []byte("argo-rollouts-demo ")
+	for i := 0; i < repeated; i++ {
+		body[i] = pattern[i%len(pattern)]
+	}
+
+	randomBytes(body[repeated:])
+
+	return body
+}
+
+// encodingWeight returns the q-value the client gives a content coding in
+// Accept-Encoding, 0 when it isn't accepted. "*" stands for any coding not
+// listed by name.
+func encodingWeight(c echo.Context, coding string) float64 {
+	wildcard := 0.0
+	for _, encoding := range strings.Split(c.Request().Header.Get(echo.HeaderAcceptEncoding), ",") {
+		name, params, _ := strings.Cut(encoding, ";")
+		name = strings.TrimSpace(name)
+		q := 1.0
+		for _, param := range strings.Split(params, ";") {
+			key, value, _ := strings.Cut(strings.TrimSpace(param), "=")
+			if strings.EqualFold(strings.TrimSpace(key), "q") {
+				parsed, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+				if err != nil || parsed < 0 || parsed > 1 {
+					parsed = 0
+				}
+				q = parsed
+			}
+		}
+		switch {
+		case strings.EqualFold(name, coding):
+			return q
+		case name == "*":
+			wildcard = q
+		}
+	}
+	return wildcard
+}
+
+// acceptsEncoding reports whether the client accepts the content encoding.
+func acceptsEncoding(c echo.Context, coding string) bool {
+	return encodingWeight(c, coding) > 0
+}
+
+// preferredEncoding picks the offered coding the client weighs highest, the
+// first offered on a tie, or "" when it accepts none of them.
+func preferredEncoding(c echo.Context, offered ...string) string {
+	best, bestWeight := "", 0.0
+	for _, coding := range offered {
+		if weight := encodingWeight(c, coding); weight > bestWeight {
+			best, bestWeight = coding, weight
+		}
+	}
+	return best
+}
+
+func payloadHandler(c echo.Context) error {
+	payloadConfigMu.RLock()
+	cfg := payloadConfig
+	payloadConfigMu.RUnlock()
+
+	// Query parameters override the configured shape for one request
+	if value := c.QueryParam("size"); value != "" {
+		size, err := strconv.Atoi(value)
+		if err != nil || size < 0 || size > maxPayloadSize {
+			httpRequestsTotal.WithLabelValues("/api/payload", fmt.Sprintf("%d", http.StatusBadRequest)).Inc()
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("size must be between 0 and %d", maxPayloadSize)})
+		}
+		cfg.SizeBytes = size
+	}
+	if value := c.QueryParam("compressibility"); value != "" {
+		compressibility, err := strconv.ParseFloat(value, 64)
+		if err != nil || compressibility < 0 || compressibility > 100 {
+			httpRequestsTotal.WithLabelValues("/api/payload", fmt.Sprintf("%d", http.StatusBadRequest)).Inc()
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "compressibility must be between 0 and 100"})
+		}
+		cfg.Compressibility = compressibility
+	}
+
+	body := generatePayload(cfg.SizeBytes, cfg.Compressibility)
+	header := c.Response().Header()
+	header.Set("X-Version", version)
+	header.Add(echo.HeaderVary, echo.HeaderAcceptEncoding)
+	payloadBytesTotal.WithLabelValues("uncompressed", "identity").Add(float64(len(body)))
+
+	if cfg.Compression && acceptsEncoding(c, "gzip") {
+		var compressed bytes.Buffer
+		gz := gzip.NewWriter(&compressed)
+		gz.Write(body)
+		gz.Close()
+
+		header.Set(echo.HeaderContentEncoding, "gzip")
+		header.Set("X-Uncompressed-Length", strconv.Itoa(len(body)))
+		payloadBytesTotal.WithLabelValues("compressed", "gzip").Add(float64(compressed.Len()))
+		httpRequestsTotal.WithLabelValues("/api/payload", fmt.Sprintf("%d", http.StatusOK)).Inc()
+		return c.Blob(http.StatusOK, echo.MIMEOctetStream, compressed.Bytes())
+	}
+
+	payloadBytesTotal.WithLabelValues("compressed", "identity").Add(float64(len(body)))
+	httpRequestsTotal.WithLabelValues("/api/payload", fmt.Sprintf("%d", http.StatusOK)).Inc()
+	return c.Blob(http.StatusOK, echo.MIMEOctetStream, body)
+}
+
+func getPayloadConfigHandler(c echo.Context) error {
+	payloadConfigMu.RLock()
+	current := payloadConfig
+	payloadConfigMu.RUnlock()
+
+	httpRequestsTotal.WithLabelValues("/api/payload/config", fmt.Sprintf("%d", http.StatusOK)).Inc()
+	return c.JSON(http.StatusOK, current)
+}
+
+func setPayloadConfigHandler(c echo.Context) error {
+	payloadConfigMu.RLock()
+	update := payloadConfig
+	payloadConfigMu.RUnlock()
+
+	if err := json.NewDecoder(c.Request().Body).Decode(&update); err != nil {
+		httpRequestsTotal.WithLabelValues("/api/payload/config", fmt.Sprintf("%d", http.StatusBadRequest)).Inc()
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid JSON"})
+	}
+
+	if err := update.validate(); err != nil {
+		httpRequestsTotal.WithLabelValues("/api/payload/config", fmt.Sprintf("%d", http.StatusBadRequest)).Inc()
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+
+	payloadConfigMu.Lock()
+	payloadConfig = update
+	payloadConfigMu.Unlock()
+
+	httpRequestsTotal.WithLabelValues("/api/payload/config", fmt.Sprintf("%d", http.StatusOK)).Inc()
+	return c.JSON(http.StatusOK, update)
+}
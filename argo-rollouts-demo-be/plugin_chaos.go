@@ -0,0 +1,25 @@
+//go:build !prodlike
+
+package main
+
+// The chaos controls change behavior for every route, so prod-like builds
+// (-tags prodlike) leave them out. FAULT_* settings still apply at startup.
+func init() {
+	registerRoutePlugin("chaos", func(r *pluginRoutes) {
+		r.GET("/api/faults", getFaultsHandler)
+		r.POST("/api/faults", setFaultsHandler, requireAdminToken)
+		r.GET("/api/faults/log", faultLogHandler)
+		r.DELETE("/api/faults/log", clearFaultLogHandler, requireAdminToken)
+		r.GET("/api/faults/stats", faultStatsHandler)
+		r.DELETE("/api/faults/stats", resetFaultStatsHandler, requireAdminToken)
+		r.GET("/api/chaos/burst", getErrorBurstHandler)
+		r.POST("/api/chaos/burst", startErrorBurstHandler, requireAdminToken)
+		r.DELETE("/api/chaos/burst", stopErrorBurstHandler, requireAdminToken)
+		r.GET("/api/chaos/cpu", getCPUBurnHandler)
+		r.POST("/api/chaos/cpu", startCPUBurnHandler, requireAdminToken)
+		r.DELETE("/api/chaos/cpu", stopCPUBurnHandler, requireAdminToken)
+		r.GET("/api/chaos/crash", getCrashHandler)
+		r.POST("/api/chaos/crash", crashHandler, requireAdminToken)
+		r.DELETE("/api/chaos/crash", cancelCrashHandler, requireAdminToken)
+	})
+}
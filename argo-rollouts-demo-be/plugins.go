@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+
+	"github.com/labstack/echo/v4"
+)
+
+// routePlugin is a set of optional routes compiled in by a build-tagged file
+// that calls registerRoutePlugin from init, e.g. the chaos endpoints that
+// "prod-like" builds (-tags prodlike) leave out.
+type routePlugin struct {
+	name   string
+	routes func(r *pluginRoutes)
+}
+
+type pluginRoute struct {
+	method     string
+	path       string
+	handler    echo.HandlerFunc
+	middleware []echo.MiddlewareFunc
+}
+
+// pluginRoutes collects a plugin's routes so they can be checked for
+// conflicts before any of them is mounted.
+type pluginRoutes struct {
+	routes []pluginRoute
+}
+
+func (r *pluginRoutes) GET(path string, h echo.HandlerFunc, m ...echo.MiddlewareFunc) {
+	r.routes = append(r.routes, pluginRoute{http.MethodGet, path, h, m})
+}
+
+func (r *pluginRoutes) POST(path string, h echo.HandlerFunc, m ...echo.MiddlewareFunc) {
+	r.routes = append(r.routes, pluginRoute{http.MethodPost, path, h, m})
+}
+
+func (r *pluginRoutes) DELETE(path string, h echo.HandlerFunc, m ...echo.MiddlewareFunc) {
+	r.routes = append(r.routes, pluginRoute{http.MethodDelete, path, h, m})
+}
+
+// PluginStatus reports whether a compiled-in plugin was mounted.
+type PluginStatus struct {
+	Name   string   `json:"name"`
+	Loaded bool     `json:"loaded"`
+	Routes []string `json:"routes"`
+	Error  string   `json:"error,omitempty"`
+}
+
+// componentPlugin is a component compiled in by a build-tagged file that
+// calls registerComponentPlugin from init, e.g. the gRPC server that only
+// the canary flavor has.
+type componentPlugin struct {
+	name      string
+	component func() Lifecycle
+	dependsOn []string
+}
+
+var (
+	routePlugins     []routePlugin
+	componentPlugins []componentPlugin
+	pluginStatuses   []PluginStatus
+)
+
+func registerRoutePlugin(name string, routes func(r *pluginRoutes)) {
+	routePlugins = append(routePlugins, routePlugin{name: name, routes: routes})
+}
+
+func registerComponentPlugin(name string, component func() Lifecycle, dependsOn ...string) {
+	componentPlugins = append(componentPlugins, componentPlugin{name: name, component: component, dependsOn: dependsOn})
+}
+
+// addComponentPlugins adds the plugins' components after the core ones, so
+// they can depend on any of them.
+func addComponentPlugins(components *supervisor) {
+	for _, plugin := range componentPlugins {
+		components.Add(plugin.name, plugin.component(), plugin.dependsOn...)
+	}
+}
+
+// mountRoutePlugins adds the plugins' routes after the core ones. Echo
+// silently replaces a route registered twice, so a plugin claiming a method
+// and path that is already taken is skipped as a whole, with the conflict
+// logged and reported by /api/plugins, instead of shadowing a core handler.
+func mountRoutePlugins(e *echo.Echo) {
+	taken := map[string]string{}
+	for _, route := range e.Routes() {
+		taken[route.Method+" "+route.Path] = "core"
+	}
+
+	sort.Slice(routePlugins, func(i, j int) bool { return routePlugins[i].name < routePlugins[j].name })
+	for _, plugin := range routePlugins {
+		var collected pluginRoutes
+		plugin.routes(&collected)
+
+		status := PluginStatus{Name: plugin.name, Routes: []string{}}
+		seen := map[string]bool{}
+		for _, route := range collected.routes {
+			key := route.method + " " + route.path
+			status.Routes = append(status.Routes, key)
+			if owner, ok := taken[key]; ok && status.Error == "" {
+				status.Error = fmt.Sprintf("%s is already registered by %s", key, owner)
+			} else if seen[key] && status.Error == "" {
+				status.Error = fmt.Sprintf("%s is registered twice", key)
+			}
+			seen[key] = true
+		}
+
+		if status.Error != "" {
+			warnf("Skipping route plugin %s: %s", plugin.name, status.Error)
+		} else {
+			for _, route := range collected.routes {
+				e.Add(route.method, route.path, route.handler, route.middleware...)
+				taken[route.method+" "+route.path] = "plugin " + plugin.name
+			}
+			status.Loaded = true
+			infof("Route plugin %s mounted with %d routes", plugin.name, len(collected.routes))
+		}
+		pluginStatuses = append(pluginStatuses, status)
+	}
+}
+
+func listPluginsHandler(c echo.Context) error {
+	statuses := pluginStatuses
+	if statuses == nil {
+		statuses = []PluginStatus{}
+	}
+	httpRequestsTotal.WithLabelValues("/api/plugins", fmt.Sprintf("%d", http.StatusOK)).Inc()
+	return c.JSON(http.StatusOK, statuses)
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/pprof"
+
+	"github.com/labstack/echo/v4"
+)
+
+// With ENABLE_PPROF=true the runtime profiles are served under
+// /debug/pprof, by default on localhost:6060 so they are only reachable
+// through kubectl port-forward, e.g. while the load generator runs:
+//
+//	kubectl port-forward pod/<pod> 6060
+//	go tool pprof http://localhost:6060/debug/pprof/profile?seconds=30
+//
+// PPROF_ADDR="" mounts them on the API port instead.
+var (
+	pprofEnabled = isTruthy(getEnvOrDefault("ENABLE_PPROF", "false"))
+	pprofAddr    = getEnvOrDefault("PPROF_ADDR", "localhost:6060")
+)
+
+func pprofMux() *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	return mux
+}
+
+// mountPprof serves the profiles on the API server when they don't get a
+// port of their own.
+func mountPprof(e *echo.Echo) {
+	if !pprofEnabled || pprofAddr != "" {
+		return
+	}
+	e.Any("/debug/pprof/*", echo.WrapHandler(pprofMux()))
+}
+
+// newPprofServer runs the profiles on their own port as a component.
+func newPprofServer() Lifecycle {
+	srv := &http.Server{Addr: pprofAddr, Handler: pprofMux()}
+	return lifecycleFuncs{
+		start: func(context.Context) error {
+			listener, err := net.Listen("tcp", srv.Addr)
+			if err != nil {
+				return err
+			}
+			infof("pprof listening on %s", listener.Addr())
+			go func() {
+				if err := srv.Serve(listener); err != nil && err != http.ErrServerClosed {
+					warnf("pprof server stopped: %v", err)
+				}
+			}()
+			return nil
+		},
+		stop: srv.Shutdown,
+	}
+}
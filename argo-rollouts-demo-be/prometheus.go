@@ -0,0 +1,28 @@
+package main
+
+import (
+	"regexp"
+
+	"github.com/labstack/echo/v4"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+func init() {
+	// Swap the default Go collector for one that also exports the GC,
+	// scheduler and memory runtime/metrics, which are the ones worth
+	// comparing between a stable and a canary build
+	prometheus.Unregister(collectors.NewGoCollector())
+	prometheus.MustRegister(collectors.NewGoCollector(
+		collectors.WithGoCollectorRuntimeMetrics(
+			collectors.GoRuntimeMetricsRule{Matcher: regexp.MustCompile(`^/(gc|sched|memory)/.*`)},
+		),
+	))
+}
+
+// prometheusHandler serves the default registry in the Prometheus exposition
+// format, so AnalysisTemplates using the prometheus provider can scrape the
+// pod directly. promhttp counts its own requests in
+// promhttp_metric_handler_requests_total.
+var prometheusHandler = echo.WrapHandler(promhttp.Handler())
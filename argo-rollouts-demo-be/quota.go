@@ -0,0 +1,260 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// quotaWindow is a fixed accounting window (hourly or daily) with its own
+// per-key request limit. A limit of 0 disables the window.
+type quotaWindow struct {
+	Name   string
+	Length time.Duration
+	Limit  int64
+}
+
+type localQuotaEntry struct {
+	count   int64
+	expires time.Time
+}
+
+type QuotaUsage struct {
+	Window    string    `json:"window"`
+	Limit     int64     `json:"limit"`
+	Used      int64     `json:"used"`
+	Remaining int64     `json:"remaining"`
+	ResetAt   time.Time `json:"resetAt"`
+}
+
+var (
+	quotaWindows = []quotaWindow{
+		{Name: "hourly", Length: time.Hour, Limit: int64(getEnvFloatOrDefault("QUOTA_HOURLY", 0))},
+		{Name: "daily", Length: 24 * time.Hour, Limit: int64(getEnvFloatOrDefault("QUOTA_DAILY", 0))},
+	}
+	quotaKeyHeader = getEnvOrDefault("QUOTA_KEY_HEADER", "X-API-Key")
+
+	// Local fallback counters used when Redis is unavailable
+	localQuota   = map[string]*localQuotaEntry{}
+	localQuotaMu sync.Mutex
+
+	quotaRequestsTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "quota_requests_total",
+			Help: "Total number of requests evaluated against API key quotas by result",
+		},
+		[]string{"result"},
+	)
+	quotaExceededTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "quota_exceeded_total",
+			Help: "Total number of requests rejected because a quota window was exhausted",
+		},
+		[]string{"window"},
+	)
+)
+
+func quotaEnabled(windows []quotaWindow) bool {
+	for _, w := range windows {
+		if w.Limit > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// requestQuotaWindows returns the windows that apply to the request: the
+// session's own limits within a session, the shared ones otherwise.
+func requestQuotaWindows(c echo.Context) []quotaWindow {
+	session, ok := sessionFromContext(c.Request().Context())
+	if !ok {
+		return quotaWindows
+	}
+	return []quotaWindow{
+		{Name: "hourly", Length: time.Hour, Limit: session.Quota.Hourly},
+		{Name: "daily", Length: 24 * time.Hour, Limit: session.Quota.Daily},
+	}
+}
+
+// quotaKey returns the key identifying the caller, falling back to a shared
+// "anonymous" bucket for requests without one. Sessions are always
+// accounted by session. API keys and session tokens are hashed, so secrets
+// of any length or content never end up in Redis key names or responses.
+func quotaKey(c echo.Context) string {
+	if session, ok := sessionFromContext(c.Request().Context()); ok {
+		return "session-" + quotaKeyHash(session.Token)
+	}
+	if key := c.Request().Header.Get(quotaKeyHeader); key != "" {
+		return "key-" + quotaKeyHash(key)
+	}
+	return "anonymous"
+}
+
+func quotaKeyHash(secret string) string {
+	digest := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(digest[:16])
+}
+
+func quotaBucket(apiKey string, w quotaWindow, now time.Time) (string, time.Time) {
+	start := now.Truncate(w.Length)
+	return fmt.Sprintf("quota:%s:%s:%d", apiKey, w.Name, start.Unix()), start.Add(w.Length)
+}
+
+// incrQuota increments the counter for the given bucket and returns the new
+// value, using Redis when available so all replicas share one quota.
+func incrQuota(bucket string, ttl time.Duration) int64 {
+	if redisClient != nil {
+		pipe := redisClient.TxPipeline()
+		incr := pipe.Incr(redisCtx, bucket)
+		pipe.Expire(redisCtx, bucket, ttl)
+		_, err := pipe.Exec(redisCtx)
+		if err == nil {
+			return incr.Val()
+		}
+		warnf("Failed to update quota in Redis: %v", err)
+	}
+
+	now := time.Now()
+	localQuotaMu.Lock()
+	defer localQuotaMu.Unlock()
+
+	entry, ok := localQuota[bucket]
+	if !ok {
+		entry = &localQuotaEntry{expires: now.Add(ttl)}
+		localQuota[bucket] = entry
+	}
+	entry.count++
+	return entry.count
+}
+
+// sweepLocalQuota drops local buckets whose window has already ended.
+func sweepLocalQuota(now time.Time) {
+	localQuotaMu.Lock()
+	defer localQuotaMu.Unlock()
+	for key, entry := range localQuota {
+		if now.After(entry.expires) {
+			delete(localQuota, key)
+		}
+	}
+}
+
+func runLocalQuotaSweep(stop <-chan struct{}) {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case now := <-ticker.C:
+			sweepLocalQuota(now)
+		}
+	}
+}
+
+func peekQuota(bucket string) int64 {
+	if redisClient != nil {
+		if used, err := redisClient.Get(redisCtx, bucket).Int64(); err == nil {
+			return used
+		}
+	}
+
+	localQuotaMu.Lock()
+	defer localQuotaMu.Unlock()
+	if entry, ok := localQuota[bucket]; ok && time.Now().Before(entry.expires) {
+		return entry.count
+	}
+	return 0
+}
+
+func quotaMiddleware(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		windows := requestQuotaWindows(c)
+		if !quotaEnabled(windows) {
+			return next(c)
+		}
+		switch c.Path() {
+		case "/api/healthz", "/api/readyz", "/api/quota", "/metrics":
+			return next(c)
+		}
+
+		apiKey := quotaKey(c)
+		now := time.Now()
+		header := c.Response().Header()
+		remainingMin := int64(-1)
+
+		for _, w := range windows {
+			if w.Limit <= 0 {
+				continue
+			}
+			bucket, resetAt := quotaBucket(apiKey, w, now)
+			used := incrQuota(bucket, w.Length)
+			remaining := w.Limit - used
+			if remaining < 0 {
+				remaining = 0
+			}
+
+			// Report the most restrictive window in the standard headers
+			if remainingMin < 0 || remaining < remainingMin {
+				remainingMin = remaining
+				header.Set("X-RateLimit-Limit", strconv.FormatInt(w.Limit, 10))
+				header.Set("X-RateLimit-Remaining", strconv.FormatInt(remaining, 10))
+				header.Set("X-RateLimit-Reset", strconv.FormatInt(resetAt.Unix(), 10))
+			}
+
+			if used > w.Limit {
+				quotaExceededTotal.WithLabelValues(w.Name).Inc()
+				quotaRequestsTotal.WithLabelValues("rejected").Inc()
+				header.Set("Retry-After", strconv.Itoa(int(time.Until(resetAt).Seconds())+1))
+				httpRequestsTotal.WithLabelValues(c.Path(), fmt.Sprintf("%d", http.StatusTooManyRequests)).Inc()
+				return c.JSON(http.StatusTooManyRequests, map[string]string{
+					"error": fmt.Sprintf("%s quota exceeded", w.Name),
+				})
+			}
+		}
+
+		quotaRequestsTotal.WithLabelValues("allowed").Inc()
+		return next(c)
+	}
+}
+
+func getQuotaHandler(c echo.Context) error {
+	apiKey := quotaKey(c)
+	windows := requestQuotaWindows(c)
+	now := time.Now()
+	usage := []QuotaUsage{}
+
+	for _, w := range windows {
+		if w.Limit <= 0 {
+			continue
+		}
+		bucket, resetAt := quotaBucket(apiKey, w, now)
+		used := peekQuota(bucket)
+		remaining := w.Limit - used
+		if remaining < 0 {
+			remaining = 0
+		}
+		usage = append(usage, QuotaUsage{
+			Window:    w.Name,
+			Limit:     w.Limit,
+			Used:      used,
+			Remaining: remaining,
+			ResetAt:   resetAt,
+		})
+	}
+
+	httpRequestsTotal.WithLabelValues("/api/quota", fmt.Sprintf("%d", http.StatusOK)).Inc()
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"key":     apiKey,
+		"enabled": quotaEnabled(windows),
+		"quotas":  usage,
+	})
+}
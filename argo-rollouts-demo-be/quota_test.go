@@ -0,0 +1,49 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// The raw API key must not end up in the bucket name, whatever it contains.
+func TestQuotaKeyHashesAPIKey(t *testing.T) {
+	secret := "secret:with spaces\nand" + strings.Repeat("x", 4096)
+	req := httptest.NewRequest(http.MethodGet, "/api/check", nil)
+	req.Header.Set(quotaKeyHeader, secret)
+	c := echo.New().NewContext(req, httptest.NewRecorder())
+
+	key := quotaKey(c)
+	if strings.Contains(key, "secret") || len(key) > 64 {
+		t.Fatalf("quotaKey = %q, want a short hash of the API key", key)
+	}
+	other := httptest.NewRequest(http.MethodGet, "/api/check", nil)
+	other.Header.Set(quotaKeyHeader, secret+"y")
+	if quotaKey(echo.New().NewContext(other, httptest.NewRecorder())) == key {
+		t.Fatal("different API keys share a quota key")
+	}
+}
+
+func TestSweepLocalQuota(t *testing.T) {
+	previousClient := redisClient
+	redisClient = nil
+	t.Cleanup(func() { redisClient = previousClient })
+	now := time.Now()
+	incrQuota("quota:test:expired", time.Millisecond)
+	incrQuota("quota:test:live", time.Hour)
+
+	sweepLocalQuota(now.Add(time.Second))
+
+	localQuotaMu.Lock()
+	_, expired := localQuota["quota:test:expired"]
+	_, live := localQuota["quota:test:live"]
+	delete(localQuota, "quota:test:live")
+	localQuotaMu.Unlock()
+	if expired || !live {
+		t.Fatalf("after sweep: expired bucket kept = %v, live bucket kept = %v", expired, live)
+	}
+}
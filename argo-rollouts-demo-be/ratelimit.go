@@ -0,0 +1,164 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// RateLimit is the per-client-IP token bucket applied to the API. Each IP
+// may send Burst requests at once and RPS per second sustained; RPS 0
+// turns limiting off. Unlike the quotas it is per pod and in memory, so
+// lowering it mid-rollout shows 429 spikes straight away.
+type RateLimit struct {
+	RPS   float64 `json:"rps"`
+	Burst int     `json:"burst"` // Zero means RPS rounded up
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+var (
+	rateLimit = RateLimit{
+		RPS:   getEnvFloatOrDefault("RATE_LIMIT_RPS", 0),
+		Burst: int(getEnvFloatOrDefault("RATE_LIMIT_BURST", 0)),
+	}
+	rateLimitMu sync.Mutex
+
+	// Guarded by rateLimitMu, like the limit they are filled at
+	rateLimitBuckets   = map[string]*tokenBucket{}
+	rateLimitLastPrune time.Time
+
+	rateLimitRequestsTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "rate_limit_requests_total",
+			Help: "Total number of requests evaluated against the per-IP rate limit by result",
+		},
+		[]string{"result"},
+	)
+)
+
+func init() {
+	if err := rateLimit.validate(); err != nil {
+		warnf("Invalid RATE_LIMIT_RPS/RATE_LIMIT_BURST, rate limiting disabled: %v", err)
+		rateLimit = RateLimit{}
+	}
+}
+
+func (r RateLimit) validate() error {
+	if r.RPS < 0 || math.IsNaN(r.RPS) || math.IsInf(r.RPS, 0) {
+		return fmt.Errorf("rps must be zero or positive")
+	}
+	if r.Burst < 0 {
+		return fmt.Errorf("burst must not be negative")
+	}
+	return nil
+}
+
+func (r RateLimit) burst() float64 {
+	if r.Burst > 0 {
+		return float64(r.Burst)
+	}
+	return math.Max(1, math.Ceil(r.RPS))
+}
+
+// takeRateLimitToken spends a token from the IP's bucket. When it is empty
+// it returns false and how long until the next token.
+func takeRateLimitToken(ip string, now time.Time) (bool, time.Duration) {
+	rateLimitMu.Lock()
+	defer rateLimitMu.Unlock()
+
+	limit := rateLimit
+	if limit.RPS <= 0 {
+		return true, 0
+	}
+	burst := limit.burst()
+
+	// Buckets idle long enough to have refilled are the same as new ones
+	if now.Sub(rateLimitLastPrune) > time.Minute {
+		for key, bucket := range rateLimitBuckets {
+			if now.Sub(bucket.last).Seconds()*limit.RPS >= burst {
+				delete(rateLimitBuckets, key)
+			}
+		}
+		rateLimitLastPrune = now
+	}
+
+	bucket, ok := rateLimitBuckets[ip]
+	if !ok {
+		bucket = &tokenBucket{tokens: burst, last: now}
+		rateLimitBuckets[ip] = bucket
+	}
+	bucket.tokens = math.Min(burst, bucket.tokens+now.Sub(bucket.last).Seconds()*limit.RPS)
+	bucket.last = now
+	if bucket.tokens < 1 {
+		return false, time.Duration((1 - bucket.tokens) / limit.RPS * float64(time.Second))
+	}
+	bucket.tokens--
+	return true, 0
+}
+
+func rateLimitMiddleware(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		switch c.Path() {
+		case "/api/healthz", "/api/readyz", "/api/rate-limit", "/metrics":
+			return next(c)
+		}
+
+		allowed, wait := takeRateLimitToken(c.RealIP(), time.Now())
+		if allowed {
+			rateLimitRequestsTotal.WithLabelValues("allowed").Inc()
+			return next(c)
+		}
+
+		rateLimitRequestsTotal.WithLabelValues("rejected").Inc()
+		c.Response().Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		httpRequestsTotal.WithLabelValues(c.Path(), fmt.Sprintf("%d", http.StatusTooManyRequests)).Inc()
+		return c.JSON(http.StatusTooManyRequests, map[string]string{"error": "Rate limit exceeded"})
+	}
+}
+
+func getRateLimitHandler(c echo.Context) error {
+	rateLimitMu.Lock()
+	current := rateLimit
+	rateLimitMu.Unlock()
+
+	httpRequestsTotal.WithLabelValues("/api/rate-limit", fmt.Sprintf("%d", http.StatusOK)).Inc()
+	return c.JSON(http.StatusOK, current)
+}
+
+// setRateLimitHandler changes the limit at runtime. Existing buckets are
+// dropped, so every client starts again with a full burst.
+func setRateLimitHandler(c echo.Context) error {
+	rateLimitMu.Lock()
+	update := rateLimit
+	rateLimitMu.Unlock()
+
+	if err := json.NewDecoder(c.Request().Body).Decode(&update); err != nil {
+		httpRequestsTotal.WithLabelValues("/api/rate-limit", fmt.Sprintf("%d", http.StatusBadRequest)).Inc()
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid JSON"})
+	}
+	if err := update.validate(); err != nil {
+		httpRequestsTotal.WithLabelValues("/api/rate-limit", fmt.Sprintf("%d", http.StatusBadRequest)).Inc()
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+
+	rateLimitMu.Lock()
+	rateLimit = update
+	rateLimitBuckets = map[string]*tokenBucket{}
+	rateLimitMu.Unlock()
+
+	infof("Rate limit set to %.1f rps, burst %d", update.RPS, update.Burst)
+	httpRequestsTotal.WithLabelValues("/api/rate-limit", fmt.Sprintf("%d", http.StatusOK)).Inc()
+	return c.JSON(http.StatusOK, update)
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"os"
+	"sort"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Readiness, unlike liveness, depends on more than the process being up:
+// the pod warms up after starting, needs Redis when it is configured
+// and stops taking traffic when asked to shut down.
+var (
+	// Extra time after startup before reporting ready, e.g. to let caches
+	// fill before the rollout sends traffic, or to show a rollout pausing
+	// on a canary that isn't ready. WARMUP_SECONDS is the older name.
+	warmupDuration = time.Duration(getEnvFloatOrDefault("STARTUP_DELAY_SECONDS",
+		getEnvFloatOrDefault("WARMUP_SECONDS", 0)) * float64(time.Second))
+
+	// With false, a Redis outage is reported but doesn't fail readiness,
+	// matching a fail-open store
+	readyzRequireRedis = isTruthy(getEnvOrDefault("READYZ_REQUIRE_REDIS", "true"))
+	readyzRedisTimeout = 500 * time.Millisecond
+
+	// On SIGTERM readiness fails for this long before the server stops, the
+	// time Kubernetes needs to take the pod out of its Service endpoints, so
+	// requests still routed here during a rollout are served
+	shutdownDelay = time.Duration(getEnvFloatOrDefault("SHUTDOWN_DELAY_SECONDS", 5) * float64(time.Second))
+	// How long in-flight requests then get to finish
+	shutdownTimeout = time.Duration(getEnvFloatOrDefault("SHUTDOWN_TIMEOUT_SECONDS", 10) * float64(time.Second))
+
+	warmedUp atomic.Bool
+	draining atomic.Bool
+	// When the warm-up ends, in Unix nanoseconds, 0 until it has started
+	warmupEndsAt atomic.Int64
+)
+
+// markStarted starts the warm-up once every component is running.
+func markStarted() {
+	if warmupDuration <= 0 {
+		warmedUp.Store(true)
+		return
+	}
+	infof("Warming up for %s before reporting ready", warmupDuration)
+	warmupEndsAt.Store(time.Now().Add(warmupDuration).UnixNano())
+	time.AfterFunc(warmupDuration, func() {
+		warmedUp.Store(true)
+		infof("Warm-up complete")
+	})
+}
+
+// warmupRemaining is how much longer the warm-up keeps the pod unready.
+// Before it starts that is the whole warm-up.
+func warmupRemaining() time.Duration {
+	if warmedUp.Load() {
+		return 0
+	}
+	endsAt := warmupEndsAt.Load()
+	if endsAt == 0 {
+		return warmupDuration
+	}
+	return max(time.Until(time.Unix(0, endsAt)), 0)
+}
+
+// drain fails readiness for the rest of the process's life, closes
+// keep-alive connections after their current request so clients reconnect
+// to other pods, and on SIGTERM waits out shutdownDelay. Ctrl-C skips the
+// wait, nothing is routing to a local run.
+func drain(e *echo.Echo, sig os.Signal) {
+	draining.Store(true)
+	e.Server.SetKeepAlivesEnabled(false)
+	e.TLSServer.SetKeepAlivesEnabled(false)
+
+	if sig == syscall.SIGTERM && shutdownDelay > 0 {
+		infof("Failing readiness for %s before draining", shutdownDelay)
+		time.Sleep(shutdownDelay)
+	}
+	infof("Draining %d in-flight requests", inFlightRequests.Load())
+}
+
+// checkReadiness runs every readiness check. The map holds "ok" or why
+// the check failed for each, failing the names of the checks that make the
+// pod not ready.
+func checkReadiness(ctx context.Context) (checks map[string]string, failing []string) {
+	checks = map[string]string{}
+	fail := func(name, reason string) {
+		checks[name] = reason
+		failing = append(failing, name)
+	}
+
+	if ok, reason := certReady(); !ok {
+		fail("certificate", reason)
+	} else {
+		checks["certificate"] = "ok"
+	}
+
+	if !warmedUp.Load() {
+		fail("warmup", "warming up")
+	} else {
+		checks["warmup"] = "ok"
+	}
+
+	if draining.Load() {
+		fail("drain", "shutting down")
+	} else {
+		checks["drain"] = "ok"
+	}
+
+	// Without REDIS_ADDR the pod runs on local metrics and Redis isn't a
+	// dependency at all. While the circuit breaker is open there's no point
+	// pinging, the reconnect loop already does.
+	if redisClient == nil {
+		checks["redis"] = "not configured, using local metrics"
+	} else {
+		err := simulatedOutage(failFeatureStore)
+		if err == nil {
+			err = breaker.state()
+		}
+		if err == nil {
+			pingCtx, cancel := context.WithTimeout(ctx, readyzRedisTimeout)
+			err = redisClient.Ping(pingCtx).Err()
+			cancel()
+		}
+		switch {
+		case err == nil:
+			checks["redis"] = "ok"
+		case readyzRequireRedis:
+			fail("redis", err.Error())
+		default:
+			checks["redis"] = fmt.Sprintf("%v (not required)", err)
+		}
+	}
+
+	sort.Strings(failing)
+	return checks, failing
+}
+
+func readyzHandler(c echo.Context) error {
+	checks, failing := checkReadiness(c.Request().Context())
+
+	statusCode := http.StatusOK
+	status := "ready"
+	if len(failing) > 0 {
+		statusCode = http.StatusServiceUnavailable
+		status = "not ready"
+	}
+
+	httpRequestsTotal.WithLabelValues("/api/readyz", fmt.Sprintf("%d", statusCode)).Inc()
+	return c.JSON(statusCode, map[string]interface{}{
+		"status":  status,
+		"checks":  checks,
+		"failing": append([]string{}, failing...),
+		// Rounded up, so it doesn't read 0 while still warming up
+		"warmupSecondsRemaining": math.Ceil(warmupRemaining().Seconds()),
+	})
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/redis/go-redis/v9"
+)
+
+// The circuit breaker keeps a Redis outage from costing every request a
+// dial timeout. After REDIS_BREAKER_FAILURES connection errors in a row
+// commands fail fast with errRedisCircuitOpen, while a background loop
+// pings Redis with exponential backoff, from REDIS_RECONNECT_MIN_SECONDS up
+// to REDIS_RECONNECT_MAX_SECONDS, and closes the breaker once it answers.
+// This also covers Redis being down when the pod starts.
+var (
+	redisBreakerFailures = int(getEnvFloatOrDefault("REDIS_BREAKER_FAILURES", 5))
+	redisReconnectMin    = time.Duration(getEnvFloatOrDefault("REDIS_RECONNECT_MIN_SECONDS", 1) * float64(time.Second))
+	redisReconnectMax    = time.Duration(getEnvFloatOrDefault("REDIS_RECONNECT_MAX_SECONDS", 30) * float64(time.Second))
+
+	errRedisCircuitOpen = errors.New("redis circuit breaker open")
+
+	breaker = &redisBreaker{}
+
+	redisUp = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "redis_up",
+			Help: "1 when the primary Redis is reachable, 0 while the circuit breaker is open",
+		},
+	)
+	redisBreakerTransitionsTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "redis_breaker_transitions_total",
+			Help: "Circuit breaker state changes by the state entered",
+		},
+		[]string{"state"},
+	)
+)
+
+type redisBreaker struct {
+	mu       sync.Mutex
+	open     bool
+	failures int
+	lastErr  error
+	openedAt time.Time
+	// Signalled when the breaker opens, so reconnection starts right away
+	opened chan struct{}
+}
+
+func init() {
+	breaker.opened = make(chan struct{}, 1)
+}
+
+// redisProbeKey marks the reconnect loop's pings, which go through while
+// the breaker is open.
+type redisProbeKey struct{}
+
+// connectionError reports whether err means Redis couldn't be reached, as
+// opposed to a reply such as a missing key or a wrong type, or the caller
+// giving up.
+func connectionError(err error) bool {
+	var reply redis.Error
+	switch {
+	case err == nil, errors.Is(err, redis.Nil), errors.Is(err, errRedisCircuitOpen):
+		return false
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		return false
+	case errors.As(err, &reply):
+		return false
+	}
+	return true
+}
+
+func (b *redisBreaker) allow(ctx context.Context) error {
+	if ctx.Value(redisProbeKey{}) != nil {
+		return nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.open {
+		return errRedisCircuitOpen
+	}
+	return nil
+}
+
+func (b *redisBreaker) record(err error) {
+	if !connectionError(err) {
+		if err == nil || !errors.Is(err, errRedisCircuitOpen) {
+			b.mu.Lock()
+			b.failures = 0
+			b.mu.Unlock()
+		}
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures++
+	b.lastErr = err
+	if !b.open && b.failures >= redisBreakerFailures {
+		b.tripLocked(err)
+	}
+}
+
+// trip opens the breaker straight away, e.g. when the first ping fails.
+func (b *redisBreaker) trip(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.lastErr = err
+	if !b.open {
+		b.tripLocked(err)
+	}
+}
+
+func (b *redisBreaker) tripLocked(err error) {
+	b.open, b.openedAt = true, time.Now()
+	redisUp.Set(0)
+	redisBreakerTransitionsTotal.WithLabelValues("open").Inc()
+	warnf("Redis unavailable, failing fast until it is back: %v", err)
+	select {
+	case b.opened <- struct{}{}:
+	default:
+	}
+}
+
+func (b *redisBreaker) reset() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures, b.lastErr = 0, nil
+	redisUp.Set(1)
+	if b.open {
+		b.open = false
+		redisBreakerTransitionsTotal.WithLabelValues("closed").Inc()
+		infof("Reconnected to Redis after %s", time.Since(b.openedAt).Round(time.Second))
+	}
+}
+
+// state is nil while Redis is usable, otherwise why it isn't.
+func (b *redisBreaker) state() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !b.open {
+		return nil
+	}
+	return fmt.Errorf("circuit open since %s: %v", b.openedAt.UTC().Format(time.RFC3339), b.lastErr)
+}
+
+// redisBreakerHook is added last, closest to the connection, so the
+// metrics and tracing hooks also see the fast failures.
+type redisBreakerHook struct{}
+
+func (redisBreakerHook) DialHook(next redis.DialHook) redis.DialHook {
+	return next
+}
+
+func (redisBreakerHook) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		if err := breaker.allow(ctx); err != nil {
+			cmd.SetErr(err)
+			return err
+		}
+		err := next(ctx, cmd)
+		breaker.record(err)
+		return err
+	}
+}
+
+func (redisBreakerHook) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return func(ctx context.Context, cmds []redis.Cmder) error {
+		if err := breaker.allow(ctx); err != nil {
+			for _, cmd := range cmds {
+				cmd.SetErr(err)
+			}
+			return err
+		}
+		err := next(ctx, cmds)
+		breaker.record(err)
+		return err
+	}
+}
+
+var _ redis.Hook = redisBreakerHook{}
+
+// runRedisReconnect pings Redis while the breaker is open, backing off
+// exponentially between attempts.
+func runRedisReconnect(stop <-chan struct{}) {
+	for {
+		select {
+		case <-stop:
+			return
+		case <-breaker.opened:
+		}
+
+		backoff := redisReconnectMin
+		for breaker.state() != nil {
+			select {
+			case <-stop:
+				return
+			case <-time.After(backoff):
+			}
+			ctx, cancel := context.WithTimeout(context.WithValue(redisCtx, redisProbeKey{}, true), readyzRedisTimeout)
+			err := redisClient.Ping(ctx).Err()
+			cancel()
+			if err == nil {
+				breaker.reset()
+				break
+			}
+			debugf("Redis reconnect attempt failed, retrying in %s: %v", backoff, err)
+			backoff = min(backoff*2, redisReconnectMax)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/redis/go-redis/v9"
+)
+
+var (
+	redisCommandDuration = promauto.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "redis_command_duration_seconds",
+			Help:    "Redis command latency by store and command, pipelines are recorded as a single \"pipeline\" command",
+			Buckets: []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 3},
+		},
+		[]string{"store", "command"},
+	)
+	redisCommandErrorsTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "redis_command_errors_total",
+			Help: "Failed Redis commands by store and command, cache misses excluded",
+		},
+		[]string{"store", "command"},
+	)
+)
+
+// redisMetricsHook records dependency latency and errors, so a slow Redis is
+// visible separately from app-injected latency and failures.
+type redisMetricsHook struct {
+	store string // "primary" or "secondary"
+}
+
+func (redisMetricsHook) DialHook(next redis.DialHook) redis.DialHook {
+	return next
+}
+
+func (h redisMetricsHook) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		start := time.Now()
+		err := next(ctx, cmd)
+		elapsed := time.Since(start)
+		redisCommandDuration.WithLabelValues(h.store, cmd.Name()).Observe(elapsed.Seconds())
+		addServerTiming(ctx, timingRedis, elapsed)
+		if err != nil && !errors.Is(err, redis.Nil) {
+			redisCommandErrorsTotal.WithLabelValues(h.store, cmd.Name()).Inc()
+		}
+		return err
+	}
+}
+
+func (h redisMetricsHook) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return func(ctx context.Context, cmds []redis.Cmder) error {
+		start := time.Now()
+		err := next(ctx, cmds)
+		elapsed := time.Since(start)
+		redisCommandDuration.WithLabelValues(h.store, "pipeline").Observe(elapsed.Seconds())
+		addServerTiming(ctx, timingRedis, elapsed)
+		for _, cmd := range cmds {
+			if cmdErr := cmd.Err(); cmdErr != nil && !errors.Is(cmdErr, redis.Nil) {
+				redisCommandErrorsTotal.WithLabelValues(h.store, cmd.Name()).Inc()
+			}
+		}
+		return err
+	}
+}
+
+var _ redis.Hook = redisMetricsHook{}
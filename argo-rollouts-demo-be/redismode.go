@@ -0,0 +1,214 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// REDIS_MODE picks how REDIS_ADDR is read:
+//
+//	standalone  a single host:port (the default)
+//	sentinel    comma-separated Sentinel addresses; REDIS_MASTER_NAME names
+//	            the monitored master, REDIS_SENTINEL_PASSWORD authenticates
+//	            to the Sentinels when they require it
+//	cluster     comma-separated seed nodes of a Redis Cluster; keys aren't
+//	            hash-tagged, so reads and deletes spanning keys go through
+//	            getKeys and deleteKeys rather than MGET or a multi-key DEL
+//
+// Managed Redis usually also needs credentials and TLS: REDIS_PASSWORD (a
+// rotating secret, see Secret), REDIS_USERNAME for ACL users, REDIS_DB, and
+// REDIS_TLS=true with optional REDIS_TLS_CA_FILE, REDIS_TLS_CERT_FILE and
+// REDIS_TLS_KEY_FILE for a client certificate, REDIS_TLS_SERVER_NAME and
+// REDIS_TLS_INSECURE_SKIP_VERIFY.
+const (
+	redisModeStandalone = "standalone"
+	redisModeSentinel   = "sentinel"
+	redisModeCluster    = "cluster"
+)
+
+var (
+	redisMode             = getEnvOrDefault("REDIS_MODE", redisModeStandalone)
+	redisMasterName       = getEnvOrDefault("REDIS_MASTER_NAME", "mymaster")
+	redisSentinelPassword = getEnvOrDefault("REDIS_SENTINEL_PASSWORD", "")
+	redisUsername         = getEnvOrDefault("REDIS_USERNAME", "")
+	redisDB               = getEnvOrDefault("REDIS_DB", "0")
+
+	redisTLS                   = isTruthy(getEnvOrDefault("REDIS_TLS", "false"))
+	redisTLSCAFile             = getEnvOrDefault("REDIS_TLS_CA_FILE", "")
+	redisTLSCertFile           = getEnvOrDefault("REDIS_TLS_CERT_FILE", "")
+	redisTLSKeyFile            = getEnvOrDefault("REDIS_TLS_KEY_FILE", "")
+	redisTLSServerName         = getEnvOrDefault("REDIS_TLS_SERVER_NAME", "")
+	redisTLSInsecureSkipVerify = isTruthy(getEnvOrDefault("REDIS_TLS_INSECURE_SKIP_VERIFY", "false"))
+)
+
+// redisTLSConfig is nil unless REDIS_TLS is enabled.
+func redisTLSConfig() (*tls.Config, error) {
+	if !redisTLS {
+		return nil, nil
+	}
+	cfg := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		ServerName:         redisTLSServerName,
+		InsecureSkipVerify: redisTLSInsecureSkipVerify,
+	}
+	if redisTLSCAFile != "" {
+		pem, err := os.ReadFile(redisTLSCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("reading REDIS_TLS_CA_FILE: %w", err)
+		}
+		cfg.RootCAs = x509.NewCertPool()
+		if !cfg.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("REDIS_TLS_CA_FILE %s has no PEM certificates", redisTLSCAFile)
+		}
+	}
+	if redisTLSCertFile != "" || redisTLSKeyFile != "" {
+		if redisTLSCertFile == "" || redisTLSKeyFile == "" {
+			return nil, errors.New("REDIS_TLS_CERT_FILE and REDIS_TLS_KEY_FILE must be set together")
+		}
+		cert, err := tls.LoadX509KeyPair(redisTLSCertFile, redisTLSKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("loading Redis client certificate: %w", err)
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	return cfg, nil
+}
+
+// newRedisClient builds the primary store's client for REDIS_MODE.
+func newRedisClient() (redis.UniversalClient, error) {
+	addrs := splitList(redisAddr)
+	if len(addrs) == 0 {
+		return nil, fmt.Errorf("REDIS_ADDR is empty")
+	}
+	db, err := strconv.Atoi(redisDB)
+	if err != nil || db < 0 {
+		return nil, fmt.Errorf("REDIS_DB must be a non-negative integer, not %q", redisDB)
+	}
+	tlsConfig, err := redisTLSConfig()
+	if err != nil {
+		return nil, err
+	}
+	// Read on every new connection so a rotated password is picked up
+	credentials := func() (string, string) {
+		return redisUsername, redisPassword.Value()
+	}
+
+	switch redisMode {
+	case redisModeStandalone:
+		if len(addrs) > 1 {
+			return nil, fmt.Errorf("REDIS_ADDR lists %d addresses, set REDIS_MODE to sentinel or cluster", len(addrs))
+		}
+		return redis.NewClient(&redis.Options{
+			Addr:                addrs[0],
+			CredentialsProvider: credentials,
+			DB:                  db,
+			TLSConfig:           tlsConfig,
+			DialTimeout:         5 * time.Second,
+			ReadTimeout:         3 * time.Second,
+			WriteTimeout:        3 * time.Second,
+		}), nil
+	case redisModeSentinel:
+		return redis.NewFailoverClient(&redis.FailoverOptions{
+			MasterName:          redisMasterName,
+			SentinelAddrs:       addrs,
+			SentinelPassword:    redisSentinelPassword,
+			CredentialsProvider: credentials,
+			DB:                  db,
+			TLSConfig:           tlsConfig,
+			DialTimeout:         5 * time.Second,
+			ReadTimeout:         3 * time.Second,
+			WriteTimeout:        3 * time.Second,
+		}), nil
+	case redisModeCluster:
+		if db != 0 {
+			return nil, errors.New("REDIS_DB must be 0 in cluster mode, Redis Cluster has a single database")
+		}
+		return redis.NewClusterClient(&redis.ClusterOptions{
+			Addrs:               addrs,
+			CredentialsProvider: credentials,
+			TLSConfig:           tlsConfig,
+			DialTimeout:         5 * time.Second,
+			ReadTimeout:         3 * time.Second,
+			WriteTimeout:        3 * time.Second,
+		}), nil
+	default:
+		return nil, fmt.Errorf("REDIS_MODE must be %s, %s or %s, not %q",
+			redisModeStandalone, redisModeSentinel, redisModeCluster, redisMode)
+	}
+}
+
+// getKeys reads string keys like MGET, nil for a missing key. The keys of a
+// family (status_<code>:v<version>, success_window:...) hash to different
+// cluster slots, where MGET fails with CROSSSLOT, so they go out as one
+// pipeline of GETs that the cluster client splits by node.
+func getKeys(ctx context.Context, client redis.UniversalClient, keys []string) ([]interface{}, error) {
+	pipe := client.Pipeline()
+	cmds := make([]*redis.StringCmd, len(keys))
+	for i, key := range keys {
+		cmds[i] = pipe.Get(ctx, key)
+	}
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		return nil, err
+	}
+	values := make([]interface{}, len(keys))
+	for i, cmd := range cmds {
+		value, err := cmd.Result()
+		if err == redis.Nil {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		values[i] = value
+	}
+	return values, nil
+}
+
+// deleteKeys deletes keys one DEL each in a pipeline, for the same reason.
+func deleteKeys(ctx context.Context, client redis.UniversalClient, keys []string) error {
+	pipe := client.Pipeline()
+	for _, key := range keys {
+		pipe.Del(ctx, key)
+	}
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// scanKeys returns the keys matching pattern. A cluster spreads keys over
+// its masters and SCAN only sees the node it runs on, so each is scanned.
+func scanKeys(ctx context.Context, client redis.UniversalClient, pattern string) ([]string, error) {
+	scan := func(ctx context.Context, node redis.Cmdable) ([]string, error) {
+		var keys []string
+		iter := node.Scan(ctx, 0, pattern, 100).Iterator()
+		for iter.Next(ctx) {
+			keys = append(keys, iter.Val())
+		}
+		return keys, iter.Err()
+	}
+
+	cluster, ok := client.(*redis.ClusterClient)
+	if !ok {
+		return scan(ctx, client)
+	}
+	var (
+		mu   sync.Mutex
+		keys []string
+	)
+	err := cluster.ForEachMaster(ctx, func(ctx context.Context, node *redis.Client) error {
+		nodeKeys, err := scan(ctx, node)
+		mu.Lock()
+		keys = append(keys, nodeKeys...)
+		mu.Unlock()
+		return err
+	})
+	return keys, err
+}
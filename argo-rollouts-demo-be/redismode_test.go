@@ -0,0 +1,46 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// The counter keys of one family land on different cluster slots, so
+// reading and resetting them must not use multi-key commands.
+func TestRedisCountersInClusterMode(t *testing.T) {
+	useCounterStore(t, newFakeRedis(t, true), redisCounterStore{})
+	ctx := context.Background()
+
+	if err := counterStore.Incr(ctx, map[int]int64{http.StatusOK: 3, http.StatusInternalServerError: 1}); err != nil {
+		t.Fatalf("Incr: %v", err)
+	}
+
+	byVersion, err := counterStore.GetCounts(ctx)
+	if err != nil {
+		t.Fatalf("GetCounts: %v", err)
+	}
+	if got := byVersion[version]; got["200"] != 3 || got["500"] != 1 {
+		t.Fatalf("counts for %s = %v, want 3 200s and 1 500", version, got)
+	}
+
+	succeeded, total, err := redisSuccessWindow(ctx, redisClient, version, time.Now(), 5*time.Second)
+	if err != nil {
+		t.Fatalf("redisSuccessWindow: %v", err)
+	}
+	if succeeded != 3 || total != 4 {
+		t.Fatalf("success window = %d/%d, want 3/4", succeeded, total)
+	}
+
+	if err := counterStore.Reset(ctx); err != nil {
+		t.Fatalf("Reset: %v", err)
+	}
+	byVersion, err = counterStore.GetCounts(ctx)
+	if err != nil {
+		t.Fatalf("GetCounts after reset: %v", err)
+	}
+	if len(byVersion) != 0 {
+		t.Fatalf("counts after reset = %v, want none", byVersion)
+	}
+}
@@ -0,0 +1,275 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+const instancesKey = "instances"
+
+// Instance is a pod's entry in the Redis-backed instance registry. Entries
+// expire unless refreshed by the pod's heartbeat, so crashed pods drop out.
+type Instance struct {
+	Pod       string    `json:"pod"`
+	Version   string    `json:"version"`
+	BuildHash string    `json:"buildHash"`
+	StartedAt time.Time `json:"startedAt"`
+	LastSeen  time.Time `json:"lastSeen"`
+	Addr      string    `json:"addr,omitempty"` // Where peers reach the pod, host:port
+	Ready     bool      `json:"ready"`          // As of the last heartbeat, like /api/readyz
+}
+
+type ClusterCounts struct {
+	Status200 float64 `json:"200"`
+	Status500 float64 `json:"500"` // Every failed check, as in StatusCounts
+	Total     float64 `json:"total"`
+	ErrorRate float64 `json:"errorRate"` // Percentage
+}
+
+type PodCounts struct {
+	Instance
+	ClusterCounts
+}
+
+type ClusterMetrics struct {
+	Totals    ClusterCounts            `json:"totals"`
+	ByVersion map[string]ClusterCounts `json:"byVersion"`
+	ByPod     []PodCounts              `json:"byPod"`
+	Source    string                   `json:"source"` // "redis" or "local"
+}
+
+var (
+	startTime         = time.Now().UTC()
+	instanceHeartbeat = time.Duration(getEnvFloatOrDefault("INSTANCE_HEARTBEAT_SECONDS", 5) * float64(time.Second))
+
+	// INSTANCE_ADDR overrides the address advertised to peers, by default
+	// POD_IP (from the downward API) on the listen port
+	instanceAddrOverride = getEnvOrDefault("INSTANCE_ADDR", "")
+	podIP                = getEnvOrDefault("POD_IP", "")
+)
+
+// instanceAddr is the address this pod advertises in the registry, empty
+// when peers have no way to reach it.
+func instanceAddr() string {
+	if instanceAddrOverride != "" {
+		return instanceAddrOverride
+	}
+	if podIP == "" {
+		return ""
+	}
+	_, port, err := net.SplitHostPort(listenAddr)
+	if err != nil {
+		port = "8080"
+	}
+	return net.JoinHostPort(podIP, port)
+}
+
+func instanceKey(pod string) string {
+	return fmt.Sprintf("instance:%s", pod)
+}
+
+func podCountsKey(pod string) string {
+	return fmt.Sprintf("pod_counts:%s", pod)
+}
+
+func registerInstance() error {
+	_, failing := checkReadiness(redisCtx)
+	ready := len(failing) == 0
+	key := instanceKey(podName)
+	pipe := redisClient.TxPipeline()
+	pipe.HSet(redisCtx, key, map[string]interface{}{
+		"version":   version,
+		"buildHash": buildHash,
+		"startedAt": startTime.Format(time.RFC3339Nano),
+		"lastSeen":  time.Now().UTC().Format(time.RFC3339Nano),
+		"addr":      instanceAddr(),
+		"ready":     strconv.FormatBool(ready),
+	})
+	pipe.Expire(redisCtx, key, 3*instanceHeartbeat)
+	pipe.SAdd(redisCtx, instancesKey, podName)
+	_, err := pipe.Exec(redisCtx)
+	return err
+}
+
+// runInstanceHeartbeat keeps this pod's registry entry alive until stop is
+// closed, then removes it so peers stop counting it immediately.
+func runInstanceHeartbeat(stop <-chan struct{}) {
+	if err := registerInstance(); err != nil {
+		warnf("Failed to register instance: %v", err)
+	}
+
+	ticker := time.NewTicker(instanceHeartbeat)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			deregisterInstance()
+			return
+		case <-ticker.C:
+			if err := registerInstance(); err != nil {
+				warnf("Failed to refresh instance registration: %v", err)
+			}
+		}
+	}
+}
+
+func deregisterInstance() {
+	pipe := redisClient.TxPipeline()
+	pipe.Del(redisCtx, instanceKey(podName))
+	pipe.SRem(redisCtx, instancesKey, podName)
+	if _, err := pipe.Exec(redisCtx); err != nil {
+		warnf("Failed to deregister instance: %v", err)
+	}
+}
+
+// listInstances returns the live instances, pruning registry members whose
+// heartbeat has expired.
+func listInstances() ([]Instance, error) {
+	pods, err := redisClient.SMembers(redisCtx, instancesKey).Result()
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(pods)
+
+	instances := make([]Instance, 0, len(pods))
+	for _, pod := range pods {
+		fields, err := redisClient.HGetAll(redisCtx, instanceKey(pod)).Result()
+		if err != nil {
+			return nil, err
+		}
+		if len(fields) == 0 {
+			redisClient.SRem(redisCtx, instancesKey, pod)
+			continue
+		}
+		startedAt, _ := time.Parse(time.RFC3339Nano, fields["startedAt"])
+		lastSeen, _ := time.Parse(time.RFC3339Nano, fields["lastSeen"])
+		instances = append(instances, Instance{
+			Pod:       pod,
+			Version:   fields["version"],
+			BuildHash: fields["buildHash"],
+			StartedAt: startedAt,
+			LastSeen:  lastSeen,
+			Addr:      fields["addr"],
+			Ready:     fields["ready"] != "false",
+		})
+	}
+	return instances, nil
+}
+
+func localInstance() Instance {
+	_, failing := checkReadiness(redisCtx)
+	ready := len(failing) == 0
+	return Instance{
+		Pod:       podName,
+		Version:   version,
+		BuildHash: buildHash,
+		StartedAt: startTime,
+		LastSeen:  time.Now().UTC(),
+		Addr:      instanceAddr(),
+		Ready:     ready,
+	}
+}
+
+func newClusterCounts(count200, count500 float64) ClusterCounts {
+	counts := ClusterCounts{Status200: count200, Status500: count500, Total: count200 + count500}
+	if counts.Total > 0 {
+		counts.ErrorRate = count500 / counts.Total * 100.0
+	}
+	return counts
+}
+
+func clusterMetrics() (ClusterMetrics, error) {
+	result := ClusterMetrics{
+		ByVersion: map[string]ClusterCounts{},
+		ByPod:     []PodCounts{},
+	}
+
+	if redisClient == nil {
+		folded := statusCountsOf(getStatusCounts())
+		counts := newClusterCounts(folded.Status200, folded.Status500)
+		result.Totals = counts
+		result.ByVersion[version] = counts
+		result.ByPod = append(result.ByPod, PodCounts{Instance: localInstance(), ClusterCounts: counts})
+		result.Source = "local"
+		return result, nil
+	}
+
+	instances, err := listInstances()
+	if err != nil {
+		return result, err
+	}
+
+	var total200, total500 float64
+	byVersion := map[string][2]float64{}
+	for _, instance := range instances {
+		fields, err := redisClient.HGetAll(redisCtx, podCountsKey(instance.Pod)).Result()
+		if err != nil {
+			return result, err
+		}
+		podCounts := make(map[string]float64, len(fields))
+		for status, value := range fields {
+			podCounts[status], _ = strconv.ParseFloat(value, 64)
+		}
+		folded := statusCountsOf(podCounts)
+		count200, count500 := folded.Status200, folded.Status500
+
+		total200 += count200
+		total500 += count500
+		v := byVersion[instance.Version]
+		byVersion[instance.Version] = [2]float64{v[0] + count200, v[1] + count500}
+		result.ByPod = append(result.ByPod, PodCounts{Instance: instance, ClusterCounts: newClusterCounts(count200, count500)})
+	}
+
+	result.Totals = newClusterCounts(total200, total500)
+	for v, counts := range byVersion {
+		result.ByVersion[v] = newClusterCounts(counts[0], counts[1])
+	}
+	result.Source = "redis"
+	return result, nil
+}
+
+// resetPodCounts clears the per-pod counters of every registered instance.
+func resetPodCounts() error {
+	pods, err := redisClient.SMembers(redisCtx, instancesKey).Result()
+	if err != nil {
+		return err
+	}
+	keys := []string{podCountsKey(podName)}
+	for _, pod := range pods {
+		keys = append(keys, podCountsKey(pod))
+	}
+	return deleteKeys(redisCtx, redisClient, keys)
+}
+
+func instancesHandler(c echo.Context) error {
+	instances := []Instance{localInstance()}
+	if redisClient != nil {
+		registered, err := listInstances()
+		if err != nil {
+			httpRequestsTotal.WithLabelValues("/api/instances", fmt.Sprintf("%d", http.StatusServiceUnavailable)).Inc()
+			return c.JSON(http.StatusServiceUnavailable, map[string]string{"error": err.Error()})
+		}
+		instances = registered
+	}
+
+	httpRequestsTotal.WithLabelValues("/api/instances", fmt.Sprintf("%d", http.StatusOK)).Inc()
+	return c.JSON(http.StatusOK, instances)
+}
+
+func clusterMetricsHandler(c echo.Context) error {
+	metrics, err := clusterMetrics()
+	if err != nil {
+		httpRequestsTotal.WithLabelValues("/api/metrics/cluster", fmt.Sprintf("%d", http.StatusServiceUnavailable)).Inc()
+		return c.JSON(http.StatusServiceUnavailable, map[string]string{"error": err.Error()})
+	}
+
+	httpRequestsTotal.WithLabelValues("/api/metrics/cluster", fmt.Sprintf("%d", http.StatusOK)).Inc()
+	return c.JSON(http.StatusOK, metrics)
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+	"github.com/redis/go-redis/v9"
+)
+
+const maxRequestIDLength = 128
+
+type requestIDKey struct{}
+
+// requestIDFromContext returns the ID of the request ctx belongs to, which
+// also rides along on Redis commands and outbound calls made for it.
+func requestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// validRequestID accepts IDs made of the characters UUIDs, ULIDs and
+// proxy-generated IDs use, so a client can't inject into log lines or
+// headers.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for _, r := range id {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+		case r == '-', r == '_', r == '.', r == ':':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// withRequestID keeps the caller's X-Request-ID when it is valid and makes
+// a new one otherwise.
+func withRequestID(ctx context.Context, incoming string) (context.Context, string) {
+	id := incoming
+	if !validRequestID(id) {
+		id = randomHex(16)
+	}
+	return context.WithValue(ctx, requestIDKey{}, id), id
+}
+
+// requestIDMiddleware runs first in e.Pre so every response, 404s and
+// injected failures included, carries an X-Request-ID to correlate the
+// frontend, the access log and the backend's own log lines.
+func requestIDMiddleware(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		req := c.Request()
+		ctx, id := withRequestID(req.Context(), req.Header.Get(echo.HeaderXRequestID))
+		c.SetRequest(req.WithContext(ctx))
+		c.Response().Header().Set(echo.HeaderXRequestID, id)
+		return next(c)
+	}
+}
+
+// injectRequestID passes the request ID in ctx on to an outbound request.
+func injectRequestID(ctx context.Context, req *http.Request) {
+	if id := requestIDFromContext(ctx); id != "" {
+		req.Header.Set(echo.HeaderXRequestID, id)
+	}
+}
+
+// requestContextHandler adds the request ID to every entry logged with a
+// request's context.
+type requestContextHandler struct {
+	slog.Handler
+}
+
+func (h requestContextHandler) Handle(ctx context.Context, r slog.Record) error {
+	if id := requestIDFromContext(ctx); id != "" {
+		r.AddAttrs(slog.String("request_id", id))
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h requestContextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return requestContextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h requestContextHandler) WithGroup(name string) slog.Handler {
+	return requestContextHandler{h.Handler.WithGroup(name)}
+}
+
+// redisRequestIDHook logs failed Redis commands with the ID of the request
+// they were made for.
+type redisRequestIDHook struct{}
+
+func (redisRequestIDHook) DialHook(next redis.DialHook) redis.DialHook {
+	return next
+}
+
+func (redisRequestIDHook) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		err := next(ctx, cmd)
+		if err != nil && err != redis.Nil && requestIDFromContext(ctx) != "" {
+			debugContextf(ctx, "redis: %s failed: %v", cmd.Name(), err)
+		}
+		return err
+	}
+}
+
+func (redisRequestIDHook) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return func(ctx context.Context, cmds []redis.Cmder) error {
+		err := next(ctx, cmds)
+		if err != nil && err != redis.Nil && requestIDFromContext(ctx) != "" {
+			debugContextf(ctx, "redis: pipeline of %d commands failed: %v", len(cmds), err)
+		}
+		return err
+	}
+}
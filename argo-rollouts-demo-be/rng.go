@@ -0,0 +1,104 @@
+package main
+
+import (
+	crand "crypto/rand"
+	"encoding/binary"
+	"math/rand/v2"
+	"strconv"
+	"sync"
+	"sync/atomic"
+)
+
+// RNG_MODE selects how goroutines get random numbers:
+//   - pooled (default): each worker borrows its own source from a sync.Pool,
+//     so concurrent requests never wait on each other
+//   - shared: a single source behind a mutex, the original behavior, kept for
+//     comparison under load
+var rngMode = getEnvOrDefault("RNG_MODE", "pooled")
+
+// RNG_SEED (an unsigned integer) seeds every source from it and the order
+// sources are created in, so a single-worker run is reproducible in either
+// mode. Pooled sources are then kept on a free list instead of the
+// sync.Pool, which drops them at any GC. Unset, sources are seeded from
+// crypto/rand.
+var (
+	rngSeed, rngSeedErr = strconv.ParseUint(getEnvOrDefault("RNG_SEED", ""), 10, 64)
+	rngSeeded           = rngSeedErr == nil
+	rngSources          atomic.Uint64
+)
+
+type rngSource struct {
+	chacha *rand.ChaCha8
+	rand   *rand.Rand
+}
+
+var (
+	rngPool = sync.Pool{New: func() any { return newRNGSource() }}
+
+	// Pooled sources when RNG_SEED is set, most recently released last
+	seededRNGs   []*rngSource
+	seededRNGsMu sync.Mutex
+
+	sharedRNG   = newRNGSource()
+	sharedRNGMu sync.Mutex
+)
+
+func newRNGSource() *rngSource {
+	var seed [32]byte
+	if rngSeeded {
+		binary.LittleEndian.PutUint64(seed[:], rngSeed)
+		binary.LittleEndian.PutUint64(seed[8:], rngSources.Add(1))
+	} else {
+		crand.Read(seed[:])
+	}
+	chacha := rand.NewChaCha8(seed)
+	return &rngSource{chacha: chacha, rand: rand.New(chacha)}
+}
+
+// acquireRNG returns a source for the calling goroutine's exclusive use until
+// releaseRNG.
+func acquireRNG() *rngSource {
+	switch {
+	case rngMode == "shared":
+		sharedRNGMu.Lock()
+		return sharedRNG
+	case rngSeeded:
+		seededRNGsMu.Lock()
+		defer seededRNGsMu.Unlock()
+		if n := len(seededRNGs); n > 0 {
+			source := seededRNGs[n-1]
+			seededRNGs = seededRNGs[:n-1]
+			return source
+		}
+		return newRNGSource()
+	}
+	return rngPool.Get().(*rngSource)
+}
+
+func releaseRNG(source *rngSource) {
+	switch {
+	case rngMode == "shared":
+		sharedRNGMu.Unlock()
+	case rngSeeded:
+		seededRNGsMu.Lock()
+		seededRNGs = append(seededRNGs, source)
+		seededRNGsMu.Unlock()
+	default:
+		rngPool.Put(source)
+	}
+}
+
+// randomFloat returns a uniform float in [0, 1).
+func randomFloat() float64 {
+	source := acquireRNG()
+	defer releaseRNG(source)
+	return source.rand.Float64()
+}
+
+// randomBytes fills buf with random bytes. Not for secrets, nor for IDs,
+// which RNG_SEED would make repeat across pods.
+func randomBytes(buf []byte) {
+	source := acquireRNG()
+	defer releaseRNG(source)
+	source.chacha.Read(buf)
+}
@@ -0,0 +1,83 @@
+package main
+
+import "testing"
+
+// useRNGMode switches RNG_MODE until the test or benchmark ends.
+func useRNGMode(tb testing.TB, mode string) {
+	previous := rngMode
+	rngMode = mode
+	tb.Cleanup(func() { rngMode = previous })
+}
+
+// BenchmarkRandomFloat compares the mutex-guarded shared source with pooled
+// per-worker sources under parallel load, e.g.
+//
+//	go test -run '^$' -bench RandomFloat -cpu 1,4,16
+func BenchmarkRandomFloat(b *testing.B) {
+	for _, mode := range []string{"shared", "pooled"} {
+		b.Run(mode, func(b *testing.B) {
+			useRNGMode(b, mode)
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					randomFloat()
+				}
+			})
+		})
+	}
+}
+
+func TestRandomFloatRange(t *testing.T) {
+	for _, mode := range []string{"shared", "pooled"} {
+		t.Run(mode, func(t *testing.T) {
+			useRNGMode(t, mode)
+			for i := 0; i < 10000; i++ {
+				if v := randomFloat(); v < 0 || v >= 1 {
+					t.Fatalf("randomFloat() = %v, want [0, 1)", v)
+				}
+			}
+		})
+	}
+}
+
+// With RNG_SEED a single worker replays the same sequence from the pooled
+// sources, which a sync.Pool can't promise.
+func TestSeededPooledRNGReplays(t *testing.T) {
+	useRNGMode(t, "pooled")
+	previousSeed, previousSeeded, previousSources := rngSeed, rngSeeded, seededRNGs
+	t.Cleanup(func() { rngSeed, rngSeeded, seededRNGs = previousSeed, previousSeeded, previousSources })
+	rngSeed, rngSeeded = 42, true
+
+	run := func() []float64 {
+		seededRNGs = nil
+		rngSources.Store(0)
+		values := make([]float64, 100)
+		for i := range values {
+			values[i] = randomFloat()
+		}
+		return values
+	}
+	first, second := run(), run()
+	for i := range first {
+		if first[i] != second[i] {
+			t.Fatalf("value %d: %v then %v", i, first[i], second[i])
+		}
+	}
+}
+
+// IDs must differ between pods even when every pod's simulation sources
+// start out the same.
+func TestRandomHexIgnoresSeed(t *testing.T) {
+	useRNGMode(t, "pooled")
+	previousSeed, previousSeeded, previousSources := rngSeed, rngSeeded, seededRNGs
+	t.Cleanup(func() { rngSeed, rngSeeded, seededRNGs = previousSeed, previousSeeded, previousSources })
+	rngSeed, rngSeeded = 42, true
+
+	podStart := func() string {
+		seededRNGs = nil
+		rngSources.Store(0)
+		return randomHex(16)
+	}
+	if a, b := podStart(), podStart(); a == b {
+		t.Fatalf("two pods both made ID %s", a)
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+var (
+	// Rollout object this pod belongs to, usually from the downward API
+	rolloutName       = getEnvOrDefault("ROLLOUT_NAME", "")
+	rolloutAbortToken = newSecret("rollout-abort-token", "ROLLOUT_ABORT_TOKEN")
+)
+
+func init() {
+	secrets = append(secrets, rolloutAbortToken)
+}
+
+// abortRollout aborts the pod's own Rollout the same way
+// `kubectl argo rollouts abort` does: a merge patch on the status subresource.
+func abortRollout(ctx context.Context) error {
+	kube, err := newInClusterKubeClient()
+	if err != nil {
+		return err
+	}
+	path := fmt.Sprintf("/apis/argoproj.io/v1alpha1/namespaces/%s/rollouts/%s/status", kube.namespace, rolloutName)
+	return kube.patch(ctx, path, "application/merge-patch+json", []byte(`{"status":{"abort":true}}`))
+}
+
+// abortRolloutHandler is the demo's "big red button". It is disabled unless
+// both ROLLOUT_NAME and ROLLOUT_ABORT_TOKEN are set, and requires the token
+// as a bearer token.
+func abortRolloutHandler(c echo.Context) error {
+	token := rolloutAbortToken.Value()
+	if rolloutName == "" || token == "" {
+		httpRequestsTotal.WithLabelValues("/api/rollout/abort", fmt.Sprintf("%d", http.StatusForbidden)).Inc()
+		return c.JSON(http.StatusForbidden, map[string]string{"error": "Rollout abort is not enabled"})
+	}
+
+	provided := strings.TrimPrefix(c.Request().Header.Get("Authorization"), "Bearer ")
+	if subtle.ConstantTimeCompare([]byte(provided), []byte(token)) != 1 {
+		httpRequestsTotal.WithLabelValues("/api/rollout/abort", fmt.Sprintf("%d", http.StatusUnauthorized)).Inc()
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Invalid or missing token"})
+	}
+
+	if err := abortRollout(c.Request().Context()); err != nil {
+		warnf("Failed to abort rollout %s: %v", rolloutName, err)
+		httpRequestsTotal.WithLabelValues("/api/rollout/abort", fmt.Sprintf("%d", http.StatusBadGateway)).Inc()
+		return c.JSON(http.StatusBadGateway, map[string]string{"error": err.Error()})
+	}
+
+	infof("Aborted rollout %s", rolloutName)
+
+	// Show up in the active run's recap and on the metrics timeline
+	event := RunEvent{
+		Timestamp: time.Now().UTC(),
+		Type:      "aborted",
+		Message:   fmt.Sprintf("Rollout %s aborted from pod %s", rolloutName, podName),
+	}
+	runsMu.Lock()
+	if activeRun != nil {
+		activeRun.Events = append(activeRun.Events, event)
+	}
+	runsMu.Unlock()
+	if err := addAnnotation(Annotation(event)); err != nil {
+		warnf("Failed to annotate rollout abort: %v", err)
+	}
+
+	httpRequestsTotal.WithLabelValues("/api/rollout/abort", fmt.Sprintf("%d", http.StatusOK)).Inc()
+	return c.JSON(http.StatusOK, map[string]string{"message": fmt.Sprintf("Rollout %s aborted", rolloutName)})
+}
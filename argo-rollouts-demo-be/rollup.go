@@ -0,0 +1,420 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	rollupKeyPrefix      = "rollup:"
+	rollupEventBuffer    = 4096
+	defaultRollupMinutes = 60
+)
+
+// Upper bounds of the latency histogram in milliseconds. The last bucket is
+// open-ended, so a minute's histogram has len(rollupBucketsMs)+1 counts.
+var rollupBucketsMs = []float64{1, 2, 5, 10, 25, 50, 100, 250, 500, 1000, 2500, 5000}
+
+// RollupMinute is the per-minute summary of /api/check traffic on one pod.
+// Field names are short because one is stored per pod per minute in Redis.
+type RollupMinute struct {
+	Minute  int64            `json:"t"` // Unix seconds at the start of the minute
+	Version string           `json:"v"`
+	Counts  map[string]int64 `json:"c"` // By status code
+	Buckets []int64          `json:"b"` // Latency histogram over rollupBucketsMs
+	P50     float64          `json:"p50"`
+	P95     float64          `json:"p95"`
+	P99     float64          `json:"p99"`
+}
+
+// TimeseriesPoint is one minute of the timeseries endpoint, merged across
+// pods. Percentiles are recomputed from the merged histogram.
+type TimeseriesPoint struct {
+	Time      time.Time        `json:"time"`
+	Counts    map[string]int64 `json:"counts"`
+	Total     int64            `json:"total"`
+	ErrorRate float64          `json:"errorRate"` // Percentage
+	P50       float64          `json:"p50"`       // Milliseconds
+	P95       float64          `json:"p95"`
+	P99       float64          `json:"p99"`
+}
+
+type Timeseries struct {
+	Source      string            `json:"source"` // "redis" or "local"
+	Points      []TimeseriesPoint `json:"points"`
+	Annotations []Annotation      `json:"annotations"`
+}
+
+type Heatmap struct {
+	Source    string      `json:"source"`
+	BucketsMs []float64   `json:"bucketsMs"` // Upper bounds, the last row is everything above
+	Times     []time.Time `json:"times"`
+	Counts    [][]int64   `json:"counts"` // Counts[i][j]: minute i, bucket j
+}
+
+// rollupEvent is one entry in the raw event stream. Batched checks have no
+// per-check latency, so they only add to the counts.
+type rollupEvent struct {
+	status  int
+	n       int64
+	latency time.Duration
+	timed   bool
+}
+
+var (
+	rollupRetention = time.Duration(getEnvFloatOrDefault("ROLLUP_RETENTION_MINUTES", 1440)) * time.Minute
+
+	rollupEvents = make(chan rollupEvent, rollupEventBuffer)
+
+	// Completed minutes of this pod, served when Redis isn't available
+	rollupLocal   []RollupMinute
+	rollupLocalMu sync.RWMutex
+
+	rollupEventsDropped = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: "rollup_events_dropped_total",
+			Help: "Check events dropped because the rollup worker fell behind",
+		},
+	)
+	rollupFlushErrors = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: "rollup_flush_errors_total",
+			Help: "Per-minute rollups that could not be written to Redis",
+		},
+	)
+)
+
+func rollupKey(pod string) string {
+	return rollupKeyPrefix + pod
+}
+
+// recordRollupEvent queues a check outcome for the rollup worker without
+// blocking the request; events are dropped when the buffer is full.
+func recordRollupEvent(event rollupEvent) {
+	select {
+	case rollupEvents <- event:
+	default:
+		rollupEventsDropped.Inc()
+	}
+}
+
+// rollupBucket is the in-progress aggregate of the current minute.
+type rollupBucket struct {
+	minute  time.Time
+	counts  map[int]int64
+	buckets []int64
+}
+
+func newRollupBucket(minute time.Time) *rollupBucket {
+	return &rollupBucket{
+		minute:  minute,
+		counts:  map[int]int64{},
+		buckets: make([]int64, len(rollupBucketsMs)+1),
+	}
+}
+
+func (b *rollupBucket) add(event rollupEvent) {
+	b.counts[event.status] += event.n
+	if !event.timed {
+		return
+	}
+	ms := float64(event.latency) / float64(time.Millisecond)
+	b.buckets[sort.SearchFloat64s(rollupBucketsMs, ms)] += event.n
+}
+
+func (b *rollupBucket) empty() bool {
+	return len(b.counts) == 0
+}
+
+func (b *rollupBucket) summary() RollupMinute {
+	summary := RollupMinute{
+		Minute:  b.minute.Unix(),
+		Version: version,
+		Counts:  map[string]int64{},
+		Buckets: b.buckets,
+		P50:     histogramQuantile(b.buckets, 0.50),
+		P95:     histogramQuantile(b.buckets, 0.95),
+		P99:     histogramQuantile(b.buckets, 0.99),
+	}
+	for status, n := range b.counts {
+		summary.Counts[strconv.Itoa(status)] = n
+	}
+	return summary
+}
+
+// histogramQuantile estimates a quantile in milliseconds by interpolating
+// linearly inside the bucket it falls in, like PromQL's histogram_quantile.
+// Observations in the open-ended bucket are reported at the highest bound.
+func histogramQuantile(buckets []int64, q float64) float64 {
+	var total int64
+	for _, n := range buckets {
+		total += n
+	}
+	if total == 0 {
+		return 0
+	}
+
+	rank := q * float64(total)
+	var cumulative int64
+	for i, n := range buckets {
+		if n == 0 || float64(cumulative+n) < rank {
+			cumulative += n
+			continue
+		}
+		if i == len(rollupBucketsMs) {
+			return rollupBucketsMs[i-1]
+		}
+		lower := 0.0
+		if i > 0 {
+			lower = rollupBucketsMs[i-1]
+		}
+		upper := rollupBucketsMs[i]
+		return lower + (upper-lower)*(rank-float64(cumulative))/float64(n)
+	}
+	return rollupBucketsMs[len(rollupBucketsMs)-1]
+}
+
+// runRollupWorker drains the event stream into per-minute buckets and writes
+// each completed minute once, so readers never have to scan raw events.
+func runRollupWorker(stop <-chan struct{}) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	current := newRollupBucket(time.Now().UTC().Truncate(time.Minute))
+	for {
+		select {
+		case <-stop:
+			return
+		case event := <-rollupEvents:
+			current = advanceRollup(current, time.Now())
+			current.add(event)
+		case now := <-ticker.C:
+			current = advanceRollup(current, now)
+		}
+	}
+}
+
+// advanceRollup flushes the current bucket once its minute is over and
+// returns the bucket for now.
+func advanceRollup(current *rollupBucket, now time.Time) *rollupBucket {
+	minute := now.UTC().Truncate(time.Minute)
+	if !minute.After(current.minute) {
+		return current
+	}
+	flushRollup(current)
+	return newRollupBucket(minute)
+}
+
+// flushRollup stores a completed minute locally and in Redis, trimming
+// entries older than ROLLUP_RETENTION_MINUTES.
+func flushRollup(bucket *rollupBucket) {
+	if bucket.empty() {
+		return
+	}
+	summary := bucket.summary()
+	cutoff := bucket.minute.Add(-rollupRetention).Unix()
+
+	rollupLocalMu.Lock()
+	rollupLocal = append(rollupLocal, summary)
+	trim := 0
+	for trim < len(rollupLocal) && rollupLocal[trim].Minute <= cutoff {
+		trim++
+	}
+	rollupLocal = rollupLocal[trim:]
+	rollupLocalMu.Unlock()
+
+	if redisClient == nil {
+		return
+	}
+	encoded, err := json.Marshal(summary)
+	if err != nil {
+		rollupFlushErrors.Inc()
+		return
+	}
+	key := rollupKey(podName)
+	pipe := redisClient.Pipeline()
+	pipe.ZAdd(redisCtx, key, redis.Z{Score: float64(summary.Minute), Member: encoded})
+	pipe.ZRemRangeByScore(redisCtx, key, "-inf", strconv.FormatInt(cutoff, 10))
+	// Pods that go away stop refreshing their key and it expires with them
+	pipe.Expire(redisCtx, key, rollupRetention)
+	if _, err := pipe.Exec(redisCtx); err != nil {
+		rollupFlushErrors.Inc()
+		warnf("Failed to write rollup for %s: %v", bucket.minute.Format(time.RFC3339), err)
+	}
+}
+
+// loadRollups returns the stored minutes of every pod since the given time,
+// from Redis when available and this pod's local history otherwise.
+func loadRollups(since time.Time) ([]RollupMinute, string, error) {
+	if redisClient == nil {
+		rollupLocalMu.RLock()
+		defer rollupLocalMu.RUnlock()
+		minutes := []RollupMinute{}
+		for _, minute := range rollupLocal {
+			if minute.Minute >= since.Unix() {
+				minutes = append(minutes, minute)
+			}
+		}
+		return minutes, "local", nil
+	}
+
+	keys, err := scanKeys(redisCtx, redisClient, rollupKeyPrefix+"*")
+	if err != nil {
+		return nil, "", err
+	}
+
+	minutes := []RollupMinute{}
+	for _, key := range keys {
+		members, err := redisClient.ZRangeByScore(redisCtx, key, &redis.ZRangeBy{
+			Min: strconv.FormatInt(since.Unix(), 10),
+			Max: "+inf",
+		}).Result()
+		if err != nil {
+			return nil, "", err
+		}
+		for _, member := range members {
+			var minute RollupMinute
+			if err := json.Unmarshal([]byte(member), &minute); err != nil {
+				continue
+			}
+			minutes = append(minutes, minute)
+		}
+	}
+	return minutes, "redis", nil
+}
+
+// mergeRollups sums the pods' summaries per minute, optionally restricted to
+// one version, and returns them oldest first.
+func mergeRollups(minutes []RollupMinute, versionFilter string) []*rollupBucket {
+	merged := map[int64]*rollupBucket{}
+	for _, minute := range minutes {
+		if versionFilter != "" && minute.Version != versionFilter {
+			continue
+		}
+		bucket, ok := merged[minute.Minute]
+		if !ok {
+			bucket = newRollupBucket(time.Unix(minute.Minute, 0).UTC())
+			merged[minute.Minute] = bucket
+		}
+		for status, n := range minute.Counts {
+			code, err := strconv.Atoi(status)
+			if err != nil {
+				continue
+			}
+			bucket.counts[code] += n
+		}
+		for i, n := range minute.Buckets {
+			if i < len(bucket.buckets) {
+				bucket.buckets[i] += n
+			}
+		}
+	}
+
+	result := make([]*rollupBucket, 0, len(merged))
+	for _, bucket := range merged {
+		result = append(result, bucket)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].minute.Before(result[j].minute) })
+	return result
+}
+
+func timeseriesPoint(bucket *rollupBucket) TimeseriesPoint {
+	summary := bucket.summary()
+	point := TimeseriesPoint{
+		Time:   bucket.minute,
+		Counts: summary.Counts,
+		P50:    summary.P50,
+		P95:    summary.P95,
+		P99:    summary.P99,
+	}
+	for _, n := range summary.Counts {
+		point.Total += n
+	}
+	if point.Total > 0 {
+		var errors int64
+		for status, n := range summary.Counts {
+			if strings.HasPrefix(status, "5") {
+				errors += n
+			}
+		}
+		point.ErrorRate = float64(errors) / float64(point.Total) * 100.0
+	}
+	return point
+}
+
+// rollupWindow parses ?minutes, bounded by the retention period.
+func rollupWindow(c echo.Context) (time.Time, error) {
+	minutes := defaultRollupMinutes
+	if value := c.QueryParam("minutes"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		limit := int(math.Max(rollupRetention.Minutes(), 1))
+		if err != nil || parsed < 1 || parsed > limit {
+			return time.Time{}, fmt.Errorf("minutes must be between 1 and %d", limit)
+		}
+		minutes = parsed
+	}
+	return time.Now().UTC().Truncate(time.Minute).Add(-time.Duration(minutes) * time.Minute), nil
+}
+
+func timeseriesHandler(c echo.Context) error {
+	since, err := rollupWindow(c)
+	if err != nil {
+		httpRequestsTotal.WithLabelValues("/api/timeseries", fmt.Sprintf("%d", http.StatusBadRequest)).Inc()
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+
+	minutes, source, err := loadRollups(since)
+	if err != nil {
+		httpRequestsTotal.WithLabelValues("/api/timeseries", fmt.Sprintf("%d", http.StatusInternalServerError)).Inc()
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to load timeseries"})
+	}
+
+	annotations, err := loadAnnotations(since)
+	if err != nil {
+		httpRequestsTotal.WithLabelValues("/api/timeseries", fmt.Sprintf("%d", http.StatusInternalServerError)).Inc()
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to load annotations"})
+	}
+
+	result := Timeseries{Source: source, Points: []TimeseriesPoint{}, Annotations: annotations}
+	for _, bucket := range mergeRollups(minutes, c.QueryParam("version")) {
+		result.Points = append(result.Points, timeseriesPoint(bucket))
+	}
+
+	httpRequestsTotal.WithLabelValues("/api/timeseries", fmt.Sprintf("%d", http.StatusOK)).Inc()
+	return c.JSON(http.StatusOK, result)
+}
+
+func heatmapHandler(c echo.Context) error {
+	since, err := rollupWindow(c)
+	if err != nil {
+		httpRequestsTotal.WithLabelValues("/api/timeseries/heatmap", fmt.Sprintf("%d", http.StatusBadRequest)).Inc()
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+
+	minutes, source, err := loadRollups(since)
+	if err != nil {
+		httpRequestsTotal.WithLabelValues("/api/timeseries/heatmap", fmt.Sprintf("%d", http.StatusInternalServerError)).Inc()
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to load timeseries"})
+	}
+
+	result := Heatmap{Source: source, BucketsMs: rollupBucketsMs, Times: []time.Time{}, Counts: [][]int64{}}
+	for _, bucket := range mergeRollups(minutes, c.QueryParam("version")) {
+		result.Times = append(result.Times, bucket.minute)
+		result.Counts = append(result.Counts, bucket.buckets)
+	}
+
+	httpRequestsTotal.WithLabelValues("/api/timeseries/heatmap", fmt.Sprintf("%d", http.StatusOK)).Inc()
+	return c.JSON(http.StatusOK, result)
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"argo-rollouts-demo-be/internal/weighted"
+
+	"github.com/labstack/echo/v4"
+)
+
+// RouteFault injects latency and errors into a single route, set through
+// the routes of POST /api/set-error-rate so one endpoint of a split can fail
+// while the others stay healthy:
+//
+//	{"value": 0, "routes": {"/api/products": {"errorRate": 50, "latencyMs": 200}}}
+//
+// Routes are matched on the request path, a key ending in "*" by prefix,
+// the longest match winning. They are applied by the "faults" middleware
+// after the global faults, skip the same exempt paths and, like /api/faults,
+// only apply on the pod that was told. They are part of /api/state/export.
+type RouteFault struct {
+	ErrorRate float64 `json:"errorRate"` // Percentage
+	LatencyMs float64 `json:"latencyMs"`
+}
+
+var (
+	routeFaults   = map[string]RouteFault{}
+	routeFaultsMu sync.RWMutex
+)
+
+func (f RouteFault) validate() error {
+	if err := validateErrorRatePercent(f.ErrorRate); err != nil {
+		return err
+	}
+	if math.IsNaN(f.LatencyMs) || f.LatencyMs < 0 || f.LatencyMs > maxCheckLatencyMs {
+		return fmt.Errorf("latencyMs must be between 0 and %d", maxCheckLatencyMs)
+	}
+	return nil
+}
+
+func validateRouteFaults(routes map[string]RouteFault) error {
+	for route, fault := range routes {
+		if !strings.HasPrefix(route, "/") {
+			return fmt.Errorf("route %q must start with /", route)
+		}
+		if err := fault.validate(); err != nil {
+			return fmt.Errorf("route %s: %w", route, err)
+		}
+	}
+	return nil
+}
+
+func currentRouteFaults() map[string]RouteFault {
+	routeFaultsMu.RLock()
+	defer routeFaultsMu.RUnlock()
+	snapshot := make(map[string]RouteFault, len(routeFaults))
+	for route, fault := range routeFaults {
+		snapshot[route] = fault
+	}
+	return snapshot
+}
+
+// setRouteFaults replaces every route fault, an empty map clears them.
+func setRouteFaults(routes map[string]RouteFault) {
+	snapshot := make(map[string]RouteFault, len(routes))
+	for route, fault := range routes {
+		if fault.ErrorRate > 0 || fault.LatencyMs > 0 {
+			snapshot[route] = fault
+		}
+	}
+	routeFaultsMu.Lock()
+	routeFaults = snapshot
+	routeFaultsMu.Unlock()
+
+	keys := make([]string, 0, len(snapshot))
+	for route := range snapshot {
+		keys = append(keys, route)
+	}
+	sort.Strings(keys)
+	infof("Route faults set for %d routes: %s", len(keys), strings.Join(keys, ", "))
+}
+
+// routeFaultFor finds the fault for a request path, reporting the key that
+// matched.
+func routeFaultFor(path string) (string, RouteFault, bool) {
+	routeFaultsMu.RLock()
+	defer routeFaultsMu.RUnlock()
+	if fault, ok := routeFaults[path]; ok {
+		return path, fault, true
+	}
+	var match string
+	var matched RouteFault
+	for route, fault := range routeFaults {
+		if prefix, ok := strings.CutSuffix(route, "*"); ok && strings.HasPrefix(path, prefix) && len(route) > len(match) {
+			match, matched = route, fault
+		}
+	}
+	return match, matched, match != ""
+}
+
+func routeFaultsMiddleware(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		path := c.Request().URL.Path
+		if chaosBypassed(c.Request().Context()) {
+			return next(c)
+		}
+		route, fault, ok := routeFaultFor(path)
+		if !ok {
+			return next(c)
+		}
+
+		// The controls stay reachable, or the fault couldn't be cleared
+		faultConfigMu.RLock()
+		exempt := faultConfig.ExemptPaths
+		faultConfigMu.RUnlock()
+		if strings.HasPrefix(path, "/api/faults") || path == "/api/set-error-rate" {
+			return next(c)
+		}
+		if isFaultExempt(path, exempt) {
+			faultsExemptedTotal.WithLabelValues(routeLabel(c)).Inc()
+			if fault.LatencyMs > 0 {
+				recordFaultRule("route-latency", faultOutcomeExempt, 100)
+			}
+			if fault.ErrorRate > 0 {
+				recordFaultRule("route-fault", faultOutcomeExempt, fault.ErrorRate)
+			}
+			return next(c)
+		}
+
+		endpoint := routeLabel(c)
+		if fault.LatencyMs > 0 {
+			faultsInjectedTotal.WithLabelValues(endpoint, "latency").Inc()
+			recordFaultRule("route-latency", faultOutcomeApplied, 100)
+			start := time.Now()
+			select {
+			case <-time.After(time.Duration(fault.LatencyMs * float64(time.Millisecond))):
+			case <-c.Request().Context().Done():
+				return c.Request().Context().Err()
+			}
+			addServerTiming(c.Request().Context(), timingDelay, time.Since(start))
+		}
+
+		if fault.ErrorRate > 0 {
+			failed := weighted.Chance(fault.ErrorRate/100.0, randomFloat())
+			if failed && !allowInjectedFailure("route-fault") {
+				recordFaultRule("route-fault", faultOutcomeCapped, fault.ErrorRate)
+				return next(c)
+			}
+			if !failed {
+				recordFaultRule("route-fault", faultOutcomeSampling, fault.ErrorRate)
+				return next(c)
+			}
+			recordFaultRule("route-fault", faultOutcomeApplied, fault.ErrorRate)
+			debugContextf(c.Request().Context(), "faults: injected error on %s (route %s)", path, route)
+			faultsInjectedTotal.WithLabelValues(endpoint, "error").Inc()
+			recordInjectedFailure(c.Request().Context(), endpoint, "route-fault", http.StatusInternalServerError,
+				fmt.Sprintf("route=%s probability=%.4f", route, fault.ErrorRate/100.0))
+			httpRequestsTotal.WithLabelValues(endpoint, fmt.Sprintf("%d", http.StatusInternalServerError)).Inc()
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Injected fault"})
+		}
+
+		return next(c)
+	}
+}
@@ -0,0 +1,360 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+const maxRunHistory = 20
+
+// Run is a named demo session. While a run is active the server samples the
+// /api/check counters periodically so a summary can be produced at the end.
+type Run struct {
+	ID        string       `json:"id"`
+	Name      string       `json:"name"`
+	StartedAt time.Time    `json:"startedAt"`
+	EndedAt   *time.Time   `json:"endedAt,omitempty"`
+	Baseline  StatusCounts `json:"baseline"`
+	Samples   []RunSample  `json:"samples"`
+	Events    []RunEvent   `json:"events"`
+
+	lastCounts StatusCounts // Counts at the previous sample, for interval deltas
+}
+
+type RunSample struct {
+	Timestamp        time.Time `json:"timestamp"`
+	Requests200      float64   `json:"requests200"`
+	Requests500      float64   `json:"requests500"`
+	ConfiguredRate   float64   `json:"configuredErrorRate"` // Percentage
+	ObservedRate     float64   `json:"observedErrorRate"`   // Percentage within the sample interval
+	IntervalRequests float64   `json:"intervalRequests"`
+	IntervalFailures float64   `json:"intervalFailures"`
+}
+
+type RunEvent struct {
+	Timestamp time.Time `json:"timestamp"`
+	Type      string    `json:"type"` // e.g. "promoted", "rollback", "note"
+	Message   string    `json:"message,omitempty"`
+}
+
+type RunSummary struct {
+	RunID             string      `json:"runId"`
+	Name              string      `json:"name"`
+	StartedAt         time.Time   `json:"startedAt"`
+	EndedAt           time.Time   `json:"endedAt"`
+	DurationSeconds   float64     `json:"durationSeconds"`
+	TotalRequests     float64     `json:"totalRequests"`
+	Requests200       float64     `json:"requests200"`
+	Requests500       float64     `json:"requests500"`
+	ErrorRate         float64     `json:"errorRate"` // Percentage over the whole run
+	ErrorRateOverTime []RunSample `json:"errorRateOverTime"`
+	RollbacksObserved int         `json:"rollbacksObserved"`
+	Events            []RunEvent  `json:"events"`
+	Version           string      `json:"version"`
+	Pod               string      `json:"pod"`
+}
+
+var (
+	runs              []*Run
+	activeRun         *Run
+	activeRunStop     chan struct{}
+	runsMu            sync.Mutex
+	runSampleInterval = time.Duration(getEnvFloatOrDefault("RUN_SAMPLE_INTERVAL_SECONDS", 10) * float64(time.Second))
+)
+
+func currentStatusCounts() StatusCounts {
+	return statusCountsOf(getStatusCounts())
+}
+
+func startRun(name string) (*Run, error) {
+	runsMu.Lock()
+	defer runsMu.Unlock()
+
+	if activeRun != nil {
+		return nil, fmt.Errorf("run %s is already active", activeRun.ID)
+	}
+
+	now := time.Now().UTC()
+	baseline := currentStatusCounts()
+	run := &Run{
+		ID:         fmt.Sprintf("run-%d", now.UnixNano()),
+		Name:       name,
+		StartedAt:  now,
+		Baseline:   baseline,
+		Samples:    []RunSample{},
+		Events:     []RunEvent{},
+		lastCounts: baseline,
+	}
+	activeRun = run
+	activeRunStop = make(chan struct{})
+	runs = append(runs, run)
+	if len(runs) > maxRunHistory {
+		runs = runs[len(runs)-maxRunHistory:]
+	}
+
+	go sampleRun(run, activeRunStop)
+	return run, nil
+}
+
+func sampleRun(run *Run, stop <-chan struct{}) {
+	ticker := time.NewTicker(runSampleInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			recordRunSample(run)
+		}
+	}
+}
+
+// recordRunSample appends a sample with totals relative to the run baseline
+// and the error rate observed since the previous sample.
+func recordRunSample(run *Run) {
+	counts := currentStatusCounts()
+
+	runsMu.Lock()
+	defer runsMu.Unlock()
+
+	// Counters may have been reset mid-run, in which case start over
+	previous := run.lastCounts
+	if counts.Status200 < previous.Status200 || counts.Status500 < previous.Status500 {
+		previous = StatusCounts{}
+		run.Baseline = StatusCounts{}
+	}
+
+	interval200 := counts.Status200 - previous.Status200
+	interval500 := counts.Status500 - previous.Status500
+	observed := 0.0
+	if interval200+interval500 > 0 {
+		observed = interval500 / (interval200 + interval500) * 100.0
+	}
+
+	run.Samples = append(run.Samples, RunSample{
+		Timestamp:        time.Now().UTC(),
+		Requests200:      counts.Status200 - run.Baseline.Status200,
+		Requests500:      counts.Status500 - run.Baseline.Status500,
+		ConfiguredRate:   getErrorRatePercent(),
+		ObservedRate:     observed,
+		IntervalRequests: interval200 + interval500,
+		IntervalFailures: interval500,
+	})
+	run.lastCounts = counts
+}
+
+func stopRun(id string) (*RunSummary, error) {
+	runsMu.Lock()
+	if activeRun == nil || activeRun.ID != id {
+		runsMu.Unlock()
+		return nil, fmt.Errorf("run %s is not active", id)
+	}
+	run := activeRun
+	close(activeRunStop)
+	activeRun = nil
+	activeRunStop = nil
+	runsMu.Unlock()
+
+	recordRunSample(run)
+
+	runsMu.Lock()
+	ended := time.Now().UTC()
+	run.EndedAt = &ended
+	summary := summarizeRun(run)
+	runsMu.Unlock()
+
+	return &summary, nil
+}
+
+// summarizeRun must be called with runsMu held.
+func summarizeRun(run *Run) RunSummary {
+	summary := summarizeRunTotals(run)
+	summary.ErrorRateOverTime = append([]RunSample(nil), run.Samples...)
+	return summary
+}
+
+// summarizeRunTotals is summarizeRun without the error rate series, for
+// exports that stream it.
+func summarizeRunTotals(run *Run) RunSummary {
+	ended := time.Now().UTC()
+	if run.EndedAt != nil {
+		ended = *run.EndedAt
+	}
+
+	var requests200, requests500 float64
+	if n := len(run.Samples); n > 0 {
+		requests200 = run.Samples[n-1].Requests200
+		requests500 = run.Samples[n-1].Requests500
+	}
+
+	errorRate := 0.0
+	if requests200+requests500 > 0 {
+		errorRate = requests500 / (requests200 + requests500) * 100.0
+	}
+
+	rollbacks := 0
+	for _, event := range run.Events {
+		if event.Type == "rollback" || event.Type == "aborted" {
+			rollbacks++
+		}
+	}
+
+	return RunSummary{
+		RunID:             run.ID,
+		Name:              run.Name,
+		StartedAt:         run.StartedAt,
+		EndedAt:           ended,
+		DurationSeconds:   ended.Sub(run.StartedAt).Seconds(),
+		TotalRequests:     requests200 + requests500,
+		Requests200:       requests200,
+		Requests500:       requests500,
+		ErrorRate:         errorRate,
+		RollbacksObserved: rollbacks,
+		Events:            append([]RunEvent(nil), run.Events...),
+		Version:           version,
+		Pod:               podName,
+	}
+}
+
+func findRun(id string) *Run {
+	for _, run := range runs {
+		if run.ID == id {
+			return run
+		}
+	}
+	return nil
+}
+
+func listRunsHandler(c echo.Context) error {
+	runsMu.Lock()
+	summaries := make([]RunSummary, 0, len(runs))
+	for _, run := range runs {
+		summaries = append(summaries, summarizeRun(run))
+	}
+	runsMu.Unlock()
+
+	httpRequestsTotal.WithLabelValues("/api/runs", fmt.Sprintf("%d", http.StatusOK)).Inc()
+	return c.JSON(http.StatusOK, summaries)
+}
+
+func startRunHandler(c echo.Context) error {
+	var req struct {
+		Name string `json:"name"`
+	}
+	if c.Request().ContentLength != 0 {
+		if err := json.NewDecoder(c.Request().Body).Decode(&req); err != nil {
+			httpRequestsTotal.WithLabelValues("/api/runs", fmt.Sprintf("%d", http.StatusBadRequest)).Inc()
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid JSON"})
+		}
+	}
+
+	run, err := startRun(req.Name)
+	if err != nil {
+		httpRequestsTotal.WithLabelValues("/api/runs", fmt.Sprintf("%d", http.StatusConflict)).Inc()
+		return c.JSON(http.StatusConflict, map[string]string{"error": err.Error()})
+	}
+
+	httpRequestsTotal.WithLabelValues("/api/runs", fmt.Sprintf("%d", http.StatusCreated)).Inc()
+	return c.JSON(http.StatusCreated, map[string]string{"id": run.ID, "message": "Run started"})
+}
+
+func getRunHandler(c echo.Context) error {
+	runsMu.Lock()
+	run := findRun(c.Param("id"))
+	var summary RunSummary
+	if run != nil {
+		summary = summarizeRun(run)
+	}
+	runsMu.Unlock()
+
+	if run == nil {
+		httpRequestsTotal.WithLabelValues("/api/runs/:id", fmt.Sprintf("%d", http.StatusNotFound)).Inc()
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Run not found"})
+	}
+
+	httpRequestsTotal.WithLabelValues("/api/runs/:id", fmt.Sprintf("%d", http.StatusOK)).Inc()
+	return c.JSON(http.StatusOK, summary)
+}
+
+// exportRunHandler streams the run report, with the per-sample series written
+// incrementally and compressed with zstd or gzip when accepted.
+func exportRunHandler(c echo.Context) error {
+	// Samples are append-only, so the slice taken under the lock stays
+	// valid while it is streamed
+	runsMu.Lock()
+	run := findRun(c.Param("id"))
+	var summary RunSummary
+	var samples []RunSample
+	if run != nil {
+		summary = summarizeRunTotals(run)
+		samples = run.Samples
+	}
+	runsMu.Unlock()
+
+	if run == nil {
+		httpRequestsTotal.WithLabelValues("/api/runs/:id/export", fmt.Sprintf("%d", http.StatusNotFound)).Inc()
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Run not found"})
+	}
+
+	c.Response().Header().Set(echo.HeaderContentDisposition, fmt.Sprintf("attachment; filename=%s.json", summary.RunID))
+	httpRequestsTotal.WithLabelValues("/api/runs/:id/export", fmt.Sprintf("%d", http.StatusOK)).Inc()
+
+	stream := newJSONStream(c)
+	streamRunSummary(stream, summary, samples)
+	if err := stream.Close(); err != nil {
+		warnf("Run export interrupted: %v", err)
+	}
+	return nil
+}
+
+func stopRunHandler(c echo.Context) error {
+	summary, err := stopRun(c.Param("id"))
+	if err != nil {
+		httpRequestsTotal.WithLabelValues("/api/runs/:id/stop", fmt.Sprintf("%d", http.StatusConflict)).Inc()
+		return c.JSON(http.StatusConflict, map[string]string{"error": err.Error()})
+	}
+
+	// Deliver the recap without holding up the response
+	ctx := context.WithoutCancel(c.Request().Context())
+	go func() {
+		if err := sendRunDigest(ctx, *summary); err != nil {
+			warnf("Failed to send run digest: %v", err)
+		}
+	}()
+
+	httpRequestsTotal.WithLabelValues("/api/runs/:id/stop", fmt.Sprintf("%d", http.StatusOK)).Inc()
+	return c.JSON(http.StatusOK, summary)
+}
+
+func addRunEventHandler(c echo.Context) error {
+	var event RunEvent
+	if err := json.NewDecoder(c.Request().Body).Decode(&event); err != nil || event.Type == "" {
+		httpRequestsTotal.WithLabelValues("/api/runs/:id/events", fmt.Sprintf("%d", http.StatusBadRequest)).Inc()
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid JSON, type is required"})
+	}
+	if event.Timestamp.IsZero() {
+		event.Timestamp = time.Now().UTC()
+	}
+
+	runsMu.Lock()
+	run := findRun(c.Param("id"))
+	if run != nil {
+		run.Events = append(run.Events, event)
+	}
+	runsMu.Unlock()
+
+	if run == nil {
+		httpRequestsTotal.WithLabelValues("/api/runs/:id/events", fmt.Sprintf("%d", http.StatusNotFound)).Inc()
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Run not found"})
+	}
+
+	httpRequestsTotal.WithLabelValues("/api/runs/:id/events", fmt.Sprintf("%d", http.StatusOK)).Inc()
+	return c.JSON(http.StatusOK, map[string]string{"message": "Event recorded"})
+}
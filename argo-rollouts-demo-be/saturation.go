@@ -0,0 +1,180 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Status is a one-stop utilization snapshot for analysis templates and
+// dashboards that want more than the error rate.
+type Status struct {
+	Version           string    `json:"version"`
+	Flavor            string    `json:"flavor"` // "stable" or "canary" build
+	Pod               string    `json:"pod"`
+	StartedAt         time.Time `json:"startedAt"`
+	UptimeSeconds     float64   `json:"uptimeSeconds"`
+	ErrorRate         float64   `json:"errorRate"` // Effective percentage, including jitter
+	InFlightRequests  int64     `json:"inFlightRequests"`
+	ActiveConnections int64     `json:"activeConnections"`
+	ConcurrencyLimit  int64     `json:"concurrencyLimit"`
+	Saturation        float64   `json:"saturation"` // In-flight requests / concurrency limit
+
+	TimeBomb   *TimeBombStatus   `json:"timeBomb,omitempty"` // Only while the time bomb is enabled
+	Subsystems []SubsystemStatus `json:"subsystems"`
+}
+
+var (
+	inFlightRequests  atomic.Int64
+	activeConnections atomic.Int64
+
+	// Nominal request capacity of a pod, used as the saturation denominator
+	concurrencyLimit = int64(getEnvFloatOrDefault("CONCURRENCY_LIMIT", 100))
+
+	httpRequestDuration = promauto.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:        "http_request_duration_seconds",
+			Help:        "HTTP request latency by route and status code, including injected delays",
+			Buckets:     prometheus.DefBuckets,
+			ConstLabels: prometheus.Labels{"version": version},
+		},
+		[]string{"endpoint", "status_code"},
+	)
+)
+
+func init() {
+	promauto.NewGaugeFunc(
+		prometheus.GaugeOpts{
+			Name: "http_requests_in_flight",
+			Help: "Number of HTTP requests currently being served",
+		},
+		func() float64 { return float64(inFlightRequests.Load()) },
+	)
+	promauto.NewGaugeFunc(
+		prometheus.GaugeOpts{
+			Name: "http_active_connections",
+			Help: "Number of open client TCP connections",
+		},
+		func() float64 { return float64(activeConnections.Load()) },
+	)
+	promauto.NewGaugeFunc(
+		prometheus.GaugeOpts{
+			Name: "http_saturation_ratio",
+			Help: "In-flight requests divided by CONCURRENCY_LIMIT",
+		},
+		saturation,
+	)
+}
+
+func saturation() float64 {
+	if concurrencyLimit <= 0 {
+		return 0
+	}
+	return float64(inFlightRequests.Load()) / float64(concurrencyLimit)
+}
+
+// Context key holding the time the request entered the handler chain
+const requestStartKey = "requestStart"
+
+// inFlightMiddleware counts requests for the whole lifetime of the handler
+// chain. It is installed with e.Pre so it can't be reordered away.
+func inFlightMiddleware(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		c.Set(requestStartKey, time.Now())
+		inFlightRequests.Add(1)
+		defer inFlightRequests.Add(-1)
+		return next(c)
+	}
+}
+
+// requestDuration returns how long the request has been in the handler chain,
+// including any latency injected by middleware.
+func requestDuration(c echo.Context) time.Duration {
+	start, ok := c.Get(requestStartKey).(time.Time)
+	if !ok {
+		return 0
+	}
+	return time.Since(start)
+}
+
+// requestDurationMiddleware observes every request in
+// http_request_duration_seconds. It is installed with e.Pre, after
+// inFlightMiddleware set the start time, so the route is only known once the
+// handler chain returns.
+func requestDurationMiddleware(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		err := next(c)
+		if err != nil {
+			// Let the error handler write the response so its status is known
+			c.Error(err)
+		}
+
+		httpRequestDuration.WithLabelValues(routeLabel(c), fmt.Sprintf("%d", c.Response().Status)).Observe(requestDuration(c).Seconds())
+		return err
+	}
+}
+
+// routeLabel is the endpoint label of per-route metrics: the route pattern,
+// so path parameters don't multiply series, or "unmatched".
+func routeLabel(c echo.Context) string {
+	if endpoint := c.Path(); endpoint != "" {
+		return endpoint
+	}
+	return "unmatched"
+}
+
+// trackConnState is the http.Server ConnState hook keeping the active
+// connection gauge up to date.
+func trackConnState(conn net.Conn, state http.ConnState) {
+	trackPendingConn(conn, state)
+
+	switch state {
+	case http.StateNew:
+		activeConnections.Add(1)
+	case http.StateClosed, http.StateHijacked:
+		activeConnections.Add(-1)
+	}
+}
+
+func currentStatus() Status {
+	status := Status{
+		Version:           version,
+		Flavor:            buildFlavor,
+		Pod:               podName,
+		StartedAt:         startTime,
+		UptimeSeconds:     time.Since(startTime).Seconds(),
+		ErrorRate:         effectiveErrorRate() * 100.0,
+		InFlightRequests:  inFlightRequests.Load(),
+		ActiveConnections: activeConnections.Load(),
+		ConcurrencyLimit:  concurrencyLimit,
+		Saturation:        saturation(),
+		Subsystems:        subsystemStatuses(),
+	}
+	if timeBomb, ok := timeBombStatus(time.Now()); ok {
+		status.TimeBomb = &timeBomb
+	}
+	return status
+}
+
+func statusHandler(c echo.Context) error {
+	status := currentStatus()
+
+	// Uptime and the countdown change on every call, leave them out of the
+	// ETag
+	fingerprint := status
+	fingerprint.UptimeSeconds = 0
+	if status.TimeBomb != nil {
+		timeBomb := *status.TimeBomb
+		timeBomb.SecondsRemaining = 0
+		fingerprint.TimeBomb = &timeBomb
+	}
+
+	httpRequestsTotal.WithLabelValues("/api/status", fmt.Sprintf("%d", http.StatusOK)).Inc()
+	return conditionalJSON(c, "/api/status", status, fingerprint)
+}
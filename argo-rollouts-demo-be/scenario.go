@@ -0,0 +1,560 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"go.yaml.in/yaml/v2"
+)
+
+// Scenario is a scripted demo storyline. Each step performs exactly one
+// action, for example:
+//
+//	name: canary-goes-bad
+//	steps:
+//	  - start-load: {rps: 20, concurrency: 4}
+//	  - set-rate: 0
+//	  - wait: 30s
+//	  - assert-metric: {metric: error_rate, op: "<", value: 1}
+//	  - set-rate: 25
+//	  - wait: 30s
+//	  - assert-metric: {metric: error_rate, op: ">", value: 10}
+//	  - stop-load: true
+type Scenario struct {
+	Name  string         `yaml:"name" json:"name"`
+	Steps []ScenarioStep `yaml:"steps" json:"steps"`
+}
+
+type ScenarioStep struct {
+	SetRate      *float64           `yaml:"set-rate,omitempty" json:"set-rate,omitempty"` // Percentage
+	Wait         string             `yaml:"wait,omitempty" json:"wait,omitempty"`
+	StartLoad    *ScenarioLoad      `yaml:"start-load,omitempty" json:"start-load,omitempty"`
+	StopLoad     bool               `yaml:"stop-load,omitempty" json:"stop-load,omitempty"`
+	AssertMetric *ScenarioAssertion `yaml:"assert-metric,omitempty" json:"assert-metric,omitempty"`
+}
+
+type ScenarioLoad struct {
+	RPS         float64 `yaml:"rps" json:"rps"`
+	Concurrency int     `yaml:"concurrency" json:"concurrency"`
+	Target      string  `yaml:"target,omitempty" json:"target,omitempty"` // A path on the server, /api/check when omitted
+
+	// Replays a traffic profile peaking at RPS, e.g. {rps: 50, profile: diurnal, profile-period: 1h}
+	Profile       string `yaml:"profile,omitempty" json:"profile,omitempty"`
+	ProfilePeriod string `yaml:"profile-period,omitempty" json:"profile-period,omitempty"`
+}
+
+// ScenarioAssertion compares a metric measured since the scenario started
+// against a threshold.
+type ScenarioAssertion struct {
+	Metric string  `yaml:"metric" json:"metric"` // error_rate, requests, status_200, status_500
+	Op     string  `yaml:"op" json:"op"`         // <, <=, >, >=, ==
+	Value  float64 `yaml:"value" json:"value"`
+}
+
+type ScenarioStepResult struct {
+	Index    int       `json:"index"`
+	Action   string    `json:"action"`
+	Started  time.Time `json:"started"`
+	Finished time.Time `json:"finished"`
+	Passed   bool      `json:"passed"`
+	Detail   string    `json:"detail,omitempty"`
+}
+
+type ScenarioRun struct {
+	ID        string               `json:"id"`
+	Name      string               `json:"name"`
+	Status    string               `json:"status"` // running, passed, failed
+	StartedAt time.Time            `json:"startedAt"`
+	EndedAt   *time.Time           `json:"endedAt,omitempty"`
+	Steps     []ScenarioStepResult `json:"steps"`
+	Error     string               `json:"error,omitempty"`
+}
+
+// scenarioBackend is what a scenario drives: this process when run through
+// the API, or a remote server over HTTP when run from the CLI.
+type scenarioBackend interface {
+	SetErrorRate(ctx context.Context, percent float64) error
+	Counts(ctx context.Context) (StatusCounts, error)
+	URL(path string) string
+}
+
+var (
+	scenarioRuns   []*ScenarioRun
+	scenarioRunsMu sync.Mutex
+
+	// Cancelled on shutdown to end the scenarios started through the API,
+	// and with them their load generators
+	scenarioCtx, cancelScenarios = context.WithCancel(context.Background())
+	scenarioWG                   sync.WaitGroup
+)
+
+// stopScenarios cancels the running scenarios and waits for them to finish.
+func stopScenarios(ctx context.Context) error {
+	cancelScenarios()
+	done := make(chan struct{})
+	go func() {
+		scenarioWG.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func parseScenario(data []byte) (Scenario, error) {
+	var scenario Scenario
+	// YAML is a superset of JSON, so this accepts either
+	if err := yaml.UnmarshalStrict(data, &scenario); err != nil {
+		return scenario, fmt.Errorf("parsing scenario: %w", err)
+	}
+	if len(scenario.Steps) == 0 {
+		return scenario, errors.New("scenario has no steps")
+	}
+	for i, step := range scenario.Steps {
+		if _, err := step.action(); err != nil {
+			return scenario, fmt.Errorf("step %d: %w", i+1, err)
+		}
+	}
+	return scenario, nil
+}
+
+func (s ScenarioStep) action() (string, error) {
+	var actions []string
+	if s.SetRate != nil {
+		if err := validateErrorRatePercent(*s.SetRate); err != nil {
+			return "", fmt.Errorf("set-rate: %w", err)
+		}
+		actions = append(actions, "set-rate")
+	}
+	if s.Wait != "" {
+		if _, err := time.ParseDuration(s.Wait); err != nil {
+			return "", fmt.Errorf("invalid wait duration %q", s.Wait)
+		}
+		actions = append(actions, "wait")
+	}
+	if s.StartLoad != nil {
+		if s.StartLoad.RPS <= 0 {
+			return "", errors.New("start-load rps must be positive")
+		}
+		if s.StartLoad.RPS > loadgenAPIMaxRPS {
+			return "", fmt.Errorf("start-load rps must be at most %g", loadgenAPIMaxRPS)
+		}
+		if s.StartLoad.Concurrency < 0 || s.StartLoad.Concurrency > loadgenAPIMaxConcurrency {
+			return "", fmt.Errorf("start-load concurrency must be between 0 and %d", loadgenAPIMaxConcurrency)
+		}
+		if s.StartLoad.Profile != "" {
+			if _, err := lookupTrafficProfile(s.StartLoad.Profile); err != nil {
+				return "", err
+			}
+		}
+		if s.StartLoad.ProfilePeriod != "" {
+			if _, err := time.ParseDuration(s.StartLoad.ProfilePeriod); err != nil {
+				return "", fmt.Errorf("invalid profile-period %q", s.StartLoad.ProfilePeriod)
+			}
+		}
+		actions = append(actions, "start-load")
+	}
+	if s.StopLoad {
+		actions = append(actions, "stop-load")
+	}
+	if s.AssertMetric != nil {
+		switch s.AssertMetric.Metric {
+		case "error_rate", "requests", "status_200", "status_500":
+		default:
+			return "", fmt.Errorf("unknown metric %q", s.AssertMetric.Metric)
+		}
+		switch s.AssertMetric.Op {
+		case "<", "<=", ">", ">=", "==":
+		default:
+			return "", fmt.Errorf("unknown operator %q", s.AssertMetric.Op)
+		}
+		actions = append(actions, "assert-metric")
+	}
+
+	if len(actions) != 1 {
+		return "", fmt.Errorf("each step needs exactly one action, got %d", len(actions))
+	}
+	return actions[0], nil
+}
+
+// validateLocal rejects a scenario sending load anywhere but this server,
+// for scenarios run through the API.
+func (s Scenario) validateLocal() error {
+	for i, step := range s.Steps {
+		if step.StartLoad != nil && step.StartLoad.Target != "" && !strings.HasPrefix(step.StartLoad.Target, "/") {
+			return fmt.Errorf("step %d: start-load target must be a path on this server", i+1)
+		}
+	}
+	return nil
+}
+
+func compareMetric(actual float64, op string, expected float64) bool {
+	switch op {
+	case "<":
+		return actual < expected
+	case "<=":
+		return actual <= expected
+	case ">":
+		return actual > expected
+	case ">=":
+		return actual >= expected
+	case "==":
+		return actual == expected
+	}
+	return false
+}
+
+// runScenario executes every step in order, stopping at the first failure.
+// Load started by the scenario is always stopped before returning.
+func runScenario(ctx context.Context, scenario Scenario, backend scenarioBackend, run *ScenarioRun) error {
+	baseline, err := backend.Counts(ctx)
+	if err != nil {
+		return fmt.Errorf("reading baseline counters: %w", err)
+	}
+
+	stopLoad := func() {}
+	defer func() { stopLoad() }()
+
+	for i, step := range scenario.Steps {
+		action, _ := step.action()
+		result := ScenarioStepResult{Index: i + 1, Action: action, Started: time.Now().UTC(), Passed: true}
+
+		var stepErr error
+		switch action {
+		case "set-rate":
+			stepErr = backend.SetErrorRate(ctx, *step.SetRate)
+			result.Detail = fmt.Sprintf("error rate set to %g%%", *step.SetRate)
+
+		case "wait":
+			wait, _ := time.ParseDuration(step.Wait)
+			select {
+			case <-time.After(wait):
+			case <-ctx.Done():
+				stepErr = ctx.Err()
+			}
+			result.Detail = fmt.Sprintf("waited %s", wait)
+
+		case "start-load":
+			stopLoad()
+			stopLoad = func() {}
+			target := step.StartLoad.Target
+			if target == "" {
+				target = "/api/check"
+			}
+			if strings.HasPrefix(target, "/") {
+				target = backend.URL(target)
+			}
+			period, _ := time.ParseDuration(step.StartLoad.ProfilePeriod)
+			gen, err := newLoadGenerator(LoadGenConfig{
+				Target:        target,
+				RPS:           step.StartLoad.RPS,
+				Concurrency:   step.StartLoad.Concurrency,
+				Profile:       step.StartLoad.Profile,
+				ProfilePeriod: period,
+			})
+			if err != nil {
+				stepErr = err
+				break
+			}
+			stopLoad = startScenarioLoad(ctx, gen)
+			result.Detail = fmt.Sprintf("load started at %g rps against %s", step.StartLoad.RPS, target)
+			if step.StartLoad.Profile != "" {
+				result.Detail = fmt.Sprintf("load started replaying %s at %g peak rps against %s", step.StartLoad.Profile, step.StartLoad.RPS, target)
+			}
+
+		case "stop-load":
+			stopLoad()
+			stopLoad = func() {}
+			result.Detail = "load stopped"
+
+		case "assert-metric":
+			counts, err := backend.Counts(ctx)
+			if err != nil {
+				stepErr = err
+				break
+			}
+			delta200 := counts.Status200 - baseline.Status200
+			delta500 := counts.Status500 - baseline.Status500
+			var actual float64
+			switch step.AssertMetric.Metric {
+			case "error_rate":
+				if delta200+delta500 > 0 {
+					actual = delta500 / (delta200 + delta500) * 100.0
+				}
+			case "requests":
+				actual = delta200 + delta500
+			case "status_200":
+				actual = delta200
+			case "status_500":
+				actual = delta500
+			}
+			result.Passed = compareMetric(actual, step.AssertMetric.Op, step.AssertMetric.Value)
+			result.Detail = fmt.Sprintf("%s = %.2f, expected %s %g",
+				step.AssertMetric.Metric, actual, step.AssertMetric.Op, step.AssertMetric.Value)
+		}
+
+		if stepErr != nil {
+			result.Passed = false
+			result.Detail = stepErr.Error()
+		}
+		result.Finished = time.Now().UTC()
+
+		scenarioRunsMu.Lock()
+		run.Steps = append(run.Steps, result)
+		scenarioRunsMu.Unlock()
+
+		if !result.Passed {
+			return fmt.Errorf("step %d (%s) failed: %s", result.Index, action, result.Detail)
+		}
+	}
+	return nil
+}
+
+// startScenarioLoad runs the generator in the background and returns a
+// function that stops it and waits for in-flight requests. The finished run
+// is recorded for /api/load/assert.
+func startScenarioLoad(ctx context.Context, gen *loadGenerator) func() {
+	startedAt := time.Now().UTC()
+	gen.Start(ctx)
+
+	return func() {
+		gen.Stop(context.Background())
+		recordLoadRun(summarizeLoadRun("scenario", gen.cfg.Target, startedAt, gen.Stats()))
+	}
+}
+
+// localScenarioBackend drives this process directly.
+type localScenarioBackend struct{}
+
+func (localScenarioBackend) SetErrorRate(ctx context.Context, percent float64) error {
+	setManualErrorRate(ctx, percent, "a scenario")
+	return nil
+}
+
+func (localScenarioBackend) Counts(ctx context.Context) (StatusCounts, error) {
+	return currentStatusCounts(), nil
+}
+
+func (localScenarioBackend) URL(path string) string {
+	return localURL(path)
+}
+
+// httpScenarioBackend drives a remote server through its public API.
+type httpScenarioBackend struct {
+	baseURL string
+	client  *http.Client
+}
+
+func (b httpScenarioBackend) SetErrorRate(ctx context.Context, percent float64) error {
+	body, _ := json.Marshal(ErrorRate{Value: percent})
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, b.baseURL+"/api/set-error-rate", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if token := adminToken.Value(); token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("set-error-rate returned %d: %s", resp.StatusCode, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
+
+func (b httpScenarioBackend) Counts(ctx context.Context) (StatusCounts, error) {
+	var counts StatusCounts
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, b.baseURL+"/api/metrics", nil)
+	if err != nil {
+		return counts, err
+	}
+
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return counts, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return counts, fmt.Errorf("metrics returned %d", resp.StatusCode)
+	}
+	// Besides the counts by status code the body carries their source and
+	// consistency details, which aren't numbers
+	var body map[string]interface{}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return counts, err
+	}
+	byStatus := map[string]float64{}
+	for status, value := range body {
+		if n, ok := value.(float64); ok {
+			byStatus[status] = n
+		}
+	}
+	return statusCountsOf(byStatus), nil
+}
+
+func (b httpScenarioBackend) URL(path string) string {
+	return b.baseURL + path
+}
+
+func newScenarioRun(name string) *ScenarioRun {
+	return &ScenarioRun{
+		ID:        fmt.Sprintf("scenario-%d", time.Now().UnixNano()),
+		Name:      name,
+		Status:    "running",
+		StartedAt: time.Now().UTC(),
+		Steps:     []ScenarioStepResult{},
+	}
+}
+
+func finishScenarioRun(run *ScenarioRun, err error) {
+	scenarioRunsMu.Lock()
+	defer scenarioRunsMu.Unlock()
+
+	ended := time.Now().UTC()
+	run.EndedAt = &ended
+	run.Status = "passed"
+	if err != nil {
+		run.Status = "failed"
+		run.Error = err.Error()
+	}
+}
+
+func runScenarioHandler(c echo.Context) error {
+	data, err := io.ReadAll(c.Request().Body)
+	if err != nil {
+		httpRequestsTotal.WithLabelValues("/api/scenarios/run", fmt.Sprintf("%d", http.StatusBadRequest)).Inc()
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Failed to read body"})
+	}
+
+	scenario, err := parseScenario(data)
+	if err == nil {
+		err = scenario.validateLocal()
+	}
+	if err != nil {
+		httpRequestsTotal.WithLabelValues("/api/scenarios/run", fmt.Sprintf("%d", http.StatusBadRequest)).Inc()
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+
+	run := newScenarioRun(scenario.Name)
+	scenarioRunsMu.Lock()
+	scenarioRuns = append(scenarioRuns, run)
+	if len(scenarioRuns) > maxRunHistory {
+		scenarioRuns = scenarioRuns[len(scenarioRuns)-maxRunHistory:]
+	}
+	scenarioRunsMu.Unlock()
+
+	scenarioWG.Add(1)
+	go func() {
+		defer scenarioWG.Done()
+		err := runScenario(scenarioCtx, scenario, localScenarioBackend{}, run)
+		finishScenarioRun(run, err)
+		if err != nil {
+			warnf("Scenario %s failed: %v", run.ID, err)
+		} else {
+			infof("Scenario %s passed", run.ID)
+		}
+	}()
+
+	httpRequestsTotal.WithLabelValues("/api/scenarios/run", fmt.Sprintf("%d", http.StatusAccepted)).Inc()
+	return c.JSON(http.StatusAccepted, map[string]string{"id": run.ID, "message": "Scenario started"})
+}
+
+func listScenarioRunsHandler(c echo.Context) error {
+	scenarioRunsMu.Lock()
+	body, err := json.Marshal(scenarioRuns)
+	scenarioRunsMu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	httpRequestsTotal.WithLabelValues("/api/scenarios", fmt.Sprintf("%d", http.StatusOK)).Inc()
+	return c.JSONBlob(http.StatusOK, body)
+}
+
+func getScenarioRunHandler(c echo.Context) error {
+	scenarioRunsMu.Lock()
+	var body []byte
+	var err error
+	for _, run := range scenarioRuns {
+		if run.ID == c.Param("id") {
+			body, err = json.Marshal(run)
+			break
+		}
+	}
+	scenarioRunsMu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	if body == nil {
+		httpRequestsTotal.WithLabelValues("/api/scenarios/:id", fmt.Sprintf("%d", http.StatusNotFound)).Inc()
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Scenario run not found"})
+	}
+	httpRequestsTotal.WithLabelValues("/api/scenarios/:id", fmt.Sprintf("%d", http.StatusOK)).Inc()
+	return c.JSONBlob(http.StatusOK, body)
+}
+
+// runScenarioMode executes a scenario file against a running server and
+// exits non-zero if any step fails, so it can gate a CI job or Rollouts Job
+// analysis.
+func runScenarioMode(args []string) {
+	fs := flag.NewFlagSet("scenario", flag.ExitOnError)
+	file := fs.String("file", getEnvOrDefault("SCENARIO_FILE", "scenario.yaml"), "scenario YAML file")
+	server := fs.String("server", getEnvOrDefault("SCENARIO_SERVER", "http://localhost:8080"), "base URL of the server to drive")
+	fs.Parse(args)
+
+	data, err := os.ReadFile(*file)
+	if err != nil {
+		fatalf("Failed to read scenario: %v", err)
+	}
+	scenario, err := parseScenario(data)
+	if err != nil {
+		fatalf("Invalid scenario: %v", err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	backend := httpScenarioBackend{
+		baseURL: strings.TrimSuffix(*server, "/"),
+		client:  newOutboundClient(outboundClientConfig{Name: "scenario", Timeout: 10 * time.Second}),
+	}
+	run := newScenarioRun(scenario.Name)
+	infof("Running scenario %q (%d steps) against %s", scenario.Name, len(scenario.Steps), backend.baseURL)
+
+	err = runScenario(ctx, scenario, backend, run)
+	for _, step := range run.Steps {
+		status := "PASS"
+		if !step.Passed {
+			status = "FAIL"
+		}
+		infof("  [%s] step %d %s: %s", status, step.Index, step.Action, step.Detail)
+	}
+	if err != nil {
+		errorf("Scenario failed: %v", err)
+		os.Exit(1)
+	}
+	infof("Scenario passed")
+}
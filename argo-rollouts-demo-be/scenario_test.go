@@ -0,0 +1,18 @@
+package main
+
+import (
+	"testing"
+
+	"go.yaml.in/yaml/v2"
+)
+
+// YAML spells NaN as .nan, which passes a plain range check.
+func TestScenarioStepRejectsNaNRate(t *testing.T) {
+	var step ScenarioStep
+	if err := yaml.Unmarshal([]byte("set-rate: .nan"), &step); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := step.action(); err == nil {
+		t.Fatal("want an error for set-rate: .nan")
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/common/expfmt"
+	"github.com/prometheus/common/model"
+)
+
+var (
+	scrapeSelfCheckInterval = time.Duration(getEnvFloatOrDefault("SCRAPE_SELF_CHECK_SECONDS", 30) * float64(time.Second))
+
+	scrapeSelfClient = newOutboundClient(outboundClientConfig{Name: "self-scrape", Timeout: 5 * time.Second})
+
+	scrapeSelfCheckSuccess = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "scrape_self_check_success",
+			Help: "Whether the last self-scrape of the metrics exposition parsed cleanly (1) or not (0)",
+		},
+	)
+	scrapeSelfCheckTimestamp = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "scrape_self_check_last_run_timestamp_seconds",
+			Help: "Unix time of the last metrics self-scrape",
+		},
+	)
+	scrapeSelfCheckFamilies = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "scrape_self_check_metric_families",
+			Help: "Number of metric families found by the last successful self-scrape",
+		},
+	)
+)
+
+// scrapeSelf fetches /metrics in the text exposition format, the way a
+// Prometheus scrape receives it, and parses it back. A collector that breaks
+// the format (duplicate series, invalid names, inconsistent labels) fails
+// here instead of in the analysis provider mid-rollout.
+func scrapeSelf() (int, error) {
+	req, err := http.NewRequest(http.MethodGet, localURL("/metrics"), nil)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Accept", string(expfmt.NewFormat(expfmt.TypeTextPlain)))
+
+	resp, err := scrapeSelfClient.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("scraping: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("scraping: unexpected status %d", resp.StatusCode)
+	}
+
+	parser := expfmt.NewTextParser(model.UTF8Validation)
+	parsed, err := parser.TextToMetricFamilies(resp.Body)
+	if err != nil {
+		return 0, fmt.Errorf("parsing: %w", err)
+	}
+	if len(parsed) == 0 {
+		return 0, fmt.Errorf("exposition contains no metric families")
+	}
+	return len(parsed), nil
+}
+
+func runScrapeSelfCheck(stop <-chan struct{}) {
+	if scrapeSelfCheckInterval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(scrapeSelfCheckInterval)
+	defer ticker.Stop()
+
+	// The server isn't listening yet when this starts, so the first scrape
+	// waits for the first tick
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+
+		families, err := scrapeSelf()
+		scrapeSelfCheckTimestamp.SetToCurrentTime()
+		if err != nil {
+			scrapeSelfCheckSuccess.Set(0)
+			warnf("Metrics self-scrape failed: %v", err)
+		} else {
+			scrapeSelfCheckSuccess.Set(1)
+			scrapeSelfCheckFamilies.Set(float64(families))
+			debugf("metrics self-scrape: %d families", families)
+		}
+	}
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+const redactedValue = "[REDACTED]"
+
+// Secret is a sensitive setting that may come from a mounted file instead of
+// a plaintext env var. File-backed secrets are re-read periodically so a
+// rotated Kubernetes Secret is picked up without a restart.
+//
+// Resolution order for a secret with env var NAME:
+//  1. the file named by NAME_FILE
+//  2. SECRETS_DIR/<secret name>, when it exists
+//  3. the NAME env var itself
+type Secret struct {
+	name   string
+	envVar string
+
+	mu       sync.RWMutex
+	value    string
+	source   string // "file", "env" or "" when unset
+	path     string
+	loadedAt time.Time
+}
+
+type SecretStatus struct {
+	Name     string     `json:"name"`
+	EnvVar   string     `json:"envVar"`
+	Source   string     `json:"source,omitempty"`
+	Path     string     `json:"path,omitempty"`
+	Value    string     `json:"value,omitempty"` // Always redacted
+	LoadedAt *time.Time `json:"loadedAt,omitempty"`
+}
+
+var (
+	secretsDir            = getEnvOrDefault("SECRETS_DIR", "/var/run/secrets/argo-rollouts-demo")
+	secretsReloadInterval = time.Duration(getEnvFloatOrDefault("SECRETS_RELOAD_SECONDS", 10) * float64(time.Second))
+
+	redisPassword      = newSecret("redis-password", "REDIS_PASSWORD")
+	digestWebhookURL   = newSecret("digest-webhook-url", "RUN_DIGEST_WEBHOOK_URL")
+	digestSMTPPassword = newSecret("smtp-password", "RUN_DIGEST_SMTP_PASSWORD")
+	adminToken         = newSecret("admin-token", "ADMIN_TOKEN")
+
+	secrets = []*Secret{redisPassword, digestWebhookURL, digestSMTPPassword, adminToken}
+)
+
+func newSecret(name, envVar string) *Secret {
+	s := &Secret{name: name, envVar: envVar}
+	if _, err := s.load(); err != nil {
+		warnf("Failed to load secret %s: %v", name, err)
+	}
+	return s
+}
+
+func (s *Secret) filePath() string {
+	if path := getEnvOrDefault(s.envVar+"_FILE", ""); path != "" {
+		return path
+	}
+	path := filepath.Join(secretsDir, s.name)
+	if _, err := os.Stat(path); err == nil {
+		return path
+	}
+	return ""
+}
+
+// load re-reads the secret and reports whether its value changed.
+func (s *Secret) load() (bool, error) {
+	value, source, path := "", "", s.filePath()
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return false, err
+		}
+		value, source = strings.TrimRight(string(data), "\r\n"), "file"
+	} else if env := os.Getenv(s.envVar); env != "" {
+		value, source = env, "env"
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	changed := value != s.value
+	s.value, s.source, s.path = value, source, path
+	if changed || s.loadedAt.IsZero() {
+		s.loadedAt = time.Now().UTC()
+	}
+	return changed, nil
+}
+
+func (s *Secret) Value() string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.value
+}
+
+func (s *Secret) Status() SecretStatus {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	status := SecretStatus{Name: s.name, EnvVar: s.envVar, Source: s.source, Path: s.path}
+	if s.value != "" {
+		loadedAt := s.loadedAt
+		status.Value = redactedValue
+		status.LoadedAt = &loadedAt
+	}
+	return status
+}
+
+// runSecretReload polls file-backed secrets for rotation until stop is closed.
+func runSecretReload(stop <-chan struct{}) {
+	if secretsReloadInterval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(secretsReloadInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			for _, s := range secrets {
+				changed, err := s.load()
+				if err != nil {
+					warnf("Failed to reload secret %s: %v", s.name, err)
+				} else if changed {
+					infof("Reloaded secret %s", s.name)
+				}
+			}
+		}
+	}
+}
+
+// redactingWriter masks current secret values in everything logged, e.g. a
+// webhook URL quoted in a transport error.
+type redactingWriter struct {
+	out io.Writer
+}
+
+func (w *redactingWriter) Write(p []byte) (int, error) {
+	redacted := p
+	for _, s := range secrets {
+		// Very short values would mask unrelated text
+		if value := s.Value(); len(value) >= 4 {
+			redacted = bytes.ReplaceAll(redacted, []byte(value), []byte(redactedValue))
+		}
+	}
+	if _, err := w.out.Write(redacted); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func secretStatuses() []SecretStatus {
+	statuses := make([]SecretStatus, 0, len(secrets))
+	for _, s := range secrets {
+		statuses = append(statuses, s.Status())
+	}
+	return statuses
+}
+
+// getConfigHandler shows the effective configuration with every secret
+// redacted, so it is safe to show on screen during a demo.
+func getConfigHandler(c echo.Context) error {
+	httpRequestsTotal.WithLabelValues("/api/config", fmt.Sprintf("%d", http.StatusOK)).Inc()
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"version":    version,
+		"buildHash":  buildHash,
+		"pod":        podName,
+		"listenAddr": listenAddr,
+		"redisAddr":  redisAddr,
+		"redisMode":  redisMode,
+		"redis": map[string]interface{}{
+			"username": redisUsername,
+			"db":       redisDB,
+			"tls":      redisTLS,
+		},
+		"configFile": configFile,
+		"middleware": activeMiddlewareOrder,
+		"faults":     currentConfig().Faults,
+		"digest": map[string]string{
+			"smtpAddr":     digestSMTPAddr,
+			"smtpFrom":     digestSMTPFrom,
+			"smtpTo":       digestSMTPTo,
+			"smtpUsername": digestSMTPUsername,
+		},
+		"secrets": secretStatuses(),
+	})
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Server-Timing metric names, in the order they are reported.
+const (
+	timingDelay = "delay" // Latency injected on purpose
+	timingRedis = "redis" // Time spent waiting on Redis
+	timingApp   = "app"   // Everything else the handler did
+)
+
+var serverTimingEnabled = isTruthy(getEnvOrDefault("SERVER_TIMING", "true"))
+
+// serverTiming accumulates where a request's time went. Redis commands can
+// run on other goroutines, hence the lock.
+type serverTiming struct {
+	mu    sync.Mutex
+	spent map[string]time.Duration
+}
+
+type serverTimingKey struct{}
+
+// addServerTiming charges d to the request's named timing. Work finishing
+// after the headers were sent, like asynchronous counter writes, doesn't show.
+func addServerTiming(ctx context.Context, name string, d time.Duration) {
+	timing, ok := ctx.Value(serverTimingKey{}).(*serverTiming)
+	if !ok {
+		return
+	}
+	timing.mu.Lock()
+	timing.spent[name] += d
+	timing.mu.Unlock()
+}
+
+// header renders the Server-Timing value for a request that has been running
+// for total. The app share is what's left after the delay and Redis time.
+func (t *serverTiming) header(total time.Duration) string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	app := total - t.spent[timingDelay] - t.spent[timingRedis]
+	parts := make([]string, 0, 4)
+	for _, entry := range []struct {
+		name string
+		d    time.Duration
+		desc string
+	}{
+		{timingDelay, t.spent[timingDelay], "Injected delay"},
+		{timingRedis, t.spent[timingRedis], "Redis"},
+		{timingApp, max(app, 0), "Handler"},
+		{"total", total, ""},
+	} {
+		part := fmt.Sprintf("%s;dur=%.3f", entry.name, float64(entry.d)/float64(time.Millisecond))
+		if entry.desc != "" {
+			part += fmt.Sprintf(";desc=%q", entry.desc)
+		}
+		parts = append(parts, part)
+	}
+	return strings.Join(parts, ", ")
+}
+
+// serverTimingMiddleware adds a Server-Timing header breaking each response
+// down into injected delay, Redis and handler time, so browser DevTools show
+// where a canary's time went. It is installed with e.Pre, after
+// inFlightMiddleware set the start time.
+func serverTimingMiddleware(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		if !serverTimingEnabled {
+			return next(c)
+		}
+
+		timing := &serverTiming{spent: map[string]time.Duration{}}
+		req := c.Request()
+		c.SetRequest(req.WithContext(context.WithValue(req.Context(), serverTimingKey{}, timing)))
+
+		c.Response().Before(func() {
+			header := c.Response().Header()
+			header.Set("Server-Timing", timing.header(requestDuration(c)))
+			// Lets the cross-origin frontend read the timings too
+			header.Set("Timing-Allow-Origin", "*")
+		})
+		return next(c)
+	}
+}
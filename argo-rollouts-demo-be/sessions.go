@@ -0,0 +1,390 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/redis/go-redis/v9"
+)
+
+// Session is a self-service sandbox handed to one workshop attendee. Requests
+// carrying its token get the session's error rate and quotas instead of the
+// shared ones, and are counted under its namespace.
+type Session struct {
+	Token     string       `json:"token"`
+	Namespace string       `json:"namespace"`
+	Preset    string       `json:"preset"`
+	ErrorRate float64      `json:"errorRate"` // Percentage, from the preset
+	Quota     SessionQuota `json:"quota"`
+	CreatedAt time.Time    `json:"createdAt"`
+	ExpiresAt time.Time    `json:"expiresAt"`
+}
+
+// SessionQuota limits requests per window for one session. Zero disables
+// the window.
+type SessionQuota struct {
+	Hourly int64 `json:"hourly"`
+	Daily  int64 `json:"daily"`
+}
+
+type SessionPreset struct {
+	Description string  `json:"description"`
+	ErrorRate   float64 `json:"errorRate"` // Percentage
+}
+
+type sessionRequest struct {
+	Namespace  string        `json:"namespace"`
+	Preset     string        `json:"preset"`
+	TTLMinutes float64       `json:"ttlMinutes"`
+	Quota      *SessionQuota `json:"quota"`
+}
+
+type sessionContextKey struct{}
+
+var (
+	sessionPresets = map[string]SessionPreset{
+		"healthy": {Description: "No injected errors", ErrorRate: 0},
+		"flaky":   {Description: "Occasional errors that should pass analysis", ErrorRate: 2},
+		"broken":  {Description: "A bad release the analysis should catch", ErrorRate: 50},
+		"outage":  {Description: "Every check fails", ErrorRate: 100},
+	}
+	defaultSessionPreset = getEnvOrDefault("SESSION_DEFAULT_PRESET", "healthy")
+
+	sessionHeader          = getEnvOrDefault("SESSION_HEADER", "X-Session-Token")
+	sessionNamespacePrefix = getEnvOrDefault("SESSION_NAMESPACE_PREFIX", "workshop-")
+	sessionTTL             = time.Duration(getEnvFloatOrDefault("SESSION_TTL_MINUTES", 120) * float64(time.Minute))
+	sessionMaxTTL          = time.Duration(getEnvFloatOrDefault("SESSION_MAX_TTL_MINUTES", 480) * float64(time.Minute))
+	sessionDefaultQuota    = SessionQuota{
+		Hourly: int64(getEnvFloatOrDefault("SESSION_QUOTA_HOURLY", 0)),
+		Daily:  int64(getEnvFloatOrDefault("SESSION_QUOTA_DAILY", 0)),
+	}
+
+	// Kubernetes-style names, so a namespace can double as a real one
+	sessionNamespacePattern = regexp.MustCompile(`^[a-z0-9]([-a-z0-9]{0,61}[a-z0-9])?$`)
+
+	errSessionNamespaceTaken = errors.New("namespace is already bound to an active session")
+
+	// Used when Redis isn't available, keyed by token
+	localSessions   = map[string]Session{}
+	localSessionsMu sync.Mutex
+
+	sessionsCreatedTotal = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: "sessions_created_total",
+			Help: "Total number of demo sessions created",
+		},
+	)
+	sessionRequestsTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "session_check_requests_total",
+			Help: "/api/check requests made with a session, by session namespace and status code",
+		},
+		[]string{"namespace", "status_code"},
+	)
+)
+
+func sessionKey(token string) string {
+	return fmt.Sprintf("session:%s", token)
+}
+
+func sessionNamespaceKey(namespace string) string {
+	return fmt.Sprintf("session_ns:%s", namespace)
+}
+
+func newSessionToken() (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+// storeSession saves a new session, claiming its namespace so two attendees
+// can't share a sandbox. Redis expires both keys with the session.
+func storeSession(session Session) error {
+	ttl := time.Until(session.ExpiresAt)
+
+	if redisClient == nil {
+		localSessionsMu.Lock()
+		defer localSessionsMu.Unlock()
+		for _, existing := range localSessions {
+			if existing.Namespace == session.Namespace && time.Now().Before(existing.ExpiresAt) {
+				return errSessionNamespaceTaken
+			}
+		}
+		localSessions[session.Token] = session
+		return nil
+	}
+
+	claimed, err := redisClient.SetNX(redisCtx, sessionNamespaceKey(session.Namespace), session.Token, ttl).Result()
+	if err != nil {
+		return err
+	}
+	if !claimed {
+		return errSessionNamespaceTaken
+	}
+	encoded, err := json.Marshal(session)
+	if err != nil {
+		return err
+	}
+	return redisClient.Set(redisCtx, sessionKey(session.Token), encoded, ttl).Err()
+}
+
+// lookupSession returns the session for a token, or false once it has
+// expired or been ended.
+func lookupSession(ctx context.Context, token string) (Session, bool, error) {
+	if redisClient == nil {
+		localSessionsMu.Lock()
+		defer localSessionsMu.Unlock()
+		session, ok := localSessions[token]
+		if !ok || time.Now().After(session.ExpiresAt) {
+			return Session{}, false, nil
+		}
+		return session, true, nil
+	}
+
+	encoded, err := redisClient.Get(ctx, sessionKey(token)).Result()
+	if err == redis.Nil {
+		return Session{}, false, nil
+	}
+	if err != nil {
+		return Session{}, false, err
+	}
+	var session Session
+	if err := json.Unmarshal([]byte(encoded), &session); err != nil {
+		return Session{}, false, err
+	}
+	return session, true, nil
+}
+
+func deleteSession(session Session) error {
+	if redisClient == nil {
+		localSessionsMu.Lock()
+		delete(localSessions, session.Token)
+		localSessionsMu.Unlock()
+		return nil
+	}
+
+	pipe := redisClient.TxPipeline()
+	pipe.Del(redisCtx, sessionKey(session.Token))
+	pipe.Del(redisCtx, sessionNamespaceKey(session.Namespace))
+	_, err := pipe.Exec(redisCtx)
+	return err
+}
+
+// runSessionCleanup drops expired local sessions. Redis expires its copies on
+// its own.
+func runSessionCleanup(stop <-chan struct{}) {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case now := <-ticker.C:
+			localSessionsMu.Lock()
+			for token, session := range localSessions {
+				if now.After(session.ExpiresAt) {
+					delete(localSessions, token)
+					debugf("sessions: expired %s", session.Namespace)
+				}
+			}
+			localSessionsMu.Unlock()
+		}
+	}
+}
+
+func sessionFromContext(ctx context.Context) (Session, bool) {
+	session, ok := ctx.Value(sessionContextKey{}).(Session)
+	return session, ok
+}
+
+// sessionErrorRate replaces the shared error rate (0-1) for requests made
+// within a session.
+func sessionErrorRate(ctx context.Context, rate float64) float64 {
+	if session, ok := sessionFromContext(ctx); ok {
+		return session.ErrorRate / 100.0
+	}
+	return rate
+}
+
+// sessionsMiddleware resolves the session token header. Requests without
+// one use the shared settings; an unknown or expired token is rejected so an
+// attendee notices their sandbox is gone.
+func sessionsMiddleware(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		token := c.Request().Header.Get(sessionHeader)
+		if token == "" || strings.HasPrefix(c.Path(), "/api/sessions") {
+			return next(c)
+		}
+
+		session, ok, err := Session{}, false, simulatedOutage(failFeatureAuth)
+		if err == nil {
+			session, ok, err = lookupSession(c.Request().Context(), token)
+		}
+		if err != nil {
+			warnf("Failed to look up session: %v", err)
+			if dependencyFailure(failFeatureAuth, err) == nil {
+				// Failing open: serve the request with the shared settings
+				return next(c)
+			}
+			httpRequestsTotal.WithLabelValues(c.Path(), fmt.Sprintf("%d", http.StatusServiceUnavailable)).Inc()
+			return c.JSON(http.StatusServiceUnavailable, map[string]string{"error": "Session store unavailable"})
+		}
+		if !ok {
+			httpRequestsTotal.WithLabelValues(c.Path(), fmt.Sprintf("%d", http.StatusUnauthorized)).Inc()
+			return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Invalid or expired session"})
+		}
+
+		c.SetRequest(c.Request().WithContext(context.WithValue(c.Request().Context(), sessionContextKey{}, session)))
+		c.Response().Header().Set("X-Session-Namespace", session.Namespace)
+		return next(c)
+	}
+}
+
+func createSessionHandler(c echo.Context) error {
+	var req sessionRequest
+	if c.Request().ContentLength != 0 {
+		if err := json.NewDecoder(c.Request().Body).Decode(&req); err != nil {
+			httpRequestsTotal.WithLabelValues("/api/sessions", fmt.Sprintf("%d", http.StatusBadRequest)).Inc()
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid JSON"})
+		}
+	}
+
+	badRequest := func(message string) error {
+		httpRequestsTotal.WithLabelValues("/api/sessions", fmt.Sprintf("%d", http.StatusBadRequest)).Inc()
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": message})
+	}
+
+	if req.Preset == "" {
+		req.Preset = defaultSessionPreset
+	}
+	preset, ok := sessionPresets[req.Preset]
+	if !ok {
+		return badRequest(fmt.Sprintf("Unknown preset %q", req.Preset))
+	}
+
+	ttl := sessionTTL
+	if req.TTLMinutes != 0 {
+		ttl = time.Duration(req.TTLMinutes * float64(time.Minute))
+	}
+	if ttl <= 0 || ttl > sessionMaxTTL {
+		return badRequest(fmt.Sprintf("ttlMinutes must be between 0 and %g", sessionMaxTTL.Minutes()))
+	}
+
+	quota := sessionDefaultQuota
+	if req.Quota != nil {
+		quota = *req.Quota
+	}
+	if quota.Hourly < 0 || quota.Daily < 0 {
+		return badRequest("Quota limits must not be negative")
+	}
+
+	token, err := newSessionToken()
+	if err != nil {
+		httpRequestsTotal.WithLabelValues("/api/sessions", fmt.Sprintf("%d", http.StatusInternalServerError)).Inc()
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to create session"})
+	}
+	if req.Namespace == "" {
+		req.Namespace = sessionNamespacePrefix + token[:8]
+	}
+	if !sessionNamespacePattern.MatchString(req.Namespace) {
+		return badRequest("Namespace must be a valid Kubernetes namespace name")
+	}
+
+	now := time.Now().UTC()
+	session := Session{
+		Token:     token,
+		Namespace: req.Namespace,
+		Preset:    req.Preset,
+		ErrorRate: preset.ErrorRate,
+		Quota:     quota,
+		CreatedAt: now,
+		ExpiresAt: now.Add(ttl),
+	}
+	if err := storeSession(session); err != nil {
+		if errors.Is(err, errSessionNamespaceTaken) {
+			httpRequestsTotal.WithLabelValues("/api/sessions", fmt.Sprintf("%d", http.StatusConflict)).Inc()
+			return c.JSON(http.StatusConflict, map[string]string{"error": err.Error()})
+		}
+		warnf("Failed to store session: %v", err)
+		httpRequestsTotal.WithLabelValues("/api/sessions", fmt.Sprintf("%d", http.StatusInternalServerError)).Inc()
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to create session"})
+	}
+
+	sessionsCreatedTotal.Inc()
+	infof("Created session for namespace %s (preset %s, expires %s)", session.Namespace, session.Preset, session.ExpiresAt.Format(time.RFC3339))
+
+	httpRequestsTotal.WithLabelValues("/api/sessions", fmt.Sprintf("%d", http.StatusCreated)).Inc()
+	return c.JSON(http.StatusCreated, session)
+}
+
+func getSessionHandler(c echo.Context) error {
+	session, ok, err := lookupSession(c.Request().Context(), c.Param("token"))
+	if err != nil {
+		httpRequestsTotal.WithLabelValues("/api/sessions/:token", fmt.Sprintf("%d", http.StatusServiceUnavailable)).Inc()
+		return c.JSON(http.StatusServiceUnavailable, map[string]string{"error": "Session store unavailable"})
+	}
+	if !ok {
+		httpRequestsTotal.WithLabelValues("/api/sessions/:token", fmt.Sprintf("%d", http.StatusNotFound)).Inc()
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Session not found"})
+	}
+
+	httpRequestsTotal.WithLabelValues("/api/sessions/:token", fmt.Sprintf("%d", http.StatusOK)).Inc()
+	return c.JSON(http.StatusOK, session)
+}
+
+func deleteSessionHandler(c echo.Context) error {
+	session, ok, err := lookupSession(c.Request().Context(), c.Param("token"))
+	if err == nil && ok {
+		err = deleteSession(session)
+	}
+	if err != nil {
+		httpRequestsTotal.WithLabelValues("/api/sessions/:token", fmt.Sprintf("%d", http.StatusServiceUnavailable)).Inc()
+		return c.JSON(http.StatusServiceUnavailable, map[string]string{"error": "Session store unavailable"})
+	}
+	if !ok {
+		httpRequestsTotal.WithLabelValues("/api/sessions/:token", fmt.Sprintf("%d", http.StatusNotFound)).Inc()
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Session not found"})
+	}
+
+	httpRequestsTotal.WithLabelValues("/api/sessions/:token", fmt.Sprintf("%d", http.StatusOK)).Inc()
+	return c.JSON(http.StatusOK, map[string]string{"message": fmt.Sprintf("Session for %s ended", session.Namespace)})
+}
+
+func listSessionPresetsHandler(c echo.Context) error {
+	names := make([]string, 0, len(sessionPresets))
+	for name := range sessionPresets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	presets := make([]map[string]interface{}, 0, len(names))
+	for _, name := range names {
+		presets = append(presets, map[string]interface{}{
+			"name":        name,
+			"description": sessionPresets[name].Description,
+			"errorRate":   sessionPresets[name].ErrorRate,
+		})
+	}
+
+	httpRequestsTotal.WithLabelValues("/api/sessions/presets", fmt.Sprintf("%d", http.StatusOK)).Inc()
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"default": defaultSessionPreset,
+		"presets": presets,
+	})
+}
@@ -0,0 +1,7 @@
+//go:build !unix
+
+package main
+
+// handleRuntimeSignals is a no-op where SIGUSR1/SIGUSR2/SIGHUP don't exist;
+// the config file can still be reloaded through /api/admin/reload.
+func handleRuntimeSignals() {}
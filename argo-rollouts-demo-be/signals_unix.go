@@ -0,0 +1,30 @@
+//go:build unix
+
+package main
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// handleRuntimeSignals lets operators introspect a pod with kill via
+// kubectl exec: SIGUSR1 dumps state to the log, SIGUSR2 toggles debug logs
+// and SIGHUP reloads the config file.
+func handleRuntimeSignals() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGUSR1, syscall.SIGUSR2, syscall.SIGHUP)
+
+	go func() {
+		for sig := range signals {
+			switch sig {
+			case syscall.SIGUSR1:
+				dumpState()
+			case syscall.SIGUSR2:
+				toggleDebugLogging()
+			case syscall.SIGHUP:
+				reloadConfig()
+			}
+		}
+	}()
+}
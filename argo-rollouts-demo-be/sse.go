@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	sseClientBuffer = 16
+	sseHeartbeat    = 15 * time.Second
+)
+
+// sseHub fans server-sent events out to the connected clients. A client that
+// can't keep up misses events rather than slowing down the publisher.
+type sseHub struct {
+	mu      sync.Mutex
+	clients map[chan []byte]struct{}
+}
+
+func newSSEHub() *sseHub {
+	return &sseHub{clients: map[chan []byte]struct{}{}}
+}
+
+func (h *sseHub) subscribe() chan []byte {
+	ch := make(chan []byte, sseClientBuffer)
+	h.mu.Lock()
+	h.clients[ch] = struct{}{}
+	h.mu.Unlock()
+	return ch
+}
+
+func (h *sseHub) unsubscribe(ch chan []byte) {
+	h.mu.Lock()
+	delete(h.clients, ch)
+	h.mu.Unlock()
+}
+
+// publish sends one event to every client. data must be a single line, which
+// holds for compact JSON.
+func (h *sseHub) publish(event string, data []byte) {
+	frame := []byte(fmt.Sprintf("event: %s\ndata: %s\n\n", event, data))
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for ch := range h.clients {
+		select {
+		case ch <- frame:
+		default:
+		}
+	}
+}
+
+// serveSSE streams the hub's events to the client until it disconnects or
+// the server shuts down, with periodic comments so proxies don't drop an
+// idle connection.
+func serveSSE(c echo.Context, hub *sseHub) error {
+	ch := hub.subscribe()
+	defer hub.unsubscribe(ch)
+
+	header := c.Response().Header()
+	header.Set(echo.HeaderContentType, "text/event-stream")
+	header.Set(echo.HeaderCacheControl, "no-cache")
+	header.Set(echo.HeaderConnection, "keep-alive")
+	header.Set("X-Accel-Buffering", "no")
+	c.Response().WriteHeader(http.StatusOK)
+	c.Response().Flush()
+
+	heartbeat := time.NewTicker(sseHeartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-c.Request().Context().Done():
+			return nil
+		case <-streamsStop:
+			return nil
+		case frame := <-ch:
+			if _, err := c.Response().Write(frame); err != nil {
+				return nil
+			}
+		case <-heartbeat.C:
+			if _, err := c.Response().Write([]byte(": ping\n\n")); err != nil {
+				return nil
+			}
+		}
+		c.Response().Flush()
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// An open event stream must not hold the server's shutdown until the
+// timeout, or the components stopped after it get an expired context.
+func TestShutdownEndsOpenStreams(t *testing.T) {
+	previous := streamsStop
+	streamsStop = make(chan struct{})
+	t.Cleanup(func() { streamsStop = previous })
+
+	e := echo.New()
+	e.HideBanner, e.HidePort = true, true
+	hub := newSSEHub()
+	e.GET("/stream", func(c echo.Context) error { return serveSSE(c, hub) })
+	server := httpServer{e: e, addr: "127.0.0.1:0"}
+	if err := server.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for e.ListenerAddr() == nil {
+		if time.Now().After(deadline) {
+			t.Fatal("server did not start")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	resp, err := http.Get("http://" + e.ListenerAddr().String() + "/stream")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	started := time.Now()
+	if err := server.Stop(ctx); err != nil {
+		t.Fatalf("Stop with an open stream: %v", err)
+	}
+	if elapsed := time.Since(started); elapsed > time.Second {
+		t.Fatalf("Stop took %v", elapsed)
+	}
+	// The stream ends rather than being cut off mid-event
+	if _, err := bufio.NewReader(resp.Body).ReadString('\n'); err == nil {
+		t.Fatal("stream still open after shutdown")
+	}
+}
@@ -0,0 +1,391 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+const stateFormatVersion = 1
+
+// StateArchive is a portable snapshot of everything a demo environment
+// accumulates at runtime, so it can be restored after a teardown or cloned
+// into another cluster.
+type StateArchive struct {
+	FormatVersion int                `json:"formatVersion"`
+	ExportedAt    time.Time          `json:"exportedAt"`
+	Source        StateSource        `json:"source"`
+	Config        StateConfig        `json:"config"`
+	Counters      map[string]float64 `json:"counters"`
+	Runs          []Run              `json:"runs"`
+}
+
+type StateSource struct {
+	Version   string `json:"version"`
+	BuildHash string `json:"buildHash"`
+	Pod       string `json:"pod"`
+}
+
+type StateConfig struct {
+	ErrorRate     float64          `json:"errorRate"` // Percentage, same as /api/set-error-rate
+	Latency       *CheckLatency    `json:"latency,omitempty"`
+	Certificate   *SimulatedCert   `json:"certificate,omitempty"`
+	FeatureCanary *FeatureCanary   `json:"featureCanary,omitempty"`
+	Faults        *FaultConfig     `json:"faults,omitempty"`
+	Payload       *PayloadConfig   `json:"payload,omitempty"`
+	Jitter        *ErrorRateJitter `json:"jitter,omitempty"`
+	WeightFailure *WeightFailure   `json:"weightFailure,omitempty"`
+	BlastRadius   *BlastRadius     `json:"blastRadius,omitempty"`
+	TimeBomb      *TimeBomb        `json:"timeBomb,omitempty"`
+	ErrorMode     *ErrorMode       `json:"errorMode,omitempty"`
+
+	Routes        map[string]RouteFault `json:"routes"` // Empty clears them, left alone by older archives without it
+	FailPolicies  map[string]FailPolicy `json:"failPolicies,omitempty"`
+	StatusWeights StatusWeights         `json:"statusWeights,omitempty"`
+
+	// A ramp that is running or still to come, absent without one
+	ErrorRateSchedule *ErrorRateSchedule `json:"errorRateSchedule,omitempty"`
+}
+
+func exportState() StateArchive {
+	checkLatencyMu.RLock()
+	currentLatency := checkLatency
+	checkLatencyMu.RUnlock()
+
+	certMu.RLock()
+	currentCert := cert
+	certMu.RUnlock()
+
+	featureCanaryMu.RLock()
+	currentFeatureCanary := featureCanary
+	featureCanaryMu.RUnlock()
+
+	faultConfigMu.RLock()
+	currentFaults := faultConfig
+	faultConfigMu.RUnlock()
+
+	payloadConfigMu.RLock()
+	currentPayload := payloadConfig
+	payloadConfigMu.RUnlock()
+
+	jitterMu.RLock()
+	currentJitter := errorRateJitter
+	jitterMu.RUnlock()
+
+	weightFailureMu.RLock()
+	currentWeightFailure := weightFailure
+	weightFailureMu.RUnlock()
+
+	blastRadiusMu.Lock()
+	currentBlastRadius := blastRadius
+	blastRadiusMu.Unlock()
+
+	timeBombMu.RLock()
+	currentTimeBomb := timeBomb
+	timeBombMu.RUnlock()
+
+	currentMode := currentErrorMode()
+
+	failPoliciesMu.RLock()
+	currentFailPolicies := make(map[string]FailPolicy, len(failPolicies))
+	for feature, policy := range failPolicies {
+		currentFailPolicies[feature] = policy
+	}
+	failPoliciesMu.RUnlock()
+
+	runsMu.Lock()
+	archivedRuns := make([]Run, 0, len(runs))
+	for _, run := range runs {
+		archivedRuns = append(archivedRuns, *run)
+	}
+	runsMu.Unlock()
+
+	return StateArchive{
+		FormatVersion: stateFormatVersion,
+		ExportedAt:    time.Now().UTC(),
+		Source: StateSource{
+			Version:   version,
+			BuildHash: buildHash,
+			Pod:       podName,
+		},
+		Config: StateConfig{
+			ErrorRate:     getErrorRatePercent(),
+			Latency:       &currentLatency,
+			Certificate:   &currentCert,
+			FeatureCanary: &currentFeatureCanary,
+			Faults:        &currentFaults,
+			Payload:       &currentPayload,
+			Jitter:        &currentJitter,
+			WeightFailure: &currentWeightFailure,
+			BlastRadius:   &currentBlastRadius,
+			TimeBomb:      &currentTimeBomb,
+			ErrorMode:     &currentMode,
+			Routes:        currentRouteFaults(),
+			FailPolicies:  currentFailPolicies,
+			StatusWeights: currentStatusWeights(),
+
+			ErrorRateSchedule: currentErrorRateSchedule(),
+		},
+		Counters: getStatusCounts(),
+		Runs:     archivedRuns,
+	}
+}
+
+func importState(archive StateArchive) error {
+	if archive.FormatVersion != stateFormatVersion {
+		return fmt.Errorf("unsupported archive format version %d", archive.FormatVersion)
+	}
+	if err := validateErrorRatePercent(archive.Config.ErrorRate); err != nil {
+		return err
+	}
+	if archive.Config.Latency != nil {
+		if err := archive.Config.Latency.validate(); err != nil {
+			return err
+		}
+	}
+	if err := archive.Config.StatusWeights.validate(); err != nil {
+		return err
+	}
+	if archive.Config.TimeBomb != nil {
+		if err := archive.Config.TimeBomb.validate(); err != nil {
+			return err
+		}
+	}
+	if archive.Config.Payload != nil {
+		if err := archive.Config.Payload.validate(); err != nil {
+			return fmt.Errorf("payload: %w", err)
+		}
+	}
+	if archive.Config.Faults != nil {
+		if err := archive.Config.Faults.validate(); err != nil {
+			return fmt.Errorf("faults: %w", err)
+		}
+	}
+	if err := validateRouteFaults(archive.Config.Routes); err != nil {
+		return fmt.Errorf("routes: %w", err)
+	}
+	if archive.Config.FeatureCanary != nil {
+		if err := archive.Config.FeatureCanary.validate(); err != nil {
+			return fmt.Errorf("featureCanary: %w", err)
+		}
+	}
+	if archive.Config.Jitter != nil {
+		if err := archive.Config.Jitter.validate(); err != nil {
+			return fmt.Errorf("jitter: %w", err)
+		}
+	}
+	if archive.Config.WeightFailure != nil {
+		if err := archive.Config.WeightFailure.validate(); err != nil {
+			return fmt.Errorf("weightFailure: %w", err)
+		}
+	}
+	if archive.Config.BlastRadius != nil {
+		if err := archive.Config.BlastRadius.validate(); err != nil {
+			return fmt.Errorf("blastRadius: %w", err)
+		}
+	}
+	if archive.Config.ErrorMode != nil {
+		if err := archive.Config.ErrorMode.validate(); err != nil {
+			return fmt.Errorf("errorMode: %w", err)
+		}
+	}
+	if archive.Config.ErrorRateSchedule != nil {
+		if err := archive.Config.ErrorRateSchedule.validate(); err != nil {
+			return fmt.Errorf("errorRateSchedule: %w", err)
+		}
+	}
+	for status, count := range archive.Counters {
+		if code, err := strconv.Atoi(status); err != nil || code < 100 || code > 599 {
+			return fmt.Errorf("counter %q is not a status code", status)
+		}
+		if count < 0 {
+			return fmt.Errorf("counter %s must not be negative", status)
+		}
+	}
+	for feature, policy := range archive.Config.FailPolicies {
+		if _, ok := defaultFailPolicies[feature]; !ok {
+			return fmt.Errorf("unknown fail policy feature %q", feature)
+		}
+		if !validFailPolicyMode(policy.Mode) {
+			return fmt.Errorf("fail policy for %s must have mode \"open\" or \"closed\"", feature)
+		}
+	}
+
+	setManualErrorRate(redisCtx, archive.Config.ErrorRate, "a state import")
+	if archive.Config.ErrorRateSchedule != nil {
+		startErrorRateSchedule(*archive.Config.ErrorRateSchedule, time.Now().UTC())
+	}
+	if archive.Config.ErrorMode != nil {
+		applyErrorMode(*archive.Config.ErrorMode)
+	}
+
+	if archive.Config.StatusWeights != nil {
+		storeStatusWeights(archive.Config.StatusWeights)
+	}
+
+	if archive.Config.Latency != nil {
+		setCheckLatency(redisCtx, *archive.Config.Latency)
+	}
+
+	if archive.Config.Certificate != nil {
+		certMu.Lock()
+		cert = *archive.Config.Certificate
+		certMu.Unlock()
+	}
+
+	if archive.Config.FeatureCanary != nil {
+		featureCanaryMu.Lock()
+		featureCanary = *archive.Config.FeatureCanary
+		featureCanaryMu.Unlock()
+	}
+
+	if archive.Config.Faults != nil {
+		faultConfigMu.Lock()
+		faultConfig = *archive.Config.Faults
+		faultConfigMu.Unlock()
+	}
+
+	if archive.Config.Routes != nil {
+		setRouteFaults(archive.Config.Routes)
+	}
+
+	if archive.Config.Payload != nil {
+		payloadConfigMu.Lock()
+		payloadConfig = *archive.Config.Payload
+		payloadConfigMu.Unlock()
+	}
+
+	if archive.Config.Jitter != nil {
+		jitterMu.Lock()
+		errorRateJitter = *archive.Config.Jitter
+		jitterMu.Unlock()
+	}
+
+	if archive.Config.WeightFailure != nil {
+		weightFailureMu.Lock()
+		weightFailure = *archive.Config.WeightFailure
+		weightFailureMu.Unlock()
+	}
+
+	if archive.Config.BlastRadius != nil {
+		blastRadiusMu.Lock()
+		blastRadius = *archive.Config.BlastRadius
+		blastRadiusMu.Unlock()
+	}
+
+	if archive.Config.TimeBomb != nil {
+		// The countdown restarts, an archived one would long have run out
+		restored := *archive.Config.TimeBomb
+		restored.ArmedAt = time.Now().UTC()
+		timeBombMu.Lock()
+		timeBomb = restored
+		timeBombMu.Unlock()
+	}
+
+	if len(archive.Config.FailPolicies) > 0 {
+		failPoliciesMu.Lock()
+		update := make(map[string]FailPolicy, len(failPolicies))
+		for feature, policy := range failPolicies {
+			update[feature] = policy
+		}
+		for feature, policy := range archive.Config.FailPolicies {
+			update[feature] = policy
+		}
+		failPolicies = update
+		failPoliciesMu.Unlock()
+	}
+
+	restoreStatusCounts(archive.Counters)
+	restoreRuns(archive.Runs)
+	return nil
+}
+
+// restoreStatusCounts overwrites the /api/check counters with the archived
+// values in the counter store and the local Prometheus metrics. The archive
+// only has totals, so they are all attributed to this pod's version.
+func restoreStatusCounts(counts map[string]float64) {
+	restored := make(map[int]int64, len(counts))
+	for status, count := range counts {
+		// Checked by importState
+		code, _ := strconv.Atoi(status)
+		restored[code] = int64(count)
+	}
+	if err := counterStore.Restore(redisCtx, restored); err != nil {
+		warnf("Failed to restore %s counters: %v", counterStore.Name(), err)
+	}
+	// This pod's Redis hash now holds them too
+	storedCheckCounts.reset()
+	storedCheckCounts.add(restored)
+
+	// Prometheus counters can't be set directly, so recreate each series
+	for status, count := range counts {
+		httpRequestsTotal.DeleteLabelValues("/api/check", status)
+		httpRequestsTotal.WithLabelValues("/api/check", status).Add(count)
+	}
+}
+
+// restoreRuns replaces the run history with the archived runs. Runs that were
+// still active when exported are restored as finished, and any run active on
+// this instance keeps going.
+func restoreRuns(archived []Run) {
+	runsMu.Lock()
+	defer runsMu.Unlock()
+
+	restored := make([]*Run, 0, len(archived)+1)
+	for i := range archived {
+		run := archived[i]
+		if run.EndedAt == nil {
+			ended := run.StartedAt
+			if n := len(run.Samples); n > 0 {
+				ended = run.Samples[n-1].Timestamp
+			}
+			run.EndedAt = &ended
+		}
+		restored = append(restored, &run)
+	}
+	if activeRun != nil {
+		restored = append(restored, activeRun)
+	}
+	if len(restored) > maxRunHistory {
+		restored = restored[len(restored)-maxRunHistory:]
+	}
+	runs = restored
+}
+
+// exportStateHandler serves GET /api/state/export. Left out of the archive:
+// sessions, which are attendees' credentials and expire on their own, and
+// the session presets, which are built in rather than set at runtime.
+func exportStateHandler(c echo.Context) error {
+	archive := exportState()
+
+	c.Response().Header().Set(echo.HeaderContentDisposition,
+		fmt.Sprintf("attachment; filename=demo-state-%s.json", archive.ExportedAt.Format("20060102-150405")))
+	httpRequestsTotal.WithLabelValues("/api/state/export", fmt.Sprintf("%d", http.StatusOK)).Inc()
+
+	stream := newJSONStream(c)
+	streamStateArchive(stream, archive)
+	if err := stream.Close(); err != nil {
+		warnf("State export interrupted: %v", err)
+	}
+	return nil
+}
+
+func importStateHandler(c echo.Context) error {
+	var archive StateArchive
+	if err := json.NewDecoder(c.Request().Body).Decode(&archive); err != nil {
+		httpRequestsTotal.WithLabelValues("/api/state/import", fmt.Sprintf("%d", http.StatusBadRequest)).Inc()
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid JSON"})
+	}
+
+	if err := importState(archive); err != nil {
+		httpRequestsTotal.WithLabelValues("/api/state/import", fmt.Sprintf("%d", http.StatusBadRequest)).Inc()
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+
+	httpRequestsTotal.WithLabelValues("/api/state/import", fmt.Sprintf("%d", http.StatusOK)).Inc()
+	return c.JSON(http.StatusOK, map[string]string{"message": "State imported successfully"})
+}
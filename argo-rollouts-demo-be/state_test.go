@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func restoreAfterImport(t *testing.T) {
+	previousRate, previousMode := getErrorRatePercent(), currentErrorMode()
+	t.Cleanup(func() {
+		cancelErrorRateSchedule()
+		storeErrorRatePercent(previousRate)
+		applyErrorMode(previousMode)
+		storedCheckCounts.reset()
+	})
+}
+
+func TestImportStateRestoresThroughCounterStore(t *testing.T) {
+	store := newMemoryCounterStore()
+	useCounterStore(t, nil, store)
+	restoreAfterImport(t)
+
+	to := 40.0
+	archive := StateArchive{
+		FormatVersion: stateFormatVersion,
+		Config: StateConfig{
+			ErrorRate:         to,
+			ErrorMode:         &ErrorMode{Mode: errorModeEveryNth, N: 3},
+			ErrorRateSchedule: &ErrorRateSchedule{From: &to, To: 80, DurationSeconds: 60, StartAt: time.Now().Add(time.Hour)},
+		},
+		Counters: map[string]float64{"200": 5, "500": 2},
+	}
+	if err := importState(archive); err != nil {
+		t.Fatal(err)
+	}
+
+	byVersion, _ := store.GetCounts(context.Background())
+	if got := byVersion[version]; got["200"] != 5 || got["500"] != 2 {
+		t.Fatalf("memory store has %v, want the archived counts", got)
+	}
+	if mode := currentErrorMode(); mode.Mode != errorModeEveryNth || mode.N != 3 {
+		t.Fatalf("error mode %+v, want every-nth 3", mode)
+	}
+	if schedule := currentErrorRateSchedule(); schedule == nil || schedule.To != 80 {
+		t.Fatalf("schedule %+v, want the archived ramp to 80", schedule)
+	}
+}
+
+// Restored totals land in this pod's Redis hash as well, so the consistency
+// check doesn't report them as drift.
+func TestImportStateRestoresPodHash(t *testing.T) {
+	useCounterStore(t, newFakeRedis(t, false), redisCounterStore{})
+	restoreAfterImport(t)
+
+	archive := StateArchive{FormatVersion: stateFormatVersion, Counters: map[string]float64{"200": 9, "503": 1}}
+	if err := importState(archive); err != nil {
+		t.Fatal(err)
+	}
+	result, err := checkCounterConsistency(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Consistent || result.Redis["200"] != 9 || result.Redis["503"] != 1 {
+		t.Fatalf("got %+v, want the archived counts on both sides", result)
+	}
+}
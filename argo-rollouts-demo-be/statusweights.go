@@ -0,0 +1,143 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"argo-rollouts-demo-be/internal/weighted"
+
+	"github.com/labstack/echo/v4"
+)
+
+// StatusWeights maps failure status codes to the percentage of /api/check
+// requests answered with them, e.g. {"503": 1, "429": 0.5}. They apply on
+// top of the error rate, which keeps injecting 500s, so {"500": 2} with an
+// error rate of 0 is the same as an error rate of 2.
+type StatusWeights map[string]float64
+
+// Fault rule reported for failures picked from the status weights
+const statusWeightsRule = "status-weights"
+
+var (
+	statusWeights   = loadStatusWeights()
+	statusWeightsMu sync.RWMutex
+)
+
+// loadStatusWeights reads STATUS_WEIGHTS, a list like "503=1,429=0.5".
+func loadStatusWeights() StatusWeights {
+	weights := StatusWeights{}
+	for _, entry := range splitList(getEnvOrDefault("STATUS_WEIGHTS", "")) {
+		status, percent, _ := strings.Cut(entry, "=")
+		value, err := strconv.ParseFloat(strings.TrimSpace(percent), 64)
+		if err != nil {
+			warnf("Ignoring STATUS_WEIGHTS entry %q: %v", entry, err)
+			continue
+		}
+		weights[strings.TrimSpace(status)] = value
+	}
+	if err := weights.validate(); err != nil {
+		warnf("Ignoring STATUS_WEIGHTS: %v", err)
+		return StatusWeights{}
+	}
+	return weights
+}
+
+func (w StatusWeights) validate() error {
+	total := 0.0
+	for status, percent := range w {
+		code, err := strconv.Atoi(status)
+		if err != nil || code < 400 || code > 599 {
+			return fmt.Errorf("status %q must be a 4xx or 5xx code", status)
+		}
+		if math.IsNaN(percent) || math.IsInf(percent, 0) || percent < 0 {
+			return fmt.Errorf("weight for %s must be a finite non-negative percentage", status)
+		}
+		total += percent
+	}
+	if total > 100 {
+		return fmt.Errorf("weights must not add up to more than 100%%, got %g%%", total)
+	}
+	return nil
+}
+
+func (w StatusWeights) totalPercent() float64 {
+	total := 0.0
+	for _, percent := range w {
+		total += percent
+	}
+	return total
+}
+
+func currentStatusWeights() StatusWeights {
+	statusWeightsMu.RLock()
+	defer statusWeightsMu.RUnlock()
+	weights := make(StatusWeights, len(statusWeights))
+	for status, percent := range statusWeights {
+		weights[status] = percent
+	}
+	return weights
+}
+
+func storeStatusWeights(weights StatusWeights) {
+	statusWeightsMu.Lock()
+	statusWeights = weights
+	statusWeightsMu.Unlock()
+}
+
+// statusWeightChoices turns the weights into check outcomes weighted by their
+// probability, sorted by status code so a seeded random source replays the
+// same picks.
+func statusWeightChoices(weights StatusWeights) []weighted.Choice[checkOutcome] {
+	choices := make([]weighted.Choice[checkOutcome], 0, len(weights))
+	for status, percent := range weights {
+		code, err := strconv.Atoi(status)
+		if err != nil || percent <= 0 {
+			continue
+		}
+		outcome := checkOutcome{status: code, rule: statusWeightsRule, probability: percent / 100.0}
+		choices = append(choices, weighted.Choice[checkOutcome]{Value: outcome, Weight: outcome.probability})
+	}
+	sort.Slice(choices, func(i, j int) bool { return choices[i].Value.status < choices[j].Value.status })
+	return choices
+}
+
+func getStatusWeightsHandler(c echo.Context) error {
+	weights := currentStatusWeights()
+	httpRequestsTotal.WithLabelValues("/api/status-weights", fmt.Sprintf("%d", http.StatusOK)).Inc()
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"weights":      weights,
+		"totalPercent": weights.totalPercent(),
+	})
+}
+
+// setStatusWeightsHandler replaces the whole weight map; an empty map turns
+// the extra failure codes off again.
+func setStatusWeightsHandler(c echo.Context) error {
+	var update StatusWeights
+	if err := json.NewDecoder(c.Request().Body).Decode(&update); err != nil {
+		httpRequestsTotal.WithLabelValues("/api/set-status-weights", fmt.Sprintf("%d", http.StatusBadRequest)).Inc()
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid JSON"})
+	}
+	if update == nil {
+		update = StatusWeights{}
+	}
+	if err := update.validate(); err != nil {
+		httpRequestsTotal.WithLabelValues("/api/set-status-weights", fmt.Sprintf("%d", http.StatusBadRequest)).Inc()
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+
+	storeStatusWeights(update)
+
+	httpRequestsTotal.WithLabelValues("/api/set-status-weights", fmt.Sprintf("%d", http.StatusOK)).Inc()
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"message":      "Status weights updated",
+		"weights":      update,
+		"totalPercent": update.totalPercent(),
+	})
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Subsystem states reported by /api/status.
+const (
+	subsystemDisabled = "disabled" // Switched off with <NAME>_ENABLED
+	subsystemIdle     = "idle"     // Enabled, not needed yet
+	subsystemReady    = "ready"
+	subsystemFailed   = "failed" // Initialization failed; the feature stays off
+)
+
+// SubsystemStatus is a subsystem's entry in /api/status.
+type SubsystemStatus struct {
+	Name          string     `json:"name"`
+	Enabled       bool       `json:"enabled"`
+	State         string     `json:"state"`
+	Error         string     `json:"error,omitempty"`
+	InitializedAt *time.Time `json:"initializedAt,omitempty"`
+}
+
+// subsystem is an optional feature that is set up on first use rather than
+// at startup. Initialization runs at most once, even when the first uses
+// race, and a failure (or panic) only disables the feature.
+type subsystem struct {
+	name    string
+	enabled bool
+	init    func() error // Nil when there is nothing to set up
+
+	once          sync.Once
+	mu            sync.RWMutex
+	initialized   bool
+	err           error
+	initializedAt time.Time
+}
+
+var (
+	tracingSubsystem = newSubsystem("tracing", nil)
+	busSubsystem     = newSubsystem("bus", initAlertEventBus)
+	loadgenSubsystem = newSubsystem("loadgen", initLoadgenMetrics)
+	flagsSubsystem   = newSubsystem("flags", initFlagEngine)
+
+	subsystems = []*subsystem{tracingSubsystem, busSubsystem, loadgenSubsystem, flagsSubsystem}
+
+	subsystemReadyGauge = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "subsystem_ready",
+			Help: "Whether an optional subsystem initialized successfully (1) or not, or not yet (0)",
+		},
+		[]string{"subsystem"},
+	)
+)
+
+func newSubsystem(name string, init func() error) *subsystem {
+	key := strings.ToUpper(name) + "_ENABLED"
+	return &subsystem{
+		name:    name,
+		enabled: isTruthy(getEnvOrDefault(key, "true")),
+		init:    init,
+	}
+}
+
+// start initializes the subsystem on first call and returns the outcome on
+// every call. A disabled subsystem is never initialized.
+func (s *subsystem) start() error {
+	if !s.enabled {
+		return fmt.Errorf("subsystem %s is disabled", s.name)
+	}
+	s.once.Do(func() {
+		began := time.Now()
+		err := s.runInit()
+
+		s.mu.Lock()
+		s.initialized = true
+		s.err = err
+		s.initializedAt = time.Now().UTC()
+		s.mu.Unlock()
+
+		if err != nil {
+			warnf("Subsystem %s failed to initialize: %v", s.name, err)
+			subsystemReadyGauge.WithLabelValues(s.name).Set(0)
+			return
+		}
+		subsystemReadyGauge.WithLabelValues(s.name).Set(1)
+		debugf("subsystem %s initialized in %s", s.name, time.Since(began))
+	})
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.err != nil {
+		return fmt.Errorf("subsystem %s unavailable: %w", s.name, s.err)
+	}
+	return nil
+}
+
+func (s *subsystem) runInit() (err error) {
+	if s.init == nil {
+		return nil
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return s.init()
+}
+
+func (s *subsystem) status() SubsystemStatus {
+	status := SubsystemStatus{Name: s.name, Enabled: s.enabled, State: subsystemDisabled}
+	if !s.enabled {
+		return status
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	switch {
+	case !s.initialized:
+		status.State = subsystemIdle
+	case s.err != nil:
+		status.State = subsystemFailed
+		status.Error = s.err.Error()
+	default:
+		status.State = subsystemReady
+	}
+	if s.initialized {
+		initializedAt := s.initializedAt
+		status.InitializedAt = &initializedAt
+	}
+	return status
+}
+
+func subsystemStatuses() []SubsystemStatus {
+	statuses := make([]SubsystemStatus, 0, len(subsystems))
+	for _, s := range subsystems {
+		statuses = append(statuses, s.status())
+	}
+	return statuses
+}
+
+// stopSubsystems ends the background workers subsystems started on demand,
+// such as the alert event relay.
+func stopSubsystems(ctx context.Context) error {
+	close(backgroundStop)
+	return nil
+}
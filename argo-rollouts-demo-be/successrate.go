@@ -0,0 +1,206 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/redis/go-redis/v9"
+)
+
+const defaultSuccessWindow = 60 * time.Second
+
+var (
+	// Longest window /api/analysis/success-rate accepts. Buckets are kept
+	// that long, one per second per version.
+	successRateMaxWindow = time.Duration(getEnvFloatOrDefault("SUCCESS_RATE_MAX_WINDOW_SECONDS", 300)) * time.Second
+
+	localSuccessWindow = newSuccessWindow(successRateMaxWindow)
+)
+
+// successBucket holds the /api/check outcomes of one second.
+type successBucket struct {
+	second    int64 // Unix seconds
+	succeeded int64
+	total     int64
+}
+
+// successWindow is this pod's per-second outcomes, for when Redis isn't
+// available. It is a ring indexed by the second.
+type successWindow struct {
+	mu      sync.Mutex
+	buckets []successBucket
+}
+
+func newSuccessWindow(span time.Duration) *successWindow {
+	return &successWindow{buckets: make([]successBucket, max(int(span/time.Second), 1))}
+}
+
+func (w *successWindow) add(now time.Time, counts map[int]int64) {
+	succeeded, total := foldSuccessCounts(counts)
+	second := now.Unix()
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	bucket := &w.buckets[second%int64(len(w.buckets))]
+	if bucket.second != second {
+		*bucket = successBucket{second: second}
+	}
+	bucket.succeeded += succeeded
+	bucket.total += total
+}
+
+// sum adds up the seconds in the window ending at now, now included.
+func (w *successWindow) sum(now time.Time, window time.Duration) (int64, int64) {
+	from := now.Unix() - int64(window/time.Second)
+	var succeeded, total int64
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for _, bucket := range w.buckets {
+		if bucket.second > from && bucket.second <= now.Unix() {
+			succeeded += bucket.succeeded
+			total += bucket.total
+		}
+	}
+	return succeeded, total
+}
+
+// successWindowKey is a per-second counter of one version, field being
+// "ok" for passed checks or "all".
+func successWindowKey(v string, second int64, field string) string {
+	return fmt.Sprintf("success_window:v%s:%d:%s", strings.TrimPrefix(v, "v"), second, field)
+}
+
+// foldSuccessCounts splits check outcomes into passed and total.
+func foldSuccessCounts(counts map[int]int64) (int64, int64) {
+	var succeeded, total int64
+	for statusCode, n := range counts {
+		if statusCode == http.StatusOK {
+			succeeded += n
+		}
+		total += n
+	}
+	return succeeded, total
+}
+
+// addSuccessWindow queues the increments of this second's shared buckets.
+// They expire once they are older than the longest window.
+func addSuccessWindow(ctx context.Context, pipe redis.Pipeliner, now time.Time, counts map[int]int64) {
+	succeeded, total := foldSuccessCounts(counts)
+	ttl := successRateMaxWindow + 10*time.Second
+	for field, n := range map[string]int64{"ok": succeeded, "all": total} {
+		key := successWindowKey(version, now.Unix(), field)
+		pipe.IncrBy(ctx, key, n)
+		pipe.Expire(ctx, key, ttl)
+	}
+}
+
+// redisSuccessWindow sums the shared buckets of the window for version v,
+// or all versions when v is empty.
+func redisSuccessWindow(ctx context.Context, client redis.UniversalClient, v string, now time.Time, window time.Duration) (int64, int64, error) {
+	versions := []string{v}
+	if v == "" {
+		var err error
+		versions, err = client.SMembers(ctx, statusVersionsKey).Result()
+		if err != nil {
+			return 0, 0, err
+		}
+		if len(versions) == 0 {
+			return 0, 0, nil
+		}
+	}
+
+	seconds := int64(window / time.Second)
+	keys := make([]string, 0, 2*int(seconds)*len(versions))
+	for _, v := range versions {
+		for second := now.Unix() - seconds + 1; second <= now.Unix(); second++ {
+			keys = append(keys, successWindowKey(v, second, "ok"), successWindowKey(v, second, "all"))
+		}
+	}
+	values, err := getKeys(ctx, client, keys)
+	if err != nil {
+		return 0, 0, err
+	}
+	var succeeded, total int64
+	for i := 0; i < len(values); i += 2 {
+		succeeded += int64(redisFloat(values[i]))
+		total += int64(redisFloat(values[i+1]))
+	}
+	return succeeded, total, nil
+}
+
+// parseSuccessWindow accepts a Go duration ("60s", "5m") or plain seconds.
+func parseSuccessWindow(value string) (time.Duration, error) {
+	if value == "" {
+		return defaultSuccessWindow, nil
+	}
+	window, err := time.ParseDuration(value)
+	if err != nil {
+		seconds, parseErr := strconv.ParseFloat(value, 64)
+		if parseErr != nil {
+			return 0, fmt.Errorf("window must be a duration like 60s or a number of seconds")
+		}
+		window = time.Duration(seconds * float64(time.Second))
+	}
+	if window < time.Second || window > successRateMaxWindow {
+		return 0, fmt.Errorf("window must be between 1s and %s", successRateMaxWindow)
+	}
+	return window.Truncate(time.Second), nil
+}
+
+// successRateHandler serves GET /api/analysis/success-rate: the percentage
+// of /api/check requests that passed over a trailing window (?window=60s,
+// optionally ?version=). Unlike the cumulative counters it tells a canary
+// that just started failing from one that failed long ago. The body is a
+// bare JSON number so an Argo Rollouts web metric can use jsonPath "{$}";
+// ?verbose=1 returns the counts behind it. An empty window reads 100.
+func successRateHandler(c echo.Context) error {
+	window, err := parseSuccessWindow(c.QueryParam("window"))
+	if err != nil {
+		httpRequestsTotal.WithLabelValues("/api/analysis/success-rate", fmt.Sprintf("%d", http.StatusBadRequest)).Inc()
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+	v := c.QueryParam("version")
+
+	now := time.Now()
+	source := "local"
+	var succeeded, total int64
+	// Only the Redis store writes the shared buckets; with any other an
+	// empty Redis window would read as a perfect 100
+	if usesRedisCounters() {
+		err = simulatedOutage(failFeatureStore)
+		if err == nil {
+			succeeded, total, err = redisSuccessWindow(c.Request().Context(), redisClient, v, now, window)
+		}
+		if err == nil {
+			source = "redis"
+		} else if err := dependencyFailure(failFeatureStore, err); err != nil {
+			httpRequestsTotal.WithLabelValues("/api/analysis/success-rate", fmt.Sprintf("%d", http.StatusServiceUnavailable)).Inc()
+			return c.JSON(http.StatusServiceUnavailable, map[string]string{"error": "Counter store unavailable"})
+		}
+	}
+	if source == "local" && (v == "" || strings.TrimPrefix(v, "v") == strings.TrimPrefix(version, "v")) {
+		succeeded, total = localSuccessWindow.sum(now, window)
+	}
+
+	rate := 100.0
+	if total > 0 {
+		rate = float64(succeeded) / float64(total) * 100.0
+	}
+
+	httpRequestsTotal.WithLabelValues("/api/analysis/success-rate", fmt.Sprintf("%d", http.StatusOK)).Inc()
+	if isTruthy(c.QueryParam("verbose")) {
+		return c.JSON(http.StatusOK, map[string]interface{}{
+			"successRate": rate,
+			"window":      window.String(),
+			"succeeded":   succeeded,
+			"requests":    total,
+			"source":      source,
+		})
+	}
+	return c.JSON(http.StatusOK, rate)
+}
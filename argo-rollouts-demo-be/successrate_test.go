@@ -0,0 +1,49 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// useCounterStore swaps the Redis client and counter store for the test.
+func useCounterStore(t *testing.T, fake *fakeRedis, store CounterStore) {
+	t.Helper()
+	previousClient, previousStore := redisClient, counterStore
+	if fake != nil {
+		redisClient = fake.client(t)
+	}
+	counterStore = store
+	t.Cleanup(func() { redisClient, counterStore = previousClient, previousStore })
+}
+
+// With Redis up but the memory store selected, nothing writes the Redis
+// buckets, so the rate has to come from this pod's window.
+func TestSuccessRateMemoryStoreWithRedis(t *testing.T) {
+	useCounterStore(t, newFakeRedis(t, false), newMemoryCounterStore())
+	previousWindow := localSuccessWindow
+	localSuccessWindow = newSuccessWindow(successRateMaxWindow)
+	t.Cleanup(func() { localSuccessWindow = previousWindow })
+
+	localSuccessWindow.add(time.Now(), map[int]int64{http.StatusOK: 1, http.StatusInternalServerError: 3})
+
+	req := httptest.NewRequest(http.MethodGet, "/api/analysis/success-rate?verbose=1", nil)
+	rec := httptest.NewRecorder()
+	if err := successRateHandler(echo.New().NewContext(req, rec)); err != nil {
+		t.Fatal(err)
+	}
+	var body struct {
+		SuccessRate float64 `json:"successRate"`
+		Source      string  `json:"source"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	if body.Source != "local" || body.SuccessRate != 25 {
+		t.Fatalf("got %+v, want 25%% from the local window", body)
+	}
+}
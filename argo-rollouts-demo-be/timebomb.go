@@ -0,0 +1,156 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Time bomb states reported by /api/status.
+const (
+	timeBombArmed     = "armed"     // Counting down, every check passes
+	timeBombDetonated = "detonated" // Failing at ErrorRate
+)
+
+// TimeBomb makes a canary behave perfectly for GraceSeconds after startup
+// and then degrade to ErrorRate, showing why an analysis that finishes
+// before the problem starts promotes a bad release. Setting it through the
+// API re-arms it from that moment.
+type TimeBomb struct {
+	Enabled      bool      `json:"enabled"`
+	GraceSeconds float64   `json:"graceSeconds"`
+	ErrorRate    float64   `json:"errorRate"` // Percentage applied once detonated
+	ArmedAt      time.Time `json:"armedAt"`   // Start of the countdown, ignored on input
+}
+
+// TimeBombStatus is the countdown shown in /api/status.
+type TimeBombStatus struct {
+	State            string    `json:"state"`
+	DetonatesAt      time.Time `json:"detonatesAt"`
+	SecondsRemaining float64   `json:"secondsRemaining"`
+	ErrorRate        float64   `json:"errorRate"`
+}
+
+var (
+	timeBomb = TimeBomb{
+		Enabled:      getEnvOrDefault("TIME_BOMB_GRACE_SECONDS", "") != "",
+		GraceSeconds: getEnvFloatOrDefault("TIME_BOMB_GRACE_SECONDS", 60),
+		ErrorRate:    getEnvFloatOrDefault("TIME_BOMB_ERROR_RATE", 100),
+		ArmedAt:      startTime,
+	}
+	timeBombMu sync.RWMutex
+)
+
+func init() {
+	promauto.NewGaugeFunc(
+		prometheus.GaugeOpts{
+			Name: "time_bomb_seconds_remaining",
+			Help: "Seconds until the time bomb failure mode goes off, 0 once detonated or when disabled",
+		},
+		func() float64 {
+			if status, ok := timeBombStatus(time.Now()); ok {
+				return status.SecondsRemaining
+			}
+			return 0
+		},
+	)
+}
+
+func (b TimeBomb) validate() error {
+	if math.IsNaN(b.GraceSeconds) || math.IsInf(b.GraceSeconds, 0) || b.GraceSeconds < 0 {
+		return fmt.Errorf("graceSeconds must be a finite non-negative number")
+	}
+	return validateErrorRatePercent(b.ErrorRate)
+}
+
+func (b TimeBomb) detonatesAt() time.Time {
+	return b.ArmedAt.Add(time.Duration(b.GraceSeconds * float64(time.Second)))
+}
+
+// timeBombStatus reports the countdown, or false when the time bomb is off.
+func timeBombStatus(now time.Time) (TimeBombStatus, bool) {
+	timeBombMu.RLock()
+	current := timeBomb
+	timeBombMu.RUnlock()
+
+	if !current.Enabled {
+		return TimeBombStatus{}, false
+	}
+	status := TimeBombStatus{
+		State:       timeBombDetonated,
+		DetonatesAt: current.detonatesAt(),
+		ErrorRate:   current.ErrorRate,
+	}
+	if remaining := status.DetonatesAt.Sub(now); remaining > 0 {
+		status.State = timeBombArmed
+		status.SecondsRemaining = remaining.Seconds()
+	}
+	return status, true
+}
+
+// timeBombErrorRate replaces the error rate and rule while the time bomb is
+// enabled: nothing fails before it goes off, and ErrorRate applies after.
+// The bool reports whether the bomb is still armed, in which case the other
+// injected failure codes are held back too.
+func timeBombErrorRate(rate float64, rule string) (float64, string, bool) {
+	status, ok := timeBombStatus(time.Now())
+	if !ok {
+		return rate, rule, false
+	}
+	if status.State == timeBombArmed {
+		return 0, "time-bomb", true
+	}
+	return status.ErrorRate / 100.0, "time-bomb", false
+}
+
+func getTimeBombHandler(c echo.Context) error {
+	timeBombMu.RLock()
+	current := timeBomb
+	timeBombMu.RUnlock()
+
+	response := map[string]interface{}{
+		"enabled":      current.Enabled,
+		"graceSeconds": current.GraceSeconds,
+		"errorRate":    current.ErrorRate,
+		"armedAt":      current.ArmedAt,
+	}
+	if status, ok := timeBombStatus(time.Now()); ok {
+		response["state"] = status.State
+		response["detonatesAt"] = status.DetonatesAt
+		response["secondsRemaining"] = status.SecondsRemaining
+	}
+
+	httpRequestsTotal.WithLabelValues("/api/time-bomb", fmt.Sprintf("%d", http.StatusOK)).Inc()
+	return c.JSON(http.StatusOK, response)
+}
+
+// setTimeBombHandler updates the time bomb and restarts the countdown.
+func setTimeBombHandler(c echo.Context) error {
+	timeBombMu.RLock()
+	update := timeBomb
+	timeBombMu.RUnlock()
+
+	if err := json.NewDecoder(c.Request().Body).Decode(&update); err != nil {
+		httpRequestsTotal.WithLabelValues("/api/time-bomb", fmt.Sprintf("%d", http.StatusBadRequest)).Inc()
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid JSON"})
+	}
+	if err := update.validate(); err != nil {
+		httpRequestsTotal.WithLabelValues("/api/time-bomb", fmt.Sprintf("%d", http.StatusBadRequest)).Inc()
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+	update.ArmedAt = time.Now().UTC()
+
+	timeBombMu.Lock()
+	timeBomb = update
+	timeBombMu.Unlock()
+
+	httpRequestsTotal.WithLabelValues("/api/time-bomb", fmt.Sprintf("%d", http.StatusOK)).Inc()
+	return c.JSON(http.StatusOK, update)
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"crypto/tls"
+	"errors"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// With TLS_CERT_FILE and TLS_KEY_FILE set the API is also served over
+// HTTPS on TLS_ADDR, next to the plain listener that probes and scrapes
+// keep using. H2C_ENABLED=true adds HTTP/2 without TLS to the plain
+// listener, for meshes that speak h2c between sidecar and app.
+var (
+	tlsCertFile = getEnvOrDefault("TLS_CERT_FILE", "")
+	tlsKeyFile  = getEnvOrDefault("TLS_KEY_FILE", "")
+	tlsAddr     = getEnvOrDefault("TLS_ADDR", ":8443")
+	h2cEnabled  = isTruthy(getEnvOrDefault("H2C_ENABLED", "false"))
+)
+
+// tlsCertLoader serves the key pair from disk and reloads it when the files
+// change, so a certificate renewed by cert-manager is used without a
+// restart. Files are checked at most every SECRETS_RELOAD_SECONDS.
+type tlsCertLoader struct {
+	certFile, keyFile string
+
+	mu      sync.Mutex
+	cert    *tls.Certificate
+	modTime time.Time
+	checked time.Time
+}
+
+func newTLSCertLoader(certFile, keyFile string) (*tlsCertLoader, error) {
+	l := &tlsCertLoader{certFile: certFile, keyFile: keyFile}
+	if err := l.load(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// latestModTime is the newer of the two files' modification times.
+func (l *tlsCertLoader) latestModTime() (time.Time, error) {
+	var latest time.Time
+	for _, name := range []string{l.certFile, l.keyFile} {
+		info, err := os.Stat(name)
+		if err != nil {
+			return latest, err
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest, nil
+}
+
+func (l *tlsCertLoader) load() error {
+	modTime, err := l.latestModTime()
+	if err != nil {
+		return err
+	}
+	cert, err := tls.LoadX509KeyPair(l.certFile, l.keyFile)
+	if err != nil {
+		return err
+	}
+	l.cert, l.modTime, l.checked = &cert, modTime, time.Now()
+	return nil
+}
+
+func (l *tlsCertLoader) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if time.Since(l.checked) >= secretsReloadInterval {
+		l.checked = time.Now()
+		if modTime, err := l.latestModTime(); err != nil {
+			warnf("Failed to check TLS certificate files: %v", err)
+		} else if !modTime.Equal(l.modTime) {
+			// Keep serving the old pair if the new one is unusable, e.g.
+			// caught between the certificate and key being written
+			if err := l.load(); err != nil {
+				warnf("Failed to reload TLS certificate: %v", err)
+			} else {
+				infof("Reloaded TLS certificate from %s", l.certFile)
+			}
+		}
+	}
+	return l.cert, nil
+}
+
+// configureTLSServer prepares Echo's TLS server when a certificate is
+// configured; httpServer starts it when it has a TLS config.
+func configureTLSServer(e *echo.Echo) error {
+	if tlsCertFile == "" && tlsKeyFile == "" {
+		return nil
+	}
+	if tlsCertFile == "" || tlsKeyFile == "" {
+		return errors.New("TLS_CERT_FILE and TLS_KEY_FILE must be set together")
+	}
+	loader, err := newTLSCertLoader(tlsCertFile, tlsKeyFile)
+	if err != nil {
+		return err
+	}
+
+	s := e.TLSServer
+	s.Addr = tlsAddr
+	s.TLSConfig = &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: loader.getCertificate,
+		NextProtos:     []string{"h2", "http/1.1"},
+	}
+	s.ConnState = trackConnState
+	applyServerLimits(s)
+	return nil
+}
+
+// enableH2C lets the plain listener accept HTTP/2 with prior knowledge
+// alongside HTTP/1.1.
+func enableH2C(s *http.Server) {
+	s.Protocols = new(http.Protocols)
+	s.Protocols.SetHTTP1(true)
+	s.Protocols.SetUnencryptedHTTP2(true)
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+const topologyServiceID = "argo-rollouts-demo-be"
+
+// Health of a node or edge in the service map.
+const (
+	topologyHealthy  = "healthy"
+	topologyDegraded = "degraded"
+	topologyDown     = "down"
+)
+
+// TopologyNode is a service in the simulated graph. Only this service and
+// Redis are real; the dependencies exist only to give the map some shape.
+type TopologyNode struct {
+	ID        string  `json:"id"`
+	Type      string  `json:"type"` // frontend, service, cache or dependency
+	Version   string  `json:"version,omitempty"`
+	Health    string  `json:"health"`
+	ErrorRate float64 `json:"errorRate"` // Percentage
+	LatencyMs float64 `json:"latencyMs"`
+	Simulated bool    `json:"simulated"`
+}
+
+// TopologyEdge is a call from Source to Target, carrying the health the
+// caller observes.
+type TopologyEdge struct {
+	Source    string  `json:"source"`
+	Target    string  `json:"target"`
+	Health    string  `json:"health"`
+	ErrorRate float64 `json:"errorRate"`
+	LatencyMs float64 `json:"latencyMs"`
+}
+
+type Topology struct {
+	GeneratedAt time.Time      `json:"generatedAt"`
+	Nodes       []TopologyNode `json:"nodes"`
+	Edges       []TopologyEdge `json:"edges"`
+}
+
+var (
+	topologyDependencies = splitList(getEnvOrDefault("TOPOLOGY_DEPENDENCIES", "auth-service,catalog-service,payments-service"))
+
+	// Dependency blamed for the injected failures. Unset, each version picks
+	// one deterministically so a canary appears to break a different call.
+	topologyFaultyDependency = getEnvOrDefault("TOPOLOGY_FAULTY_DEPENDENCY", "")
+
+	topologyRedisTimeout = 250 * time.Millisecond
+)
+
+func topologyHealth(errorRate, latencyMs float64) string {
+	switch {
+	case errorRate >= 25:
+		return topologyDown
+	case errorRate >= 1 || latencyMs >= 500:
+		return topologyDegraded
+	}
+	return topologyHealthy
+}
+
+// dependencyBaseLatency gives each simulated dependency a stable latency
+// between 5 and 40ms.
+func dependencyBaseLatency(name string) float64 {
+	h := fnv.New32a()
+	h.Write([]byte(name))
+	return float64(5 + h.Sum32()%36)
+}
+
+func faultyDependency() string {
+	if topologyFaultyDependency != "" || len(topologyDependencies) == 0 {
+		return topologyFaultyDependency
+	}
+	h := fnv.New32a()
+	h.Write([]byte(version))
+	return topologyDependencies[h.Sum32()%uint32(len(topologyDependencies))]
+}
+
+// redisNode pings the real Redis, with a short timeout so a hanging Redis
+// shows up as down instead of stalling the map.
+func redisNode(ctx context.Context) TopologyNode {
+	node := TopologyNode{ID: "redis", Type: "cache", Health: topologyDown, ErrorRate: 100}
+	if redisClient == nil {
+		return node
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, topologyRedisTimeout)
+	defer cancel()
+	start := time.Now()
+	if err := redisClient.Ping(ctx).Err(); err != nil {
+		return node
+	}
+	node.LatencyMs = float64(time.Since(start)) / float64(time.Millisecond)
+	node.ErrorRate = 0
+	node.Health = topologyHealth(0, node.LatencyMs)
+	return node
+}
+
+// buildTopology derives the graph from the live chaos settings: the error
+// rate lands on the faulty dependency and injected latency on all of them.
+func buildTopology(ctx context.Context) Topology {
+	errorRate := effectiveErrorRate() * 100.0
+
+	faultConfigMu.RLock()
+	faults := faultConfig
+	faultConfigMu.RUnlock()
+
+	faulty := faultyDependency()
+	nodes := []TopologyNode{{ID: "argo-rollouts-demo-fe", Type: "frontend", Health: topologyHealthy, Simulated: true}}
+	edges := []TopologyEdge{}
+
+	dependencyLatency := 0.0
+	for _, name := range topologyDependencies {
+		node := TopologyNode{
+			ID:        name,
+			Type:      "dependency",
+			LatencyMs: dependencyBaseLatency(name) + faults.LatencyMs,
+			Simulated: true,
+		}
+		if name == faulty {
+			node.ErrorRate = errorRate
+		}
+		node.Health = topologyHealth(node.ErrorRate, node.LatencyMs)
+		dependencyLatency = max(dependencyLatency, node.LatencyMs)
+
+		nodes = append(nodes, node)
+		edges = append(edges, TopologyEdge{
+			Source:    topologyServiceID,
+			Target:    name,
+			Health:    node.Health,
+			ErrorRate: node.ErrorRate,
+			LatencyMs: node.LatencyMs,
+		})
+	}
+
+	redis := redisNode(ctx)
+	nodes = append(nodes, redis)
+	edges = append(edges, TopologyEdge{
+		Source:    topologyServiceID,
+		Target:    redis.ID,
+		Health:    redis.Health,
+		ErrorRate: redis.ErrorRate,
+		LatencyMs: redis.LatencyMs,
+	})
+
+	// The service fails whenever its faulty dependency does, and waits on
+	// its slowest one
+	serviceErrorRate := errorRate + faults.ErrorRate*(100-errorRate)/100
+	service := TopologyNode{
+		ID:        topologyServiceID,
+		Type:      "service",
+		Version:   version,
+		ErrorRate: serviceErrorRate,
+		LatencyMs: dependencyLatency,
+	}
+	service.Health = topologyHealth(service.ErrorRate, service.LatencyMs)
+	nodes = append(nodes, service)
+	edges = append(edges, TopologyEdge{
+		Source:    "argo-rollouts-demo-fe",
+		Target:    topologyServiceID,
+		Health:    service.Health,
+		ErrorRate: service.ErrorRate,
+		LatencyMs: service.LatencyMs,
+	})
+
+	return Topology{GeneratedAt: time.Now().UTC(), Nodes: nodes, Edges: edges}
+}
+
+func topologyHandler(c echo.Context) error {
+	httpRequestsTotal.WithLabelValues("/api/topology", fmt.Sprintf("%d", http.StatusOK)).Inc()
+	return c.JSON(http.StatusOK, buildTopology(c.Request().Context()))
+}
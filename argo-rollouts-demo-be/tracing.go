@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	crand "crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/redis/go-redis/v9"
+)
+
+// TraceContext is a minimal W3C Trace Context (traceparent) span. The demo
+// doesn't export spans anywhere; it only keeps IDs consistent so a failing
+// request can be correlated across the frontend, logs and Redis.
+type TraceContext struct {
+	TraceID    string
+	SpanID     string
+	ParentID   string
+	Flags      string
+	TraceState string
+}
+
+type traceContextKey struct{}
+
+// randomHex makes trace and request IDs. They come from crypto/rand rather
+// than the simulation's sources, which RNG_SEED makes identical on every pod.
+func randomHex(n int) string {
+	buf := make([]byte, n)
+	crand.Read(buf)
+	return hex.EncodeToString(buf)
+}
+
+func isValidHexID(id string, length int) bool {
+	if len(id) != length || strings.Trim(id, "0") == "" {
+		return false
+	}
+	_, err := hex.DecodeString(id)
+	return err == nil
+}
+
+// parseTraceparent parses a version 00 traceparent header.
+func parseTraceparent(header string) (TraceContext, bool) {
+	parts := strings.Split(strings.TrimSpace(header), "-")
+	if len(parts) != 4 || parts[0] != "00" {
+		return TraceContext{}, false
+	}
+	if !isValidHexID(parts[1], 32) || !isValidHexID(parts[2], 16) || len(parts[3]) != 2 {
+		return TraceContext{}, false
+	}
+	return TraceContext{TraceID: parts[1], ParentID: parts[2], Flags: parts[3]}, true
+}
+
+func (t TraceContext) Traceparent() string {
+	return fmt.Sprintf("00-%s-%s-%s", t.TraceID, t.SpanID, t.Flags)
+}
+
+// Child returns a new span in the same trace, parented to t.
+func (t TraceContext) Child() TraceContext {
+	return TraceContext{
+		TraceID:    t.TraceID,
+		SpanID:     randomHex(8),
+		ParentID:   t.SpanID,
+		Flags:      t.Flags,
+		TraceState: t.TraceState,
+	}
+}
+
+func traceFromContext(ctx context.Context) (TraceContext, bool) {
+	trace, ok := ctx.Value(traceContextKey{}).(TraceContext)
+	return trace, ok
+}
+
+// injectTraceHeaders propagates the trace in ctx onto an outbound request as
+// a new child span.
+func injectTraceHeaders(ctx context.Context, req *http.Request) {
+	trace, ok := traceFromContext(ctx)
+	if !ok {
+		return
+	}
+	child := trace.Child()
+	req.Header.Set("traceparent", child.Traceparent())
+	if child.TraceState != "" {
+		req.Header.Set("tracestate", child.TraceState)
+	}
+}
+
+// tracingMiddleware continues the caller's trace when a valid traceparent is
+// present and starts a new one otherwise, echoing the trace ID back. With the
+// tracing subsystem off, requests pass through untraced.
+func tracingMiddleware(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		if tracingSubsystem.start() != nil {
+			return next(c)
+		}
+		req := c.Request()
+		trace, ok := parseTraceparent(req.Header.Get("traceparent"))
+		if ok {
+			trace.SpanID = randomHex(8)
+			trace.TraceState = req.Header.Get("tracestate")
+		} else {
+			trace = TraceContext{TraceID: randomHex(16), SpanID: randomHex(8), Flags: "01"}
+		}
+
+		c.SetRequest(req.WithContext(context.WithValue(req.Context(), traceContextKey{}, trace)))
+		c.Response().Header().Set("X-Trace-Id", trace.TraceID)
+
+		return next(c)
+	}
+}
+
+// redisTraceHook attaches the active trace to Redis commands. Without a
+// tracing backend the "span attributes" end up in the debug log.
+type redisTraceHook struct{}
+
+func (redisTraceHook) DialHook(next redis.DialHook) redis.DialHook {
+	return next
+}
+
+func (redisTraceHook) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		start := time.Now()
+		err := next(ctx, cmd)
+		if trace, ok := traceFromContext(ctx); ok {
+			debugf("redis: db.operation=%s trace_id=%s parent_span_id=%s duration=%s error=%v",
+				cmd.Name(), trace.TraceID, trace.SpanID, time.Since(start), err)
+		}
+		return err
+	}
+}
+
+func (redisTraceHook) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return func(ctx context.Context, cmds []redis.Cmder) error {
+		start := time.Now()
+		err := next(ctx, cmds)
+		if trace, ok := traceFromContext(ctx); ok {
+			names := make([]string, len(cmds))
+			for i, cmd := range cmds {
+				names[i] = cmd.Name()
+			}
+			debugf("redis: db.operation=pipeline(%s) trace_id=%s parent_span_id=%s duration=%s error=%v",
+				strings.Join(names, ","), trace.TraceID, trace.SpanID, time.Since(start), err)
+		}
+		return err
+	}
+}
+
+var _ redis.Hook = redisTraceHook{}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+// Traffic patterns are parametric shapes for the load generator, for when
+// none of the recorded profiles fits:
+//
+//	constant  RPS all the time
+//	sine      between MinRPS and RPS, one wave every PeriodSeconds
+//	spike     MinRPS, then RPS for the last SpikeSeconds of every PeriodSeconds
+//	ramp      MinRPS to RPS over PeriodSeconds, then RPS
+const (
+	trafficPatternConstant = "constant"
+	trafficPatternSine     = "sine"
+	trafficPatternSpike    = "spike"
+	trafficPatternRamp     = "ramp"
+)
+
+type TrafficPattern struct {
+	Pattern       string  `json:"pattern,omitempty"` // constant when omitted
+	RPS           float64 `json:"rps"`
+	MinRPS        float64 `json:"minRps,omitempty"`
+	PeriodSeconds float64 `json:"periodSeconds,omitempty"`
+	SpikeSeconds  float64 `json:"spikeSeconds,omitempty"`
+}
+
+// TrafficScheduleStep plays a pattern for DurationSeconds.
+type TrafficScheduleStep struct {
+	TrafficPattern
+	DurationSeconds float64 `json:"durationSeconds"`
+}
+
+// TrafficSchedule plays its steps in order, holding the last one's rate
+// at the end unless it loops.
+type TrafficSchedule struct {
+	Steps []TrafficScheduleStep `json:"steps"`
+	Loop  bool                  `json:"loop,omitempty"`
+}
+
+func finiteNonNegative(name string, value float64) error {
+	if math.IsNaN(value) || math.IsInf(value, 0) || value < 0 {
+		return fmt.Errorf("%s must be a finite non-negative number", name)
+	}
+	return nil
+}
+
+func (p TrafficPattern) validate() error {
+	for name, value := range map[string]float64{"rps": p.RPS, "minRps": p.MinRPS, "periodSeconds": p.PeriodSeconds, "spikeSeconds": p.SpikeSeconds} {
+		if err := finiteNonNegative(name, value); err != nil {
+			return err
+		}
+	}
+	if p.RPS <= 0 {
+		return fmt.Errorf("rps must be positive")
+	}
+	switch p.Pattern {
+	case "", trafficPatternConstant:
+		return nil
+	case trafficPatternSine, trafficPatternSpike, trafficPatternRamp:
+	default:
+		return fmt.Errorf("unknown pattern %q, expected %s, %s, %s or %s", p.Pattern,
+			trafficPatternConstant, trafficPatternSine, trafficPatternSpike, trafficPatternRamp)
+	}
+	if p.MinRPS > p.RPS {
+		return fmt.Errorf("minRps must not be above rps")
+	}
+	if p.PeriodSeconds <= 0 {
+		return fmt.Errorf("periodSeconds must be positive for %s", p.Pattern)
+	}
+	if p.Pattern == trafficPatternSpike && (p.SpikeSeconds <= 0 || p.SpikeSeconds > p.PeriodSeconds) {
+		return fmt.Errorf("spikeSeconds must be positive and at most periodSeconds")
+	}
+	return nil
+}
+
+func (p TrafficPattern) name() string {
+	if p.Pattern == "" {
+		return trafficPatternConstant
+	}
+	return p.Pattern
+}
+
+// rate is the RPS to send elapsed into the pattern.
+func (p TrafficPattern) rate(elapsed time.Duration) float64 {
+	seconds := max(elapsed.Seconds(), 0)
+	switch p.Pattern {
+	case trafficPatternSine:
+		// Starts at the trough
+		wave := (1 - math.Cos(2*math.Pi*seconds/p.PeriodSeconds)) / 2
+		return p.MinRPS + (p.RPS-p.MinRPS)*wave
+	case trafficPatternSpike:
+		if math.Mod(seconds, p.PeriodSeconds) >= p.PeriodSeconds-p.SpikeSeconds {
+			return p.RPS
+		}
+		return p.MinRPS
+	case trafficPatternRamp:
+		progress := min(seconds/p.PeriodSeconds, 1)
+		return p.MinRPS + (p.RPS-p.MinRPS)*progress
+	}
+	return p.RPS
+}
+
+func (s TrafficSchedule) validate() error {
+	if len(s.Steps) == 0 {
+		return fmt.Errorf("schedule needs at least one step")
+	}
+	for i, step := range s.Steps {
+		if err := step.validate(); err != nil {
+			return fmt.Errorf("schedule step %d: %w", i+1, err)
+		}
+		if math.IsNaN(step.DurationSeconds) || math.IsInf(step.DurationSeconds, 0) || step.DurationSeconds <= 0 {
+			return fmt.Errorf("schedule step %d: durationSeconds must be positive", i+1)
+		}
+	}
+	return nil
+}
+
+// peakRPS is the highest rate any step asks for.
+func (s TrafficSchedule) peakRPS() float64 {
+	peak := 0.0
+	for _, step := range s.Steps {
+		peak = max(peak, step.RPS)
+	}
+	return peak
+}
+
+// at returns the step playing elapsed into the schedule and how far into
+// that step it is.
+func (s TrafficSchedule) at(elapsed time.Duration) (int, time.Duration) {
+	var total time.Duration
+	for _, step := range s.Steps {
+		total += time.Duration(step.DurationSeconds * float64(time.Second))
+	}
+	if elapsed >= total {
+		if !s.Loop {
+			last := len(s.Steps) - 1
+			return last, elapsed - (total - time.Duration(s.Steps[last].DurationSeconds*float64(time.Second)))
+		}
+		elapsed %= total
+	}
+	for i, step := range s.Steps {
+		length := time.Duration(step.DurationSeconds * float64(time.Second))
+		if elapsed < length {
+			return i, elapsed
+		}
+		elapsed -= length
+	}
+	return len(s.Steps) - 1, elapsed
+}
+
+// rate is the RPS to send elapsed into the schedule.
+func (s TrafficSchedule) rate(elapsed time.Duration) float64 {
+	i, into := s.at(elapsed)
+	return s.Steps[i].rate(into)
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"path"
+	"sort"
+	"time"
+)
+
+// TrafficProfile is a recorded traffic shape the load generator replays,
+// scaled so its highest point is the configured peak RPS. Points are
+// fractions of the peak, evenly spaced over Period and interpolated
+// linearly between. A looping profile starts over after Period, otherwise
+// the last point is held.
+type TrafficProfile struct {
+	Name        string        `json:"name"`
+	Description string        `json:"description"`
+	Period      time.Duration `json:"period"`
+	Loop        bool          `json:"loop"`
+	Points      []float64     `json:"points"`
+}
+
+//go:embed trafficprofiles/*.json
+var trafficProfileFiles embed.FS
+
+var trafficProfiles = mustLoadTrafficProfiles()
+
+func mustLoadTrafficProfiles() map[string]*TrafficProfile {
+	profiles, err := loadTrafficProfiles()
+	if err != nil {
+		panic(fmt.Sprintf("bundled traffic profiles: %v", err))
+	}
+	return profiles
+}
+
+func loadTrafficProfiles() (map[string]*TrafficProfile, error) {
+	files, err := trafficProfileFiles.ReadDir("trafficprofiles")
+	if err != nil {
+		return nil, err
+	}
+	profiles := map[string]*TrafficProfile{}
+	for _, file := range files {
+		data, err := trafficProfileFiles.ReadFile(path.Join("trafficprofiles", file.Name()))
+		if err != nil {
+			return nil, err
+		}
+		var raw struct {
+			TrafficProfile
+			Period string `json:"period"`
+		}
+		if err := json.Unmarshal(data, &raw); err != nil {
+			return nil, fmt.Errorf("%s: %w", file.Name(), err)
+		}
+		profile := raw.TrafficProfile
+		if profile.Period, err = time.ParseDuration(raw.Period); err != nil || profile.Period <= 0 {
+			return nil, fmt.Errorf("%s: invalid period %q", file.Name(), raw.Period)
+		}
+		if len(profile.Points) < 2 {
+			return nil, fmt.Errorf("%s: needs at least two points", file.Name())
+		}
+		for _, point := range profile.Points {
+			if point < 0 || point > 1 {
+				return nil, fmt.Errorf("%s: points must be between 0 and 1", file.Name())
+			}
+		}
+		profiles[profile.Name] = &profile
+	}
+	return profiles, nil
+}
+
+// trafficProfileNames lists the bundled profiles for error messages and
+// the load generator's /profile endpoint.
+func trafficProfileNames() []string {
+	names := make([]string, 0, len(trafficProfiles))
+	for name := range trafficProfiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func lookupTrafficProfile(name string) (*TrafficProfile, error) {
+	profile, ok := trafficProfiles[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown traffic profile %q, available: %v", name, trafficProfileNames())
+	}
+	return profile, nil
+}
+
+// fraction returns the share of the peak to send elapsed into a replay
+// that plays the whole profile over period, which may be shorter than the
+// recorded one to compress a day into a demo.
+func (p *TrafficProfile) fraction(elapsed, period time.Duration) float64 {
+	if period <= 0 {
+		period = p.Period
+	}
+	if elapsed < 0 {
+		elapsed = 0
+	}
+	if elapsed >= period {
+		if !p.Loop {
+			return p.Points[len(p.Points)-1]
+		}
+		elapsed %= period
+	}
+	// Looping profiles wrap from the last point back to the first, the
+	// others end on the last point exactly
+	segments := len(p.Points) - 1
+	if p.Loop {
+		segments = len(p.Points)
+	}
+	position := float64(elapsed) / float64(period) * float64(segments)
+	i := int(position)
+	from := p.Points[i%len(p.Points)]
+	to := p.Points[(i+1)%len(p.Points)]
+	return from + (to-from)*(position-float64(i))
+}
@@ -0,0 +1,263 @@
+//go:build !stable
+
+package main
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// The subset of RFC 6455 the dashboard needs: the server sends text frames
+// and pings, and reads the client's control frames. Client messages are
+// read and ignored.
+const (
+	wsAcceptGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+	wsOpText  = 0x1
+	wsOpClose = 0x8
+	wsOpPing  = 0x9
+	wsOpPong  = 0xA
+
+	wsMaxFrameBytes = 64 << 10
+	wsWriteTimeout  = 5 * time.Second
+)
+
+// DashboardFrame is sent to /ws clients on every push.
+type DashboardFrame struct {
+	Type    string `json:"type"` // Always "metrics" for now
+	Version string `json:"version"`
+	Pod     string `json:"pod"`
+	MetricsStreamEvent
+}
+
+var (
+	wsPushInterval = time.Duration(getEnvFloatOrDefault("WS_PUSH_INTERVAL_MS", 1000) * float64(time.Millisecond))
+	// Clients that don't answer (or send anything) for two ping intervals
+	// are disconnected. At least a second, which also keeps a zero or
+	// negative setting from dropping every client at once.
+	wsPingInterval = max(time.Duration(getEnvFloatOrDefault("WS_PING_INTERVAL_SECONDS", 15)*float64(time.Second)), time.Second)
+
+	websocketConnections = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "websocket_connections",
+			Help: "Number of open /ws dashboard connections",
+		},
+	)
+)
+
+// wsConn is a server-side WebSocket connection. Writes come from the push
+// loop and from the reader answering pings, hence the lock.
+type wsConn struct {
+	conn   net.Conn
+	reader *bufio.Reader
+
+	writeMu sync.Mutex
+}
+
+func (ws *wsConn) writeFrame(opcode byte, payload []byte) error {
+	header := []byte{0x80 | opcode} // FIN, never fragmented
+	switch n := len(payload); {
+	case n <= 125:
+		header = append(header, byte(n))
+	case n <= 0xFFFF:
+		header = append(header, 126, byte(n>>8), byte(n))
+	default:
+		header = append(header, 127)
+		header = binary.BigEndian.AppendUint64(header, uint64(n))
+	}
+
+	ws.writeMu.Lock()
+	defer ws.writeMu.Unlock()
+	ws.conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+	if _, err := ws.conn.Write(append(header, payload...)); err != nil {
+		return err
+	}
+	return nil
+}
+
+// readFrame reads one frame from the client, unmasking its payload.
+func (ws *wsConn) readFrame() (byte, []byte, error) {
+	var head [2]byte
+	if _, err := io.ReadFull(ws.reader, head[:]); err != nil {
+		return 0, nil, err
+	}
+	opcode := head[0] & 0x0F
+	if head[1]&0x80 == 0 {
+		return 0, nil, errors.New("client frame is not masked")
+	}
+
+	length := uint64(head[1] & 0x7F)
+	switch length {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(ws.reader, ext[:]); err != nil {
+			return 0, nil, err
+		}
+		length = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(ws.reader, ext[:]); err != nil {
+			return 0, nil, err
+		}
+		length = binary.BigEndian.Uint64(ext[:])
+	}
+	if length > wsMaxFrameBytes {
+		return 0, nil, fmt.Errorf("frame of %d bytes is too large", length)
+	}
+
+	var mask [4]byte
+	if _, err := io.ReadFull(ws.reader, mask[:]); err != nil {
+		return 0, nil, err
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(ws.reader, payload); err != nil {
+		return 0, nil, err
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+	return opcode, payload, nil
+}
+
+// readLoop handles the client's frames until the connection ends. Every
+// frame, pongs included, pushes the read deadline out, which is what drops
+// clients that went away without closing.
+func (ws *wsConn) readLoop() {
+	for {
+		ws.conn.SetReadDeadline(time.Now().Add(2 * wsPingInterval))
+		opcode, payload, err := ws.readFrame()
+		if err != nil {
+			return
+		}
+		switch opcode {
+		case wsOpPing:
+			if err := ws.writeFrame(wsOpPong, payload); err != nil {
+				return
+			}
+		case wsOpClose:
+			ws.writeFrame(wsOpClose, payload)
+			return
+		}
+	}
+}
+
+func headerHasToken(header http.Header, name, token string) bool {
+	for _, value := range header.Values(name) {
+		for _, part := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(part), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func wsAcceptKey(key string) string {
+	digest := sha1.Sum([]byte(key + wsAcceptGUID))
+	return base64.StdEncoding.EncodeToString(digest[:])
+}
+
+// websocketHandler serves /ws, pushing a DashboardFrame every
+// WS_PUSH_INTERVAL_MS so the demo UI can chart canary and stable live.
+// The server pings every WS_PING_INTERVAL_SECONDS; browsers answer
+// automatically.
+func websocketHandler(c echo.Context) error {
+	req := c.Request()
+	key := req.Header.Get("Sec-WebSocket-Key")
+	if !headerHasToken(req.Header, "Connection", "upgrade") || !headerHasToken(req.Header, "Upgrade", "websocket") ||
+		req.Header.Get("Sec-WebSocket-Version") != "13" || key == "" {
+		httpRequestsTotal.WithLabelValues("/ws", fmt.Sprintf("%d", http.StatusBadRequest)).Inc()
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Expected a WebSocket upgrade"})
+	}
+
+	conn, rw, err := c.Response().Hijack()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	// The server's read timeout would otherwise still apply
+	conn.SetDeadline(time.Time{})
+
+	handshake := "HTTP/1.1 101 Switching Protocols\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + wsAcceptKey(key) + "\r\n\r\n"
+	if _, err := conn.Write([]byte(handshake)); err != nil {
+		return nil
+	}
+	c.Response().Status = http.StatusSwitchingProtocols
+	httpRequestsTotal.WithLabelValues("/ws", fmt.Sprintf("%d", http.StatusSwitchingProtocols)).Inc()
+
+	websocketConnections.Inc()
+	defer websocketConnections.Dec()
+
+	ws := &wsConn{conn: conn, reader: rw.Reader}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ws.readLoop()
+	}()
+	serveDashboard(ws, done)
+	return nil
+}
+
+// serveDashboard pushes frames and pings until the client leaves or the
+// server shuts down.
+func serveDashboard(ws *wsConn, done <-chan struct{}) {
+	push := time.NewTicker(max(wsPushInterval, 100*time.Millisecond))
+	defer push.Stop()
+	ping := time.NewTicker(wsPingInterval)
+	defer ping.Stop()
+
+	send := func() error {
+		event, err := currentMetricsEvent()
+		if err != nil {
+			// Skip the tick, the client keeps its last numbers
+			debugf("ws: skipping push: %v", err)
+			return nil
+		}
+		encoded, err := json.Marshal(DashboardFrame{Type: "metrics", Version: version, Pod: podName, MetricsStreamEvent: event})
+		if err != nil {
+			warnf("Failed to encode dashboard frame: %v", err)
+			return nil
+		}
+		return ws.writeFrame(wsOpText, encoded)
+	}
+
+	if err := send(); err != nil {
+		return
+	}
+	for {
+		select {
+		case <-done:
+			return
+		case <-streamsStop:
+			// 1001: going away
+			ws.writeFrame(wsOpClose, []byte{0x03, 0xE9})
+			return
+		case <-push.C:
+			if err := send(); err != nil {
+				return
+			}
+		case <-ping.C:
+			if err := ws.writeFrame(wsOpPing, nil); err != nil {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"sync"
+
+	"github.com/labstack/echo/v4"
+)
+
+// WeightFailure simulates a bug that only appears at scale: while enabled,
+// /api/check fails only once the canary weight is above Threshold, so the
+// early rollout steps pass analysis and a later step catches the regression.
+type WeightFailure struct {
+	Enabled   bool    `json:"enabled"`
+	Threshold float64 `json:"threshold"` // Canary weight in percent
+	ErrorRate float64 `json:"errorRate"` // Percentage applied above the threshold
+}
+
+var (
+	weightFailure = WeightFailure{
+		Enabled:   getEnvOrDefault("WEIGHT_FAILURE_THRESHOLD", "") != "",
+		Threshold: getEnvFloatOrDefault("WEIGHT_FAILURE_THRESHOLD", 50),
+		ErrorRate: getEnvFloatOrDefault("WEIGHT_FAILURE_ERROR_RATE", 100),
+	}
+	weightFailureMu sync.RWMutex
+)
+
+// weightGatedErrorRate replaces the error rate and rule while the weight
+// failure mode is enabled. An unknown weight counts as below the threshold.
+func weightGatedErrorRate(rate float64, rule string) (float64, string) {
+	weightFailureMu.RLock()
+	current := weightFailure
+	weightFailureMu.RUnlock()
+
+	if !current.Enabled {
+		return rate, rule
+	}
+	if weight, ok := canaryWeight(); ok && weight > current.Threshold {
+		return current.ErrorRate / 100.0, "weight-threshold"
+	}
+	return 0, "weight-threshold"
+}
+
+func getWeightFailureHandler(c echo.Context) error {
+	weightFailureMu.RLock()
+	current := weightFailure
+	weightFailureMu.RUnlock()
+
+	response := map[string]interface{}{
+		"enabled":   current.Enabled,
+		"threshold": current.Threshold,
+		"errorRate": current.ErrorRate,
+	}
+	if weight, ok := canaryWeight(); ok {
+		response["weight"] = weight
+		response["active"] = current.Enabled && weight > current.Threshold
+	} else {
+		response["active"] = false
+	}
+
+	httpRequestsTotal.WithLabelValues("/api/weight-failure", fmt.Sprintf("%d", http.StatusOK)).Inc()
+	return c.JSON(http.StatusOK, response)
+}
+
+func (w WeightFailure) validate() error {
+	if math.IsNaN(w.Threshold) || w.Threshold < 0 || w.Threshold > 100 {
+		return fmt.Errorf("threshold must be between 0 and 100")
+	}
+	return validateErrorRatePercent(w.ErrorRate)
+}
+
+func setWeightFailureHandler(c echo.Context) error {
+	weightFailureMu.RLock()
+	update := weightFailure
+	weightFailureMu.RUnlock()
+
+	if err := json.NewDecoder(c.Request().Body).Decode(&update); err != nil {
+		httpRequestsTotal.WithLabelValues("/api/weight-failure", fmt.Sprintf("%d", http.StatusBadRequest)).Inc()
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid JSON"})
+	}
+
+	if err := update.validate(); err != nil {
+		httpRequestsTotal.WithLabelValues("/api/weight-failure", fmt.Sprintf("%d", http.StatusBadRequest)).Inc()
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+
+	weightFailureMu.Lock()
+	weightFailure = update
+	weightFailureMu.Unlock()
+
+	httpRequestsTotal.WithLabelValues("/api/weight-failure", fmt.Sprintf("%d", http.StatusOK)).Inc()
+	return c.JSON(http.StatusOK, update)
+}
@@ -19,7 +19,7 @@ const DEFAULT_API_RATE = import.meta.env.MODE === 'development' ? 2000 : 500;
 // Get stored values from localStorage or use defaults
 const getStoredErrorRate = () => {
   const stored = localStorage.getItem('errorRate');
-  return stored ? parseInt(stored) : 0;
+  return stored ? parseFloat(stored) : 0;
 };
 
 const getStoredApiRate = () => {